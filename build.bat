@@ -5,7 +5,7 @@ echo 开始编译微服务...
 REM 编译服务注册中心
 echo 编译服务注册中心...
 cd center_service
-go build -o ..\bin\center_service.exe main.go
+go build -o ..\bin\center_service.exe .
 if %errorlevel% equ 0 (
     echo ✓ 服务注册中心编译成功: bin\center_service.exe
 ) else (
@@ -16,7 +16,7 @@ if %errorlevel% equ 0 (
 REM 编译用户服务
 echo 编译用户服务...
 cd ..\user_service
-go build -o ..\bin\user_service.exe main.go
+go build -o ..\bin\user_service.exe .
 if %errorlevel% equ 0 (
     echo ✓ 用户服务编译成功: bin\user_service.exe
 ) else (
@@ -27,7 +27,7 @@ if %errorlevel% equ 0 (
 REM 编译订单服务
 echo 编译订单服务...
 cd ..\order_service
-go build -o ..\bin\order_service.exe main.go
+go build -o ..\bin\order_service.exe .
 if %errorlevel% equ 0 (
     echo ✓ 订单服务编译成功: bin\order_service.exe
 ) else (
@@ -38,7 +38,7 @@ if %errorlevel% equ 0 (
 REM 编译网关服务
 echo 编译网关服务...
 cd ..\gateway_service
-go build -o ..\bin\gateway_service.exe main.go
+go build -o ..\bin\gateway_service.exe .
 if %errorlevel% equ 0 (
     echo ✓ 网关服务编译成功: bin\gateway_service.exe
 ) else (
@@ -49,7 +49,7 @@ if %errorlevel% equ 0 (
 REM 编译测试客户端
 echo 编译测试客户端...
 cd ..\client
-go build -o ..\bin\client.exe main.go
+go build -o ..\bin\client.exe .
 if %errorlevel% equ 0 (
     echo ✓ 测试客户端编译成功: bin\client.exe
 ) else (
@@ -5,7 +5,7 @@ echo "开始编译微服务..."
 # 编译服务注册中心
 echo "编译服务注册中心..."
 cd center_service
-go build -o ../bin/center_service main.go
+go build -o ../bin/center_service .
 if [ $? -eq 0 ]; then
     echo "✓ 服务注册中心编译成功: bin/center_service"
 else
@@ -16,7 +16,7 @@ fi
 # 编译用户服务
 echo "编译用户服务..."
 cd ../user_service
-go build -o ../bin/user_service main.go
+go build -o ../bin/user_service .
 if [ $? -eq 0 ]; then
     echo "✓ 用户服务编译成功: bin/user_service"
 else
@@ -27,7 +27,7 @@ fi
 # 编译订单服务
 echo "编译订单服务..."
 cd ../order_service
-go build -o ../bin/order_service main.go
+go build -o ../bin/order_service .
 if [ $? -eq 0 ]; then
     echo "✓ 订单服务编译成功: bin/order_service"
 else
@@ -38,7 +38,7 @@ fi
 # 编译网关服务
 echo "编译网关服务..."
 cd ../gateway_service
-go build -o ../bin/gateway_service main.go
+go build -o ../bin/gateway_service .
 if [ $? -eq 0 ]; then
     echo "✓ 网关服务编译成功: bin/gateway_service"
 else
@@ -49,7 +49,7 @@ fi
 # 编译测试客户端
 echo "编译测试客户端..."
 cd ../client
-go build -o ../bin/client main.go
+go build -o ../bin/client .
 if [ $? -eq 0 ]; then
     echo "✓ 测试客户端编译成功: bin/client"
 else
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// 活动类型
+const (
+	ActivityRegister    = "register"    // 服务注册
+	ActivityUnregister  = "unregister"  // 服务注销
+	ActivityExpire      = "expire"      // 心跳超时被移除
+	ActivityCertificate = "certificate" // 本地CA签发证书
+)
+
+// Activity 注册中心的一条活动记录
+type Activity struct {
+	Time     time.Time `json:"time"`
+	Type     string    `json:"type"`
+	Service  string    `json:"service"`
+	Instance string    `json:"instance,omitempty"` // 实例ID
+	URL      string    `json:"url,omitempty"`
+	Source   string    `json:"source,omitempty"` // 发起请求的客户端地址
+}
+
+// ActivityFeed 保存最近的活动记录，并推送给订阅者（Web控制台）
+type ActivityFeed struct {
+	mu          sync.Mutex
+	items       []Activity
+	size        int
+	subscribers map[chan Activity]struct{}
+}
+
+// NewActivityFeed 创建活动记录，最多保留 size 条
+func NewActivityFeed(size int) *ActivityFeed {
+	return &ActivityFeed{
+		items:       make([]Activity, 0, size),
+		size:        size,
+		subscribers: make(map[chan Activity]struct{}),
+	}
+}
+
+// Add 追加一条活动记录（Time 为空时使用当前时间）
+// 推送给订阅者时不阻塞，订阅者处理不过来时丢弃
+func (f *ActivityFeed) Add(a Activity) {
+	if a.Time.IsZero() {
+		a.Time = time.Now()
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.items = append(f.items, a)
+	if len(f.items) > f.size {
+		f.items = f.items[len(f.items)-f.size:]
+	}
+	for ch := range f.subscribers {
+		select {
+		case ch <- a:
+		default:
+		}
+	}
+}
+
+// Recent 返回最近的活动记录（按时间从旧到新）
+func (f *ActivityFeed) Recent() []Activity {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Activity(nil), f.items...)
+}
+
+// Subscribe 订阅新的活动记录，调用 cancel 取消订阅
+func (f *ActivityFeed) Subscribe(buffer int) (<-chan Activity, func()) {
+	ch := make(chan Activity, buffer)
+	f.mu.Lock()
+	f.subscribers[ch] = struct{}{}
+	f.mu.Unlock()
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			f.mu.Lock()
+			delete(f.subscribers, ch)
+			f.mu.Unlock()
+			close(ch)
+		})
+	}
+	return ch, cancel
+}
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"ttt/pkg/config"
+	"ttt/pkg/log"
+	"ttt/pkg/registry"
+	"ttt/pkg/requestid"
+	"ttt/pkg/tlsconfig"
+)
+
+// caValidity 本地 CA 证书的有效期
+const caValidity = 10 * 365 * 24 * time.Hour
+
+// CA 注册中心内置的本地证书颁发机构，为注册的服务签发短期证书
+//
+// CA 证书和私钥保存在 CA_DIR 目录（ca.crt、ca.key），第一次启动时生成，完全离线工作。
+// 服务用 CA_TOKENS 中的令牌申请证书，令牌决定可以申请哪个服务名的证书。
+type CA struct {
+	cert    *x509.Certificate
+	certPEM []byte
+	key     *ecdsa.PrivateKey
+	ttl     time.Duration
+	tokens  map[string]string // 令牌 -> 服务名，* 表示任意服务
+}
+
+// caTokenAny 可以申请任意服务名证书的令牌
+const caTokenAny = "*"
+
+// LoadCAFromEnv 按环境变量加载（或生成）本地 CA；没有设置 CA_TOKENS 时返回 nil（不签发证书）
+//
+//	CA_DIR        CA 证书和私钥所在目录，默认 ca
+//	CA_TOKENS     申请证书的令牌，格式 服务名=令牌，多个用逗号分隔，服务名为 * 表示任意服务
+//	CA_CERT_TTL   签发证书的有效期，默认 1h，服务在剩余约1/3有效期时自动更新
+func LoadCAFromEnv(logger *log.Logger) (*CA, error) {
+	tokens, err := parseCATokens(config.GetEnv("CA_TOKENS", ""))
+	if err != nil || len(tokens) == 0 {
+		return nil, err
+	}
+	ttl, err := time.ParseDuration(config.GetEnv("CA_CERT_TTL", "1h"))
+	if err != nil || ttl < time.Minute {
+		return nil, fmt.Errorf("CA_CERT_TTL 无效（至少 1m）: %q", config.GetEnv("CA_CERT_TTL", "1h"))
+	}
+	ca, err := LoadCA(config.GetEnv("CA_DIR", "ca"), logger)
+	if err != nil {
+		return nil, err
+	}
+	ca.ttl = ttl
+	ca.tokens = tokens
+	return ca, nil
+}
+
+// parseCATokens 解析 服务名=令牌 列表
+func parseCATokens(s string) (map[string]string, error) {
+	tokens := make(map[string]string)
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		name, token, ok := strings.Cut(item, "=")
+		name, token = strings.TrimSpace(name), strings.TrimSpace(token)
+		if !ok || name == "" || token == "" {
+			return nil, fmt.Errorf("CA_TOKENS 格式错误: %q（应为 服务名=令牌）", item)
+		}
+		tokens[token] = name
+	}
+	return tokens, nil
+}
+
+// LoadCA 从目录加载 CA 证书和私钥，不存在时生成新的 CA
+func LoadCA(dir string, logger *log.Logger) (*CA, error) {
+	certFile, keyFile := filepath.Join(dir, "ca.crt"), filepath.Join(dir, "ca.key")
+	certPEM, err := os.ReadFile(certFile)
+	if errors.Is(err, os.ErrNotExist) {
+		if err := createCA(certFile, keyFile); err != nil {
+			return nil, fmt.Errorf("生成本地CA失败: %w", err)
+		}
+		logger.Info("✓ 已生成本地CA", log.String("cert", certFile))
+		certPEM, err = os.ReadFile(certFile)
+	}
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	ca := &CA{certPEM: certPEM, ttl: time.Hour}
+	certBlock, _ := pem.Decode(certPEM)
+	keyBlock, _ := pem.Decode(keyPEM)
+	if certBlock == nil || keyBlock == nil {
+		return nil, fmt.Errorf("%s 或 %s 不是有效的 PEM 文件", certFile, keyFile)
+	}
+	if ca.cert, err = x509.ParseCertificate(certBlock.Bytes); err != nil {
+		return nil, fmt.Errorf("解析CA证书失败: %w", err)
+	}
+	if ca.key, err = x509.ParseECPrivateKey(keyBlock.Bytes); err != nil {
+		return nil, fmt.Errorf("解析CA私钥失败: %w", err)
+	}
+	if !ca.key.PublicKey.Equal(ca.cert.PublicKey) {
+		return nil, errors.New("CA证书和私钥不匹配")
+	}
+	return ca, nil
+}
+
+// createCA 生成自签名的 CA 证书和私钥（私钥文件只有当前用户可读）
+func createCA(certFile, keyFile string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          randomSerial(),
+		Subject:               pkix.Name{CommonName: "ttt local CA", Organization: []string{"ttt"}},
+		NotBefore:             now.Add(-time.Minute),
+		NotAfter:              now.Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(certFile), 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		return err
+	}
+	return os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644)
+}
+
+func randomSerial() *big.Int {
+	serial, _ := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 127))
+	return serial
+}
+
+// authorize 检查令牌是否可以申请该服务名的证书
+func (ca *CA) authorize(token, name string) bool {
+	for t, allowed := range ca.tokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			return allowed == caTokenAny || allowed == name
+		}
+	}
+	return false
+}
+
+// Sign 按证书请求为服务签发证书：服务名写入 CN 和 URI SAN（registry.ServiceURI），
+// address 写入 DNS 或 IP SAN，同时可用于服务端和客户端认证。证书请求中的主题和 SAN 被忽略
+func (ca *CA) Sign(csr *x509.CertificateRequest, name, address string, ttl time.Duration) (*x509.Certificate, []byte, error) {
+	if err := csr.CheckSignature(); err != nil {
+		return nil, nil, fmt.Errorf("证书请求签名无效: %w", err)
+	}
+	uri, err := url.Parse(registry.ServiceURI(name))
+	if err != nil {
+		return nil, nil, err
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber: randomSerial(),
+		Subject:      pkix.Name{CommonName: name, Organization: []string{"ttt"}},
+		NotBefore:    now.Add(-time.Minute),
+		NotAfter:     now.Add(ttl),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		URIs:         []*url.URL{uri},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv6loopback, net.IPv4(127, 0, 0, 1)},
+	}
+	if tmpl.NotAfter.After(ca.cert.NotAfter) {
+		tmpl.NotAfter = ca.cert.NotAfter
+	}
+	if ip := net.ParseIP(address); ip != nil {
+		tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+	} else if address != "" && address != "localhost" {
+		tmpl.DNSNames = append(tmpl.DNSNames, address)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, csr.PublicKey, ca.key)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), nil
+}
+
+// centerCertTTL 注册中心自己使用的证书的有效期，在剩余约1/3有效期时更新
+const centerCertTTL = 30 * 24 * time.Hour
+
+// ServerStore 没有配置证书文件时，注册中心使用本地 CA 签发的证书提供 HTTPS，
+// 服务申请证书时令牌和 CA 证书不会明文传输（服务用 TLS_CA_FILE 指定 CA_DIR 中的 ca.crt 验证注册中心）
+func (ca *CA) ServerStore(logger *log.Logger) (*tlsconfig.Store, error) {
+	cfg, err := tlsconfig.FromEnv()
+	if err != nil {
+		return nil, err
+	}
+	cert, err := ca.issue(registry.CenterServiceName, centerCertTTL)
+	if err != nil {
+		return nil, err
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	store := tlsconfig.NewManaged(cfg, logger)
+	store.SetCertificate(cert, roots)
+	go func() {
+		for {
+			lifetime := cert.Leaf.NotAfter.Sub(cert.Leaf.NotBefore)
+			time.Sleep(time.Until(cert.Leaf.NotBefore.Add(lifetime * 2 / 3)))
+			next, err := ca.issue(registry.CenterServiceName, centerCertTTL)
+			if err != nil {
+				logger.Warn("更新注册中心证书失败，稍后重试", log.Err(err))
+				time.Sleep(time.Minute)
+				continue
+			}
+			cert = next
+			store.SetCertificate(cert, roots)
+			logger.Info("✓ 注册中心证书已更新", log.Any("not_after", cert.Leaf.NotAfter))
+		}
+	}()
+	return store, nil
+}
+
+// issue 生成新的私钥并签发证书
+func (ca *CA) issue(name string, ttl time.Duration) (*tls.Certificate, error) {
+	key, csr, err := newCSR(name)
+	if err != nil {
+		return nil, err
+	}
+	leaf, _, err := ca.Sign(csr, name, "", ttl)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Certificate{Certificate: [][]byte{leaf.Raw}, PrivateKey: key, Leaf: leaf}, nil
+}
+
+// newCSR 生成私钥和证书请求
+func newCSR(name string) (*ecdsa.PrivateKey, *x509.CertificateRequest, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	csrDER, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: pkix.Name{CommonName: name}}, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	csr, err := x509.ParseCertificateRequest(csrDER)
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, csr, nil
+}
+
+// HandleCertificate 返回 CA 证书（PEM），用于配置 TLS_CA_FILE 或让客户端信任本地 CA
+func (ca *CA) HandleCertificate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		requestid.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-pem-file")
+	w.Write(ca.certPEM)
+}
+
+// HandleSign 签发服务证书：Authorization: Bearer 令牌，请求体为 registry.CertificateRequest
+func (sr *ServiceRegistry) HandleSign(ca *CA) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			requestid.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req registry.CertificateRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Name == "" || req.CSR == "" {
+			requestid.Error(w, r, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		logger := sr.logger.ForRequest(r).With(log.String("service", req.Name), log.String("source", clientIP(r)))
+		token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		// 注册中心的证书只由注册中心自己签发，服务的令牌（包括 * 令牌）不能申请
+		if req.Name == registry.CenterServiceName || !ca.authorize(token, req.Name) {
+			logger.Warn("拒绝签发证书：令牌无效或无权申请该服务的证书")
+			requestid.Error(w, r, "Forbidden", http.StatusForbidden)
+			return
+		}
+		block, _ := pem.Decode([]byte(req.CSR))
+		if block == nil || block.Type != "CERTIFICATE REQUEST" {
+			requestid.Error(w, r, "Invalid certificate request", http.StatusBadRequest)
+			return
+		}
+		csr, err := x509.ParseCertificateRequest(block.Bytes)
+		if err != nil {
+			requestid.Error(w, r, "Invalid certificate request", http.StatusBadRequest)
+			return
+		}
+		cert, certPEM, err := ca.Sign(csr, req.Name, req.Address, ca.ttl)
+		if err != nil {
+			logger.Warn("签发证书失败", log.Err(err))
+			requestid.Error(w, r, "Invalid certificate request", http.StatusBadRequest)
+			return
+		}
+
+		logger.Info("✓ 签发服务证书", log.String("serial", cert.SerialNumber.Text(16)), log.Any("not_after", cert.NotAfter))
+		sr.activity.Add(Activity{Type: ActivityCertificate, Service: req.Name, Source: clientIP(r)})
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(registry.CertificateResponse{
+			Certificate: string(certPEM),
+			CA:          string(ca.certPEM),
+			NotAfter:    cert.NotAfter,
+		})
+	}
+}
+
+// runCertCommand 离线签发证书并写入文件：center_service cert 服务名 [输出目录] [有效期]
+// 用于需要证书文件的场景（例如注册中心自己、对外提供 HTTPS 的网关），CA 与注册中心使用同一个 CA_DIR
+func runCertCommand(args []string) error {
+	if len(args) < 1 || len(args) > 3 {
+		return errors.New("用法: center_service cert 服务名 [输出目录，默认当前目录] [有效期，默认 720h]")
+	}
+	name, dir, ttl := args[0], ".", 720*time.Hour
+	if len(args) > 1 {
+		dir = args[1]
+	}
+	if len(args) > 2 {
+		d, err := time.ParseDuration(args[2])
+		if err != nil || d <= 0 {
+			return fmt.Errorf("有效期无效: %q", args[2])
+		}
+		ttl = d
+	}
+
+	logger := log.NewFromEnv()
+	ca, err := LoadCA(config.GetEnv("CA_DIR", "ca"), logger)
+	if err != nil {
+		return err
+	}
+	key, csr, err := newCSR(name)
+	if err != nil {
+		return err
+	}
+	cert, certPEM, err := ca.Sign(csr, name, "", ttl)
+	if err != nil {
+		return err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	certFile, keyFile := filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		return err
+	}
+	if err := os.WriteFile(certFile, certPEM, 0o644); err != nil {
+		return err
+	}
+	logger.Info("✓ 已签发证书", log.String("cert", certFile), log.String("key", keyFile), log.Any("not_after", cert.NotAfter))
+	return nil
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"sort"
+	"time"
+
+	"ttt/pkg/log"
+	"ttt/pkg/requestid"
+)
+
+// Web控制台静态资源（HTML/JS/CSS），编译进程序，无需额外部署
+//
+//go:embed dashboard
+var dashboardFiles embed.FS
+
+// dashboardService 控制台显示的服务信息
+type dashboardService struct {
+	ServiceInfo
+	HeartbeatAge float64 `json:"heartbeat_age_seconds"` // 距离上次心跳的秒数
+}
+
+// dashboardState 控制台状态快照
+type dashboardState struct {
+	ServerTime  time.Time          `json:"server_time"`
+	ExpireAfter float64            `json:"expire_after_seconds"`
+	Services    []dashboardService `json:"services"`
+	Activity    []Activity         `json:"activity,omitempty"`
+}
+
+// snapshot 生成当前服务列表快照（包含已超时但尚未被清理的服务，由前端标记）
+func (sr *ServiceRegistry) snapshot() dashboardState {
+	now := time.Now()
+	sr.mu.RLock()
+	services := make([]dashboardService, 0, len(sr.services))
+	for _, service := range sr.services {
+		services = append(services, dashboardService{
+			ServiceInfo:  *service,
+			HeartbeatAge: now.Sub(service.LastHeartbeat).Seconds(),
+		})
+	}
+	sr.mu.RUnlock()
+
+	sort.Slice(services, func(i, j int) bool {
+		return services[i].Name < services[j].Name
+	})
+
+	return dashboardState{
+		ServerTime:  now,
+		ExpireAfter: heartbeatTimeout.Seconds(),
+		Services:    services,
+	}
+}
+
+// RegisterDashboard 注册Web控制台的路由
+//
+//	GET /dashboard/             控制台页面
+//	GET /dashboard/api/state    服务列表和最近活动
+//	GET /dashboard/api/events   实时更新（Server-Sent Events）
+//
+// 注销操作直接调用 POST /unregister（启用本地 CA 时需要令牌，控制台无法注销）
+func (sr *ServiceRegistry) RegisterDashboard(mux *http.ServeMux) {
+	static, err := fs.Sub(dashboardFiles, "dashboard")
+	if err != nil {
+		panic(err)
+	}
+	mux.Handle("/dashboard/", http.StripPrefix("/dashboard/", http.FileServer(http.FS(static))))
+	mux.HandleFunc("/dashboard/api/state", sr.DashboardState)
+	mux.HandleFunc("/dashboard/api/events", sr.DashboardEvents)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		http.Redirect(w, r, "/dashboard/", http.StatusFound)
+	})
+}
+
+// DashboardState 返回服务列表和最近活动
+func (sr *ServiceRegistry) DashboardState(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		requestid.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	state := sr.snapshot()
+	state.Activity = sr.activity.Recent()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(state)
+}
+
+// DashboardEvents 通过 Server-Sent Events 推送实时更新：
+//   - snapshot: 服务列表快照，每秒一次，有活动时立即推送
+//   - activity: 新的活动记录
+func (sr *ServiceRegistry) DashboardEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		requestid.Error(w, r, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+
+	activity, cancel := sr.activity.Subscribe(32)
+	defer cancel()
+
+	logger := sr.logger.ForRequest(r)
+	logger.Debug("控制台已连接", log.String("client", clientIP(r)))
+	defer logger.Debug("控制台已断开", log.String("client", clientIP(r)))
+
+	send := func(event string, v interface{}) bool {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return false
+		}
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data); err != nil {
+			return false
+		}
+		flusher.Flush()
+		return true
+	}
+
+	if !send("snapshot", sr.snapshot()) {
+		return
+	}
+
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case a, ok := <-activity:
+			if !ok || !send("activity", a) || !send("snapshot", sr.snapshot()) {
+				return
+			}
+		case <-ticker.C:
+			if !send("snapshot", sr.snapshot()) {
+				return
+			}
+		}
+	}
+}
+
+// clientIP 返回请求的客户端地址（不含端口）
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,173 @@
+// 服务注册中心Web控制台：通过 /dashboard/api/events 实时接收服务列表和活动记录
+(function () {
+  "use strict";
+
+  var MAX_ACTIVITY = 200;
+
+  var servicesEl = document.getElementById("services");
+  var countEl = document.getElementById("service-count");
+  var activityEl = document.getElementById("activity");
+  var connectionEl = document.getElementById("connection");
+
+  var activityLabels = {
+    register: "注册",
+    unregister: "注销",
+    expire: "心跳超时移除",
+    certificate: "签发证书"
+  };
+
+  function el(tag, className, text) {
+    var node = document.createElement(tag);
+    if (className) {
+      node.className = className;
+    }
+    if (text !== undefined) {
+      node.textContent = text;
+    }
+    return node;
+  }
+
+  function formatTime(value) {
+    var d = new Date(value);
+    var pad = function (n) { return (n < 10 ? "0" : "") + n; };
+    return pad(d.getHours()) + ":" + pad(d.getMinutes()) + ":" + pad(d.getSeconds());
+  }
+
+  function formatAge(seconds) {
+    if (seconds < 1) {
+      return "刚刚";
+    }
+    if (seconds < 60) {
+      return Math.floor(seconds) + " 秒前";
+    }
+    return Math.floor(seconds / 60) + " 分 " + Math.floor(seconds % 60) + " 秒前";
+  }
+
+  // 心跳间隔为5秒：超过一次心跳未收到显示为“延迟”，超过过期时间显示为“已过期”
+  function statusOf(service, expireAfter) {
+    var age = service.heartbeat_age_seconds;
+    if (age > expireAfter) {
+      return { className: "offline", text: "已过期" };
+    }
+    if (age > expireAfter * 0.6) {
+      return { className: "stale", text: "心跳延迟" };
+    }
+    return { className: "online", text: "在线" };
+  }
+
+  function renderServices(state) {
+    var services = state.services || [];
+    countEl.textContent = services.length;
+    servicesEl.textContent = "";
+
+    if (services.length === 0) {
+      var empty = el("tr", "empty");
+      var cell = el("td", "", "暂无已注册的服务");
+      cell.colSpan = 5;
+      empty.appendChild(cell);
+      servicesEl.appendChild(empty);
+      return;
+    }
+
+    services.forEach(function (service) {
+      var row = el("tr");
+      var status = statusOf(service, state.expire_after_seconds);
+
+      var nameCell = el("td", "", service.name);
+      nameCell.appendChild(el("div", "muted small", service.version ? service.id + " · " + service.version : service.id));
+      row.appendChild(nameCell);
+      var urlCell = el("td");
+      var link = el("a", "", service.url);
+      link.href = service.url;
+      link.target = "_blank";
+      link.rel = "noopener";
+      urlCell.appendChild(link);
+      row.appendChild(urlCell);
+      row.appendChild(el("td", "", formatAge(service.heartbeat_age_seconds)));
+
+      var statusCell = el("td");
+      statusCell.appendChild(el("span", "badge " + status.className, status.text));
+      row.appendChild(statusCell);
+
+      var actionCell = el("td");
+      var button = el("button", "", "注销");
+      button.addEventListener("click", function () {
+        deregister(service);
+      });
+      actionCell.appendChild(button);
+      row.appendChild(actionCell);
+
+      servicesEl.appendChild(row);
+    });
+  }
+
+  function addActivity(a) {
+    var item = el("li");
+    item.appendChild(el("span", "time", formatTime(a.time)));
+    item.appendChild(el("span", a.type, activityLabels[a.type] || a.type));
+    item.appendChild(document.createTextNode(" " + a.service));
+    if (a.url) {
+      item.appendChild(el("span", "muted", " " + a.url));
+    }
+    if (a.source) {
+      item.appendChild(el("span", "muted", "（来自 " + a.source + "）"));
+    }
+
+    activityEl.insertBefore(item, activityEl.firstChild);
+    while (activityEl.children.length > MAX_ACTIVITY) {
+      activityEl.removeChild(activityEl.lastChild);
+    }
+  }
+
+  function deregister(service) {
+    if (!window.confirm("确定要注销实例 " + service.id + " 吗？\n（服务仍在运行时，下次心跳会自动重新注册）")) {
+      return;
+    }
+    fetch("/unregister?id=" + encodeURIComponent(service.id), { method: "POST" })
+      .then(function (resp) {
+        if (!resp.ok) {
+          return resp.text().then(function (text) {
+            throw new Error(text);
+          });
+        }
+      })
+      .catch(function (err) {
+        window.alert("注销失败: " + err.message);
+      });
+  }
+
+  function setConnected(connected) {
+    connectionEl.className = "badge " + (connected ? "online" : "offline");
+    connectionEl.textContent = connected ? "实时更新中" : "连接断开，重连中...";
+  }
+
+  function connect() {
+    var source = new EventSource("api/events");
+    source.onopen = function () {
+      setConnected(true);
+    };
+    source.onerror = function () {
+      // EventSource 会自动重连
+      setConnected(false);
+    };
+    source.addEventListener("snapshot", function (e) {
+      renderServices(JSON.parse(e.data));
+    });
+    source.addEventListener("activity", function (e) {
+      addActivity(JSON.parse(e.data));
+    });
+  }
+
+  fetch("api/state")
+    .then(function (resp) {
+      return resp.json();
+    })
+    .then(function (state) {
+      renderServices(state);
+      (state.activity || []).forEach(addActivity);
+    })
+    .catch(function () {
+      setConnected(false);
+    })
+    .then(connect);
+})();
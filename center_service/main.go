@@ -1,152 +1,61 @@
 package main
 
 import (
-	"embed"
 	"encoding/json"
 	"fmt"
-	"image/color"
-	"log"
+	"math/rand"
 	"net/http"
 	"os"
-	"path/filepath"
-	"runtime"
 	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"fyne.io/fyne/v2"
-	"fyne.io/fyne/v2/app"
-	"fyne.io/fyne/v2/canvas"
 	"fyne.io/fyne/v2/container"
-	"fyne.io/fyne/v2/storage"
-	"fyne.io/fyne/v2/theme"
 	"fyne.io/fyne/v2/widget"
+
+	"ttt/pkg/config"
+	"ttt/pkg/log"
+	"ttt/pkg/registry"
+	"ttt/pkg/requestid"
+	"ttt/pkg/tlsconfig"
+	"ttt/pkg/ui"
 )
 
-// 嵌入字体文件
-// 使用方法：将中文字体文件复制到 fonts 目录下
-// 支持的格式：.ttf, .otf（注意：Fyne不支持.ttc字体集合文件）
-// 如果没有字体文件，程序会自动使用系统字体作为fallback
-//go:embed fonts/*
-var embeddedFonts embed.FS
-
-// ServiceInfo 服务信息
-type ServiceInfo struct {
-	Name          string    `json:"name"`
-	Address       string    `json:"address"`
-	Port          int       `json:"port"`
-	URL           string    `json:"url"`
-	LastHeartbeat time.Time `json:"last_heartbeat"`
-}
+// heartbeatTimeout 超过该时间未收到心跳的服务视为下线
+const heartbeatTimeout = 10 * time.Second
 
-// LogMessage 日志消息结构
-type LogMessage struct {
-	Text  string
-	Color color.Color
-}
+// ServiceInfo 服务实例信息，同一服务名可以注册多个实例（按实例ID区分）
+type ServiceInfo = registry.Instance
 
 // ServiceRegistry 服务注册中心
 type ServiceRegistry struct {
-	services     map[string]*ServiceInfo
+	services     map[string]*ServiceInfo // 实例ID -> 实例
 	mu           sync.RWMutex
-	logContainer *fyne.Container
-	logScroll    *container.Scroll
+	logger       *log.Logger
 	servicesList *widget.List
 	servicesData []*ServiceInfo
 	muData       sync.RWMutex
 	updateListFn func()
-	refreshChan  chan struct{}   // 用于触发UI刷新
-	logChan      chan LogMessage // 用于在主线程中更新日志
+	refreshChan  chan struct{} // 用于触发UI刷新
+	activity     *ActivityFeed // 最近的注册/注销/过期记录，供Web控制台显示
+	ca           *CA           // 本地 CA，不为空时注册、心跳和注销需要令牌或客户端证书
 }
 
 // NewServiceRegistry 创建新的服务注册中心
-func NewServiceRegistry(logContainer *fyne.Container, logScroll *container.Scroll, servicesList *widget.List, refreshChan chan struct{}, logChan chan LogMessage) *ServiceRegistry {
+func NewServiceRegistry(logger *log.Logger, servicesList *widget.List, refreshChan chan struct{}) *ServiceRegistry {
 	return &ServiceRegistry{
 		services:     make(map[string]*ServiceInfo),
-		logContainer: logContainer,
-		logScroll:    logScroll,
+		logger:       logger,
 		servicesList: servicesList,
 		servicesData: make([]*ServiceInfo, 0),
 		refreshChan:  refreshChan,
-		logChan:      logChan,
-	}
-}
-
-// logMessage 添加日志消息（通过channel在主线程中更新）
-func (sr *ServiceRegistry) logMessage(msg string) {
-	if sr.logChan != nil {
-		timestamp := time.Now().Format("15:04:05")
-		timestampText := fmt.Sprintf("[%s] ", timestamp)
-
-		// 根据消息内容确定颜色
-		var msgColor color.Color
-		if contains(msg, "注册") {
-			msgColor = color.NRGBA{R: 0, G: 200, B: 0, A: 255} // 绿色
-		} else if contains(msg, "注销") {
-			msgColor = color.NRGBA{R: 255, G: 165, B: 0, A: 255} // 橙色
-		} else if contains(msg, "过期") || contains(msg, "移除") {
-			msgColor = color.NRGBA{R: 255, G: 0, B: 0, A: 255} // 红色
-		} else if contains(msg, "启动") || contains(msg, "就绪") {
-			msgColor = color.NRGBA{R: 0, G: 150, B: 255, A: 255} // 蓝色
-		} else {
-			msgColor = color.NRGBA{R: 200, G: 200, B: 200, A: 255} // 灰色（默认）
-		}
-
-		logMsg := LogMessage{
-			Text:  fmt.Sprintf("%s%s\n", timestampText, msg),
-			Color: msgColor,
-		}
-
-		select {
-		case sr.logChan <- logMsg:
-		default:
-			// channel满了，跳过
-		}
+		activity:     NewActivityFeed(200),
 	}
 }
 
-// contains 检查字符串是否包含子串（不区分大小写）
-func contains(s, substr string) bool {
-	return len(s) >= len(substr) && (s == substr || len(substr) == 0 ||
-		(len(s) > len(substr) && (s[:len(substr)] == substr ||
-			s[len(s)-len(substr):] == substr ||
-			containsHelper(s, substr))))
-}
-
-func containsHelper(s, substr string) bool {
-	for i := 0; i <= len(s)-len(substr); i++ {
-		if s[i:i+len(substr)] == substr {
-			return true
-		}
-	}
-	return false
-}
-
-// splitLogMessage 解析日志消息，返回时间戳和消息内容
-func splitLogMessage(msg string) []string {
-	// 格式: [15:04:05] 消息内容\n
-	if len(msg) < 12 || msg[0] != '[' {
-		return []string{"", msg}
-	}
-
-	// 查找时间戳结束位置
-	endIdx := -1
-	for i := 1; i < len(msg) && i < 12; i++ {
-		if msg[i] == ']' {
-			endIdx = i
-			break
-		}
-	}
-
-	if endIdx == -1 {
-		return []string{"", msg}
-	}
-
-	timestamp := msg[:endIdx+1] + " "
-	message := msg[endIdx+2:]
-	return []string{timestamp, message}
-}
-
 // updateServicesList 更新服务列表显示
 // 这个函数会：
 // 1. 调用refreshServicesList更新数据
@@ -166,177 +75,307 @@ func (sr *ServiceRegistry) updateServicesList() {
 	}
 }
 
-// Register 注册服务
+// Register 注册服务实例
 func (sr *ServiceRegistry) Register(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		requestid.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
 	var service ServiceInfo
 	if err := json.NewDecoder(r.Body).Decode(&service); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		requestid.Error(w, r, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if service.Name == "" || service.Port <= 0 {
+		requestid.Error(w, r, "Missing name or port", http.StatusBadRequest)
 		return
 	}
 
+	if !sr.authorizeInstance(r, service.Name) {
+		sr.logger.ForRequest(r).Warn("拒绝注册：没有该服务的令牌或客户端证书", log.String("service", service.Name), log.String("source", clientIP(r)))
+		requestid.Error(w, r, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	if service.ID == "" {
+		service.ID = registry.DefaultID(service.Name, service.Address, service.Port)
+	}
+	switch service.Scheme {
+	case "":
+		service.Scheme = "http"
+	case "http", "https":
+	default:
+		requestid.Error(w, r, "Invalid scheme (http or https)", http.StatusBadRequest)
+		return
+	}
 	service.LastHeartbeat = time.Now()
-	service.URL = fmt.Sprintf("http://%s:%d", service.Address, service.Port)
+	service.URL = fmt.Sprintf("%s://%s:%d", service.Scheme, service.Address, service.Port)
 
 	sr.mu.Lock()
-	sr.services[service.Name] = &service
+	// 实例ID已被其他服务使用时，还需要有那个服务的权限，否则可以用自己的令牌接管其他服务的实例
+	if existing := sr.services[service.ID]; existing != nil && existing.Name != service.Name && !sr.authorizeInstance(r, existing.Name) {
+		sr.mu.Unlock()
+		sr.logger.ForRequest(r).Warn("拒绝注册：实例ID属于其他服务", log.String("service", service.Name), log.String("id", service.ID), log.String("owner", existing.Name), log.String("source", clientIP(r)))
+		requestid.Error(w, r, "Forbidden", http.StatusForbidden)
+		return
+	}
+	sr.services[service.ID] = &service
 	sr.mu.Unlock()
 
 	// 在锁外执行日志和UI更新，避免死锁
-	msg := fmt.Sprintf("服务注册: %s -> %s", service.Name, service.URL)
-	sr.logMessage(msg)
+	sr.logger.ForRequest(r).Info("服务注册", log.String("service", service.Name), log.String("id", service.ID), log.String("version", service.Version), log.String("url", service.URL))
+	sr.activity.Add(Activity{Type: ActivityRegister, Service: service.Name, Instance: service.ID, URL: service.URL, Source: clientIP(r)})
 	// 立即更新服务列表并刷新UI
 	sr.updateServicesList()
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{
 		"status": "registered",
+		"id":     service.ID,
 		"name":   service.Name,
 		"url":    service.URL,
 	})
 }
 
-// Discover 发现服务
+// Discover 发现服务，服务有多个在线实例时随机返回其中一个
 func (sr *ServiceRegistry) Discover(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		requestid.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
 	serviceName := r.URL.Query().Get("name")
 	if serviceName == "" {
-		http.Error(w, "Missing name parameter", http.StatusBadRequest)
+		requestid.Error(w, r, "Missing name parameter", http.StatusBadRequest)
 		return
 	}
 
 	sr.mu.RLock()
-	service, exists := sr.services[serviceName]
-	sr.mu.RUnlock()
-
-	if !exists {
-		http.Error(w, "Service not found", http.StatusNotFound)
-		return
+	var found bool
+	candidates := make([]ServiceInfo, 0)
+	for _, service := range sr.services {
+		if service.Name != serviceName {
+			continue
+		}
+		found = true
+		// 过期的实例（10秒未心跳）由定期清理移除，这里只跳过
+		if time.Since(service.LastHeartbeat) <= heartbeatTimeout {
+			candidates = append(candidates, *service)
+		}
 	}
+	sr.mu.RUnlock()
 
-	// 检查服务是否过期（10秒未心跳则认为服务下线）
-	if time.Since(service.LastHeartbeat) > 10*time.Second {
-		sr.mu.Lock()
-		delete(sr.services, serviceName)
-		sr.mu.Unlock()
-		sr.updateServicesList()
-		http.Error(w, "Service expired", http.StatusNotFound)
+	if len(candidates) == 0 {
+		if found {
+			requestid.Error(w, r, "Service expired", http.StatusNotFound)
+		} else {
+			requestid.Error(w, r, "Service not found", http.StatusNotFound)
+		}
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(service)
+	json.NewEncoder(w).Encode(candidates[rand.Intn(len(candidates))])
 }
 
-// ListServices 列出所有服务
+// ListServices 列出所有在线的服务实例（按服务名、实例ID排序）
 func (sr *ServiceRegistry) ListServices(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		requestid.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
 	sr.mu.RLock()
-	services := make([]*ServiceInfo, 0, len(sr.services))
+	services := make([]ServiceInfo, 0, len(sr.services))
 	for _, service := range sr.services {
 		// 过滤过期服务（10秒未心跳则认为服务下线）
-		if time.Since(service.LastHeartbeat) <= 10*time.Second {
-			services = append(services, service)
+		if time.Since(service.LastHeartbeat) <= heartbeatTimeout {
+			services = append(services, *service)
 		}
 	}
 	sr.mu.RUnlock()
 
+	sort.Slice(services, func(i, j int) bool {
+		if services[i].Name != services[j].Name {
+			return services[i].Name < services[j].Name
+		}
+		return services[i].ID < services[j].ID
+	})
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(services)
 }
 
-// Heartbeat 心跳更新
+// matchInstances 返回请求参数指定的实例ID（调用方需持有锁）
+// 优先使用 id 参数；只有 name 参数时匹配该服务的所有实例（兼容旧版本的服务）
+func (sr *ServiceRegistry) matchInstances(r *http.Request) []string {
+	if id := r.URL.Query().Get("id"); id != "" {
+		if _, exists := sr.services[id]; exists {
+			return []string{id}
+		}
+		return nil
+	}
+	name := r.URL.Query().Get("name")
+	ids := make([]string, 0, 1)
+	for id, service := range sr.services {
+		if service.Name == name {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// authorizeInstance 启用本地 CA 时，注册、心跳和注销需要该服务的令牌（CA_TOKENS 中的令牌）
+// 或该服务的客户端证书（URI SAN 为 registry.ServiceURI），否则任何人都可以冒充或注销服务
+func (sr *ServiceRegistry) authorizeInstance(r *http.Request, name string) bool {
+	if sr.ca == nil {
+		return true
+	}
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && tlsconfig.HasURI(r.TLS.VerifiedChains[0][0], registry.ServiceURI(name)) {
+		return true
+	}
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return token != "" && sr.ca.authorize(token, name)
+}
+
+// authorizeInstances 检查是否可以操作所有匹配的实例（调用方需持有锁）
+func (sr *ServiceRegistry) authorizeInstances(r *http.Request, ids []string) bool {
+	for _, id := range ids {
+		if !sr.authorizeInstance(r, sr.services[id].Name) {
+			return false
+		}
+	}
+	return true
+}
+
+// Heartbeat 心跳更新，实例不存在时返回404（服务收到后会重新注册）
 func (sr *ServiceRegistry) Heartbeat(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		requestid.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	serviceName := r.URL.Query().Get("name")
-	if serviceName == "" {
-		http.Error(w, "Missing name parameter", http.StatusBadRequest)
+	if r.URL.Query().Get("id") == "" && r.URL.Query().Get("name") == "" {
+		requestid.Error(w, r, "Missing id or name parameter", http.StatusBadRequest)
 		return
 	}
 
 	sr.mu.Lock()
-	if service, exists := sr.services[serviceName]; exists {
-		service.LastHeartbeat = time.Now()
+	ids := sr.matchInstances(r)
+	if !sr.authorizeInstances(r, ids) {
+		sr.mu.Unlock()
+		requestid.Error(w, r, "Forbidden", http.StatusForbidden)
+		return
+	}
+	for _, id := range ids {
+		sr.services[id].LastHeartbeat = time.Now()
 	}
 	sr.mu.Unlock()
 
+	if len(ids) == 0 {
+		requestid.Error(w, r, "Service not registered", http.StatusNotFound)
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
 }
 
-// Unregister 注销服务
+// Unregister 注销服务实例（id 参数），或注销服务的所有实例（name 参数）
 func (sr *ServiceRegistry) Unregister(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		requestid.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	serviceName := r.URL.Query().Get("name")
-	if serviceName == "" {
-		http.Error(w, "Missing name parameter", http.StatusBadRequest)
+	if r.URL.Query().Get("id") == "" && r.URL.Query().Get("name") == "" {
+		requestid.Error(w, r, "Missing id or name parameter", http.StatusBadRequest)
 		return
 	}
 
 	sr.mu.Lock()
-	existed := false
-	if _, exists := sr.services[serviceName]; exists {
-		delete(sr.services, serviceName)
-		existed = true
+	ids := sr.matchInstances(r)
+	if !sr.authorizeInstances(r, ids) {
+		sr.mu.Unlock()
+		sr.logger.ForRequest(r).Warn("拒绝注销：没有该服务的令牌或客户端证书", log.String("source", clientIP(r)))
+		requestid.Error(w, r, "Forbidden", http.StatusForbidden)
+		return
+	}
+	removed := make([]*ServiceInfo, 0, 1)
+	for _, id := range ids {
+		removed = append(removed, sr.services[id])
+		delete(sr.services, id)
 	}
 	sr.mu.Unlock()
 
 	// 如果服务存在，记录日志并更新UI（在锁外执行，避免死锁）
-	if existed {
-		msg := fmt.Sprintf("服务注销: %s", serviceName)
-		sr.logMessage(msg)
+	for _, service := range removed {
+		sr.logger.ForRequest(r).Warn("服务注销", log.String("service", service.Name), log.String("id", service.ID))
+		sr.activity.Add(Activity{Type: ActivityUnregister, Service: service.Name, Instance: service.ID, URL: service.URL, Source: clientIP(r)})
+	}
+	if len(removed) > 0 {
 		// 立即更新服务列表并刷新UI
 		sr.updateServicesList()
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
-		"status": "unregistered",
-		"name":   serviceName,
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":  "unregistered",
+		"name":    r.URL.Query().Get("name"),
+		"id":      r.URL.Query().Get("id"),
+		"removed": len(removed),
 	})
 }
 
 func main() {
+	// center_service cert 服务名 [输出目录] [有效期]：用本地CA离线签发证书文件后退出
+	if len(os.Args) > 1 && os.Args[1] == "cert" {
+		if err := runCertCommand(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// 创建GUI应用
-	myApp := app.New()
-	// 设置支持中文的主题（使用系统默认字体，支持中文）
-	myApp.Settings().SetTheme(newChineseTheme())
-	myWindow := myApp.NewWindow("服务注册中心 (端口: 8080)")
+	myApp := ui.NewApp()
+	port := config.GetEnvInt("PORT", 8080)
+	myWindow := myApp.NewWindow(fmt.Sprintf("服务注册中心 (端口: %d)", port))
 	myWindow.Resize(fyne.NewSize(800, 600))
 
-	// 创建日志显示区域（使用canvas.Text支持彩色显示）
-	logContainer := container.NewVBox()
-	// 添加初始消息
-	initText := canvas.NewText("服务注册中心启动中...", color.NRGBA{R: 200, G: 200, B: 200, A: 255})
-	initText.TextStyle = fyne.TextStyle{Monospace: true}
-	logContainer.Add(initText)
-	logScroll := container.NewScroll(logContainer)
-	logScroll.SetMinSize(fyne.NewSize(0, 0))
+	// 创建日志：同时输出到标准输出和内存环形缓冲区，GUI订阅缓冲区显示
+	logBuffer := log.NewRingBuffer(200)
+	logger := log.NewFromEnv(logBuffer)
+
+	// 创建日志面板和状态栏
+	logPanel := ui.NewLogPanel(logBuffer, myWindow)
+	statusBar := ui.NewStatusBar()
+	logger.Info("服务注册中心启动中...")
+
+	// 配置了证书时使用 HTTPS（证书文件变化时自动重新加载）
+	tlsStore, err := tlsconfig.LoadFromEnv(logger)
+	if err != nil {
+		logger.Fatal("加载TLS证书失败", log.Err(err))
+	}
+	if tlsStore != nil {
+		go tlsStore.Watch(5 * time.Second)
+	}
+
+	// 设置了 CA_TOKENS 时作为本地CA为注册的服务签发证书
+	ca, err := LoadCAFromEnv(logger)
+	if err != nil {
+		logger.Fatal("加载本地CA失败", log.Err(err))
+	}
+	if ca != nil && !tlsStore.Enabled() {
+		// 启用本地CA时总是使用 HTTPS，令牌和 CA 证书不能明文传输
+		if tlsStore, err = ca.ServerStore(logger); err != nil {
+			logger.Fatal("签发注册中心证书失败", log.Err(err))
+		}
+	}
 
 	// 创建刷新channel，用于在主线程中刷新UI
 	refreshChan := make(chan struct{}, 10)
-	// 创建日志channel，用于在主线程中更新日志
-	logChan := make(chan LogMessage, 100)
 
 	// 使用mutex保护服务列表数据
 	var servicesDataMu sync.RWMutex
@@ -370,54 +409,24 @@ func main() {
 	)
 
 	// 创建注册中心实例
-	registry := NewServiceRegistry(logContainer, logScroll, servicesList, refreshChan, logChan)
+	registry := NewServiceRegistry(logger, servicesList, refreshChan)
+	registry.ca = ca
 
-	// 在主线程中处理日志更新和UI刷新（必须在创建registry之后启动）
-	// 先启动日志处理goroutine，确保能接收日志消息
-	logHandlerReady := make(chan bool, 1)
+	// 处理UI刷新请求
 	go func() {
-		logHandlerReady <- true
-		for {
-			select {
-			case logMsg := <-logChan:
-				// 更新日志容器（线程安全）
-				if registry.logContainer != nil {
-					// 创建带颜色的文本
-					logText := canvas.NewText(logMsg.Text, logMsg.Color)
-					logText.TextStyle = fyne.TextStyle{Monospace: true}
-					logText.Alignment = fyne.TextAlignLeading
-
-					// 添加到容器
-					registry.logContainer.Add(logText)
-
-					// 限制日志条目数量，避免内存占用过大（保留最后200条）
-					if len(registry.logContainer.Objects) > 200 {
-						// 移除最旧的条目
-						oldObjs := registry.logContainer.Objects
-						registry.logContainer.Objects = oldObjs[len(oldObjs)-200:]
-						registry.logContainer.Refresh()
-					}
-
-					// 滚动到底部
-					logScroll.ScrollToBottom()
-				}
-			case <-refreshChan:
-				// 刷新列表
-				servicesList.Refresh()
-			}
+		for range refreshChan {
+			// 刷新列表
+			servicesList.Refresh()
 		}
 	}()
 
-	// 等待日志处理goroutine启动
-	<-logHandlerReady
-
 	// 更新服务列表数据的函数（只更新数据，不触发UI刷新）
 	refreshServicesList := func() {
 		registry.mu.RLock()
 		newServicesData := make([]*ServiceInfo, 0, len(registry.services))
 		for _, service := range registry.services {
 			// 过滤过期服务（10秒未心跳则认为服务下线）
-			if time.Since(service.LastHeartbeat) <= 10*time.Second {
+			if time.Since(service.LastHeartbeat) <= heartbeatTimeout {
 				newServicesData = append(newServicesData, service)
 			}
 		}
@@ -425,7 +434,10 @@ func main() {
 
 		// 按服务名称排序，确保顺序稳定
 		sort.Slice(newServicesData, func(i, j int) bool {
-			return newServicesData[i].Name < newServicesData[j].Name
+			if newServicesData[i].Name != newServicesData[j].Name {
+				return newServicesData[i].Name < newServicesData[j].Name
+			}
+			return newServicesData[i].ID < newServicesData[j].ID
 		})
 
 		// 更新服务列表数据（使用mutex保护）
@@ -459,20 +471,28 @@ func main() {
 	http.HandleFunc("/discover", registry.Discover)
 	http.HandleFunc("/services", registry.ListServices)
 	http.HandleFunc("/heartbeat", registry.Heartbeat)
-
-	port := 8080
+	registry.RegisterDashboard(http.DefaultServeMux)
+	if ca != nil {
+		http.HandleFunc("/ca/certificate", ca.HandleCertificate)
+		http.HandleFunc("/ca/sign", registry.HandleSign(ca))
+	}
 
 	// 启动HTTP服务器
 	go func() {
-		registry.logMessage(fmt.Sprintf("服务注册中心启动在端口 %d", port))
-		registry.logMessage("API端点:")
-		registry.logMessage(fmt.Sprintf("  POST http://localhost:%d/register - 注册服务", port))
-		registry.logMessage(fmt.Sprintf("  POST http://localhost:%d/unregister?name=服务名 - 注销服务", port))
-		registry.logMessage(fmt.Sprintf("  GET  http://localhost:%d/discover?name=服务名 - 发现服务", port))
-		registry.logMessage(fmt.Sprintf("  GET  http://localhost:%d/services - 列出所有服务", port))
-		registry.logMessage(fmt.Sprintf("  POST http://localhost:%d/heartbeat?name=服务名 - 发送心跳", port))
-		registry.logMessage("服务已就绪，等待服务注册...")
-		log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", port), nil))
+		logger.Info("服务注册中心启动", log.Int("port", port))
+		logger.Info("API端点:")
+		logger.Info(fmt.Sprintf("  POST http://localhost:%d/register - 注册服务（启用本地CA时需要令牌或客户端证书）", port))
+		logger.Info(fmt.Sprintf("  POST http://localhost:%d/unregister?id=实例ID - 注销实例（?name=服务名 注销所有实例）", port))
+		logger.Info(fmt.Sprintf("  GET  http://localhost:%d/discover?name=服务名 - 发现服务（多个实例时随机返回一个）", port))
+		logger.Info(fmt.Sprintf("  GET  http://localhost:%d/services - 列出所有服务", port))
+		logger.Info(fmt.Sprintf("  POST http://localhost:%d/heartbeat?id=实例ID - 发送心跳", port))
+		if ca != nil {
+			logger.Info(fmt.Sprintf("  GET  http://localhost:%d/ca/certificate - 本地CA证书", port))
+			logger.Info(fmt.Sprintf("  POST http://localhost:%d/ca/sign - 签发服务证书（需要 CA_TOKENS 中的令牌）", port))
+		}
+		logger.Info(fmt.Sprintf("Web控制台: http://<本机地址>:%d/dashboard/", port))
+		logger.Info("服务已就绪，等待服务注册...")
+		logger.Fatal("HTTP服务退出", log.Err(tlsconfig.ListenAndServe(fmt.Sprintf(":%d", port), requestid.Middleware(http.DefaultServeMux), tlsStore)))
 	}()
 
 	// 定期清理过期服务（每2秒检查一次，10秒未心跳则移除）
@@ -481,228 +501,43 @@ func main() {
 		defer ticker.Stop()
 		for range ticker.C {
 			removed := false
-			removedNames := make([]string, 0)
+			removedServices := make([]*ServiceInfo, 0)
 			registry.mu.Lock()
-			for name, service := range registry.services {
-				if time.Since(service.LastHeartbeat) > 10*time.Second {
-					delete(registry.services, name)
-					removedNames = append(removedNames, name)
+			for id, service := range registry.services {
+				if time.Since(service.LastHeartbeat) > heartbeatTimeout {
+					delete(registry.services, id)
+					removedServices = append(removedServices, service)
 					removed = true
 				}
 			}
 			registry.mu.Unlock()
 			// 如果有服务被移除，记录日志并更新UI（在锁外执行）
 			if removed {
-				for _, name := range removedNames {
-					registry.logMessage(fmt.Sprintf("服务过期已移除: %s", name))
+				for _, service := range removedServices {
+					logger.Error("服务过期已移除", log.String("service", service.Name), log.String("id", service.ID))
+					registry.activity.Add(Activity{Type: ActivityExpire, Service: service.Name, Instance: service.ID, URL: service.URL})
 				}
 				registry.updateServicesList()
 			}
 		}
 	}()
 
-	// 创建UI布局
-	statusLabel := widget.NewLabel(fmt.Sprintf("状态: 运行中 | 端口: %d | 已注册服务: 0", port))
-
 	// 定期更新状态
 	go func() {
+		statusBar.SetState(ui.StateRunning)
+		statusBar.Set("状态", "运行中")
+		statusBar.Set("端口", strconv.Itoa(port))
 		ticker := time.NewTicker(1 * time.Second)
 		defer ticker.Stop()
-		for range ticker.C {
+		for {
 			registry.mu.RLock()
 			count := len(registry.services)
 			registry.mu.RUnlock()
-			statusLabel.SetText(fmt.Sprintf("状态: 运行中 | 端口: %d | 已注册服务: %d", port, count))
+			statusBar.Set("已注册服务", strconv.Itoa(count))
+			<-ticker.C
 		}
 	}()
 
-	// 创建HSplit并设置分割比例（左侧占30%，右侧占70%）
-	split := container.NewHSplit(
-		container.NewBorder(
-			widget.NewLabel("已注册服务列表"), nil, nil, nil,
-			servicesList,
-		),
-		container.NewBorder(
-			widget.NewLabel("日志输出"), nil, nil, nil,
-			logScroll,
-		),
-	)
-	split.SetOffset(0.3) // 左侧占30%宽度
-
-	content := container.NewBorder(
-		statusLabel,
-		nil,
-		nil,
-		nil,
-		split,
-	)
-
-	myWindow.SetContent(content)
+	myWindow.SetContent(ui.ServiceLayout(statusBar, logPanel, "已注册服务列表", servicesList))
 	myWindow.ShowAndRun()
 }
-
-// chineseTheme 支持中文的主题
-type chineseTheme struct {
-	baseTheme   fyne.Theme
-	chineseFont fyne.Resource
-}
-
-func newChineseTheme() *chineseTheme {
-	t := &chineseTheme{
-		baseTheme: theme.DefaultTheme(),
-	}
-
-	// 尝试加载系统字体
-	t.chineseFont = loadSystemChineseFont()
-	if t.chineseFont == nil {
-		// 如果找不到系统字体，在Windows上直接使用默认主题字体
-		// Fyne在Windows上会自动使用系统默认字体，通常支持中文
-		t.chineseFont = t.baseTheme.Font(fyne.TextStyle{})
-		if t.chineseFont == nil {
-			t.chineseFont = theme.DefaultTheme().Font(fyne.TextStyle{})
-		}
-	}
-
-	return t
-}
-
-// loadSystemChineseFont 加载中文字体（优先使用嵌入的字体，否则使用系统字体）
-func loadSystemChineseFont() fyne.Resource {
-	// 1. 优先尝试加载嵌入的字体文件
-	if embeddedFont := loadEmbeddedFont(); embeddedFont != nil {
-		return embeddedFont
-	}
-
-	// 2. 如果嵌入字体不存在，尝试加载系统字体
-	var fontPaths []string
-
-	switch runtime.GOOS {
-	case "windows":
-		// Windows字体路径，按优先级排序
-		// 优先使用常见的Windows中文字体
-		windir := os.Getenv("WINDIR")
-		if windir == "" {
-			windir = "C:\\Windows"
-		}
-		
-		// 注意：Fyne不支持.ttc字体集合，只使用.ttf文件
-		fontPaths = []string{
-			filepath.Join(windir, "Fonts", "simhei.ttf"),  // SimHei (黑体)
-			filepath.Join(windir, "Fonts", "simsun.ttf"),  // SimSun (宋体)
-			filepath.Join(windir, "Fonts", "simkai.ttf"),  // SimKai (楷体)
-			filepath.Join(windir, "Fonts", "simli.ttf"),   // SimLi (隶书)
-		}
-		
-		if windir != "C:\\Windows" {
-			fontPaths = append(fontPaths,
-				filepath.Join("C:\\Windows", "Fonts", "simhei.ttf"),
-				filepath.Join("C:\\Windows", "Fonts", "simsun.ttf"),
-			)
-		}
-	case "darwin": // macOS
-		// 只使用.ttf文件，Fyne不支持.ttc
-		fontPaths = []string{
-			"/Library/Fonts/Arial Unicode.ttf",
-		}
-	case "linux":
-		// 只使用.ttf文件，Fyne不支持.ttc
-		fontPaths = []string{
-			"/usr/share/fonts/truetype/droid/DroidSansFallbackFull.ttf",
-		}
-	}
-
-	// 尝试加载系统字体文件（只加载.ttf和.otf，跳过.ttc）
-	for _, path := range fontPaths {
-		// 检查文件扩展名，只加载.ttf和.otf文件
-		ext := filepath.Ext(path)
-		if ext != ".ttf" && ext != ".otf" {
-			continue // 跳过非.ttf/.otf文件
-		}
-
-		// 检查文件是否存在
-		if info, err := os.Stat(path); err == nil && !info.IsDir() {
-			// 尝试使用file://协议加载字体
-			uri := storage.NewFileURI(path)
-			res, err := storage.LoadResourceFromURI(uri)
-			if err == nil && res != nil {
-				// 验证字体资源是否有效
-				content := res.Content()
-				if len(content) > 0 {
-					// 字体加载成功
-					return res
-				}
-			}
-			
-			// 如果storage.LoadResourceFromURI失败，尝试直接读取文件
-			if data, err := os.ReadFile(path); err == nil && len(data) > 0 {
-				// 创建内存资源
-				res := fyne.NewStaticResource(filepath.Base(path), data)
-				return res
-			}
-		}
-	}
-
-	// 如果所有字体都加载失败，返回nil（会使用默认字体）
-	return nil
-}
-
-// loadEmbeddedFont 加载嵌入的字体文件
-// 注意：Fyne不支持.ttc（TrueType Collection）字体集合文件，只支持.ttf和.otf
-func loadEmbeddedFont() fyne.Resource {
-	// 直接读取fonts目录下的文件
-	entries, err := embeddedFonts.ReadDir("fonts")
-	if err != nil {
-		return nil
-	}
-
-	// 按优先级查找字体文件（只查找.ttf和.otf，跳过.ttc）
-	preferredNames := []string{"chinese.ttf", "chinese.otf", "msyh.ttf", "simsun.ttf", "font.ttf", "font.otf"}
-
-	// 先查找优先字体
-	for _, preferredName := range preferredNames {
-		for _, entry := range entries {
-			if entry.Name() == preferredName && !entry.IsDir() {
-				data, err := embeddedFonts.ReadFile("fonts/" + preferredName)
-				if err == nil && len(data) > 0 {
-					return fyne.NewStaticResource(preferredName, data)
-				}
-			}
-		}
-	}
-
-	// 如果优先字体没找到，查找任何.ttf或.otf字体文件（跳过.ttc）
-	for _, entry := range entries {
-		if entry.IsDir() {
-			continue
-		}
-
-		name := entry.Name()
-		ext := filepath.Ext(name)
-		// 只加载.ttf和.otf文件，跳过.ttc（Fyne不支持）
-		if ext == ".ttf" || ext == ".otf" {
-			data, err := embeddedFonts.ReadFile("fonts/" + name)
-			if err == nil && len(data) > 0 {
-				return fyne.NewStaticResource(name, data)
-			}
-		}
-	}
-
-	return nil
-}
-
-func (t *chineseTheme) Color(name fyne.ThemeColorName, variant fyne.ThemeVariant) color.Color {
-	return t.baseTheme.Color(name, variant)
-}
-
-func (t *chineseTheme) Icon(name fyne.ThemeIconName) fyne.Resource {
-	return t.baseTheme.Icon(name)
-}
-
-func (t *chineseTheme) Font(style fyne.TextStyle) fyne.Resource {
-	// 使用加载的中文字体
-	return t.chineseFont
-}
-
-func (t *chineseTheme) Size(name fyne.ThemeSizeName) float32 {
-	return t.baseTheme.Size(name)
-}
@@ -2,84 +2,38 @@ package main
 
 import (
 	"bytes"
-	"embed"
 	"encoding/json"
 	"fmt"
-	"image/color"
 	"io"
 	"net/http"
-	"os"
-	"path/filepath"
-	"runtime"
 	"time"
 
 	"fyne.io/fyne/v2"
-	"fyne.io/fyne/v2/app"
-	"fyne.io/fyne/v2/canvas"
 	"fyne.io/fyne/v2/container"
-	"fyne.io/fyne/v2/storage"
-	"fyne.io/fyne/v2/theme"
 	"fyne.io/fyne/v2/widget"
-)
 
-// 嵌入字体文件
-// 使用方法：将中文字体文件复制到 fonts 目录下
-// 支持的格式：.ttf, .otf（注意：Fyne不支持.ttc字体集合文件）
-// 如果没有字体文件，程序会自动使用系统字体作为fallback
-// 注意：如果fonts目录下没有任何字体文件，请至少放置一个字体文件
-//
-//	或者注释掉下面这行，程序会使用系统字体作为fallback
-//
-//go:embed fonts/*
-var embeddedFonts embed.FS
+	"ttt/pkg/log"
+	"ttt/pkg/requestid"
+	"ttt/pkg/ui"
+)
 
 // TestClient 测试客户端
 type TestClient struct {
-	gatewayURL   string
-	logContainer *fyne.Container
-	logScroll    *container.Scroll
+	gatewayURL string
+	logger     *log.Logger
 }
 
 // NewTestClient 创建新的测试客户端
-func NewTestClient(gatewayURL string, logContainer *fyne.Container, logScroll *container.Scroll) *TestClient {
+func NewTestClient(gatewayURL string, logger *log.Logger) *TestClient {
 	return &TestClient{
-		gatewayURL:   gatewayURL,
-		logContainer: logContainer,
-		logScroll:    logScroll,
-	}
-}
-
-// logMessage 添加日志消息（彩色显示）
-func (tc *TestClient) logMessage(msg string, msgColor color.Color) {
-	if tc.logContainer != nil {
-		timestamp := time.Now().Format("15:04:05")
-		fullMsg := fmt.Sprintf("[%s] %s", timestamp, msg)
-
-		// 创建带颜色的文本
-		logText := canvas.NewText(fullMsg, msgColor)
-		logText.TextStyle = fyne.TextStyle{Monospace: true}
-		logText.Alignment = fyne.TextAlignLeading
-
-		// 添加到容器
-		tc.logContainer.Add(logText)
-
-		// 限制日志条目数量（保留最后200条）
-		if len(tc.logContainer.Objects) > 200 {
-			oldObjs := tc.logContainer.Objects
-			tc.logContainer.Objects = oldObjs[len(oldObjs)-200:]
-			tc.logContainer.Refresh()
-		}
-
-		// 滚动到底部
-		if tc.logScroll != nil {
-			tc.logScroll.ScrollToBottom()
-		}
+		gatewayURL: gatewayURL,
+		logger:     logger,
 	}
 }
 
 // sendRequest 发送HTTP请求
 func (tc *TestClient) sendRequest(method, url string, body []byte) {
-	tc.logMessage(fmt.Sprintf("发送请求: %s %s", method, url), color.NRGBA{R: 0, G: 150, B: 255, A: 255})
+	tc.logger.Info("发送请求", log.String("method", method), log.String("url", url))
 
 	var req *http.Request
 	var err error
@@ -87,14 +41,14 @@ func (tc *TestClient) sendRequest(method, url string, body []byte) {
 	if body != nil {
 		req, err = http.NewRequest(method, url, bytes.NewBuffer(body))
 		if err != nil {
-			tc.logMessage(fmt.Sprintf("错误: 创建请求失败 - %v", err), color.NRGBA{R: 255, G: 0, B: 0, A: 255})
+			tc.logger.Error("创建请求失败", log.Err(err))
 			return
 		}
 		req.Header.Set("Content-Type", "application/json")
 	} else {
 		req, err = http.NewRequest(method, url, nil)
 		if err != nil {
-			tc.logMessage(fmt.Sprintf("错误: 创建请求失败 - %v", err), color.NRGBA{R: 255, G: 0, B: 0, A: 255})
+			tc.logger.Error("创建请求失败", log.Err(err))
 			return
 		}
 	}
@@ -102,7 +56,7 @@ func (tc *TestClient) sendRequest(method, url string, body []byte) {
 	client := &http.Client{Timeout: 5 * time.Second}
 	resp, err := client.Do(req)
 	if err != nil {
-		tc.logMessage(fmt.Sprintf("错误: 请求失败 - %v", err), color.NRGBA{R: 255, G: 0, B: 0, A: 255})
+		tc.logger.Error("请求失败", log.Err(err))
 		return
 	}
 	defer resp.Body.Close()
@@ -110,39 +64,43 @@ func (tc *TestClient) sendRequest(method, url string, body []byte) {
 	// 读取响应
 	respBody, err := io.ReadAll(resp.Body)
 	if err != nil {
-		tc.logMessage(fmt.Sprintf("错误: 读取响应失败 - %v", err), color.NRGBA{R: 255, G: 0, B: 0, A: 255})
+		tc.logger.Error("读取响应失败", log.Err(err))
 		return
 	}
 
+	// 记录网关返回的请求ID，便于在各服务日志中查找
+	requestID := log.String("request_id", resp.Header.Get(requestid.Header))
+
 	// 格式化JSON响应
 	var prettyJSON bytes.Buffer
 	if err := json.Indent(&prettyJSON, respBody, "", "  "); err != nil {
 		// 如果不是JSON，直接显示原始内容
-		tc.logMessage(fmt.Sprintf("响应 [%d]: %s", resp.StatusCode, string(respBody)), color.NRGBA{R: 0, G: 200, B: 0, A: 255})
+		tc.logger.Info(fmt.Sprintf("响应 [%d]: %s", resp.StatusCode, string(respBody)), requestID)
 	} else {
-		tc.logMessage(fmt.Sprintf("响应 [%d]:\n%s", resp.StatusCode, prettyJSON.String()), color.NRGBA{R: 0, G: 200, B: 0, A: 255})
+		tc.logger.Info(fmt.Sprintf("响应 [%d]:\n%s", resp.StatusCode, prettyJSON.String()), requestID)
 	}
 }
 
 func main() {
 	// 创建GUI应用
-	myApp := app.New()
-	// 设置支持中文的主题
-	myApp.Settings().SetTheme(newChineseTheme())
+	myApp := ui.NewApp()
 	myWindow := myApp.NewWindow("API测试客户端")
 	myWindow.Resize(fyne.NewSize(800, 600))
 
 	gatewayURL := "http://localhost:8083"
 
-	// 创建日志显示区域
-	logContainer := container.NewVBox()
-	initText := canvas.NewText("API测试客户端就绪...", color.NRGBA{R: 200, G: 200, B: 200, A: 255})
-	initText.TextStyle = fyne.TextStyle{Monospace: true}
-	logContainer.Add(initText)
-	logScroll := container.NewScroll(logContainer)
-	logScroll.SetMinSize(fyne.NewSize(0, 0))
+	// 创建日志：同时输出到标准输出和内存环形缓冲区，GUI订阅缓冲区显示
+	logBuffer := log.NewRingBuffer(200)
+	logger := log.NewFromEnv(logBuffer)
+
+	// 创建日志面板和状态栏
+	logPanel := ui.NewLogPanel(logBuffer, myWindow)
+	statusBar := ui.NewStatusBar()
+	statusBar.SetState(ui.StateRunning)
+	statusBar.Set("网关", gatewayURL)
+	logger.Info("API测试客户端就绪...")
 
-	client := NewTestClient(gatewayURL, logContainer, logScroll)
+	client := NewTestClient(gatewayURL, logger)
 
 	// 创建测试按钮
 	btnGetUsers := widget.NewButton("获取所有用户", func() {
@@ -215,175 +173,6 @@ func main() {
 	)
 
 	// 创建主布局
-	content := container.NewHSplit(
-		container.NewBorder(
-			widget.NewLabel("测试操作"), nil, nil, nil,
-			container.NewScroll(buttonContainer),
-		),
-		container.NewBorder(
-			widget.NewLabel("请求日志"), nil, nil, nil,
-			logScroll,
-		),
-	)
-
-	myWindow.SetContent(content)
+	myWindow.SetContent(ui.ServiceLayout(statusBar, logPanel, "测试操作", container.NewScroll(buttonContainer)))
 	myWindow.ShowAndRun()
 }
-
-// chineseTheme 支持中文的主题
-type chineseTheme struct {
-	baseTheme   fyne.Theme
-	chineseFont fyne.Resource
-}
-
-func newChineseTheme() *chineseTheme {
-	t := &chineseTheme{
-		baseTheme: theme.DefaultTheme(),
-	}
-
-	// 尝试加载系统字体
-	t.chineseFont = loadSystemChineseFont()
-	if t.chineseFont == nil {
-		// 如果找不到系统字体，在Windows上直接使用默认主题字体
-		// Fyne在Windows上会自动使用系统默认字体，通常支持中文
-		t.chineseFont = t.baseTheme.Font(fyne.TextStyle{})
-		if t.chineseFont == nil {
-			t.chineseFont = theme.DefaultTheme().Font(fyne.TextStyle{})
-		}
-	}
-
-	return t
-}
-
-// loadSystemChineseFont 加载中文字体（优先使用嵌入的字体，否则使用系统字体）
-func loadSystemChineseFont() fyne.Resource {
-	// 1. 优先尝试加载嵌入的字体文件
-	if embeddedFont := loadEmbeddedFont(); embeddedFont != nil {
-		return embeddedFont
-	}
-
-	// 2. 如果嵌入字体不存在，尝试加载系统字体
-	var fontPaths []string
-
-	switch runtime.GOOS {
-	case "windows":
-		// Windows字体路径，按优先级排序
-		windir := os.Getenv("WINDIR")
-		if windir == "" {
-			windir = "C:\\Windows"
-		}
-
-		// 注意：Fyne不支持.ttc字体集合，只使用.ttf文件
-		fontPaths = []string{
-			filepath.Join(windir, "Fonts", "simhei.ttf"), // SimHei (黑体)
-			filepath.Join(windir, "Fonts", "simsun.ttf"), // SimSun (宋体)
-			filepath.Join(windir, "Fonts", "simkai.ttf"), // SimKai (楷体)
-			filepath.Join(windir, "Fonts", "simli.ttf"),  // SimLi (隶书)
-		}
-
-		if windir != "C:\\Windows" {
-			fontPaths = append(fontPaths,
-				filepath.Join("C:\\Windows", "Fonts", "simhei.ttf"),
-				filepath.Join("C:\\Windows", "Fonts", "simsun.ttf"),
-			)
-		}
-	case "darwin": // macOS
-		// 只使用.ttf文件，Fyne不支持.ttc
-		fontPaths = []string{
-			"/Library/Fonts/Arial Unicode.ttf",
-		}
-	case "linux":
-		// 只使用.ttf文件，Fyne不支持.ttc
-		fontPaths = []string{
-			"/usr/share/fonts/truetype/droid/DroidSansFallbackFull.ttf",
-		}
-	}
-
-	// 尝试加载系统字体文件（只加载.ttf和.otf，跳过.ttc）
-	for _, path := range fontPaths {
-		// 检查文件扩展名，只加载.ttf和.otf文件
-		ext := filepath.Ext(path)
-		if ext != ".ttf" && ext != ".otf" {
-			continue // 跳过非.ttf/.otf文件
-		}
-
-		if info, err := os.Stat(path); err == nil && !info.IsDir() {
-			uri := storage.NewFileURI(path)
-			res, err := storage.LoadResourceFromURI(uri)
-			if err == nil && res != nil {
-				content := res.Content()
-				if len(content) > 0 {
-					return res
-				}
-			}
-
-			if data, err := os.ReadFile(path); err == nil && len(data) > 0 {
-				res := fyne.NewStaticResource(filepath.Base(path), data)
-				return res
-			}
-		}
-	}
-
-	return nil
-}
-
-// loadEmbeddedFont 加载嵌入的字体文件
-// 注意：Fyne不支持.ttc（TrueType Collection）字体集合文件，只支持.ttf和.otf
-func loadEmbeddedFont() fyne.Resource {
-	// 直接读取fonts目录下的文件
-	entries, err := embeddedFonts.ReadDir("fonts")
-	if err != nil {
-		return nil
-	}
-
-	// 按优先级查找字体文件（只查找.ttf和.otf，跳过.ttc）
-	preferredNames := []string{"chinese.ttf", "chinese.otf", "msyh.ttf", "simsun.ttf", "font.ttf", "font.otf"}
-
-	// 先查找优先字体
-	for _, preferredName := range preferredNames {
-		for _, entry := range entries {
-			if entry.Name() == preferredName && !entry.IsDir() {
-				data, err := embeddedFonts.ReadFile("fonts/" + preferredName)
-				if err == nil && len(data) > 0 {
-					return fyne.NewStaticResource(preferredName, data)
-				}
-			}
-		}
-	}
-
-	// 如果优先字体没找到，查找任何.ttf或.otf字体文件（跳过.ttc）
-	for _, entry := range entries {
-		if entry.IsDir() {
-			continue
-		}
-
-		name := entry.Name()
-		ext := filepath.Ext(name)
-		// 只加载.ttf和.otf文件，跳过.ttc（Fyne不支持）
-		if ext == ".ttf" || ext == ".otf" {
-			data, err := embeddedFonts.ReadFile("fonts/" + name)
-			if err == nil && len(data) > 0 {
-				return fyne.NewStaticResource(name, data)
-			}
-		}
-	}
-
-	return nil
-}
-
-func (t *chineseTheme) Color(name fyne.ThemeColorName, variant fyne.ThemeVariant) color.Color {
-	return t.baseTheme.Color(name, variant)
-}
-
-func (t *chineseTheme) Icon(name fyne.ThemeIconName) fyne.Resource {
-	return t.baseTheme.Icon(name)
-}
-
-func (t *chineseTheme) Font(style fyne.TextStyle) fyne.Resource {
-	// 使用加载的中文字体
-	return t.chineseFont
-}
-
-func (t *chineseTheme) Size(name fyne.ThemeSizeName) float32 {
-	return t.baseTheme.Size(name)
-}
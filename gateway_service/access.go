@@ -0,0 +1,191 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"sort"
+	"strings"
+
+	"ttt/pkg/log"
+	"ttt/pkg/requestid"
+)
+
+// AccessConfig 路由的IP访问控制，按真实的客户端IP检查（见顶层的 trusted_proxies）
+//
+// 条目可以是 CIDR（10.0.0.0/8）、单个IP，或以 @ 开头引用顶层 ip_lists 中的命名列表。
+// 先检查 deny，匹配则拒绝；allow 不为空时只允许匹配的IP。被拒绝的请求返回 403。
+type AccessConfig struct {
+	Allow []string `json:"allow,omitempty"`
+	Deny  []string `json:"deny,omitempty"`
+}
+
+// ipSet 一组网段
+type ipSet []netip.Prefix
+
+// contains 判断IP是否属于任一网段
+func (s ipSet) contains(ip netip.Addr) bool {
+	ip = ip.Unmap().WithZone("")
+	for _, prefix := range s {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseIPSet 解析 CIDR、单个IP和 @命名列表
+func parseIPSet(entries []string, lists map[string]ipSet, label string) (ipSet, []error) {
+	var set ipSet
+	var errs []error
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if name, ok := strings.CutPrefix(entry, "@"); ok {
+			list, found := lists[name]
+			if !found {
+				errs = append(errs, fmt.Errorf("%s 引用了未定义的IP列表 %q", label, name))
+				continue
+			}
+			set = append(set, list...)
+			continue
+		}
+		if strings.Contains(entry, "/") {
+			prefix, err := netip.ParsePrefix(entry)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s 中的网段无效: %q", label, entry))
+				continue
+			}
+			if prefix.Addr().Is4In6() {
+				prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+			}
+			set = append(set, prefix.Masked())
+			continue
+		}
+		ip, err := netip.ParseAddr(entry)
+		if err != nil || ip.Zone() != "" {
+			errs = append(errs, fmt.Errorf("%s 中的IP无效: %q", label, entry))
+			continue
+		}
+		ip = ip.Unmap()
+		set = append(set, netip.PrefixFrom(ip, ip.BitLen()))
+	}
+	return set, errs
+}
+
+// compileIPLists 解析顶层的命名IP列表（列表之间不能互相引用）
+func compileIPLists(cfg map[string][]string) (map[string]ipSet, []error) {
+	names := make([]string, 0, len(cfg))
+	for name := range cfg {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	lists := make(map[string]ipSet, len(cfg))
+	var errs []error
+	for _, name := range names {
+		set, setErrs := parseIPSet(cfg[name], nil, "ip_lists."+name)
+		errs = append(errs, setErrs...)
+		lists[name] = set
+	}
+	return lists, errs
+}
+
+// accessPolicy 编译后的IP访问控制
+type accessPolicy struct {
+	allow ipSet
+	deny  ipSet
+}
+
+// compileAccess 解析路由的 access 配置
+func compileAccess(cfg AccessConfig, lists map[string]ipSet) (*accessPolicy, []error) {
+	allow, errs := parseIPSet(cfg.Allow, lists, "access.allow")
+	deny, denyErrs := parseIPSet(cfg.Deny, lists, "access.deny")
+	errs = append(errs, denyErrs...)
+	if len(cfg.Allow) == 0 && len(cfg.Deny) == 0 {
+		errs = append(errs, errors.New("access 需要设置 allow 或 deny"))
+	}
+	return &accessPolicy{allow: allow, deny: deny}, errs
+}
+
+// allowed 判断客户端IP是否允许访问，无法解析的IP只在没有 allow 列表时允许
+func (p *accessPolicy) allowed(client string) bool {
+	ip, err := netip.ParseAddr(client)
+	if err != nil {
+		return len(p.allow) == 0
+	}
+	if p.deny.contains(ip) {
+		return false
+	}
+	return len(p.allow) == 0 || p.allow.contains(ip)
+}
+
+// checkAccess 按路由的IP访问控制拒绝不允许的客户端
+func (gs *GatewayService) checkAccess(route *Route, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client := clientIP(r)
+		if !route.access.allowed(client) {
+			gs.logger.ForRequest(r).Warn("✗ 客户端IP不允许访问",
+				log.String("route", route.Name),
+				log.String("client_ip", client),
+				log.String("peer", peerIP(r)))
+			requestid.Error(w, r, "Access denied", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// resolveClientIP 返回请求的真实客户端IP
+//
+// 连接来自可信代理时，从右往左检查 X-Forwarded-For，跳过可信代理添加的地址，
+// 第一个不可信的地址就是客户端；客户端自己伪造的 X-Forwarded-For 位于最左侧，不会被采用。
+// 连接不是来自可信代理时忽略 X-Forwarded-For，直接使用连接的远端地址。
+func (t *RouteTable) resolveClientIP(r *http.Request) string {
+	peer := peerIP(r)
+	if len(t.trustedProxies) == 0 {
+		return peer
+	}
+	client, err := netip.ParseAddr(peer)
+	if err != nil {
+		return peer
+	}
+	client = client.Unmap()
+	hops := forwardedFor(r.Header)
+	for i := len(hops) - 1; i >= 0 && t.trustedProxies.contains(client); i-- {
+		hop, err := netip.ParseAddr(hops[i])
+		if err != nil {
+			break // 无法解析的地址之前的内容都不可信
+		}
+		client = hop.Unmap()
+	}
+	return client.String()
+}
+
+// forwardedFor 按顺序返回所有 X-Forwarded-For 请求头中的地址
+func forwardedFor(h http.Header) []string {
+	var hops []string
+	for _, value := range h.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(value, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+	return hops
+}
+
+// clientIP 返回真实的客户端IP（路由处理中按 trusted_proxies 解析），其他情况下为连接的远端地址
+func clientIP(r *http.Request) string {
+	if m := routeFromContext(r.Context()); m != nil && m.clientIP != "" {
+		return m.clientIP
+	}
+	return peerIP(r)
+}
+
+// peerIP 返回连接的远端地址（直接连接网关的客户端或代理）
+func peerIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
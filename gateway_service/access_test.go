@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func mustIPSet(t *testing.T, entries ...string) ipSet {
+	t.Helper()
+	set, errs := parseIPSet(entries, nil, "test")
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	return set
+}
+
+func TestResolveClientIP(t *testing.T) {
+	trusted := mustIPSet(t, "10.0.0.0/8", "::1")
+
+	tests := []struct {
+		name    string
+		trusted ipSet
+		remote  string
+		xff     []string
+		want    string
+	}{
+		{"no trusted proxies ignores xff", nil, "203.0.113.7:1234", []string{"198.51.100.1"}, "203.0.113.7"},
+		{"untrusted peer ignores xff", trusted, "203.0.113.7:1234", []string{"198.51.100.1"}, "203.0.113.7"},
+		{"trusted peer without xff", trusted, "10.0.0.1:1234", nil, "10.0.0.1"},
+		{"trusted peer uses last hop", trusted, "10.0.0.1:1234", []string{"198.51.100.1"}, "198.51.100.1"},
+		// 客户端伪造的地址在最左侧，最右侧的不可信地址才是代理看到的客户端
+		{"spoofed leftmost xff", trusted, "10.0.0.1:1234", []string{"1.2.3.4, 198.51.100.1"}, "198.51.100.1"},
+		{"skips trusted hops", trusted, "10.0.0.1:1234", []string{"1.2.3.4, 198.51.100.1, 10.0.0.2, 10.0.0.3"}, "198.51.100.1"},
+		{"all hops trusted", trusted, "10.0.0.1:1234", []string{"10.0.0.2, 10.0.0.3"}, "10.0.0.2"},
+		{"multiple xff headers", trusted, "10.0.0.1:1234", []string{"1.2.3.4", "198.51.100.1, 10.0.0.2"}, "198.51.100.1"},
+		{"spoofed header before proxy header", trusted, "10.0.0.1:1234", []string{"1.2.3.4", "198.51.100.1"}, "198.51.100.1"},
+		{"malformed hop stops at last trusted", trusted, "10.0.0.1:1234", []string{"1.2.3.4, not-an-ip, 10.0.0.2"}, "10.0.0.2"},
+		{"malformed last hop", trusted, "10.0.0.1:1234", []string{"1.2.3.4, 198.51.100.1:80"}, "10.0.0.1"},
+		{"empty hop", trusted, "10.0.0.1:1234", []string{"1.2.3.4,,"}, "10.0.0.1"},
+		{"ipv4-mapped peer is trusted", trusted, "[::ffff:10.0.0.1]:1234", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"ipv4-mapped hop is unmapped", trusted, "10.0.0.1:1234", []string{"::ffff:198.51.100.1"}, "198.51.100.1"},
+		{"ipv4-mapped trusted hop is skipped", trusted, "10.0.0.1:1234", []string{"198.51.100.1, ::ffff:10.0.0.2"}, "198.51.100.1"},
+		{"ipv6 trusted peer", trusted, "[::1]:1234", []string{"2001:db8::1"}, "2001:db8::1"},
+		{"unparseable remote addr", trusted, "pipe", []string{"198.51.100.1"}, "pipe"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remote
+			for _, v := range tt.xff {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+			table := &RouteTable{trustedProxies: tt.trusted}
+			if got := table.resolveClientIP(r); got != tt.want {
+				t.Errorf("resolveClientIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAccessPolicyAllowed(t *testing.T) {
+	lists := map[string]ipSet{"office": mustIPSet(t, "192.168.0.0/16")}
+
+	tests := []struct {
+		name   string
+		cfg    AccessConfig
+		client string
+		want   bool
+	}{
+		{"allow match", AccessConfig{Allow: []string{"10.0.0.0/8"}}, "10.1.2.3", true},
+		{"allow miss", AccessConfig{Allow: []string{"10.0.0.0/8"}}, "11.1.2.3", false},
+		{"single ip allow", AccessConfig{Allow: []string{"203.0.113.7"}}, "203.0.113.7", true},
+		{"deny only match", AccessConfig{Deny: []string{"10.0.0.0/8"}}, "10.1.2.3", false},
+		{"deny only miss", AccessConfig{Deny: []string{"10.0.0.0/8"}}, "11.1.2.3", true},
+		{"deny over allow", AccessConfig{Allow: []string{"10.0.0.0/8"}, Deny: []string{"10.0.0.5"}}, "10.0.0.5", false},
+		{"deny over allow other ip", AccessConfig{Allow: []string{"10.0.0.0/8"}, Deny: []string{"10.0.0.5"}}, "10.0.0.6", true},
+		{"named list", AccessConfig{Allow: []string{"@office"}}, "192.168.1.1", true},
+		{"named list in deny", AccessConfig{Allow: []string{"0.0.0.0/0"}, Deny: []string{"@office"}}, "192.168.1.1", false},
+		{"ipv4-mapped client", AccessConfig{Allow: []string{"10.0.0.0/8"}}, "::ffff:10.1.2.3", true},
+		{"ipv4-mapped client denied", AccessConfig{Deny: []string{"10.0.0.0/8"}}, "::ffff:10.1.2.3", false},
+		{"ipv4-mapped cidr entry", AccessConfig{Allow: []string{"::ffff:10.0.0.0/104"}}, "10.1.2.3", true},
+		{"ipv6 allow", AccessConfig{Allow: []string{"2001:db8::/32"}}, "2001:db8::1", true},
+		{"ipv6 client not in ipv4 list", AccessConfig{Allow: []string{"0.0.0.0/0"}}, "2001:db8::1", false},
+		{"zoned client", AccessConfig{Allow: []string{"fe80::/10"}}, "fe80::1%eth0", true},
+		{"unparseable client with allow", AccessConfig{Allow: []string{"10.0.0.0/8"}}, "pipe", false},
+		{"unparseable client deny only", AccessConfig{Deny: []string{"10.0.0.0/8"}}, "pipe", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, errs := compileAccess(tt.cfg, lists)
+			if len(errs) > 0 {
+				t.Fatal(errs)
+			}
+			if got := policy.allowed(tt.client); got != tt.want {
+				t.Errorf("allowed(%q) = %v, want %v", tt.client, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	mathrand "math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"ttt/pkg/config"
+	"ttt/pkg/log"
+	"ttt/pkg/requestid"
+)
+
+// accessLog 访问日志：每个请求一行，格式为 Common/Combined Log Format 或 JSON
+type accessLog struct {
+	sink   log.Sink
+	sample float64 // 记录的成功请求比例（0-1），4xx/5xx 和中断的请求总是记录
+}
+
+// newAccessLogFromEnv 根据环境变量创建访问日志，ACCESS_LOG 为空时返回 nil（不记录访问日志）
+//
+//	ACCESS_LOG          stdout 或日志文件路径，为空则不记录
+//	ACCESS_LOG_FORMAT   common/combined/json（默认 combined）
+//	ACCESS_LOG_SAMPLE   记录的成功请求比例 0-1（默认 1），4xx/5xx 响应总是记录
+//	ACCESS_LOG_MAX_MB   单个日志文件最大大小，单位MB（默认 100）
+//	ACCESS_LOG_BACKUPS  保留的旧日志文件个数（默认 5）
+func newAccessLogFromEnv() (*accessLog, error) {
+	target := config.GetEnv("ACCESS_LOG", "")
+	if target == "" {
+		return nil, nil
+	}
+
+	var enc log.Encoder
+	switch format := strings.ToLower(config.GetEnv("ACCESS_LOG_FORMAT", "combined")); format {
+	case "common":
+		enc = clfEncoder{}
+	case "combined":
+		enc = clfEncoder{combined: true}
+	case "json":
+		enc = log.JSONEncoder{}
+	default:
+		return nil, fmt.Errorf("未知的 ACCESS_LOG_FORMAT %q（可用: common, combined, json）", format)
+	}
+
+	sample, err := strconv.ParseFloat(config.GetEnv("ACCESS_LOG_SAMPLE", "1"), 64)
+	if err != nil || sample < 0 || sample > 1 {
+		return nil, fmt.Errorf("ACCESS_LOG_SAMPLE 必须是 0 到 1 之间的数")
+	}
+
+	if target == "stdout" {
+		return &accessLog{sink: log.NewStdoutSink(enc), sample: sample}, nil
+	}
+	maxSize := int64(config.GetEnvInt("ACCESS_LOG_MAX_MB", 100)) << 20
+	backups := config.GetEnvInt("ACCESS_LOG_BACKUPS", 5)
+	rf, err := log.NewRotatingFile(target, maxSize, backups, enc)
+	if err != nil {
+		return nil, fmt.Errorf("无法打开访问日志文件 %s: %w", target, err)
+	}
+	return &accessLog{sink: rf, sample: sample}, nil
+}
+
+// accessEntry 一个请求在处理过程中收集的访问日志信息
+type accessEntry struct {
+	start    time.Time
+	uri      string // 原始的请求URI（中间件可能改写请求的路径和查询参数）
+	path     string
+	query    string
+	clientIP string
+	route    string
+	service  string
+
+	// 最后一次转发的上游实例，以及从发出请求到收到响应头的耗时
+	// （聚合路由并发调用多个上游，由 mu 保护）
+	mu              sync.Mutex
+	instance        string
+	upstream        string
+	upstreamLatency time.Duration
+	attempts        int
+}
+
+type accessEntryKey struct{}
+
+// newAccessEntry 在请求开始时记录原始的请求信息（查询参数中的凭证被替换为 REDACTED）
+func newAccessEntry(r *http.Request, start time.Time) *accessEntry {
+	uri := r.RequestURI
+	if path, query, ok := strings.Cut(uri, "?"); ok {
+		uri = path + "?" + redactQuery(query)
+	}
+	return &accessEntry{start: start, uri: uri, path: r.URL.Path, query: redactQuery(r.URL.RawQuery), clientIP: peerIP(r)}
+}
+
+// credentialParams 访问日志中不记录值的查询参数（小写）
+var credentialParams = map[string]bool{
+	apiKeyQueryParam: true,
+	"apikey":         true,
+	"access_token":   true,
+	"id_token":       true,
+	"refresh_token":  true,
+	"token":          true,
+	"password":       true,
+	"client_secret":  true,
+}
+
+// redactQuery 把查询参数中的凭证替换为 REDACTED，其余参数保持原样（不重新编码、不改变顺序）
+func redactQuery(query string) string {
+	if query == "" {
+		return query
+	}
+	params := strings.Split(query, "&")
+	for i, param := range params {
+		key, _, _ := strings.Cut(param, "=")
+		if name, err := url.QueryUnescape(key); err == nil && credentialParams[strings.ToLower(name)] {
+			params[i] = key + "=REDACTED"
+		}
+	}
+	return strings.Join(params, "&")
+}
+
+// recordUpstream 记录一次转发到上游实例的结果（重试时记录最后一次）
+func recordUpstream(ctx context.Context, upstream *Upstream, latency time.Duration) {
+	if e, ok := ctx.Value(accessEntryKey{}).(*accessEntry); ok {
+		e.mu.Lock()
+		e.instance, e.upstream, e.upstreamLatency = upstream.ID, upstream.URL, latency
+		e.attempts++
+		e.mu.Unlock()
+	}
+}
+
+// write 写出一条访问日志，status 为 0 表示没有完整返回响应（连接被中止，包括返回响应体的过程中）
+func (a *accessLog) write(r *http.Request, e *accessEntry, status int, size int64, now time.Time) {
+	if a == nil {
+		return
+	}
+	if status > 0 && status < 400 && a.sample < 1 && mathrand.Float64() >= a.sample {
+		return
+	}
+	e.mu.Lock()
+	instance, upstream, upstreamLatency, attempts := e.instance, e.upstream, e.upstreamLatency, e.attempts
+	e.mu.Unlock()
+	entry := log.Entry{
+		Time:    e.start,
+		Level:   log.InfoLevel,
+		Message: "access",
+		Fields: []log.Field{
+			log.String("request_id", requestid.FromRequest(r)),
+			log.String("client_ip", e.clientIP),
+			log.String("user", r.Header.Get("X-User-ID")),
+			log.String("method", r.Method),
+			log.String("host", r.Host),
+			log.String("uri", e.uri),
+			log.String("path", e.path),
+			log.String("query", e.query),
+			log.String("proto", r.Proto),
+			log.Int("status", status),
+			log.Int64("bytes", size),
+			log.Float64("duration_ms", milliseconds(now.Sub(e.start))),
+			log.String("route", e.route),
+			log.String("service", e.service),
+			log.String("instance", instance),
+			log.String("upstream", upstream),
+			log.Float64("upstream_ms", milliseconds(upstreamLatency)),
+			log.Int("attempts", attempts),
+			log.String("referer", r.Referer()),
+			log.String("user_agent", r.UserAgent()),
+		},
+	}
+	a.sink.Write(entry)
+}
+
+// milliseconds 以毫秒为单位的时长（保留3位小数）
+func milliseconds(d time.Duration) float64 {
+	return math.Round(float64(d)/float64(time.Microsecond)) / 1000
+}
+
+// clfEncoder Common Log Format，combined 为 true 时为 Combined Log Format（追加 Referer 和 User-Agent），如：
+//
+//	10.0.0.1 - alice [02/Jan/2024:15:04:05 +0800] "GET /api/user?id=1 HTTP/1.1" 200 42 "-" "curl/8.4.0"
+type clfEncoder struct {
+	combined bool
+}
+
+// Encode 实现 log.Encoder，从访问日志的字段中取值
+func (c clfEncoder) Encode(e log.Entry) []byte {
+	fields := make(map[string]interface{}, len(e.Fields))
+	for _, f := range e.Fields {
+		fields[f.Key] = f.Value
+	}
+	str := func(key string) string {
+		s, _ := fields[key].(string)
+		return s
+	}
+	dash := func(s string) string {
+		if s == "" {
+			return "-"
+		}
+		return s
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(dash(str("client_ip")))
+	buf.WriteString(" - ")
+	buf.WriteString(dash(clfEscape(str("user"))))
+	buf.WriteString(e.Time.Format(" [02/Jan/2006:15:04:05 -0700] "))
+	buf.WriteByte('"')
+	buf.WriteString(clfEscape(str("method") + " " + str("uri") + " " + str("proto")))
+	buf.WriteString(`" `)
+	fmt.Fprint(&buf, fields["status"])
+	if size, _ := fields["bytes"].(int64); size > 0 {
+		buf.WriteString(" " + strconv.FormatInt(size, 10))
+	} else {
+		buf.WriteString(" -")
+	}
+	if c.combined {
+		buf.WriteString(` "` + dash(clfEscape(str("referer"))) + `" "` + dash(clfEscape(str("user_agent"))) + `"`)
+	}
+	return buf.Bytes()
+}
+
+// clfEscape 转义引号、反斜杠和不可打印字符（与 Apache 的访问日志相同），避免伪造日志行
+func clfEscape(s string) string {
+	var buf strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch b := s[i]; {
+		case b == '"' || b == '\\':
+			buf.WriteByte('\\')
+			buf.WriteByte(b)
+		case b < 0x20 || b >= 0x7f:
+			fmt.Fprintf(&buf, `\x%02x`, b)
+		default:
+			buf.WriteByte(b)
+		}
+	}
+	return buf.String()
+}
@@ -0,0 +1,496 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"ttt/pkg/config"
+	"ttt/pkg/log"
+	"ttt/pkg/requestid"
+)
+
+// 管理接口的路径
+const (
+	adminRoutesPath    = "/admin/routes"
+	adminUpstreamsPath = "/admin/upstreams"
+	adminRegistryPath  = "/admin/registry/refresh"
+	adminLogLevelPath  = "/admin/log-level"
+	adminAPIKeysPath   = "/admin/api-keys"
+	adminCachePath     = "/admin/cache"
+	adminSplitsPath    = "/admin/splits"
+	adminMirrorsPath   = "/admin/mirrors"
+	adminFaultsPath    = "/admin/faults"
+)
+
+// adminHandler 管理接口，在单独的端口上提供服务（GATEWAY_ADMIN_PORT），所有接口都需要通过 requireAdmin
+func (gs *GatewayService) adminHandler() http.Handler {
+	mux := http.NewServeMux()
+	handle := func(path string, h http.HandlerFunc) {
+		mux.HandleFunc(path, gs.requireAdmin(h))
+	}
+	handle(adminRoutesPath, gs.handleRoutes)
+	handle(adminRoutesPath+"/", gs.handleRouteAdmin)
+	handle(adminUpstreamsPath, gs.handleUpstreams)
+	handle(adminRegistryPath, gs.handleRegistryRefresh)
+	handle(adminLogLevelPath, gs.handleLogLevel)
+	handle(adminAPIKeysPath, gs.handleAPIKeys)
+	handle(adminAPIKeysPath+"/", gs.handleAPIKey)
+	handle(adminCachePath, gs.handleCache)
+	handle(adminCachePath+"/purge", gs.handleCachePurge)
+	handle(adminSplitsPath, gs.handleSplits)
+	handle(adminSplitsPath+"/", gs.handleSplit)
+	handle(adminMirrorsPath, gs.handleMirrors)
+	handle(adminFaultsPath, gs.handleFaults)
+	handle(adminFaultsPath+"/", gs.handleFault)
+	return mux
+}
+
+// loadAdminToken 返回管理接口的访问令牌和监听的地址
+//
+// 设置了 GATEWAY_ADMIN_TOKEN 时使用该令牌，管理接口监听所有网卡；
+// 否则在启动时生成随机令牌并写入 GATEWAY_ADMIN_TOKEN_FILE（默认 admin_token，只有当前用户可读），管理接口只监听本机。
+// 管理接口总是需要令牌，本机的其他程序也不能直接访问
+func loadAdminToken(logger *log.Logger) (token, host string, err error) {
+	if token = config.GetEnv("GATEWAY_ADMIN_TOKEN", ""); token != "" {
+		return token, "", nil
+	}
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token = hex.EncodeToString(b)
+	file := config.GetEnv("GATEWAY_ADMIN_TOKEN_FILE", "admin_token")
+	if err := os.WriteFile(file, []byte(token+"\n"), 0o600); err != nil {
+		return "", "", fmt.Errorf("无法写入管理接口令牌文件 %s: %w", file, err)
+	}
+	// 文件已存在时 WriteFile 不会修改权限
+	if err := os.Chmod(file, 0o600); err != nil {
+		return "", "", err
+	}
+	logger.Info("管理接口只监听本机，访问令牌已写入文件", log.String("file", file))
+	return token, "127.0.0.1", nil
+}
+
+// requireAdmin 管理接口的访问控制：需要携带 Authorization: Bearer <token>（见 loadAdminToken）
+func (gs *GatewayService) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := bearerToken(r)
+		if gs.adminToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(gs.adminToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="gateway-admin"`)
+			requestid.Error(w, r, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// writeJSON 返回JSON响应
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+// decodeJSONBody 严格解析请求体（未知字段视为错误），请求体为空时保留默认值
+func decodeJSONBody(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	return nil
+}
+
+// handleRoutes 列出所有路由及请求统计（累计和最近1分钟）
+//
+//	GET /admin/routes
+func (gs *GatewayService) handleRoutes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		requestid.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, gs.routeStatuses(""))
+}
+
+// handleRouteAdmin 查看、临时停用和恢复单条路由
+//
+//	GET  /admin/routes/{route}          查看路由及请求统计
+//	POST /admin/routes/{route}/disable  停用路由 {"duration": "10m", "reason": "..."}，期间请求返回 503，到期后自动恢复
+//	POST /admin/routes/{route}/enable   恢复路由
+func (gs *GatewayService) handleRouteAdmin(w http.ResponseWriter, r *http.Request) {
+	name, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, adminRoutesPath+"/"), "/")
+	if name == "" || len(gs.routeStatuses(name)) == 0 {
+		requestid.Error(w, r, "Route not found", http.StatusNotFound)
+		return
+	}
+
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+	case action == "disable" && r.Method == http.MethodPost:
+		var req struct {
+			Duration Duration `json:"duration"`
+			Reason   string   `json:"reason"`
+		}
+		if err := decodeJSONBody(w, r, &req); err != nil || req.Duration.Duration < 0 {
+			requestid.Error(w, r, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		duration := req.Duration.Duration
+		if duration == 0 {
+			duration = defaultDisableDuration
+		}
+		gs.setRouteDisabled(name, &RouteDisable{Reason: req.Reason, Until: time.Now().Add(duration)})
+		gs.logger.ForRequest(r).Warn("⚠ 停用路由", log.String("route", name), log.String("reason", req.Reason), log.Duration("duration", duration))
+	case action == "enable" && r.Method == http.MethodPost:
+		gs.setRouteDisabled(name, nil)
+		gs.logger.ForRequest(r).Info("✓ 恢复路由", log.String("route", name))
+	case action == "" || action == "disable" || action == "enable":
+		requestid.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	default:
+		requestid.Error(w, r, "Not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, gs.routeStatuses(name)[0])
+}
+
+// handleUpstreams 列出已发现的服务实例：熔断器状态、统计窗口内的请求数和失败数、是否被摘除
+//
+//	GET /admin/upstreams
+func (gs *GatewayService) handleUpstreams(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		requestid.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, gs.upstreamList())
+}
+
+// handleRegistryRefresh 立即从注册中心刷新服务实例，返回刷新后的实例列表
+//
+//	POST /admin/registry/refresh
+func (gs *GatewayService) handleRegistryRefresh(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		requestid.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := gs.RefreshAllServices(); err != nil {
+		requestid.Error(w, r, "Registry unavailable: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	gs.logger.ForRequest(r).Info("✓ 已从注册中心刷新服务列表")
+	writeJSON(w, http.StatusOK, gs.upstreamList())
+}
+
+// handleLogLevel 查看和修改日志级别（不需要重启，重启后恢复为 LOG_LEVEL）
+//
+//	GET /admin/log-level
+//	PUT /admin/log-level  {"level": "debug"}
+func (gs *GatewayService) handleLogLevel(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var req struct {
+			Level string `json:"level"`
+		}
+		if err := decodeJSONBody(w, r, &req); err != nil {
+			requestid.Error(w, r, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		level, err := log.ParseLevel(req.Level)
+		if err != nil {
+			requestid.Error(w, r, "Invalid level (debug, info, warn, error)", http.StatusBadRequest)
+			return
+		}
+		from := gs.logger.Level()
+		gs.logger.SetLevel(level)
+		// 调高级别后这条日志可能不再输出，用 Warn 尽量保留修改记录
+		gs.logger.ForRequest(r).Warn("✓ 修改日志级别", log.String("from", from.String()), log.String("to", level.String()))
+	default:
+		requestid.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"level": gs.logger.Level().String()})
+}
+
+// handleAPIKeys 列出和创建API Key
+//
+//	GET  /admin/api-keys  列出所有Key及使用统计
+//	POST /admin/api-keys  创建Key，响应中的 key 只返回这一次
+func (gs *GatewayService) handleAPIKeys(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, gs.apiKeys.List(time.Now()))
+	case http.MethodPost:
+		var req APIKeyRequest
+		if err := decodeJSONBody(w, r, &req); err != nil {
+			requestid.Error(w, r, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		info, key, err := gs.apiKeys.Create(req, time.Now())
+		if err != nil {
+			requestid.Error(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+		gs.logger.ForRequest(r).Info("✓ 创建API Key", log.String("key_id", info.ID), log.String("owner", info.Owner))
+		writeJSON(w, http.StatusCreated, map[string]interface{}{"key": key, "api_key": info})
+	default:
+		requestid.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleAPIKey 管理单个API Key
+//
+//	GET    /admin/api-keys/{id}         查看Key及使用统计
+//	POST   /admin/api-keys/{id}/rotate  轮换密钥，可选请求体 {"grace": "1h"} 让旧密钥在宽限期内继续有效
+//	DELETE /admin/api-keys/{id}         吊销Key
+func (gs *GatewayService) handleAPIKey(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, adminAPIKeysPath+"/")
+	id, action, _ := strings.Cut(rest, "/")
+	if id == "" {
+		requestid.Error(w, r, "Missing key id", http.StatusNotFound)
+		return
+	}
+
+	now := time.Now()
+	var info APIKeyInfo
+	var err error
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		info, err = gs.apiKeys.Get(id, now)
+	case action == "" && r.Method == http.MethodDelete:
+		if info, err = gs.apiKeys.Revoke(id, now); err == nil {
+			gs.logger.ForRequest(r).Warn("API Key 已吊销", log.String("key_id", id), log.String("owner", info.Owner))
+		}
+	case action == "rotate" && r.Method == http.MethodPost:
+		var req struct {
+			Grace Duration `json:"grace"`
+		}
+		if err := decodeJSONBody(w, r, &req); err != nil || req.Grace.Duration < 0 {
+			requestid.Error(w, r, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		var key string
+		if info, key, err = gs.apiKeys.Rotate(id, req.Grace.Duration, now); err == nil {
+			gs.logger.ForRequest(r).Info("✓ API Key 已轮换",
+				log.String("key_id", id),
+				log.String("owner", info.Owner),
+				log.Duration("grace", req.Grace.Duration))
+			writeJSON(w, http.StatusOK, map[string]interface{}{"key": key, "api_key": info})
+			return
+		}
+	case action == "" || action == "rotate":
+		requestid.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	default:
+		requestid.Error(w, r, "Not found", http.StatusNotFound)
+		return
+	}
+
+	switch {
+	case errors.Is(err, errAPIKeyNotFound):
+		requestid.Error(w, r, "API key not found", http.StatusNotFound)
+	case errors.Is(err, errAPIKeyRevoked):
+		requestid.Error(w, r, "API key has been revoked", http.StatusConflict)
+	case err != nil:
+		requestid.Error(w, r, err.Error(), http.StatusInternalServerError)
+	default:
+		writeJSON(w, http.StatusOK, info)
+	}
+}
+
+// handleCache 查看响应缓存的统计
+//
+//	GET /admin/cache
+func (gs *GatewayService) handleCache(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		requestid.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, gs.cache.Stats(gs.cacheConfig()))
+}
+
+// handleCachePurge 清除响应缓存
+//
+//	POST /admin/cache/purge  {"key": "/api/user-service/user?id=1"} 清除一个地址的缓存（包括所有 Vary 变体）
+//	POST /admin/cache/purge  {"prefix": "/api/user-service/"}       清除路径以 prefix 开头的缓存，"/" 清除全部
+func (gs *GatewayService) handleCachePurge(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		requestid.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		Key    string `json:"key"`
+		Prefix string `json:"prefix"`
+	}
+	if err := decodeJSONBody(w, r, &req); err != nil {
+		requestid.Error(w, r, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Key == "" && req.Prefix == "" {
+		requestid.Error(w, r, "key or prefix is required", http.StatusBadRequest)
+		return
+	}
+	purged := gs.cache.Purge(req.Key, req.Prefix)
+	gs.logger.ForRequest(r).Info("✓ 清除响应缓存",
+		log.String("key", req.Key),
+		log.String("prefix", req.Prefix),
+		log.Int("purged", purged))
+	writeJSON(w, http.StatusOK, map[string]int{"purged": purged})
+}
+
+// splitRoutes 返回当前路由表中配置了 split 的路由
+func (gs *GatewayService) splitRoutes() []*Route {
+	var routes []*Route
+	if table := gs.routes.Load(); table != nil {
+		for _, route := range table.Routes {
+			if route.split != nil {
+				routes = append(routes, route)
+			}
+		}
+	}
+	return routes
+}
+
+// handleSplits 查看所有路由的流量分配和各版本最近1分钟的错误率
+//
+//	GET /admin/splits
+func (gs *GatewayService) handleSplits(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		requestid.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	statuses := []SplitStatus{}
+	for _, route := range gs.splitRoutes() {
+		statuses = append(statuses, gs.splitStatus(route))
+	}
+	writeJSON(w, http.StatusOK, statuses)
+}
+
+// handleSplit 管理单条路由的流量分配
+//
+//	GET    /admin/splits/{route}  查看流量分配
+//	PUT    /admin/splits/{route}  修改权重 {"weights": {"v1": 95, "v2": 5}}，重新加载配置后仍然生效
+//	DELETE /admin/splits/{route}  恢复配置文件中的权重
+func (gs *GatewayService) handleSplit(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, adminSplitsPath+"/")
+	var route *Route
+	for _, rt := range gs.splitRoutes() {
+		if rt.Name == name {
+			route = rt
+		}
+	}
+	if route == nil {
+		requestid.Error(w, r, "Route not found or has no split", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var req struct {
+			Weights map[string]int `json:"weights"`
+		}
+		if err := decodeJSONBody(w, r, &req); err != nil {
+			requestid.Error(w, r, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := validateWeights(req.Weights); err != nil {
+			requestid.Error(w, r, "Invalid weights: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		gs.setSplitWeights(route.Name, req.Weights)
+		gs.logger.ForRequest(r).Warn("✓ 修改流量分配", log.String("route", route.Name), log.Any("weights", req.Weights))
+	case http.MethodDelete:
+		gs.setSplitWeights(route.Name, nil)
+		gs.logger.ForRequest(r).Info("✓ 恢复配置文件中的流量分配", log.String("route", route.Name))
+	default:
+		requestid.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, gs.splitStatus(route))
+}
+
+// handleMirrors 查看流量复制的统计：影子请求数、失败数、与主请求状态码不同的比例和平均耗时
+//
+//	GET /admin/mirrors
+func (gs *GatewayService) handleMirrors(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		requestid.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, gs.mirrors.Snapshot())
+}
+
+// handleFaults 查看各路由生效的故障和注入次数
+//
+//	GET /admin/faults
+func (gs *GatewayService) handleFaults(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		requestid.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, gs.faultStatuses(""))
+}
+
+// handleFault 在运行时开启或关闭路由的故障注入
+//
+//	GET    /admin/faults/{route}  查看路由的故障
+//	PUT    /admin/faults/{route}  开启故障 {"fault": {"percent": 10, "abort": 503}, "duration": "10m"}，到期后自动关闭
+//	DELETE /admin/faults/{route}  关闭管理接口开启的故障（配置文件中的故障继续生效）
+func (gs *GatewayService) handleFault(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, adminFaultsPath+"/")
+	statuses := gs.faultStatuses(name)
+	if len(statuses) == 0 {
+		requestid.Error(w, r, "Route not found", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var req struct {
+			Fault    *FaultConfig `json:"fault"`
+			Duration Duration     `json:"duration"`
+		}
+		if err := decodeJSONBody(w, r, &req); err != nil {
+			requestid.Error(w, r, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Fault == nil {
+			requestid.Error(w, r, "fault is required", http.StatusBadRequest)
+			return
+		}
+		if errs := req.Fault.validate(); len(errs) > 0 {
+			requestid.Error(w, r, "Invalid fault: "+errors.Join(errs...).Error(), http.StatusBadRequest)
+			return
+		}
+		duration, err := parseFaultDuration(req.Duration)
+		if err != nil {
+			requestid.Error(w, r, "Invalid duration: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		gs.setRuntimeFault(name, req.Fault, time.Now().Add(duration))
+		gs.logger.ForRequest(r).Warn("⚡ 开启故障注入",
+			log.String("route", name),
+			log.String("fault", faultLabel(req.Fault)),
+			log.Float64("percent", req.Fault.Percent),
+			log.String("header", req.Fault.Header),
+			log.Duration("duration", duration))
+	case http.MethodDelete:
+		gs.setRuntimeFault(name, nil, time.Time{})
+		gs.logger.ForRequest(r).Info("✓ 关闭故障注入", log.String("route", name))
+	default:
+		requestid.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, gs.faultStatuses(name)[0])
+}
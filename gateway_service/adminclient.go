@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// AdminClient 管理接口的客户端，网关的GUI通过它显示状态和执行操作，与外部工具使用同一套接口
+type AdminClient struct {
+	baseURL string
+	token   string
+	http    *http.Client
+}
+
+// NewAdminClient 创建管理接口客户端；tlsConfig 不为 nil 时使用 HTTPS
+func NewAdminClient(port int, token string, tlsConfig *tls.Config) *AdminClient {
+	c := &AdminClient{
+		baseURL: fmt.Sprintf("http://localhost:%d", port),
+		token:   token,
+		http:    &http.Client{Timeout: 5 * time.Second},
+	}
+	if tlsConfig != nil {
+		c.baseURL = fmt.Sprintf("https://localhost:%d", port)
+		c.http.Transport = &http.Transport{TLSClientConfig: tlsConfig, ForceAttemptHTTP2: true}
+	}
+	return c
+}
+
+// Routes 所有路由及请求统计
+func (c *AdminClient) Routes() ([]RouteStatus, error) {
+	var routes []RouteStatus
+	return routes, c.do(http.MethodGet, adminRoutesPath, nil, &routes)
+}
+
+// Upstreams 已发现的服务实例
+func (c *AdminClient) Upstreams() ([]UpstreamServiceStatus, error) {
+	var upstreams []UpstreamServiceStatus
+	return upstreams, c.do(http.MethodGet, adminUpstreamsPath, nil, &upstreams)
+}
+
+// RefreshRegistry 立即从注册中心刷新服务实例
+func (c *AdminClient) RefreshRegistry() error {
+	return c.do(http.MethodPost, adminRegistryPath, nil, nil)
+}
+
+// DisableRoute 临时停用路由，duration 为 0 时使用默认时长
+func (c *AdminClient) DisableRoute(name, reason string, duration time.Duration) error {
+	body := map[string]string{"reason": reason}
+	if duration > 0 {
+		body["duration"] = duration.String()
+	}
+	return c.do(http.MethodPost, adminRoutesPath+"/"+url.PathEscape(name)+"/disable", body, nil)
+}
+
+// EnableRoute 恢复停用的路由
+func (c *AdminClient) EnableRoute(name string) error {
+	return c.do(http.MethodPost, adminRoutesPath+"/"+url.PathEscape(name)+"/enable", nil, nil)
+}
+
+// LogLevel 当前日志级别
+func (c *AdminClient) LogLevel() (string, error) {
+	var resp struct {
+		Level string `json:"level"`
+	}
+	return resp.Level, c.do(http.MethodGet, adminLogLevelPath, nil, &resp)
+}
+
+// SetLogLevel 修改日志级别
+func (c *AdminClient) SetLogLevel(level string) error {
+	return c.do(http.MethodPut, adminLogLevelPath, map[string]string{"level": level}, nil)
+}
+
+// do 发送请求并解析JSON响应，非2xx响应返回错误（包含响应中的错误信息）
+func (c *AdminClient) do(method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, c.baseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("管理接口返回 %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
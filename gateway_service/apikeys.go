@@ -0,0 +1,442 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// API Key 相关的请求头和查询参数
+const (
+	apiKeyHeader     = "X-API-Key"     // 客户端携带API Key的请求头
+	apiKeyQueryParam = "api_key"       // 客户端携带API Key的查询参数
+	apiKeyIDHeader   = "X-API-Key-ID"  // 验证通过后转发给上游的Key ID
+	consumerIDHeader = "X-Consumer-ID" // 验证通过后转发给上游的Key所有者
+)
+
+// apiKeyPrefix 生成的API Key的前缀，格式为 gk_<id>_<secret>
+const apiKeyPrefix = "gk_"
+
+var (
+	errAPIKeyInvalid   = errors.New("API Key 无效")
+	errAPIKeyExpired   = errors.New("API Key 已过期")
+	errAPIKeyRevoked   = errors.New("API Key 已吊销")
+	errAPIKeyForbidden = errors.New("API Key 无权访问该路由")
+	errAPIKeyNotFound  = errors.New("API Key 不存在")
+)
+
+// APIKeyQuota 配额：每个 period 最多 requests 次请求（按固定时间窗口计算）
+type APIKeyQuota struct {
+	Requests int64    `json:"requests"`
+	Period   Duration `json:"period"`
+}
+
+// APIKey 一个API Key的记录，只保存密钥的SHA-256哈希
+type APIKey struct {
+	ID        string       `json:"id"`
+	Owner     string       `json:"owner"`
+	Routes    []string     `json:"routes"` // 允许访问的路由名称，"*" 表示所有路由
+	Quota     *APIKeyQuota `json:"quota,omitempty"`
+	ExpiresAt *time.Time   `json:"expires_at,omitempty"`
+	CreatedAt time.Time    `json:"created_at"`
+	RotatedAt *time.Time   `json:"rotated_at,omitempty"`
+	RevokedAt *time.Time   `json:"revoked_at,omitempty"`
+
+	Hash string `json:"hash"`
+	// 轮换后旧密钥在宽限期内仍然有效
+	PreviousHash      string     `json:"previous_hash,omitempty"`
+	PreviousExpiresAt *time.Time `json:"previous_expires_at,omitempty"`
+}
+
+// allowsRoute 判断Key是否可以访问路由
+func (k *APIKey) allowsRoute(route string) bool {
+	for _, r := range k.Routes {
+		if r == "*" || r == route {
+			return true
+		}
+	}
+	return false
+}
+
+// APIKeyUsage 单个Key的使用统计（保存在内存中，网关重启后清零）
+type APIKeyUsage struct {
+	Requests    int64      `json:"requests"`
+	Rejected    int64      `json:"rejected"`
+	LastUsedAt  *time.Time `json:"last_used_at,omitempty"`
+	QuotaUsed   int64      `json:"quota_used"`             // 当前配额窗口内已使用的请求数
+	QuotaResets *time.Time `json:"quota_resets,omitempty"` // 当前配额窗口结束的时间
+
+	windowStart time.Time
+}
+
+// APIKeyInfo API Key 及其使用统计（用于管理API，不包含哈希）
+type APIKeyInfo struct {
+	ID        string       `json:"id"`
+	Owner     string       `json:"owner"`
+	Routes    []string     `json:"routes"`
+	Quota     *APIKeyQuota `json:"quota,omitempty"`
+	ExpiresAt *time.Time   `json:"expires_at,omitempty"`
+	CreatedAt time.Time    `json:"created_at"`
+	RotatedAt *time.Time   `json:"rotated_at,omitempty"`
+	RevokedAt *time.Time   `json:"revoked_at,omitempty"`
+	Status    string       `json:"status"` // active / expired / revoked
+	Usage     APIKeyUsage  `json:"usage"`
+}
+
+// APIKeyStore API Key 存储，保存在本地JSON文件中，每次修改后整体写回
+type APIKeyStore struct {
+	path string
+
+	mu    sync.Mutex
+	keys  map[string]*APIKey // ID -> Key
+	usage map[string]*APIKeyUsage
+}
+
+// apiKeysFile API Key 文件的格式
+type apiKeysFile struct {
+	Keys []*APIKey `json:"keys"`
+}
+
+// LoadAPIKeyStore 从文件加载API Key，文件不存在时返回空的存储
+func LoadAPIKeyStore(path string) (*APIKeyStore, error) {
+	s := &APIKeyStore{path: path, keys: make(map[string]*APIKey), usage: make(map[string]*APIKeyUsage)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var file apiKeysFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, describeJSONError(data, err)
+	}
+	for _, k := range file.Keys {
+		s.keys[k.ID] = k
+	}
+	return s, nil
+}
+
+// save 把所有Key写回文件（先写临时文件再重命名，调用方需持有锁）
+func (s *APIKeyStore) save() error {
+	file := apiKeysFile{Keys: make([]*APIKey, 0, len(s.keys))}
+	for _, k := range s.keys {
+		file.Keys = append(file.Keys, k)
+	}
+	sort.Slice(file.Keys, func(i, j int) bool { return file.Keys[i].CreatedAt.Before(file.Keys[j].CreatedAt) })
+
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".api_keys-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+// hashAPIKey 计算API Key的哈希（Key是高熵随机数，不需要慢哈希）
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// newAPIKeySecret 生成Key ID对应的完整API Key
+func newAPIKeySecret(id string) (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return apiKeyPrefix + id + "_" + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// parseAPIKeyID 从API Key中取出Key ID
+func parseAPIKeyID(key string) (string, bool) {
+	if !strings.HasPrefix(key, apiKeyPrefix) {
+		return "", false
+	}
+	id, _, ok := strings.Cut(key[len(apiKeyPrefix):], "_")
+	return id, ok && id != ""
+}
+
+// APIKeyRequest 创建API Key的参数
+type APIKeyRequest struct {
+	Owner     string       `json:"owner"`
+	Routes    []string     `json:"routes"`
+	Quota     *APIKeyQuota `json:"quota,omitempty"`
+	ExpiresAt *time.Time   `json:"expires_at,omitempty"`
+	TTL       Duration     `json:"ttl,omitempty"` // 与 expires_at 二选一
+}
+
+// validate 校验创建参数
+func (req *APIKeyRequest) validate(now time.Time) error {
+	var errs []error
+	if strings.TrimSpace(req.Owner) == "" {
+		errs = append(errs, errors.New("缺少 owner"))
+	}
+	if len(req.Routes) == 0 {
+		errs = append(errs, errors.New("routes 不能为空（\"*\" 表示所有路由）"))
+	}
+	if req.Quota != nil && (req.Quota.Requests <= 0 || req.Quota.Period.Duration <= 0) {
+		errs = append(errs, errors.New("quota.requests 和 quota.period 必须大于 0"))
+	}
+	if req.ExpiresAt != nil && req.TTL.Duration != 0 {
+		errs = append(errs, errors.New("expires_at 和 ttl 不能同时设置"))
+	}
+	if req.TTL.Duration < 0 || (req.ExpiresAt != nil && !req.ExpiresAt.After(now)) {
+		errs = append(errs, errors.New("过期时间必须在当前时间之后"))
+	}
+	return errors.Join(errs...)
+}
+
+// Create 创建API Key，返回的完整Key只在创建时出现一次
+func (s *APIKeyStore) Create(req APIKeyRequest, now time.Time) (APIKeyInfo, string, error) {
+	if err := req.validate(now); err != nil {
+		return APIKeyInfo{}, "", err
+	}
+	idBytes := make([]byte, 8)
+	if _, err := rand.Read(idBytes); err != nil {
+		return APIKeyInfo{}, "", err
+	}
+	id := hex.EncodeToString(idBytes)
+	secret, err := newAPIKeySecret(id)
+	if err != nil {
+		return APIKeyInfo{}, "", err
+	}
+
+	k := &APIKey{
+		ID:        id,
+		Owner:     strings.TrimSpace(req.Owner),
+		Routes:    req.Routes,
+		Quota:     req.Quota,
+		ExpiresAt: req.ExpiresAt,
+		CreatedAt: now,
+		Hash:      hashAPIKey(secret),
+	}
+	if req.TTL.Duration > 0 {
+		expires := now.Add(req.TTL.Duration)
+		k.ExpiresAt = &expires
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[id] = k
+	if err := s.save(); err != nil {
+		delete(s.keys, id)
+		return APIKeyInfo{}, "", fmt.Errorf("保存API Key失败: %w", err)
+	}
+	return s.info(k, now), secret, nil
+}
+
+// Rotate 为Key生成新的密钥，旧密钥在 grace 时间内仍然有效（0 表示立即失效）
+func (s *APIKeyStore) Rotate(id string, grace time.Duration, now time.Time) (APIKeyInfo, string, error) {
+	secret, err := newAPIKeySecret(id)
+	if err != nil {
+		return APIKeyInfo{}, "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k, ok := s.keys[id]
+	if !ok {
+		return APIKeyInfo{}, "", errAPIKeyNotFound
+	}
+	if k.RevokedAt != nil {
+		return APIKeyInfo{}, "", errAPIKeyRevoked
+	}
+	old := *k
+	k.PreviousHash, k.PreviousExpiresAt = "", nil
+	if grace > 0 {
+		until := now.Add(grace)
+		k.PreviousHash, k.PreviousExpiresAt = k.Hash, &until
+	}
+	k.Hash = hashAPIKey(secret)
+	k.RotatedAt = &now
+	if err := s.save(); err != nil {
+		*k = old
+		return APIKeyInfo{}, "", fmt.Errorf("保存API Key失败: %w", err)
+	}
+	return s.info(k, now), secret, nil
+}
+
+// Revoke 吊销Key（记录保留，便于查看历史）
+func (s *APIKeyStore) Revoke(id string, now time.Time) (APIKeyInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k, ok := s.keys[id]
+	if !ok {
+		return APIKeyInfo{}, errAPIKeyNotFound
+	}
+	if k.RevokedAt == nil {
+		k.RevokedAt = &now
+		if err := s.save(); err != nil {
+			k.RevokedAt = nil
+			return APIKeyInfo{}, fmt.Errorf("保存API Key失败: %w", err)
+		}
+	}
+	return s.info(k, now), nil
+}
+
+// Get 返回单个Key的信息
+func (s *APIKeyStore) Get(id string, now time.Time) (APIKeyInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k, ok := s.keys[id]
+	if !ok {
+		return APIKeyInfo{}, errAPIKeyNotFound
+	}
+	return s.info(k, now), nil
+}
+
+// List 返回所有Key的信息（按创建时间排序）
+func (s *APIKeyStore) List(now time.Time) []APIKeyInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := make([]APIKeyInfo, 0, len(s.keys))
+	for _, k := range s.keys {
+		list = append(list, s.info(k, now))
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.Before(list[j].CreatedAt) })
+	return list
+}
+
+// info 返回Key的信息和使用统计（调用方需持有锁）
+func (s *APIKeyStore) info(k *APIKey, now time.Time) APIKeyInfo {
+	info := APIKeyInfo{
+		ID:        k.ID,
+		Owner:     k.Owner,
+		Routes:    k.Routes,
+		Quota:     k.Quota,
+		ExpiresAt: k.ExpiresAt,
+		CreatedAt: k.CreatedAt,
+		RotatedAt: k.RotatedAt,
+		RevokedAt: k.RevokedAt,
+		Status:    "active",
+	}
+	switch {
+	case k.RevokedAt != nil:
+		info.Status = "revoked"
+	case k.ExpiresAt != nil && !now.Before(*k.ExpiresAt):
+		info.Status = "expired"
+	}
+	if u := s.usage[k.ID]; u != nil {
+		info.Usage = *u
+		if k.Quota != nil && now.Sub(u.windowStart) >= k.Quota.Period.Duration {
+			info.Usage.QuotaUsed, info.Usage.QuotaResets = 0, nil
+		}
+	}
+	return info
+}
+
+// apiKeyDecision 一次API Key检查的结果
+type apiKeyDecision struct {
+	key        APIKey
+	err        error
+	quota      bool          // 超过配额
+	retryAfter time.Duration // 超过配额时距离配额窗口结束的时间
+}
+
+// Check 验证API Key能否访问路由，并计入使用统计和配额
+func (s *APIKeyStore) Check(secret, route string, now time.Time) apiKeyDecision {
+	id, ok := parseAPIKeyID(secret)
+	if !ok {
+		return apiKeyDecision{err: errAPIKeyInvalid}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k, ok := s.keys[id]
+	if !ok || !k.matches(secret, now) {
+		return apiKeyDecision{err: errAPIKeyInvalid}
+	}
+
+	u := s.usage[id]
+	if u == nil {
+		u = &APIKeyUsage{}
+		s.usage[id] = u
+	}
+	u.LastUsedAt = &now
+	reject := func(d apiKeyDecision) apiKeyDecision {
+		u.Rejected++
+		d.key = *k
+		return d
+	}
+
+	switch {
+	case k.RevokedAt != nil:
+		return reject(apiKeyDecision{err: errAPIKeyRevoked})
+	case k.ExpiresAt != nil && !now.Before(*k.ExpiresAt):
+		return reject(apiKeyDecision{err: errAPIKeyExpired})
+	case !k.allowsRoute(route):
+		return reject(apiKeyDecision{err: errAPIKeyForbidden})
+	}
+
+	if q := k.Quota; q != nil {
+		window := now.Truncate(q.Period.Duration)
+		if !u.windowStart.Equal(window) {
+			resets := window.Add(q.Period.Duration)
+			u.windowStart, u.QuotaUsed, u.QuotaResets = window, 0, &resets
+		}
+		if u.QuotaUsed >= q.Requests {
+			return reject(apiKeyDecision{quota: true, retryAfter: u.QuotaResets.Sub(now)})
+		}
+		u.QuotaUsed++
+	}
+	u.Requests++
+	return apiKeyDecision{key: *k}
+}
+
+// matches 比较密钥哈希（轮换宽限期内旧密钥也有效）
+func (k *APIKey) matches(secret string, now time.Time) bool {
+	hash := hashAPIKey(secret)
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(k.Hash)) == 1 {
+		return true
+	}
+	return k.PreviousHash != "" && k.PreviousExpiresAt != nil && now.Before(*k.PreviousExpiresAt) &&
+		subtle.ConstantTimeCompare([]byte(hash), []byte(k.PreviousHash)) == 1
+}
+
+// apiKeyFromRequest 从请求头或查询参数中取出API Key
+func apiKeyFromRequest(r *http.Request) string {
+	if key := r.Header.Get(apiKeyHeader); key != "" {
+		return key
+	}
+	return r.URL.Query().Get(apiKeyQueryParam)
+}
+
+// removeAPIKey 删除请求中的API Key，避免把密钥转发给上游
+func removeAPIKey(r *http.Request) {
+	r.Header.Del(apiKeyHeader)
+	if r.URL.RawQuery != "" {
+		q := r.URL.Query()
+		if q.Has(apiKeyQueryParam) {
+			q.Del(apiKeyQueryParam)
+			r.URL.RawQuery = q.Encode()
+		}
+	}
+}
@@ -0,0 +1,423 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"ttt/pkg/log"
+	"ttt/pkg/requestid"
+)
+
+// AuthConfig 网关认证配置（所有路由共用，路由通过 auth 字段声明各自的要求）
+type AuthConfig struct {
+	JWT JWTConfig `json:"jwt"`
+	// ClaimHeaders 验证通过后转发给上游的声明，声明名 -> 请求头（在默认映射的基础上添加或覆盖）
+	ClaimHeaders map[string]string `json:"claim_headers,omitempty"`
+}
+
+// JWTConfig JWT验证配置，hs256_secret_env、jwks_file、jwks_url 可以同时配置
+type JWTConfig struct {
+	Issuer         string    `json:"issuer,omitempty"`           // 要求的 iss（为空表示不检查）
+	Audience       string    `json:"audience,omitempty"`         // 要求的 aud（为空表示不检查）
+	HS256SecretEnv string    `json:"hs256_secret_env,omitempty"` // 保存 HS256 密钥的环境变量名
+	JWKSFile       string    `json:"jwks_file,omitempty"`        // 本地JWKS文件（RS256/ES256/HS256）
+	JWKSURL        string    `json:"jwks_url,omitempty"`         // 远程JWKS地址
+	JWKSRefresh    Duration  `json:"jwks_refresh,omitempty"`     // 远程JWKS的刷新间隔，默认 10m
+	Leeway         *Duration `json:"leeway,omitempty"`           // exp/nbf 允许的时钟误差，默认 30s（可以设置为 0）
+	RequireExp     *bool     `json:"require_exp,omitempty"`      // 是否拒绝没有 exp 的令牌，默认 true
+}
+
+// RouteAuthConfig 路由的认证要求
+type RouteAuthConfig struct {
+	Optional bool     `json:"optional,omitempty"` // 没有令牌时也放行，有令牌时仍然验证并转发声明
+	Scopes   []string `json:"scopes,omitempty"`   // 令牌必须具有所有这些 scope
+	Roles    []string `json:"roles,omitempty"`    // 令牌必须具有其中至少一个角色
+	APIKey   bool     `json:"api_key,omitempty"`  // 也接受API Key（Key的 routes 需要包含该路由，scopes/roles 只对JWT生效）
+}
+
+// defaultClaimHeaders 默认转发给上游的声明
+var defaultClaimHeaders = map[string]string{
+	"sub":   "X-User-ID",
+	"scope": "X-User-Scopes",
+	"roles": "X-User-Roles",
+}
+
+// Authenticator 根据一次加载的认证配置验证令牌
+type Authenticator struct {
+	cfg          JWTConfig
+	leeway       time.Duration
+	requireExp   bool
+	secret       []byte
+	fileKeys     []verifyKey
+	remote       *jwksCache
+	claimHeaders map[string]string // 声明名 -> 请求头
+	stripHeaders []string          // 每个请求都要删除的客户端请求头
+}
+
+// newAuthenticator 校验认证配置并加载密钥
+func newAuthenticator(cfg AuthConfig) (*Authenticator, error) {
+	var errs []error
+	jc := cfg.JWT
+	if jc.JWKSRefresh.Duration == 0 {
+		jc.JWKSRefresh.Duration = 10 * time.Minute
+	}
+	leeway := 30 * time.Second
+	if jc.Leeway != nil {
+		leeway = jc.Leeway.Duration
+	}
+	if jc.JWKSRefresh.Duration < time.Minute {
+		errs = append(errs, errors.New("auth.jwt.jwks_refresh 不能小于 1m"))
+	}
+	if leeway < 0 {
+		errs = append(errs, errors.New("auth.jwt.leeway 不能为负数"))
+	}
+
+	a := &Authenticator{
+		cfg:          jc,
+		leeway:       leeway,
+		requireExp:   jc.RequireExp == nil || *jc.RequireExp,
+		claimHeaders: make(map[string]string),
+	}
+	if jc.HS256SecretEnv != "" {
+		secret := os.Getenv(jc.HS256SecretEnv)
+		if len(secret) < 32 {
+			errs = append(errs, fmt.Errorf("auth.jwt.hs256_secret_env: 环境变量 %s 未设置或少于32个字符", jc.HS256SecretEnv))
+		}
+		a.secret = []byte(secret)
+	}
+	if jc.JWKSFile != "" {
+		keys, err := loadJWKSFile(jc.JWKSFile)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("auth.jwt.jwks_file: %w", err))
+		}
+		a.fileKeys = keys
+	}
+	if jc.JWKSURL != "" {
+		if !secureJWKSURL(jc.JWKSURL) {
+			errs = append(errs, errors.New("auth.jwt.jwks_url 必须是 https 地址（http 只允许本机地址）"))
+		}
+		a.remote = newJWKSCache(jc.JWKSURL, jc.JWKSRefresh.Duration)
+	}
+
+	strip := map[string]bool{apiKeyIDHeader: true, consumerIDHeader: true}
+	for claim, header := range defaultClaimHeaders {
+		a.claimHeaders[claim] = header
+		strip[header] = true
+	}
+	for claim, header := range cfg.ClaimHeaders {
+		if claim == "" || header == "" {
+			errs = append(errs, errors.New("auth.claim_headers 中的声明名和请求头不能为空"))
+			continue
+		}
+		header = http.CanonicalHeaderKey(header)
+		a.claimHeaders[claim] = header
+		strip[header] = true
+	}
+	for header := range strip {
+		a.stripHeaders = append(a.stripHeaders, header)
+	}
+	sort.Strings(a.stripHeaders)
+
+	return a, errors.Join(errs...)
+}
+
+// secureJWKSURL 判断JWKS地址是否可信：必须使用 https，否则网络上的攻击者可以替换签名密钥；
+// 本机地址（localhost、127.0.0.1、::1）可以使用 http
+func secureJWKSURL(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	switch u.Scheme {
+	case "https":
+		return true
+	case "http":
+		host := u.Hostname()
+		if host == "localhost" {
+			return true
+		}
+		ip := net.ParseIP(host)
+		return ip != nil && ip.IsLoopback()
+	}
+	return false
+}
+
+// configured 是否配置了验证令牌的密钥
+func (a *Authenticator) configured() bool {
+	return a.secret != nil || a.fileKeys != nil || a.remote != nil
+}
+
+// stripIdentityHeaders 删除客户端伪造的身份请求头，只有网关验证令牌后才会设置这些请求头
+func (a *Authenticator) stripIdentityHeaders(r *http.Request) {
+	for _, header := range a.stripHeaders {
+		r.Header.Del(header)
+	}
+}
+
+// keys 返回可以验证该令牌的密钥
+func (a *Authenticator) keys(header jwtHeader, now time.Time) ([]verifyKey, error) {
+	var candidates []verifyKey
+	if a.secret != nil {
+		candidates = append(candidates, verifyKey{alg: algHS256, key: a.secret})
+	}
+	candidates = append(candidates, a.fileKeys...)
+	if a.remote != nil {
+		remote, err := a.remote.get(header.Kid, now)
+		if err != nil && len(candidates) == 0 {
+			return nil, err
+		}
+		candidates = append(candidates, remote...)
+	}
+
+	var keys []verifyKey
+	for _, k := range candidates {
+		if k.alg != header.Alg {
+			continue
+		}
+		// 令牌指定了 kid 时只使用该密钥（没有 kid 的环境变量密钥除外）
+		if header.Kid != "" && k.kid != "" && k.kid != header.Kid {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+// Verify 验证令牌签名和 exp/nbf/iss/aud，返回令牌中的声明
+func (a *Authenticator) Verify(token string, now time.Time) (jwtClaims, error) {
+	header, claims, signingInput, sig, err := parseJWT(token)
+	if err != nil {
+		return nil, err
+	}
+	switch header.Alg {
+	case algHS256, algRS256, algES256:
+	default:
+		return nil, fmt.Errorf("不支持的签名算法 %q", header.Alg)
+	}
+
+	keys, err := a.keys(header, now)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, errTokenNoKey
+	}
+	verified := false
+	for _, k := range keys {
+		if k.verify(signingInput, sig) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, errTokenSignature
+	}
+
+	leeway := a.leeway
+	exp, ok, err := claims.numericClaim("exp")
+	if err != nil {
+		return nil, err
+	}
+	if !ok && a.requireExp {
+		return nil, errors.New("令牌没有过期时间（exp）")
+	}
+	if ok && now.After(exp.Add(leeway)) {
+		return nil, errors.New("令牌已过期")
+	}
+	nbf, ok, err := claims.numericClaim("nbf")
+	if err != nil {
+		return nil, err
+	}
+	if ok && now.Add(leeway).Before(nbf) {
+		return nil, errors.New("令牌尚未生效")
+	}
+	if a.cfg.Issuer != "" && claims.stringClaim("iss") != a.cfg.Issuer {
+		return nil, errors.New("令牌的签发者不匹配")
+	}
+	if a.cfg.Audience != "" && !containsString(claims.listClaim("aud"), a.cfg.Audience) {
+		return nil, errors.New("令牌的受众不匹配")
+	}
+	return claims, nil
+}
+
+// claimHeaderValue 把声明转换为请求头的值：列表用逗号连接，对象用JSON表示
+func claimHeaderValue(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		if v {
+			return "true"
+		}
+		return "false"
+	case []interface{}:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			items = append(items, claimHeaderValue(item))
+		}
+		return strings.Join(items, ",")
+	case nil:
+		return ""
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// validate 校验路由的认证要求
+func (c *RouteAuthConfig) validate(a *Authenticator) []error {
+	var errs []error
+	if !c.APIKey && !a.configured() {
+		errs = append(errs, errors.New("auth 需要先在 auth.jwt 中配置密钥（hs256_secret_env、jwks_file 或 jwks_url），或者设置 api_key"))
+	}
+	if c.Optional && (len(c.Scopes) > 0 || len(c.Roles) > 0) {
+		errs = append(errs, errors.New("auth.optional 不能与 scopes/roles 同时使用"))
+	}
+	return errs
+}
+
+// bearerToken 从 Authorization 请求头中取出 Bearer 令牌
+func bearerToken(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	return ""
+}
+
+// authError 返回认证失败的响应，带 WWW-Authenticate 响应头（错误详情只出现在响应体中）
+func authError(w http.ResponseWriter, r *http.Request, code int, errCode, description string) {
+	challenge := `Bearer realm="gateway"`
+	if errCode != "" {
+		challenge += fmt.Sprintf(`, error=%q`, errCode)
+	}
+	w.Header().Set("WWW-Authenticate", challenge)
+	msg := "Unauthorized"
+	if code == http.StatusForbidden {
+		msg = "Forbidden"
+	}
+	if description != "" {
+		msg += ": " + description
+	}
+	requestid.Error(w, r, msg, code)
+}
+
+// authenticate 路由认证：验证令牌，检查 scope 和角色，把声明作为请求头转发给上游
+func (gs *GatewayService) authenticate(a *Authenticator, req RouteAuthConfig, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		match := routeFromContext(r.Context())
+		if req.APIKey && gs.apiKeys != nil {
+			if key := apiKeyFromRequest(r); key != "" {
+				gs.authenticateAPIKey(w, r, key, next)
+				return
+			}
+		}
+
+		token := bearerToken(r)
+		if token == "" {
+			if req.Optional {
+				next.ServeHTTP(w, r)
+				return
+			}
+			authError(w, r, http.StatusUnauthorized, "", "")
+			return
+		}
+
+		claims, err := a.Verify(token, time.Now())
+		if err != nil {
+			gs.logger.ForRequest(r).Warn("✗ 令牌验证失败", log.String("route", match.route.Name), log.Err(err))
+			authError(w, r, http.StatusUnauthorized, "invalid_token", err.Error())
+			return
+		}
+
+		scopes := append(claims.listClaim("scope"), claims.listClaim("scp")...)
+		for _, scope := range req.Scopes {
+			if !containsString(scopes, scope) {
+				gs.logger.ForRequest(r).Warn("✗ 令牌缺少 scope",
+					log.String("route", match.route.Name),
+					log.String("sub", claims.stringClaim("sub")),
+					log.String("scope", scope))
+				authError(w, r, http.StatusForbidden, "insufficient_scope", "missing scope "+scope)
+				return
+			}
+		}
+		if len(req.Roles) > 0 {
+			roles := claims.listClaim("roles")
+			allowed := false
+			for _, role := range req.Roles {
+				if containsString(roles, role) {
+					allowed = true
+					break
+				}
+			}
+			if !allowed {
+				gs.logger.ForRequest(r).Warn("✗ 令牌没有所需角色",
+					log.String("route", match.route.Name),
+					log.String("sub", claims.stringClaim("sub")))
+				authError(w, r, http.StatusForbidden, "insufficient_scope", "missing required role")
+				return
+			}
+		}
+
+		for claim, header := range a.claimHeaders {
+			if v, ok := claims[claim]; ok {
+				r.Header.Set(header, claimHeaderValue(v))
+			}
+		}
+		match.authenticated = true
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authenticateAPIKey 使用API Key认证：检查有效期、路由权限和配额，把Key ID和所有者转发给上游
+func (gs *GatewayService) authenticateAPIKey(w http.ResponseWriter, r *http.Request, key string, next http.Handler) {
+	match := routeFromContext(r.Context())
+	route := match.route
+	d := gs.apiKeys.Check(key, route.Name, time.Now())
+	switch {
+	case d.quota:
+		gs.logger.ForRequest(r).Warn("✗ API Key 超过配额",
+			log.String("route", route.Name),
+			log.String("key_id", d.key.ID),
+			log.String("owner", d.key.Owner))
+		w.Header().Set("Retry-After", strconv.Itoa(max(ceilSeconds(d.retryAfter), 1)))
+		requestid.Error(w, r, "API key quota exceeded", http.StatusTooManyRequests)
+		return
+	case d.err != nil:
+		code := http.StatusUnauthorized
+		if errors.Is(d.err, errAPIKeyForbidden) {
+			code = http.StatusForbidden
+		}
+		gs.logger.ForRequest(r).Warn("✗ API Key 验证失败",
+			log.String("route", route.Name),
+			log.String("key_id", d.key.ID),
+			log.Err(d.err))
+		requestid.Error(w, r, http.StatusText(code)+": "+d.err.Error(), code)
+		return
+	}
+
+	removeAPIKey(r)
+	r.Header.Set(apiKeyIDHeader, d.key.ID)
+	r.Header.Set(consumerIDHeader, d.key.Owner)
+	match.authenticated = true
+	next.ServeHTTP(w, r)
+}
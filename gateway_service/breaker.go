@@ -0,0 +1,314 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// BreakerState 熔断器状态
+type BreakerState int
+
+const (
+	BreakerClosed   BreakerState = iota // 正常转发
+	BreakerOpen                         // 熔断中，直接拒绝
+	BreakerHalfOpen                     // 熔断时间结束，放行少量探测请求
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half_open"
+	default:
+		return "closed"
+	}
+}
+
+// Label 用于GUI显示的状态名称
+func (s BreakerState) Label() string {
+	switch s {
+	case BreakerOpen:
+		return "熔断"
+	case BreakerHalfOpen:
+		return "半开"
+	default:
+		return "正常"
+	}
+}
+
+// CircuitBreakerConfig 熔断器配置（每个上游实例一个熔断器）
+type CircuitBreakerConfig struct {
+	ConsecutiveFailures int      `json:"consecutive_failures"` // 连续失败次数达到该值时熔断
+	ErrorRate           float64  `json:"error_rate"`           // 统计窗口内错误率达到该值时熔断（0 表示不按错误率熔断）
+	MinRequests         int      `json:"min_requests"`         // 按错误率熔断需要的最少请求数
+	Window              Duration `json:"window"`               // 错误率统计窗口
+	SlowCallThreshold   Duration `json:"slow_call_threshold"`  // 超过该时间的请求视为失败（0 表示不限制）
+	OpenDuration        Duration `json:"open_duration"`        // 熔断持续时间，之后进入半开状态
+	HalfOpenRequests    int      `json:"half_open_requests"`   // 半开状态放行的探测请求数，全部成功后恢复
+}
+
+// OutlierDetectionConfig 异常实例摘除配置（服务有多个实例时生效）
+type OutlierDetectionConfig struct {
+	Interval           Duration `json:"interval"`             // 检测间隔
+	ErrorRateMargin    float64  `json:"error_rate_margin"`    // 错误率比其他实例的平均值高出该值时摘除（默认 0.2，-1 表示不摘除）
+	MinRequests        int      `json:"min_requests"`         // 参与检测需要的最少请求数
+	BaseEjectionTime   Duration `json:"base_ejection_time"`   // 摘除时间，多次摘除时按次数倍增（最多10倍）
+	MaxEjectionPercent int      `json:"max_ejection_percent"` // 同一服务最多摘除的实例比例
+}
+
+// UpstreamConfig 上游实例的熔断和异常摘除配置
+type UpstreamConfig struct {
+	CircuitBreaker   CircuitBreakerConfig   `json:"circuit_breaker"`
+	OutlierDetection OutlierDetectionConfig `json:"outlier_detection"`
+}
+
+// withDefaults 未配置的字段使用默认值
+func (c UpstreamConfig) withDefaults() UpstreamConfig {
+	cb := &c.CircuitBreaker
+	if cb.ConsecutiveFailures == 0 {
+		cb.ConsecutiveFailures = 5
+	}
+	if cb.MinRequests == 0 {
+		cb.MinRequests = 10
+	}
+	if cb.Window.Duration == 0 {
+		cb.Window.Duration = 30 * time.Second
+	}
+	if cb.OpenDuration.Duration == 0 {
+		cb.OpenDuration.Duration = 10 * time.Second
+	}
+	if cb.HalfOpenRequests == 0 {
+		cb.HalfOpenRequests = 1
+	}
+
+	od := &c.OutlierDetection
+	if od.ErrorRateMargin == 0 {
+		od.ErrorRateMargin = 0.2
+	}
+	if od.Interval.Duration == 0 {
+		od.Interval.Duration = 10 * time.Second
+	}
+	if od.MinRequests == 0 {
+		od.MinRequests = 10
+	}
+	if od.BaseEjectionTime.Duration == 0 {
+		od.BaseEjectionTime.Duration = 30 * time.Second
+	}
+	if od.MaxEjectionPercent == 0 {
+		od.MaxEjectionPercent = 50
+	}
+	return c
+}
+
+// validate 校验配置（在 withDefaults 之后调用）
+func (c UpstreamConfig) validate() error {
+	var errs []error
+	cb, od := c.CircuitBreaker, c.OutlierDetection
+	if cb.ConsecutiveFailures < 0 {
+		errs = append(errs, errors.New("upstream.circuit_breaker.consecutive_failures 不能为负数"))
+	}
+	if cb.ErrorRate < 0 || cb.ErrorRate > 1 {
+		errs = append(errs, errors.New("upstream.circuit_breaker.error_rate 必须在 0 到 1 之间"))
+	}
+	if cb.Window.Duration < time.Second {
+		errs = append(errs, errors.New("upstream.circuit_breaker.window 不能小于 1s"))
+	}
+	if cb.OpenDuration.Duration < 0 || cb.SlowCallThreshold.Duration < 0 {
+		errs = append(errs, errors.New("upstream.circuit_breaker 中的时间不能为负数"))
+	}
+	if cb.HalfOpenRequests < 0 || cb.MinRequests < 0 {
+		errs = append(errs, errors.New("upstream.circuit_breaker 中的请求数不能为负数"))
+	}
+	if (od.ErrorRateMargin < 0 && od.ErrorRateMargin != -1) || od.ErrorRateMargin > 1 {
+		errs = append(errs, errors.New("upstream.outlier_detection.error_rate_margin 必须在 0 到 1 之间（-1 表示不摘除）"))
+	}
+	if od.Interval.Duration < 100*time.Millisecond {
+		errs = append(errs, errors.New("upstream.outlier_detection.interval 不能小于 100ms"))
+	}
+	if od.MaxEjectionPercent < 0 || od.MaxEjectionPercent > 100 {
+		errs = append(errs, errors.New("upstream.outlier_detection.max_ejection_percent 必须在 0 到 100 之间"))
+	}
+	return errors.Join(errs...)
+}
+
+// windowBuckets 统计窗口分成的桶数
+const windowBuckets = 10
+
+// rollingWindow 滑动窗口请求统计
+type rollingWindow struct {
+	buckets [windowBuckets]struct {
+		start    time.Time
+		total    int
+		failures int
+	}
+}
+
+func (w *rollingWindow) add(now time.Time, window time.Duration, failed bool) {
+	width := window / windowBuckets
+	start := now.Truncate(width)
+	b := &w.buckets[int(start.UnixNano()/int64(width))%windowBuckets]
+	if !b.start.Equal(start) {
+		b.start, b.total, b.failures = start, 0, 0
+	}
+	b.total++
+	if failed {
+		b.failures++
+	}
+}
+
+func (w *rollingWindow) counts(now time.Time, window time.Duration) (total, failures int) {
+	for _, b := range w.buckets {
+		if now.Sub(b.start) < window {
+			total += b.total
+			failures += b.failures
+		}
+	}
+	return total, failures
+}
+
+func (w *rollingWindow) reset() {
+	*w = rollingWindow{}
+}
+
+// CircuitBreaker 单个上游实例的熔断器
+//
+// 关闭状态下连续失败或错误率超过阈值时打开；打开一段时间后进入半开状态，
+// 放行少量探测请求，全部成功则关闭，任何一个失败则重新打开。
+type CircuitBreaker struct {
+	mu          sync.Mutex
+	state       BreakerState
+	generation  uint64 // 每次状态变化加一，用于忽略旧状态下发出的请求结果
+	consecutive int
+	openUntil   time.Time
+	probes      int // 半开状态已放行的探测请求数
+	successes   int // 半开状态成功的探测请求数
+	stats       rollingWindow
+
+	onStateChange func(from, to BreakerState)
+}
+
+// NewCircuitBreaker 创建熔断器，onStateChange 在状态变化时调用（可以为 nil）
+func NewCircuitBreaker(onStateChange func(from, to BreakerState)) *CircuitBreaker {
+	return &CircuitBreaker{onStateChange: onStateChange}
+}
+
+// Allow 判断是否放行请求，返回请求所属的状态版本（传给 Record）
+// 不放行时返回建议的重试等待时间
+func (b *CircuitBreaker) Allow(cfg *CircuitBreakerConfig, now time.Time) (generation uint64, ok bool, retryAfter time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == BreakerOpen {
+		if now.Before(b.openUntil) {
+			return 0, false, b.openUntil.Sub(now)
+		}
+		b.setState(BreakerHalfOpen)
+	}
+	if b.state == BreakerHalfOpen {
+		if b.probes >= cfg.HalfOpenRequests {
+			return 0, false, time.Second
+		}
+		b.probes++
+	}
+	return b.generation, true, 0
+}
+
+// Record 记录请求结果
+func (b *CircuitBreaker) Record(cfg *CircuitBreakerConfig, generation uint64, now time.Time, failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.stats.add(now, cfg.Window.Duration, failed)
+	if generation != b.generation {
+		return
+	}
+
+	switch b.state {
+	case BreakerClosed:
+		if !failed {
+			b.consecutive = 0
+			return
+		}
+		b.consecutive++
+		total, failures := b.stats.counts(now, cfg.Window.Duration)
+		if b.consecutive >= cfg.ConsecutiveFailures ||
+			(cfg.ErrorRate > 0 && total >= cfg.MinRequests && float64(failures)/float64(total) >= cfg.ErrorRate) {
+			b.open(cfg, now)
+		}
+	case BreakerHalfOpen:
+		if failed {
+			b.open(cfg, now)
+			return
+		}
+		b.successes++
+		if b.successes >= cfg.HalfOpenRequests {
+			b.stats.reset()
+			b.setState(BreakerClosed)
+		}
+	}
+}
+
+// Cancel 放弃请求（例如客户端断开），不计入统计，释放半开状态的探测名额
+func (b *CircuitBreaker) Cancel(generation uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if generation == b.generation && b.state == BreakerHalfOpen && b.probes > 0 {
+		b.probes--
+	}
+}
+
+func (b *CircuitBreaker) open(cfg *CircuitBreakerConfig, now time.Time) {
+	b.openUntil = now.Add(cfg.OpenDuration.Duration)
+	b.setState(BreakerOpen)
+}
+
+// setState 切换状态并重置计数（调用方需持有锁）
+func (b *CircuitBreaker) setState(state BreakerState) {
+	from := b.state
+	b.state = state
+	b.generation++
+	b.consecutive, b.probes, b.successes = 0, 0, 0
+	if b.onStateChange != nil && from != state {
+		// 在单独的goroutine中回调，避免回调中访问熔断器导致死锁
+		go b.onStateChange(from, state)
+	}
+}
+
+// BreakerSnapshot 熔断器状态快照
+type BreakerSnapshot struct {
+	State               string     `json:"state"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	Requests            int        `json:"requests"` // 统计窗口内的请求数
+	Failures            int        `json:"failures"` // 统计窗口内的失败数（连接失败、5xx和慢请求）
+	ErrorRate           float64    `json:"error_rate"`
+	OpenUntil           *time.Time `json:"open_until,omitempty"`
+}
+
+// Snapshot 返回熔断器当前状态（打开状态已到期时显示为半开）
+func (b *CircuitBreaker) Snapshot(cfg *CircuitBreakerConfig, now time.Time) BreakerSnapshot {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	state := b.state
+	if state == BreakerOpen && !now.Before(b.openUntil) {
+		state = BreakerHalfOpen
+	}
+	total, failures := b.stats.counts(now, cfg.Window.Duration)
+	s := BreakerSnapshot{
+		State:               state.String(),
+		ConsecutiveFailures: b.consecutive,
+		Requests:            total,
+		Failures:            failures,
+	}
+	if total > 0 {
+		s.ErrorRate = float64(failures) / float64(total)
+	}
+	if state == BreakerOpen {
+		openUntil := b.openUntil
+		s.OpenUntil = &openUntil
+	}
+	return s
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerTransitions(t *testing.T) {
+	cfg := UpstreamConfig{CircuitBreaker: CircuitBreakerConfig{
+		ConsecutiveFailures: 3,
+		ErrorRate:           0.5,
+		MinRequests:         4,
+		OpenDuration:        Duration{5 * time.Second},
+		HalfOpenRequests:    2,
+	}}.withDefaults().CircuitBreaker // window 30s
+
+	// op:
+	//   ok / fail     放行一个请求并记录结果
+	//   allow         只放行，结果由后面的 done ok / done fail / cancel 记录
+	//   stale fail    用第一个放行的请求所属的状态版本记录失败
+	type step struct {
+		at   time.Duration // 相对于开始的时间
+		op   string
+		want BreakerState
+		deny bool // allow 是否被拒绝
+	}
+	open := []step{{0, "fail", BreakerClosed, false}, {0, "fail", BreakerClosed, false}, {0, "fail", BreakerOpen, false}}
+	then := func(steps ...step) []step { return append(append([]step{}, open...), steps...) }
+
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"consecutive failures open", then(
+			step{time.Second, "allow", BreakerOpen, true},
+		)},
+		{"success resets consecutive failures", []step{
+			{0, "fail", BreakerClosed, false}, {0, "fail", BreakerClosed, false}, {0, "ok", BreakerClosed, false},
+			{0, "ok", BreakerClosed, false}, {0, "ok", BreakerClosed, false}, {0, "ok", BreakerClosed, false},
+			{0, "ok", BreakerClosed, false}, {0, "fail", BreakerClosed, false}, {0, "fail", BreakerClosed, false},
+		}},
+		{"error rate opens", []step{
+			{0, "ok", BreakerClosed, false}, {0, "fail", BreakerClosed, false}, {0, "ok", BreakerClosed, false}, {0, "fail", BreakerOpen, false},
+		}},
+		{"error rate needs min requests", []step{
+			{0, "fail", BreakerClosed, false}, {0, "ok", BreakerClosed, false}, {0, "fail", BreakerClosed, false},
+		}},
+		{"error rate only counts the window", []step{
+			{0, "fail", BreakerClosed, false}, {0, "ok", BreakerClosed, false}, {0, "fail", BreakerClosed, false},
+			// 不超出窗口时这两个请求会使错误率达到 3/5
+			{40 * time.Second, "ok", BreakerClosed, false}, {40 * time.Second, "fail", BreakerClosed, false},
+		}},
+		{"half open after open duration", then(
+			step{4 * time.Second, "allow", BreakerOpen, true},
+			step{5 * time.Second, "allow", BreakerHalfOpen, false},
+		)},
+		{"half open limits probes", then(
+			step{5 * time.Second, "allow", BreakerHalfOpen, false},
+			step{5 * time.Second, "allow", BreakerHalfOpen, false},
+			step{5 * time.Second, "allow", BreakerHalfOpen, true},
+		)},
+		{"all probes succeed closes", then(
+			step{5 * time.Second, "allow", BreakerHalfOpen, false},
+			step{5 * time.Second, "allow", BreakerHalfOpen, false},
+			step{5 * time.Second, "done ok", BreakerHalfOpen, false},
+			step{5 * time.Second, "done ok", BreakerClosed, false},
+			// 关闭时清空统计，之前的失败不再计入错误率
+			step{5 * time.Second, "fail", BreakerClosed, false},
+		)},
+		{"probe failure reopens", then(
+			step{5 * time.Second, "allow", BreakerHalfOpen, false},
+			step{5 * time.Second, "done fail", BreakerOpen, false},
+			step{9 * time.Second, "allow", BreakerOpen, true},
+			step{10 * time.Second, "allow", BreakerHalfOpen, false},
+		)},
+		{"cancel releases probe", then(
+			step{5 * time.Second, "allow", BreakerHalfOpen, false},
+			step{5 * time.Second, "allow", BreakerHalfOpen, false},
+			step{5 * time.Second, "cancel", BreakerHalfOpen, false},
+			step{5 * time.Second, "allow", BreakerHalfOpen, false},
+			step{5 * time.Second, "allow", BreakerHalfOpen, true},
+		)},
+		{"stale result ignored", append([]step{{0, "allow", BreakerClosed, false}}, then(
+			step{5 * time.Second, "allow", BreakerHalfOpen, false},
+			step{5 * time.Second, "stale fail", BreakerHalfOpen, false},
+		)...)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewCircuitBreaker(nil)
+			start := time.Unix(1_700_000_000, 0)
+			var generations []uint64
+			for i, s := range tt.steps {
+				now := start.Add(s.at)
+				switch s.op {
+				case "ok", "fail", "allow":
+					generation, ok, retryAfter := b.Allow(&cfg, now)
+					if ok == s.deny {
+						t.Fatalf("step %d (%s): allowed = %v, want %v", i, s.op, ok, !s.deny)
+					}
+					if !ok {
+						if retryAfter <= 0 {
+							t.Errorf("step %d: rejected without retry after", i)
+						}
+						break
+					}
+					generations = append(generations, generation)
+					if s.op != "allow" {
+						b.Record(&cfg, generation, now, s.op == "fail")
+					}
+				case "done ok", "done fail":
+					b.Record(&cfg, generations[len(generations)-1], now, s.op == "done fail")
+				case "cancel":
+					b.Cancel(generations[len(generations)-1])
+				case "stale fail":
+					b.Record(&cfg, generations[0], now, true)
+				}
+				if b.state != s.want {
+					t.Errorf("step %d (%s at %v): state = %v, want %v", i, s.op, s.at, b.state, s.want)
+				}
+			}
+		})
+	}
+}
+
+func TestCircuitBreakerSnapshot(t *testing.T) {
+	cfg := UpstreamConfig{}.withDefaults().CircuitBreaker
+	b := NewCircuitBreaker(nil)
+	now := time.Unix(1_700_000_000, 0)
+	for i := 0; i < cfg.ConsecutiveFailures; i++ {
+		generation, _, _ := b.Allow(&cfg, now)
+		b.Record(&cfg, generation, now, true)
+	}
+
+	tests := []struct {
+		at           time.Duration
+		wantState    string
+		wantOpen     bool
+		wantRequests int
+	}{
+		{0, "open", true, 5},
+		{cfg.OpenDuration.Duration, "half_open", false, 5},
+		{cfg.Window.Duration, "half_open", false, 0},
+	}
+	for _, tt := range tests {
+		s := b.Snapshot(&cfg, now.Add(tt.at))
+		if s.State != tt.wantState || (s.OpenUntil != nil) != tt.wantOpen || s.Requests != tt.wantRequests {
+			t.Errorf("Snapshot(+%v) = %s open_until %v requests %d, want %s %v %d",
+				tt.at, s.State, s.OpenUntil, s.Requests, tt.wantState, tt.wantOpen, tt.wantRequests)
+		}
+	}
+	if s := b.Snapshot(&cfg, now); s.ErrorRate != 1 {
+		t.Errorf("error rate = %v, want 1", s.ErrorRate)
+	}
+}
+
+func TestUpstreamConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     UpstreamConfig
+		wantErr bool
+	}{
+		{"defaults", UpstreamConfig{}, false},
+		{"margin disabled", UpstreamConfig{OutlierDetection: OutlierDetectionConfig{ErrorRateMargin: -1}}, false},
+		{"margin negative", UpstreamConfig{OutlierDetection: OutlierDetectionConfig{ErrorRateMargin: -0.5}}, true},
+		{"margin above one", UpstreamConfig{OutlierDetection: OutlierDetectionConfig{ErrorRateMargin: 1.5}}, true},
+		{"ejection percent above 100", UpstreamConfig{OutlierDetection: OutlierDetectionConfig{MaxEjectionPercent: 101}}, true},
+		{"ejection percent negative", UpstreamConfig{OutlierDetection: OutlierDetectionConfig{MaxEjectionPercent: -1}}, true},
+		{"interval too short", UpstreamConfig{OutlierDetection: OutlierDetectionConfig{Interval: Duration{10 * time.Millisecond}}}, true},
+		{"error rate above one", UpstreamConfig{CircuitBreaker: CircuitBreakerConfig{ErrorRate: 2}}, true},
+		{"window too short", UpstreamConfig{CircuitBreaker: CircuitBreakerConfig{Window: Duration{500 * time.Millisecond}}}, true},
+		{"negative failures", UpstreamConfig{CircuitBreaker: CircuitBreakerConfig{ConsecutiveFailures: -1}}, true},
+		{"negative probes", UpstreamConfig{CircuitBreaker: CircuitBreakerConfig{HalfOpenRequests: -1}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.cfg.withDefaults().validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validate() = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	cfg := UpstreamConfig{}.withDefaults()
+	if cfg.OutlierDetection.ErrorRateMargin != 0.2 || cfg.OutlierDetection.MaxEjectionPercent != 50 {
+		t.Errorf("defaults = margin %v max %d%%, want 0.2 50%%", cfg.OutlierDetection.ErrorRateMargin, cfg.OutlierDetection.MaxEjectionPercent)
+	}
+	if cfg := (UpstreamConfig{OutlierDetection: OutlierDetectionConfig{ErrorRateMargin: -1}}).withDefaults(); cfg.OutlierDetection.ErrorRateMargin != -1 {
+		t.Errorf("margin -1 replaced by default %v", cfg.OutlierDetection.ErrorRateMargin)
+	}
+}
@@ -0,0 +1,697 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// cacheStatusHeader 响应中标记缓存结果的响应头：HIT、MISS、REVALIDATED 或 BYPASS
+const cacheStatusHeader = "X-Cache"
+
+// CacheConfig 响应缓存的全局配置（所有路由共用一个缓存，路由通过 cache 中间件启用）
+type CacheConfig struct {
+	MaxBytes      int64 `json:"max_bytes"`       // 缓存总大小上限，超过时淘汰最久未使用的响应，默认 64MB
+	MaxEntryBytes int64 `json:"max_entry_bytes"` // 单个响应的大小上限，超过的响应不缓存，默认 1MB
+}
+
+// withDefaults 未配置的字段使用默认值
+func (c CacheConfig) withDefaults() CacheConfig {
+	if c.MaxBytes == 0 {
+		c.MaxBytes = 64 << 20
+	}
+	if c.MaxEntryBytes == 0 {
+		c.MaxEntryBytes = 1 << 20
+	}
+	return c
+}
+
+// validate 校验配置（在 withDefaults 之后调用）
+func (c CacheConfig) validate() error {
+	var errs []error
+	if c.MaxBytes < 0 || c.MaxEntryBytes < 0 {
+		errs = append(errs, errors.New("cache.max_bytes 和 max_entry_bytes 不能为负数"))
+	} else if c.MaxEntryBytes > c.MaxBytes {
+		errs = append(errs, errors.New("cache.max_entry_bytes 不能大于 max_bytes"))
+	}
+	return errors.Join(errs...)
+}
+
+// cacheConfig 返回当前的缓存配置
+func (gs *GatewayService) cacheConfig() *CacheConfig {
+	if table := gs.routes.Load(); table != nil {
+		return &table.Cache
+	}
+	cfg := CacheConfig{}.withDefaults()
+	return &cfg
+}
+
+// cacheableStatus 默认可以缓存的响应状态码（RFC 9110 第15.1节）
+var cacheableStatus = map[int]bool{
+	http.StatusOK: true, http.StatusNonAuthoritativeInfo: true, http.StatusNoContent: true,
+	http.StatusMultipleChoices: true, http.StatusMovedPermanently: true, http.StatusPermanentRedirect: true,
+	http.StatusNotFound: true, http.StatusMethodNotAllowed: true, http.StatusGone: true,
+	http.StatusRequestURITooLong: true, http.StatusNotImplemented: true,
+}
+
+// cacheControl 解析后的 Cache-Control 指令，指令名为小写
+type cacheControl map[string]string
+
+// parseCacheControl 解析 Cache-Control 请求头或响应头
+func parseCacheControl(values []string) cacheControl {
+	cc := make(cacheControl)
+	for _, value := range values {
+		for _, directive := range strings.Split(value, ",") {
+			name, arg, _ := strings.Cut(strings.TrimSpace(directive), "=")
+			if name != "" {
+				cc[strings.ToLower(name)] = strings.Trim(arg, `"`)
+			}
+		}
+	}
+	return cc
+}
+
+func (cc cacheControl) has(name string) bool {
+	_, ok := cc[name]
+	return ok
+}
+
+// seconds 返回以秒为单位的指令（如 max-age），值无效时视为 0
+func (cc cacheControl) seconds(name string) (time.Duration, bool) {
+	arg, ok := cc[name]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil || n < 0 {
+		return 0, true
+	}
+	if n > 1<<31 {
+		n = 1 << 31
+	}
+	return time.Duration(n) * time.Second, true
+}
+
+// freshnessLifetime 响应的新鲜时间：依次使用 s-maxage、max-age、Expires；ok 为 false 表示上游没有给出
+func freshnessLifetime(h http.Header, cc cacheControl, now time.Time) (time.Duration, bool) {
+	if d, ok := cc.seconds("s-maxage"); ok {
+		return d, true
+	}
+	if d, ok := cc.seconds("max-age"); ok {
+		return d, true
+	}
+	if v := h.Get("Expires"); v != "" {
+		expires, err := http.ParseTime(v)
+		if err != nil {
+			return 0, true
+		}
+		date, err := http.ParseTime(h.Get("Date"))
+		if err != nil {
+			date = now
+		}
+		return expires.Sub(date), true
+	}
+	return 0, false
+}
+
+// cacheEntry 缓存的响应，存入缓存后不再修改
+type cacheEntry struct {
+	key     string   // 缓存key（包括 Vary 请求头的值）
+	primary string   // 不包括 Vary 请求头的key
+	uri     string   // 请求的路径和查询参数，用于按key或前缀清除缓存
+	vary    []string // 响应的 Vary 请求头名称
+
+	status int
+	header http.Header
+	body   []byte
+	size   int64
+
+	storedAt   time.Time
+	freshUntil time.Time
+	initialAge time.Duration // 存入缓存时上游返回的 Age
+	noCache    bool          // 每次使用前都需要向上游验证
+	shared     bool          // 可以用于响应携带凭证的请求（见 authorizedRequest）
+}
+
+// age 响应当前的年龄
+func (e *cacheEntry) age(now time.Time) time.Duration {
+	return e.initialAge + now.Sub(e.storedAt)
+}
+
+// fresh 是否可以不经验证直接使用
+func (e *cacheEntry) fresh(now time.Time) bool {
+	return !e.noCache && now.Before(e.freshUntil)
+}
+
+// hasValidators 是否可以用 If-None-Match/If-Modified-Since 向上游验证
+func (e *cacheEntry) hasValidators() bool {
+	return e.header.Get("ETag") != "" || e.header.Get("Last-Modified") != ""
+}
+
+// cachePrimary 同一个key的所有变体共用的信息
+type cachePrimary struct {
+	vary    []string // 最近一次缓存的响应的 Vary
+	entries int
+}
+
+// cacheFlight 同一个key正在进行的上游请求，并发的缓存未命中等待它的结果
+type cacheFlight struct {
+	done  chan struct{}
+	once  sync.Once
+	entry *cacheEntry // 可以共享的响应，为 nil 时等待的请求各自转发
+}
+
+// CacheStats 缓存统计
+type CacheStats struct {
+	Entries     int    `json:"entries"`
+	Bytes       int64  `json:"bytes"`
+	MaxBytes    int64  `json:"max_bytes"`
+	Hits        uint64 `json:"hits"`
+	Misses      uint64 `json:"misses"`
+	Revalidated uint64 `json:"revalidated"` // 上游返回 304 后继续使用的次数
+	Coalesced   uint64 `json:"coalesced"`   // 等待并发请求的结果而没有转发的次数
+	Evictions   uint64 `json:"evictions"`
+}
+
+// ResponseCache 按大小淘汰最久未使用响应的内存缓存，配置重新加载时保留
+type ResponseCache struct {
+	mu        sync.Mutex
+	lru       *list.List               // 最近使用的在前面，元素为 *cacheEntry
+	entries   map[string]*list.Element // key -> lru 中的元素
+	primaries map[string]*cachePrimary
+	flights   map[string]*cacheFlight
+	size      int64
+	evictions uint64
+
+	hits, misses, revalidated, coalesced atomic.Uint64
+}
+
+// NewResponseCache 创建响应缓存
+func NewResponseCache() *ResponseCache {
+	return &ResponseCache{
+		lru:       list.New(),
+		entries:   make(map[string]*list.Element),
+		primaries: make(map[string]*cachePrimary),
+		flights:   make(map[string]*cacheFlight),
+	}
+}
+
+// variantKey 在 primary 后面加上 Vary 请求头的值
+func variantKey(primary string, vary []string, r *http.Request) string {
+	if len(vary) == 0 {
+		return primary
+	}
+	var b strings.Builder
+	b.WriteString(primary)
+	for _, name := range vary {
+		b.WriteString("\x00")
+		b.WriteString(name)
+		b.WriteString("=")
+		b.WriteString(strings.Join(r.Header.Values(name), ","))
+	}
+	return b.String()
+}
+
+// lookup 查找请求对应的缓存，返回请求的缓存key（没有缓存时也返回，用于合并并发请求）
+func (c *ResponseCache) lookup(primary string, r *http.Request) (string, *cacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := primary
+	if p := c.primaries[primary]; p != nil {
+		key = variantKey(primary, p.vary, r)
+	}
+	el, ok := c.entries[key]
+	if !ok {
+		return key, nil
+	}
+	c.lru.MoveToFront(el)
+	return key, el.Value.(*cacheEntry)
+}
+
+// store 存入响应，超过大小上限时淘汰最久未使用的响应
+func (c *ResponseCache) store(e *cacheEntry, cfg *CacheConfig) {
+	if e.size > cfg.MaxEntryBytes {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[e.key]; ok {
+		c.removeLocked(el)
+	}
+	p := c.primaries[e.primary]
+	if p == nil {
+		p = &cachePrimary{}
+		c.primaries[e.primary] = p
+	}
+	p.vary = e.vary
+	p.entries++
+	c.entries[e.key] = c.lru.PushFront(e)
+	c.size += e.size
+	for c.size > cfg.MaxBytes {
+		c.removeLocked(c.lru.Back())
+		c.evictions++
+	}
+}
+
+// removeLocked 删除一个缓存（调用方需持有锁）
+func (c *ResponseCache) removeLocked(el *list.Element) {
+	e := c.lru.Remove(el).(*cacheEntry)
+	delete(c.entries, e.key)
+	c.size -= e.size
+	if p := c.primaries[e.primary]; p != nil {
+		if p.entries--; p.entries <= 0 {
+			delete(c.primaries, e.primary)
+		}
+	}
+}
+
+// remove 删除指定的缓存（如果仍是同一个响应）
+func (c *ResponseCache) remove(e *cacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[e.key]; ok && el.Value == e {
+		c.removeLocked(el)
+	}
+}
+
+// join 加入key对应的上游请求，leader 为 true 表示由调用方转发并在结束后调用 land
+func (c *ResponseCache) join(key string) (f *cacheFlight, leader bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if f, ok := c.flights[key]; ok {
+		return f, false
+	}
+	f = &cacheFlight{done: make(chan struct{})}
+	c.flights[key] = f
+	return f, true
+}
+
+// land 结束上游请求，把结果交给等待的请求（可以重复调用，只有第一次生效）
+func (c *ResponseCache) land(key string, f *cacheFlight, e *cacheEntry) {
+	f.once.Do(func() {
+		c.mu.Lock()
+		if c.flights[key] == f {
+			delete(c.flights, key)
+		}
+		c.mu.Unlock()
+		f.entry = e
+		close(f.done)
+	})
+}
+
+// Purge 清除请求路径和查询参数等于 key 或以 prefix 开头的所有缓存，返回清除的数量
+func (c *ResponseCache) Purge(key, prefix string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for el := c.lru.Front(); el != nil; {
+		next := el.Next()
+		e := el.Value.(*cacheEntry)
+		if (key != "" && e.uri == key) || (prefix != "" && strings.HasPrefix(e.uri, prefix)) {
+			c.removeLocked(el)
+			n++
+		}
+		el = next
+	}
+	return n
+}
+
+// Stats 返回缓存统计
+func (c *ResponseCache) Stats(cfg *CacheConfig) CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return CacheStats{
+		Entries:     c.lru.Len(),
+		Bytes:       c.size,
+		MaxBytes:    cfg.MaxBytes,
+		Hits:        c.hits.Load(),
+		Misses:      c.misses.Load(),
+		Revalidated: c.revalidated.Load(),
+		Coalesced:   c.coalesced.Load(),
+		Evictions:   c.evictions,
+	}
+}
+
+func init() {
+	registerMiddleware("cache", newCacheMiddleware)
+}
+
+// cacheMiddlewareConfig cache 中间件配置
+//
+// 只缓存 GET 请求（HEAD 请求可以使用 GET 的缓存），遵循上游的 Cache-Control、Expires、Vary，
+// 过期后用 ETag/Last-Modified 向上游验证。no-store、private、带 Set-Cookie 的响应不缓存。
+type cacheMiddlewareConfig struct {
+	// TTL 覆盖上游 max-age/Expires 给出的缓存时间，0 表示使用上游的缓存时间
+	TTL Duration `json:"ttl"`
+	// KeyHeaders 额外加入缓存key的请求头，如按用户缓存时使用 X-User-ID
+	KeyHeaders []string `json:"key_headers"`
+}
+
+func newCacheMiddleware(gs *GatewayService, config json.RawMessage) (Middleware, error) {
+	var cfg cacheMiddlewareConfig
+	if err := decodeMiddlewareConfig(config, &cfg); err != nil {
+		return nil, err
+	}
+	if cfg.TTL.Duration < 0 {
+		return nil, errors.New("ttl 不能为负数")
+	}
+	for i, name := range cfg.KeyHeaders {
+		cfg.KeyHeaders[i] = http.CanonicalHeaderKey(name)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case r.Method != http.MethodGet && r.Method != http.MethodHead:
+				next.ServeHTTP(w, r)
+				// 修改资源的请求转发后，清除该资源的缓存
+				if r.Method != http.MethodOptions && r.Method != http.MethodTrace {
+					gs.cache.Purge(r.URL.RequestURI(), "")
+				}
+			case isWebSocketUpgrade(r):
+				next.ServeHTTP(w, r)
+			default:
+				gs.serveWithCache(w, r, next, &cfg)
+			}
+		})
+	}, nil
+}
+
+// cachePrimaryKey 请求的缓存key：Host、路径、查询参数和 key_headers 的值
+func cachePrimaryKey(r *http.Request, keyHeaders []string) string {
+	var b strings.Builder
+	b.WriteString(strings.ToLower(r.Host))
+	b.WriteString(r.URL.RequestURI())
+	for _, name := range keyHeaders {
+		b.WriteString("\x00")
+		b.WriteString(name)
+		b.WriteString("=")
+		b.WriteString(strings.Join(r.Header.Values(name), ","))
+	}
+	return b.String()
+}
+
+// serveWithCache 使用缓存响应请求，缓存未命中或需要验证时转发给上游
+// 同一个key的并发未命中只转发一次，其余请求等待并使用它的结果
+func (gs *GatewayService) serveWithCache(w http.ResponseWriter, r *http.Request, next http.Handler, cfg *cacheMiddlewareConfig) {
+	reqCC := parseCacheControl(r.Header.Values("Cache-Control"))
+	if reqCC.has("no-store") {
+		w.Header().Set(cacheStatusHeader, "BYPASS")
+		next.ServeHTTP(w, r)
+		return
+	}
+	authorized := authorizedRequest(r)
+
+	now := time.Now()
+	primary := cachePrimaryKey(r, cfg.KeyHeaders)
+	key, entry := gs.cache.lookup(primary, r)
+	if entry != nil && authorized && !entry.shared {
+		entry = nil
+	}
+	if entry != nil && entry.fresh(now) && !reqCC.has("no-cache") {
+		if maxAge, ok := reqCC.seconds("max-age"); !ok || entry.age(now) <= maxAge {
+			gs.cache.hits.Add(1)
+			serveCached(w, r, entry, "HIT", now)
+			return
+		}
+	}
+
+	if r.Method == http.MethodHead {
+		gs.cache.misses.Add(1)
+		w.Header().Set(cacheStatusHeader, "MISS")
+		next.ServeHTTP(w, r)
+		return
+	}
+
+	flight, leader := gs.cache.join(key)
+	if !leader {
+		select {
+		case <-flight.done:
+		case <-r.Context().Done():
+			return
+		}
+		if e := flight.entry; e != nil && (!authorized || e.shared) && e.key == variantKey(e.primary, e.vary, r) {
+			gs.cache.coalesced.Add(1)
+			serveCached(w, r, e, "HIT", time.Now())
+			return
+		}
+		gs.cache.misses.Add(1)
+		w.Header().Set(cacheStatusHeader, "MISS")
+		next.ServeHTTP(w, r)
+		return
+	}
+	defer gs.cache.land(key, flight, nil)
+	gs.cache.misses.Add(1)
+
+	rec := &cacheRecorder{
+		ResponseWriter: w,
+		header:         make(http.Header),
+		newEntry: func(status int, h http.Header) *cacheEntry {
+			return newCacheEntry(r, primary, status, h, cfg, gs.cacheConfig(), authorized, time.Now())
+		},
+		giveUp: func() { gs.cache.land(key, flight, nil) },
+		limit:  gs.cacheConfig().MaxEntryBytes,
+	}
+	upstreamReq := r
+	if entry != nil && entry.hasValidators() {
+		// 过期的缓存用条件请求向上游验证
+		upstreamReq = r.Clone(r.Context())
+		if etag := entry.header.Get("ETag"); etag != "" {
+			upstreamReq.Header.Set("If-None-Match", etag)
+		}
+		if lm := entry.header.Get("Last-Modified"); lm != "" {
+			upstreamReq.Header.Set("If-Modified-Since", lm)
+		}
+		rec.stale = entry
+	}
+	next.ServeHTTP(rec, upstreamReq)
+
+	switch {
+	case rec.notModified:
+		now := time.Now()
+		header := entry.header.Clone()
+		for name, values := range rec.header {
+			if name != "Content-Length" {
+				header[name] = values
+			}
+		}
+		refreshed := newCacheEntry(r, primary, entry.status, header, cfg, gs.cacheConfig(), authorized, now)
+		if refreshed == nil {
+			// 上游不再允许缓存，这次仍使用已验证的响应
+			gs.cache.remove(entry)
+			serveCached(w, r, entry, "REVALIDATED", now)
+			return
+		}
+		refreshed.body, refreshed.size = entry.body, entry.size
+		gs.cache.store(refreshed, gs.cacheConfig())
+		gs.cache.revalidated.Add(1)
+		gs.cache.land(key, flight, refreshed)
+		serveCached(w, r, refreshed, "REVALIDATED", now)
+	case rec.entry != nil:
+		e := rec.entry
+		e.body = rec.buf.Bytes()
+		e.size += int64(len(e.body))
+		gs.cache.store(e, gs.cacheConfig())
+		gs.cache.land(key, flight, e)
+	}
+}
+
+// authorizedRequest 请求是否携带凭证：带 Authorization，或网关已验证了调用方的身份（API Key 在认证后会被删除）
+// 这样的请求只能使用上游明确允许共享的响应（RFC 9111 第3.5节）
+func authorizedRequest(r *http.Request) bool {
+	if r.Header.Get("Authorization") != "" {
+		return true
+	}
+	m := routeFromContext(r.Context())
+	return m != nil && m.authenticated
+}
+
+// newCacheEntry 根据上游响应创建缓存，响应不能缓存时返回 nil（响应体由调用方填充）
+func newCacheEntry(r *http.Request, primary string, status int, h http.Header, cfg *cacheMiddlewareConfig, limits *CacheConfig, authorized bool, now time.Time) *cacheEntry {
+	if !cacheableStatus[status] || h.Get("Set-Cookie") != "" || h.Get("Trailer") != "" || isEventStream(h) {
+		return nil
+	}
+	cc := parseCacheControl(h.Values("Cache-Control"))
+	if cc.has("no-store") || cc.has("private") {
+		return nil
+	}
+	shared := cc.has("public") || cc.has("s-maxage") || cc.has("must-revalidate")
+	if authorized && !shared {
+		return nil
+	}
+	if n, err := strconv.ParseInt(h.Get("Content-Length"), 10, 64); err == nil && n > limits.MaxEntryBytes {
+		return nil
+	}
+
+	var vary []string
+	for _, value := range h.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name == "*" {
+				return nil
+			} else if name != "" {
+				vary = append(vary, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+
+	lifetime, explicit := freshnessLifetime(h, cc, now)
+	if cfg.TTL.Duration > 0 {
+		lifetime, explicit = cfg.TTL.Duration, true
+	}
+	noCache := cc.has("no-cache")
+	validators := h.Get("ETag") != "" || h.Get("Last-Modified") != ""
+	// 没有缓存时间（或每次都要验证）的响应只有能验证时才值得缓存
+	if (noCache || !explicit || lifetime <= 0) && !validators {
+		return nil
+	}
+
+	var age time.Duration
+	if seconds, err := strconv.ParseInt(h.Get("Age"), 10, 64); err == nil && seconds > 0 {
+		age = time.Duration(seconds) * time.Second
+	}
+
+	e := &cacheEntry{
+		primary:    primary,
+		uri:        r.URL.RequestURI(),
+		vary:       vary,
+		status:     status,
+		header:     h.Clone(),
+		storedAt:   now,
+		freshUntil: now.Add(lifetime - age),
+		initialAge: age,
+		noCache:    noCache,
+		shared:     shared,
+	}
+	e.key = variantKey(primary, vary, r)
+	e.size = int64(len(e.key))
+	for name, values := range h {
+		for _, value := range values {
+			e.size += int64(len(name) + len(value))
+		}
+	}
+	return e
+}
+
+// serveCached 用缓存的响应回复请求，请求的 If-None-Match/If-Modified-Since 匹配时返回 304
+func serveCached(w http.ResponseWriter, r *http.Request, e *cacheEntry, status string, now time.Time) {
+	h := w.Header()
+	copyHeader(h, e.header)
+	h.Set("Age", strconv.Itoa(int(e.age(now)/time.Second)))
+	h.Set(cacheStatusHeader, status)
+	if e.status == http.StatusOK && notModified(r, e.header) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.WriteHeader(e.status)
+	if r.Method != http.MethodHead {
+		w.Write(e.body)
+	}
+}
+
+// notModified 判断客户端缓存的版本是否仍然有效
+func notModified(r *http.Request, h http.Header) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		etag := strings.TrimPrefix(h.Get("ETag"), "W/")
+		if etag == "" {
+			return false
+		}
+		for _, tag := range strings.Split(inm, ",") {
+			if tag = strings.TrimSpace(tag); tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+				return true
+			}
+		}
+		return false
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	modified, err := http.ParseTime(h.Get("Last-Modified"))
+	return err == nil && !modified.After(since)
+}
+
+// cacheRecorder 把上游响应转发给客户端，同时记录可以缓存的响应
+// 验证缓存时上游返回的 304 不转发给客户端，由调用方使用缓存回复
+type cacheRecorder struct {
+	http.ResponseWriter
+	newEntry func(status int, h http.Header) *cacheEntry
+	giveUp   func() // 响应不能缓存时尽早让等待的请求各自转发
+	limit    int64  // 响应体的大小上限，超过时不再记录
+	stale    *cacheEntry
+
+	header      http.Header
+	wroteHeader bool
+	notModified bool
+	entry       *cacheEntry // 可以缓存时记录响应，否则为 nil
+	buf         bytes.Buffer
+}
+
+// Header 写出响应头之前返回单独的 map，只记录上游的响应头，不包括外层中间件设置的响应头
+func (rec *cacheRecorder) Header() http.Header {
+	if rec.wroteHeader && !rec.notModified {
+		return rec.ResponseWriter.Header()
+	}
+	return rec.header
+}
+
+func (rec *cacheRecorder) WriteHeader(code int) {
+	if rec.wroteHeader {
+		return
+	}
+	rec.wroteHeader = true
+	if code == http.StatusNotModified && rec.stale != nil {
+		rec.notModified = true
+		return
+	}
+	if rec.entry = rec.newEntry(code, rec.header); rec.entry == nil {
+		rec.giveUp()
+	}
+	h := rec.ResponseWriter.Header()
+	copyHeader(h, rec.header)
+	h.Set(cacheStatusHeader, "MISS")
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+func (rec *cacheRecorder) Write(b []byte) (int, error) {
+	if !rec.wroteHeader {
+		rec.WriteHeader(http.StatusOK)
+	}
+	if rec.notModified {
+		return len(b), nil
+	}
+	if rec.entry != nil {
+		if int64(rec.buf.Len()+len(b)) > rec.limit {
+			rec.entry = nil
+			rec.buf = bytes.Buffer{}
+			rec.giveUp()
+		} else {
+			rec.buf.Write(b)
+		}
+	}
+	return rec.ResponseWriter.Write(b)
+}
+
+// Unwrap 供 http.ResponseController 访问底层的 ResponseWriter
+func (rec *cacheRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// Flush 支持流式响应
+func (rec *cacheRecorder) Flush() {
+	if !rec.wroteHeader {
+		rec.WriteHeader(http.StatusOK)
+	}
+	if rec.notModified {
+		return
+	}
+	if f, ok := rec.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
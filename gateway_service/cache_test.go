@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"ttt/pkg/log"
+	"ttt/pkg/registry"
+)
+
+// newCacheTestHandler 返回使用 cache 中间件的处理器，上游由 upstream 模拟
+func newCacheTestHandler(t *testing.T, upstream http.HandlerFunc) http.Handler {
+	t.Helper()
+	gs := NewGatewayService(0, registry.NewClient("", log.Nop()), log.Nop())
+	m, err := newCacheMiddleware(gs, json.RawMessage(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m(upstream)
+}
+
+// cacheCaller 请求的调用方
+type cacheCaller int
+
+const (
+	anonymous cacheCaller = iota
+	bearer                // 携带 Authorization
+	apiKey                // 网关验证了 API Key（请求中已经没有凭证）
+)
+
+func (c cacheCaller) String() string {
+	return [...]string{"anonymous", "bearer", "api key"}[c]
+}
+
+// doCached 以 caller 的身份发送请求，header 为额外的请求头
+func doCached(h http.Handler, caller cacheCaller, header map[string]string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "http://gateway/items?page=1", nil)
+	for name, value := range header {
+		r.Header.Set(name, value)
+	}
+	match := &routeMatch{route: &Route{RouteConfig: RouteConfig{Name: "items"}}}
+	switch caller {
+	case bearer:
+		r.Header.Set("Authorization", "Bearer token")
+		match.authenticated = true
+	case apiKey:
+		r.Header.Set(apiKeyIDHeader, "key-1")
+		match.authenticated = true
+	}
+	r = r.WithContext(context.WithValue(r.Context(), routeMatchKey{}, match))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestCacheSharing(t *testing.T) {
+	tests := []struct {
+		name         string
+		cacheControl string
+		first        cacheCaller
+		second       cacheCaller
+		want         string // 第二个请求的 X-Cache
+	}{
+		{"anonymous reuses anonymous", "max-age=60", anonymous, anonymous, "HIT"},
+		{"api key response not cached for anonymous", "max-age=60", apiKey, anonymous, "MISS"},
+		{"api key response not cached for other consumer", "max-age=60", apiKey, apiKey, "MISS"},
+		{"anonymous response not used for api key", "max-age=60", anonymous, apiKey, "MISS"},
+		{"bearer response not cached", "max-age=60", bearer, anonymous, "MISS"},
+		{"anonymous response not used for bearer", "max-age=60", anonymous, bearer, "MISS"},
+		{"public shared with api key", "public, max-age=60", apiKey, anonymous, "HIT"},
+		{"public used for api key", "public, max-age=60", anonymous, apiKey, "HIT"},
+		{"s-maxage shared with bearer", "s-maxage=60", anonymous, bearer, "HIT"},
+		{"must-revalidate shared", "max-age=60, must-revalidate", bearer, apiKey, "HIT"},
+		{"private never cached", "private, max-age=60", anonymous, anonymous, "MISS"},
+		{"no-store never cached", "no-store", anonymous, anonymous, "MISS"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			h := newCacheTestHandler(t, func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				w.Header().Set("Cache-Control", tt.cacheControl)
+				w.Write([]byte(`{"items":[]}`))
+			})
+			if got := doCached(h, tt.first, nil).Header().Get(cacheStatusHeader); got != "MISS" {
+				t.Fatalf("first request (%s) X-Cache = %q, want MISS", tt.first, got)
+			}
+			w := doCached(h, tt.second, nil)
+			if got := w.Header().Get(cacheStatusHeader); got != tt.want {
+				t.Errorf("second request (%s) X-Cache = %q, want %q", tt.second, got, tt.want)
+			}
+			wantCalls := 2
+			if tt.want == "HIT" {
+				wantCalls = 1
+			}
+			if calls != wantCalls || w.Body.String() != `{"items":[]}` {
+				t.Errorf("upstream calls = %d, want %d; body = %q", calls, wantCalls, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestCacheVary(t *testing.T) {
+	type step struct {
+		lang, encoding string
+		want           string // X-Cache
+	}
+	tests := []struct {
+		name  string
+		vary  string
+		steps []step
+	}{
+		{"separate variants", "Accept-Language", []step{
+			{"en", "", "MISS"}, {"en", "", "HIT"}, {"fr", "", "MISS"}, {"fr", "", "HIT"}, {"en", "", "HIT"},
+		}},
+		{"missing header is a variant", "Accept-Language", []step{
+			{"", "", "MISS"}, {"", "", "HIT"}, {"en", "", "MISS"},
+		}},
+		{"unlisted header ignored", "Accept-Language", []step{
+			{"en", "gzip", "MISS"}, {"en", "br", "HIT"},
+		}},
+		{"multiple headers", "Accept-Language, Accept-Encoding", []step{
+			{"en", "gzip", "MISS"}, {"en", "br", "MISS"}, {"en", "gzip", "HIT"}, {"en", "br", "HIT"},
+		}},
+		{"vary star not cached", "*", []step{
+			{"en", "", "MISS"}, {"en", "", "MISS"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newCacheTestHandler(t, func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Cache-Control", "max-age=60")
+				w.Header().Set("Vary", tt.vary)
+				w.Write([]byte(r.Header.Get("Accept-Language") + "/" + r.Header.Get("Accept-Encoding")))
+			})
+			for i, s := range tt.steps {
+				w := doCached(h, anonymous, map[string]string{"Accept-Language": s.lang, "Accept-Encoding": s.encoding})
+				if got := w.Header().Get(cacheStatusHeader); got != s.want {
+					t.Errorf("step %d (%s/%s) X-Cache = %q, want %q", i, s.lang, s.encoding, got, s.want)
+				}
+				// 命中时返回的是同一语言的变体
+				if lang := w.Body.String(); len(lang) < len(s.lang) || lang[:len(s.lang)] != s.lang {
+					t.Errorf("step %d body = %q, want variant %q", i, lang, s.lang)
+				}
+			}
+		})
+	}
+}
+
+func TestCacheRevalidation(t *testing.T) {
+	const lastModified = "Mon, 02 Jan 2006 15:04:05 GMT"
+	tests := []struct {
+		name         string
+		validator    string // 上游响应的验证器：ETag 或 Last-Modified
+		changed      bool   // 第二次请求前上游的内容是否变化
+		clientHeader map[string]string
+		wantCache    string
+		wantStatus   int
+		wantBody     string
+	}{
+		{"etag not modified", "ETag", false, nil, "REVALIDATED", http.StatusOK, "v1"},
+		{"etag changed", "ETag", true, nil, "MISS", http.StatusOK, "v2"},
+		{"last-modified not modified", "Last-Modified", false, nil, "REVALIDATED", http.StatusOK, "v1"},
+		{"client etag matches after revalidation", "ETag", false, map[string]string{"If-None-Match": `W/"v1"`}, "REVALIDATED", http.StatusNotModified, ""},
+		{"client etag stale", "ETag", false, map[string]string{"If-None-Match": `"v0"`}, "REVALIDATED", http.StatusOK, "v1"},
+		{"client since matches", "Last-Modified", false, map[string]string{"If-Modified-Since": lastModified}, "REVALIDATED", http.StatusNotModified, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			version := "v1"
+			conditional := false
+			h := newCacheTestHandler(t, func(w http.ResponseWriter, r *http.Request) {
+				conditional = r.Header.Get("If-None-Match") != "" || r.Header.Get("If-Modified-Since") != ""
+				// max-age=0 的响应每次使用前都需要验证
+				w.Header().Set("Cache-Control", "max-age=0")
+				if tt.validator == "ETag" {
+					w.Header().Set("ETag", `"`+version+`"`)
+					if r.Header.Get("If-None-Match") == `"`+version+`"` {
+						w.WriteHeader(http.StatusNotModified)
+						return
+					}
+				} else {
+					w.Header().Set("Last-Modified", lastModified)
+					if !tt.changed && r.Header.Get("If-Modified-Since") == lastModified {
+						w.WriteHeader(http.StatusNotModified)
+						return
+					}
+				}
+				w.Write([]byte(version))
+			})
+
+			if w := doCached(h, anonymous, nil); w.Header().Get(cacheStatusHeader) != "MISS" || w.Body.String() != "v1" {
+				t.Fatalf("first request X-Cache = %q, body = %q", w.Header().Get(cacheStatusHeader), w.Body.String())
+			}
+			if tt.changed {
+				version = "v2"
+			}
+			w := doCached(h, anonymous, tt.clientHeader)
+			if got := w.Header().Get(cacheStatusHeader); got != tt.wantCache {
+				t.Errorf("X-Cache = %q, want %q", got, tt.wantCache)
+			}
+			if w.Code != tt.wantStatus || w.Body.String() != tt.wantBody {
+				t.Errorf("response = %d %q, want %d %q", w.Code, w.Body.String(), tt.wantStatus, tt.wantBody)
+			}
+			if !conditional {
+				t.Error("stale entry was not revalidated with a conditional request")
+			}
+		})
+	}
+}
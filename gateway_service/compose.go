@@ -0,0 +1,413 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"ttt/pkg/log"
+	"ttt/pkg/requestid"
+)
+
+// composeErrorsField 组合响应中记录可选调用失败原因的字段
+const composeErrorsField = "_errors"
+
+// maxComposeBodyBytes 组合路由中单个调用的响应大小上限
+const maxComposeBodyBytes = 4 << 20
+
+// ComposeConfig 组合路由：并行调用多个服务，把各自的 JSON 结果合并成一个响应
+// 调用的 path 可以引用其他调用的结果，被引用的调用完成后才开始
+type ComposeConfig struct {
+	Calls []ComposeCall `json:"calls"`
+}
+
+// ComposeCall 组合路由中的一次调用
+type ComposeCall struct {
+	// Name 结果在响应中的字段名，也是其他调用引用结果时使用的名称
+	Name    string `json:"name"`
+	Service string `json:"service"`
+	// Path 转发到服务的路径和查询参数，支持以下引用：
+	//   ${query.名称}    请求的查询参数
+	//   ${path.分组名}   path_regex 的命名分组
+	//   ${header.名称}   请求头（如认证后的 X-User-ID）
+	//   ${调用名.字段}   其他调用的 JSON 结果中的字段，多级字段用 . 分隔，数组用下标
+	Path string `json:"path"`
+	// Timeout 调用的超时时间，默认为路由的 timeout
+	Timeout Duration `json:"timeout,omitempty"`
+	// Optional 为 true 时调用失败不影响整个响应：结果为 null，失败原因写入 _errors
+	Optional bool `json:"optional,omitempty"`
+}
+
+// composeRef 模板中的 ${...} 引用
+var composeRef = regexp.MustCompile(`\$\{([^}]*)\}`)
+
+// composeSources 内置的引用来源，不能用作调用名称
+var composeSources = map[string]bool{"query": true, "path": true, "header": true}
+
+// composePlan 编译后的组合路由
+type composePlan struct {
+	calls []*composeStep
+}
+
+// composeStep 编译后的调用
+type composeStep struct {
+	ComposeCall
+	deps []int // 依赖的调用（下标）
+}
+
+// compileCompose 校验组合路由配置，regex 为路由的 path_regex（可以为 nil）
+func compileCompose(cfg ComposeConfig, regex *regexp.Regexp) (*composePlan, []error) {
+	var errs []error
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if len(cfg.Calls) == 0 {
+		fail("compose.calls 至少需要一个调用")
+	}
+	index := make(map[string]int, len(cfg.Calls))
+	for i, call := range cfg.Calls {
+		switch {
+		case call.Name == "":
+			fail("compose.calls[%d] 缺少 name", i)
+		case composeSources[call.Name] || call.Name == composeErrorsField || strings.Contains(call.Name, "."):
+			fail("compose.calls[%d] 的 name %q 是保留名称或包含 .", i, call.Name)
+		default:
+			if _, dup := index[call.Name]; dup {
+				fail("compose.calls[%d] 的 name %q 重复", i, call.Name)
+			}
+			index[call.Name] = i
+		}
+	}
+
+	plan := &composePlan{}
+	for i, call := range cfg.Calls {
+		step := &composeStep{ComposeCall: call}
+		label := fmt.Sprintf("compose.calls[%d] (%s)", i, call.Name)
+		if call.Service == "" {
+			fail("%s 缺少 service", label)
+		}
+		if !strings.HasPrefix(call.Path, "/") {
+			fail("%s 的 path 必须以 / 开头", label)
+		}
+		if call.Timeout.Duration < 0 {
+			fail("%s 的 timeout 不能为负数", label)
+		}
+		for _, m := range composeRef.FindAllStringSubmatch(call.Path, -1) {
+			source, field, _ := strings.Cut(m[1], ".")
+			switch {
+			case field == "":
+				fail("%s 的 path 中 %s 缺少字段名（格式如 ${query.id}）", label, m[0])
+			case source == "path":
+				if regex == nil || regex.SubexpIndex(field) < 0 {
+					fail("%s 的 path 引用了 path_regex 中不存在的分组 %q", label, field)
+				}
+			case composeSources[source]:
+			default:
+				j, ok := index[source]
+				if !ok {
+					fail("%s 的 path 引用了不存在的调用 %q", label, source)
+				} else if j == i {
+					fail("%s 的 path 不能引用自己的结果", label)
+				} else {
+					step.deps = append(step.deps, j)
+				}
+			}
+		}
+		plan.calls = append(plan.calls, step)
+	}
+
+	if len(errs) == 0 {
+		if name := plan.cycle(); name != "" {
+			fail("compose.calls 中的调用 %q 存在循环引用", name)
+		}
+	}
+	return plan, errs
+}
+
+// cycle 检查调用之间的循环引用，返回循环中的一个调用名称
+func (p *composePlan) cycle() string {
+	const (
+		visiting = 1
+		done     = 2
+	)
+	state := make([]int, len(p.calls))
+	var visit func(i int) bool
+	visit = func(i int) bool {
+		switch state[i] {
+		case visiting:
+			return true
+		case done:
+			return false
+		}
+		state[i] = visiting
+		for _, j := range p.calls[i].deps {
+			if visit(j) {
+				return true
+			}
+		}
+		state[i] = done
+		return false
+	}
+	for i := range p.calls {
+		if visit(i) {
+			return p.calls[i].Name
+		}
+	}
+	return ""
+}
+
+// composeResult 一次调用的结果
+type composeResult struct {
+	body    json.RawMessage
+	value   interface{} // 解析后的结果，用于其他调用引用字段
+	status  int         // 失败时返回给客户端的状态码
+	err     error
+	elapsed time.Duration
+}
+
+// compose 组合路由的处理器：没有依赖关系的调用并行执行，全部完成后合并结果
+// 必需的调用失败时返回错误（上游返回 4xx 时使用相同的状态码，其他失败返回 502/504）
+func (gs *GatewayService) compose(plan *composePlan) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			requestid.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		match := routeFromContext(r.Context())
+		route := match.route
+
+		ctx, cancel := context.WithTimeout(r.Context(), route.timeout())
+		defer cancel()
+
+		start := time.Now()
+		// 每个调用只写入自己的结果，读取其他调用的结果前等待它的 done
+		results := make([]*composeResult, len(plan.calls))
+		done := make([]chan struct{}, len(plan.calls))
+		for i := range done {
+			done[i] = make(chan struct{})
+		}
+		for i, step := range plan.calls {
+			go func(i int, step *composeStep) {
+				defer close(done[i])
+				for _, j := range step.deps {
+					<-done[j]
+					if results[j].err != nil {
+						results[i] = &composeResult{status: http.StatusBadGateway, err: fmt.Errorf("依赖的调用 %s 失败", plan.calls[j].Name)}
+						return
+					}
+				}
+				results[i] = gs.composeCall(ctx, r, match, step, results, plan)
+			}(i, step)
+		}
+		for _, ch := range done {
+			<-ch
+		}
+		if r.Context().Err() != nil {
+			return
+		}
+
+		response := make(map[string]interface{}, len(plan.calls)+1)
+		failures := make(map[string]string)
+		for i, step := range plan.calls {
+			res := results[i]
+			if res.err == nil {
+				response[step.Name] = res.body
+				continue
+			}
+			gs.logger.ForRequest(r).Warn("✗ 组合调用失败",
+				log.String("route", route.Name),
+				log.String("call", step.Name),
+				log.String("service", step.Service),
+				log.Bool("optional", step.Optional),
+				log.Err(res.err))
+			if !step.Optional {
+				requestid.Error(w, r, fmt.Sprintf("%s: %v", step.Name, res.err), res.status)
+				return
+			}
+			response[step.Name] = nil
+			failures[step.Name] = res.err.Error()
+		}
+		if len(failures) > 0 {
+			response[composeErrorsField] = failures
+		}
+
+		gs.logger.ForRequest(r).Info("✓ 组合响应完成",
+			log.String("route", route.Name),
+			log.Int("calls", len(plan.calls)),
+			log.Int("failed", len(failures)),
+			log.Duration("duration", time.Since(start)))
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodHead {
+			return
+		}
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// composeCall 执行一次调用（依赖的调用都已成功）
+func (gs *GatewayService) composeCall(ctx context.Context, r *http.Request, match *routeMatch, step *composeStep, results []*composeResult, plan *composePlan) *composeResult {
+	timeout := step.Timeout.Duration
+	if timeout == 0 {
+		timeout = match.route.timeout()
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	path, status, err := plan.expand(step.Path, r, match, results)
+	if err != nil {
+		return &composeResult{status: status, err: err}
+	}
+
+	upstream, generation, _, err := gs.pickUpstream(step.Service, "", nil)
+	if err != nil {
+		return &composeResult{status: http.StatusServiceUnavailable, err: fmt.Errorf("服务 %s 不可用", step.Service)}
+	}
+
+	// 调用不携带客户端的请求体和查询参数；不接受压缩，便于解析结果
+	req := r.Clone(ctx)
+	req.Method = http.MethodGet
+	req.URL.RawQuery = ""
+	req.Body, req.ContentLength = nil, 0
+	for _, name := range []string{"Accept-Encoding", "Content-Type", "Content-Length", "If-None-Match", "If-Modified-Since", "Range"} {
+		req.Header.Del(name)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	start := time.Now()
+	resp, err := gs.sendRequest(ctx, req, upstream.URL+path, nil)
+	recordUpstream(ctx, upstream, time.Since(start))
+	if err != nil {
+		if r.Context().Err() != nil {
+			upstream.breaker.Cancel(generation)
+			return &composeResult{status: http.StatusBadGateway, err: err}
+		}
+		gs.recordResult(upstream, generation, 0, time.Since(start), err)
+		if ctx.Err() != nil {
+			return &composeResult{status: http.StatusGatewayTimeout, err: fmt.Errorf("调用超时（%s）", timeout)}
+		}
+		return &composeResult{status: http.StatusBadGateway, err: err}
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxComposeBodyBytes+1))
+	gs.recordResult(upstream, generation, resp.StatusCode, time.Since(start), err)
+
+	res := &composeResult{elapsed: time.Since(start)}
+	switch {
+	case err != nil:
+		res.status, res.err = http.StatusBadGateway, err
+	case resp.StatusCode >= 400:
+		res.status = http.StatusBadGateway
+		if resp.StatusCode < 500 {
+			res.status = resp.StatusCode
+		}
+		res.err = fmt.Errorf("%s 返回 %d: %s", step.Service, resp.StatusCode, upstreamErrorMessage(body))
+	case len(body) > maxComposeBodyBytes:
+		res.status, res.err = http.StatusBadGateway, fmt.Errorf("响应超过 %d 字节", maxComposeBodyBytes)
+	default:
+		dec := json.NewDecoder(bytes.NewReader(body))
+		dec.UseNumber()
+		if err := dec.Decode(&res.value); err != nil {
+			res.status, res.err = http.StatusBadGateway, errors.New("响应不是有效的JSON")
+		} else {
+			res.body = body
+		}
+	}
+	gs.logger.ForRequest(r).Debug("组合调用完成",
+		log.String("call", step.Name),
+		log.String("target", upstream.URL+path),
+		log.Int("status", resp.StatusCode),
+		log.Duration("duration", res.elapsed))
+	return res
+}
+
+// upstreamErrorMessage 从上游的错误响应中提取错误信息
+func upstreamErrorMessage(body []byte) string {
+	var payload struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(body, &payload) == nil && payload.Error != "" {
+		return payload.Error
+	}
+	msg := strings.TrimSpace(string(body))
+	if len(msg) > 200 {
+		msg = msg[:200]
+	}
+	return msg
+}
+
+// expand 替换调用路径中的引用，值经过URL编码
+// 缺少请求中的值时返回 400，其他调用的结果中缺少字段时返回 502
+func (p *composePlan) expand(template string, r *http.Request, match *routeMatch, results []*composeResult) (string, int, error) {
+	var missing []string
+	status := http.StatusBadRequest
+	out := composeRef.ReplaceAllStringFunc(template, func(ref string) string {
+		source, field, _ := strings.Cut(ref[2:len(ref)-1], ".")
+		var value string
+		var ok bool
+		switch source {
+		case "query":
+			value, ok = r.URL.Query().Get(field), r.URL.Query().Has(field)
+		case "path":
+			value, ok = match.params[field]
+		case "header":
+			value = r.Header.Get(field)
+			ok = value != ""
+		default:
+			for i, step := range p.calls {
+				if step.Name == source {
+					value, ok = jsonField(results[i].value, field)
+					break
+				}
+			}
+			if !ok {
+				status = http.StatusBadGateway
+			}
+		}
+		if !ok {
+			missing = append(missing, ref)
+			return ""
+		}
+		return strings.ReplaceAll(url.QueryEscape(value), "+", "%20")
+	})
+	if len(missing) > 0 {
+		return "", status, fmt.Errorf("缺少 %s 的值", strings.Join(missing, ", "))
+	}
+	return out, http.StatusOK, nil
+}
+
+// jsonField 按 a.b.0.c 形式的路径取 JSON 中的字段，只支持字符串、数字和布尔值
+func jsonField(v interface{}, path string) (string, bool) {
+	for _, key := range strings.Split(path, ".") {
+		switch node := v.(type) {
+		case map[string]interface{}:
+			v = node[key]
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(node) {
+				return "", false
+			}
+			v = node[i]
+		default:
+			return "", false
+		}
+	}
+	switch value := v.(type) {
+	case string:
+		return value, true
+	case json.Number:
+		return value.String(), true
+	case bool:
+		return strconv.FormatBool(value), true
+	}
+	return "", false
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"ttt/pkg/registry"
+)
+
+func TestCompileCompose(t *testing.T) {
+	regex := regexp.MustCompile(`^/api/profile/(?P<id>\d+)$`)
+	call := func(name, path string) ComposeCall {
+		return ComposeCall{Name: name, Service: "svc", Path: path}
+	}
+
+	tests := []struct {
+		name     string
+		calls    []ComposeCall
+		wantErr  string // 错误信息中的片段，空表示没有错误
+		wantDeps [][]int
+	}{
+		{"independent calls", []ComposeCall{call("user", "/users/${path.id}"), call("stats", "/stats?q=${query.q}")}, "", [][]int{nil, nil}},
+		{"dependency", []ComposeCall{call("orders", "/orders?user=${user.id}"), call("user", "/users/${header.X-User-ID}")}, "", [][]int{{1}, nil}},
+		{"chain", []ComposeCall{call("a", "/a"), call("b", "/b/${a.id}"), call("c", "/c/${b.id}/${a.id}")}, "", [][]int{nil, {0}, {1, 0}}},
+		{"no calls", nil, "至少需要一个调用", nil},
+		{"missing name", []ComposeCall{call("", "/a")}, "缺少 name", nil},
+		{"reserved name", []ComposeCall{call("query", "/a")}, "保留名称", nil},
+		{"errors field name", []ComposeCall{call("_errors", "/a")}, "保留名称", nil},
+		{"dotted name", []ComposeCall{call("a.b", "/a")}, "保留名称", nil},
+		{"duplicate name", []ComposeCall{call("a", "/a"), call("a", "/b")}, "重复", nil},
+		{"missing service", []ComposeCall{{Name: "a", Path: "/a"}}, "缺少 service", nil},
+		{"relative path", []ComposeCall{call("a", "a")}, "必须以 / 开头", nil},
+		{"missing field", []ComposeCall{call("a", "/a/${query}")}, "缺少字段名", nil},
+		{"unknown path group", []ComposeCall{call("a", "/a/${path.name}")}, "不存在的分组", nil},
+		{"unknown call", []ComposeCall{call("a", "/a/${b.id}")}, "不存在的调用", nil},
+		{"self reference", []ComposeCall{call("a", "/a/${a.id}")}, "不能引用自己", nil},
+		{"cycle", []ComposeCall{call("a", "/a/${b.id}"), call("b", "/b/${a.id}")}, "循环引用", nil},
+		{"longer cycle", []ComposeCall{call("a", "/a/${c.id}"), call("b", "/b/${a.id}"), call("c", "/c/${b.id}"), call("d", "/d")}, "循环引用", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan, errs := compileCompose(ComposeConfig{Calls: tt.calls}, regex)
+			if tt.wantErr == "" {
+				if len(errs) > 0 {
+					t.Fatalf("unexpected errors: %v", errs)
+				}
+				for i, step := range plan.calls {
+					if !reflect.DeepEqual(step.deps, tt.wantDeps[i]) {
+						t.Errorf("calls[%d].deps = %v, want %v", i, step.deps, tt.wantDeps[i])
+					}
+				}
+				return
+			}
+			var msgs []string
+			for _, err := range errs {
+				msgs = append(msgs, err.Error())
+			}
+			if !strings.Contains(strings.Join(msgs, "\n"), tt.wantErr) {
+				t.Errorf("errors = %v, want one containing %q", msgs, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestJSONField(t *testing.T) {
+	dec := json.NewDecoder(strings.NewReader(`{"id": 42, "name": "Ann Lee", "active": true, "tags": ["a", "b"], "address": {"city": "Paris"}, "note": null}`))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path   string
+		want   string
+		wantOK bool
+	}{
+		{"id", "42", true},
+		{"name", "Ann Lee", true},
+		{"active", "true", true},
+		{"tags.1", "b", true},
+		{"address.city", "Paris", true},
+		{"tags.2", "", false},
+		{"tags.x", "", false},
+		{"address", "", false},
+		{"note", "", false},
+		{"missing", "", false},
+		{"id.x", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := jsonField(v, tt.path)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("jsonField(%q) = %q, %v, want %q, %v", tt.path, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestComposeExpand(t *testing.T) {
+	plan, errs := compileCompose(ComposeConfig{Calls: []ComposeCall{
+		{Name: "user", Service: "svc", Path: "/users/1"},
+		{Name: "orders", Service: "svc", Path: "/orders"},
+	}}, nil)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	results := []*composeResult{{value: map[string]interface{}{"name": "Ann Lee"}}, nil}
+	r := httptest.NewRequest(http.MethodGet, "/api/profile?q=a%26b&empty=", nil)
+	r.Header.Set("X-User-ID", "7")
+	match := &routeMatch{params: map[string]string{"id": "1"}}
+
+	tests := []struct {
+		template   string
+		want       string
+		wantStatus int
+	}{
+		{"/users/${path.id}", "/users/1", http.StatusOK},
+		{"/search?q=${query.q}", "/search?q=a%26b", http.StatusOK},
+		{"/search?q=${query.empty}", "/search?q=", http.StatusOK},
+		{"/users/${header.X-User-ID}", "/users/7", http.StatusOK},
+		{"/orders?name=${user.name}", "/orders?name=Ann%20Lee", http.StatusOK},
+		{"/search?q=${query.missing}", "", http.StatusBadRequest},
+		{"/users/${header.X-Missing}", "", http.StatusBadRequest},
+		{"/orders?id=${user.id}", "", http.StatusBadGateway},
+	}
+	for _, tt := range tests {
+		got, status, err := plan.expand(tt.template, r, match, results)
+		if got != tt.want || status != tt.wantStatus || (err != nil) != (tt.wantStatus != http.StatusOK) {
+			t.Errorf("expand(%q) = %q, %d, %v, want %q, %d", tt.template, got, status, err, tt.want, tt.wantStatus)
+		}
+	}
+}
+
+func TestCompose(t *testing.T) {
+	var mu sync.Mutex
+	var requested []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requested = append(requested, r.URL.RequestURI())
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/users/1":
+			w.Write([]byte(`{"id": 1, "team": "core"}`))
+		case "/teams/core":
+			w.Write([]byte(`{"name": "Core"}`))
+		case "/invalid":
+			w.Write([]byte(`not json`))
+		case "/missing":
+			http.Error(w, `{"error": "no such item"}`, http.StatusNotFound)
+		default:
+			http.Error(w, "boom", http.StatusInternalServerError)
+		}
+	}))
+	defer upstream.Close()
+
+	call := func(name, path string, optional bool) ComposeCall {
+		return ComposeCall{Name: name, Service: "svc", Path: path, Optional: optional}
+	}
+	tests := []struct {
+		name       string
+		calls      []ComposeCall
+		wantStatus int
+		wantBody   string // 状态码为 200 时的响应
+		wantErr    string // 其他状态码时错误信息中的片段
+		wantCalls  []string
+	}{
+		{"dependency", []ComposeCall{call("team", "/teams/${user.team}", false), call("user", "/users/${query.id}", false)},
+			http.StatusOK, `{"team":{"name":"Core"},"user":{"id":1,"team":"core"}}`, "", []string{"/users/1", "/teams/core"}},
+		{"optional failure", []ComposeCall{call("user", "/users/1", false), call("stats", "/stats", true)},
+			http.StatusOK, `{"_errors":{"stats":"svc 返回 500: boom"},"stats":null,"user":{"id":1,"team":"core"}}`, "", nil},
+		{"required failure", []ComposeCall{call("user", "/users/1", false), call("stats", "/stats", false)},
+			http.StatusBadGateway, "", "stats: svc 返回 500", nil},
+		{"upstream 4xx kept", []ComposeCall{call("item", "/missing", false)},
+			http.StatusNotFound, "", "no such item", nil},
+		{"invalid json", []ComposeCall{call("item", "/invalid", false)},
+			http.StatusBadGateway, "", "不是有效的JSON", nil},
+		{"failed dependency skips call", []ComposeCall{call("stats", "/stats", true), call("user", "/users/${stats.id}", true)},
+			http.StatusOK, `{"_errors":{"stats":"svc 返回 500: boom","user":"依赖的调用 stats 失败"},"stats":null,"user":null}`, "", []string{"/stats"}},
+		{"missing query", []ComposeCall{call("user", "/users/${query.missing}", false)},
+			http.StatusBadRequest, "", "缺少 ${query.missing} 的值", []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan, errs := compileCompose(ComposeConfig{Calls: tt.calls}, nil)
+			if len(errs) > 0 {
+				t.Fatal(errs)
+			}
+			gs := newUpstreamTestService(registry.Instance{ID: "svc-1", Name: "svc", URL: upstream.URL})
+			mu.Lock()
+			requested = nil
+			mu.Unlock()
+
+			r := httptest.NewRequest(http.MethodGet, "/api/profile?id=1", nil)
+			match := &routeMatch{route: &Route{RouteConfig: RouteConfig{Name: "profile"}}}
+			r = r.WithContext(context.WithValue(r.Context(), routeMatchKey{}, match))
+			w := httptest.NewRecorder()
+			gs.compose(plan)(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %q)", w.Code, tt.wantStatus, w.Body.String())
+			}
+			body := strings.TrimSpace(w.Body.String())
+			if tt.wantStatus == http.StatusOK && body != tt.wantBody {
+				t.Errorf("body = %s, want %s", body, tt.wantBody)
+			}
+			if tt.wantStatus != http.StatusOK && !strings.Contains(body, tt.wantErr) {
+				t.Errorf("body = %q, want error containing %q", body, tt.wantErr)
+			}
+			// 依赖的调用在被依赖的调用完成后才发送
+			if tt.wantCalls != nil {
+				mu.Lock()
+				got := append([]string{}, requested...)
+				mu.Unlock()
+				if strings.Join(got, " ") != strings.Join(tt.wantCalls, " ") {
+					t.Errorf("upstream requests = %v, want %v", got, tt.wantCalls)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"ttt/pkg/log"
+	"ttt/pkg/requestid"
+)
+
+// CORSConfig 路由的跨域配置
+type CORSConfig struct {
+	AllowedOrigins   []string `json:"allowed_origins"`             // 允许的来源，支持 "*" 和 "https://*.example.com"
+	AllowedMethods   []string `json:"allowed_methods,omitempty"`   // 默认为路由的 methods，路由未限制方法时为常用方法
+	AllowedHeaders   []string `json:"allowed_headers,omitempty"`   // 允许的请求头，"*" 表示允许预检请求中的所有请求头
+	ExposedHeaders   []string `json:"exposed_headers,omitempty"`   // 浏览器可以读取的响应头
+	AllowCredentials bool     `json:"allow_credentials,omitempty"` // 是否允许携带Cookie等凭据
+	MaxAge           Duration `json:"max_age,omitempty"`           // 预检结果的缓存时间，默认 10m
+}
+
+// 未配置时的默认值
+var (
+	defaultCORSMethods        = []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+	defaultCORSHeaders        = []string{"Accept", "Authorization", "Content-Type", "Idempotency-Key", "X-API-Key", "X-Request-ID"}
+	defaultCORSExposedHeaders = []string{"X-Request-ID", "Retry-After", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset"}
+)
+
+// corsResponseHeaders 网关负责的跨域响应头，上游返回的同名响应头会被删除
+var corsResponseHeaders = []string{
+	"Access-Control-Allow-Origin",
+	"Access-Control-Allow-Credentials",
+	"Access-Control-Allow-Methods",
+	"Access-Control-Allow-Headers",
+	"Access-Control-Expose-Headers",
+	"Access-Control-Max-Age",
+}
+
+// corsPolicy 编译后的跨域配置
+type corsPolicy struct {
+	anyOrigin   bool
+	origins     map[string]bool
+	wildcards   [][2]string // "https://*.example.com" -> {"https://", ".example.com"}
+	methods     map[string]bool
+	anyHeader   bool
+	headers     map[string]bool // 小写
+	credentials bool
+
+	allowMethods  string
+	allowHeaders  string
+	exposeHeaders string
+	maxAge        string
+}
+
+// compileCORS 校验并编译跨域配置，routeMethods 为路由限制的方法
+func compileCORS(cfg CORSConfig, routeMethods []string) (*corsPolicy, []error) {
+	var errs []error
+	p := &corsPolicy{
+		origins:     make(map[string]bool),
+		methods:     make(map[string]bool),
+		headers:     make(map[string]bool),
+		credentials: cfg.AllowCredentials,
+	}
+
+	if len(cfg.AllowedOrigins) == 0 {
+		errs = append(errs, errors.New("cors.allowed_origins 不能为空"))
+	}
+	for _, origin := range cfg.AllowedOrigins {
+		origin = strings.ToLower(strings.TrimSuffix(origin, "/"))
+		switch {
+		case origin == "*":
+			p.anyOrigin = true
+		case strings.Count(origin, "*") == 1 && strings.Contains(origin, "://*."):
+			prefix, suffix, _ := strings.Cut(origin, "*")
+			p.wildcards = append(p.wildcards, [2]string{prefix, suffix})
+		case strings.Contains(origin, "*") || !strings.Contains(origin, "://"):
+			errs = append(errs, fmt.Errorf("cors.allowed_origins 中的来源无效: %q（格式如 https://app.example.com 或 https://*.example.com）", origin))
+		default:
+			p.origins[origin] = true
+		}
+	}
+	if p.anyOrigin && p.credentials {
+		errs = append(errs, errors.New("cors.allow_credentials 不能与 allowed_origins \"*\" 同时使用"))
+	}
+
+	methods := cfg.AllowedMethods
+	if len(methods) == 0 {
+		methods = routeMethods
+	}
+	if len(methods) == 0 {
+		methods = defaultCORSMethods
+	}
+	names := make([]string, 0, len(methods))
+	for _, m := range methods {
+		m = strings.ToUpper(m)
+		if !validMethods[m] {
+			errs = append(errs, fmt.Errorf("cors.allowed_methods 中有未知的HTTP方法 %q", m))
+		}
+		p.methods[m] = true
+		names = append(names, m)
+	}
+	p.allowMethods = strings.Join(names, ", ")
+
+	headers := cfg.AllowedHeaders
+	if len(headers) == 0 {
+		headers = defaultCORSHeaders
+	}
+	names = names[:0:0]
+	for _, h := range headers {
+		if h == "*" {
+			p.anyHeader = true
+			continue
+		}
+		p.headers[strings.ToLower(h)] = true
+		names = append(names, http.CanonicalHeaderKey(h))
+	}
+	p.allowHeaders = strings.Join(names, ", ")
+
+	exposed := cfg.ExposedHeaders
+	if exposed == nil {
+		exposed = defaultCORSExposedHeaders
+	}
+	p.exposeHeaders = strings.Join(exposed, ", ")
+
+	maxAge := cfg.MaxAge.Duration
+	if maxAge == 0 {
+		maxAge = 10 * time.Minute
+	}
+	if maxAge < 0 {
+		errs = append(errs, errors.New("cors.max_age 不能为负数"))
+	}
+	p.maxAge = strconv.Itoa(int(maxAge / time.Second))
+
+	return p, errs
+}
+
+// allowOrigin 判断来源是否被允许
+func (p *corsPolicy) allowOrigin(origin string) bool {
+	if p.anyOrigin {
+		return true
+	}
+	origin = strings.ToLower(origin)
+	if p.origins[origin] {
+		return true
+	}
+	for _, w := range p.wildcards {
+		if len(origin) > len(w[0])+len(w[1]) && strings.HasPrefix(origin, w[0]) && strings.HasSuffix(origin, w[1]) {
+			return true
+		}
+	}
+	return false
+}
+
+// isPreflight 判断是否为跨域预检请求
+func isPreflight(r *http.Request) bool {
+	return r.Method == http.MethodOptions && r.Header.Get("Origin") != "" && r.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// cors 处理路由的跨域请求：预检请求由网关直接响应，不转发给上游；
+// 普通跨域请求添加 Access-Control-* 响应头（替换上游返回的同名响应头）
+// 跨域处理在认证之前执行，因为浏览器的预检请求不携带凭据
+func (gs *GatewayService) cors(p *corsPolicy, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		h := w.Header()
+		h.Add("Vary", "Origin")
+
+		allowed := p.allowOrigin(origin)
+		if isPreflight(r) {
+			h.Add("Vary", "Access-Control-Request-Method")
+			h.Add("Vary", "Access-Control-Request-Headers")
+			gs.preflight(w, r, p, origin, allowed)
+			return
+		}
+		if !allowed {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		set := map[string]string{"Access-Control-Allow-Origin": origin}
+		if p.anyOrigin {
+			set["Access-Control-Allow-Origin"] = "*"
+		}
+		if p.credentials {
+			set["Access-Control-Allow-Credentials"] = "true"
+		}
+		if p.exposeHeaders != "" {
+			set["Access-Control-Expose-Headers"] = p.exposeHeaders
+		}
+		next.ServeHTTP(&headerRewriter{ResponseWriter: w, set: set, remove: corsResponseHeaders}, r)
+	})
+}
+
+// preflight 响应预检请求
+func (gs *GatewayService) preflight(w http.ResponseWriter, r *http.Request, p *corsPolicy, origin string, allowed bool) {
+	route := routeFromContext(r.Context()).route
+	method := strings.ToUpper(r.Header.Get("Access-Control-Request-Method"))
+	reject := func(reason string) {
+		gs.logger.ForRequest(r).Warn("✗ 拒绝跨域预检请求",
+			log.String("route", route.Name),
+			log.String("origin", origin),
+			log.String("reason", reason))
+		requestid.Error(w, r, "CORS preflight rejected: "+reason, http.StatusForbidden)
+	}
+
+	if !allowed {
+		reject("origin not allowed")
+		return
+	}
+	if !p.methods[method] {
+		reject("method " + method + " not allowed")
+		return
+	}
+	requested := r.Header.Get("Access-Control-Request-Headers")
+	if !p.anyHeader {
+		for _, name := range strings.Split(requested, ",") {
+			name = strings.ToLower(strings.TrimSpace(name))
+			if name != "" && !p.headers[name] {
+				reject("header " + name + " not allowed")
+				return
+			}
+		}
+	}
+
+	h := w.Header()
+	if p.anyOrigin {
+		h.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		h.Set("Access-Control-Allow-Origin", origin)
+	}
+	if p.credentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+	h.Set("Access-Control-Allow-Methods", p.allowMethods)
+	if p.anyHeader && requested != "" {
+		h.Set("Access-Control-Allow-Headers", requested)
+	} else if p.allowHeaders != "" {
+		h.Set("Access-Control-Allow-Headers", p.allowHeaders)
+	}
+	h.Set("Access-Control-Max-Age", p.maxAge)
+	w.WriteHeader(http.StatusNoContent)
+}
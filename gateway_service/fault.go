@@ -0,0 +1,324 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	mathrand "math/rand"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"ttt/pkg/log"
+	"ttt/pkg/requestid"
+)
+
+// faultHeader 注入了故障的响应带有该响应头，值为故障类型（连接重置的请求无法带上）
+const faultHeader = "X-Gateway-Fault"
+
+// defaultFaultDuration 通过管理接口开启的故障默认持续时间
+const defaultFaultDuration = 10 * time.Minute
+
+// FaultConfig 路由的故障注入配置，用于测试服务和客户端在上游故障时的表现
+//
+// 请求满足以下任一条件时注入故障：
+//   - 配置了 header 且请求带有该请求头（配置了 header_value 时值也要相同）
+//   - 按 percent 随机选中
+//
+// 延迟可以和其他故障同时使用；abort、reset、truncate_bytes 最多设置一个。
+type FaultConfig struct {
+	Percent     float64 `json:"percent,omitempty"`      // 注入故障的请求比例（0-100）
+	Header      string  `json:"header,omitempty"`       // 带有该请求头的请求注入故障
+	HeaderValue string  `json:"header_value,omitempty"` // 请求头的值，为空表示任意值
+
+	Delay    Duration `json:"delay,omitempty"`     // 转发前的延迟
+	DelayMax Duration `json:"delay_max,omitempty"` // 设置时延迟在 delay 和 delay_max 之间随机
+	Abort    int      `json:"abort,omitempty"`     // 不转发，直接返回该状态码
+	Reset    bool     `json:"reset,omitempty"`     // 不转发，直接断开客户端连接
+	// TruncateBytes 转发请求，但响应体只返回前 N 个字节后断开连接
+	TruncateBytes int64 `json:"truncate_bytes,omitempty"`
+}
+
+// validate 校验故障注入配置
+func (c FaultConfig) validate() []error {
+	var errs []error
+	if c.Percent < 0 || c.Percent > 100 {
+		errs = append(errs, errors.New("fault.percent 必须在 0 到 100 之间"))
+	}
+	if c.Percent == 0 && c.Header == "" {
+		errs = append(errs, errors.New("fault 需要设置 percent 或 header"))
+	}
+	if c.HeaderValue != "" && c.Header == "" {
+		errs = append(errs, errors.New("fault.header_value 需要同时设置 header"))
+	}
+	if c.Delay.Duration < 0 || c.DelayMax.Duration < 0 || c.TruncateBytes < 0 {
+		errs = append(errs, errors.New("fault.delay、delay_max 和 truncate_bytes 不能为负数"))
+	}
+	if c.DelayMax.Duration > 0 && c.DelayMax.Duration < c.Delay.Duration {
+		errs = append(errs, errors.New("fault.delay_max 不能小于 delay"))
+	}
+	if c.Abort != 0 && (c.Abort < 200 || c.Abort > 599) {
+		errs = append(errs, fmt.Errorf("fault.abort 不是有效的状态码: %d", c.Abort))
+	}
+	kinds := 0
+	for _, set := range []bool{c.Abort != 0, c.Reset, c.TruncateBytes > 0} {
+		if set {
+			kinds++
+		}
+	}
+	if kinds > 1 {
+		errs = append(errs, errors.New("fault.abort、reset 和 truncate_bytes 最多设置一个"))
+	}
+	if kinds == 0 && c.Delay.Duration == 0 && c.DelayMax.Duration == 0 {
+		errs = append(errs, errors.New("fault 至少需要设置 delay、abort、reset 或 truncate_bytes 中的一个"))
+	}
+	return errs
+}
+
+// matches 判断请求是否需要注入故障
+func (c *FaultConfig) matches(r *http.Request) bool {
+	if c.Header != "" {
+		if values := r.Header.Values(c.Header); len(values) > 0 && (c.HeaderValue == "" || values[0] == c.HeaderValue) {
+			return true
+		}
+	}
+	return c.Percent > 0 && mathrand.Float64()*100 < c.Percent
+}
+
+// delay 返回本次请求的延迟
+func (c *FaultConfig) delay() time.Duration {
+	if c.DelayMax.Duration > c.Delay.Duration {
+		return c.Delay.Duration + time.Duration(mathrand.Int63n(int64(c.DelayMax.Duration-c.Delay.Duration)))
+	}
+	return c.Delay.Duration
+}
+
+// runtimeFault 通过管理接口开启的故障，到期后自动失效
+type runtimeFault struct {
+	FaultConfig
+	expiresAt time.Time
+}
+
+// activeFault 返回路由当前生效的故障配置：管理接口开启的故障优先于配置文件
+func (gs *GatewayService) activeFault(route *Route, now time.Time) (cfg *FaultConfig, runtime bool) {
+	gs.faultMu.RLock()
+	rf := gs.runtimeFaults[route.Name]
+	gs.faultMu.RUnlock()
+	if rf != nil && now.Before(rf.expiresAt) {
+		return &rf.FaultConfig, true
+	}
+	return route.Fault, false
+}
+
+// setRuntimeFault 通过管理接口开启（cfg 不为 nil）或关闭路由的故障
+func (gs *GatewayService) setRuntimeFault(route string, cfg *FaultConfig, expiresAt time.Time) {
+	gs.faultMu.Lock()
+	defer gs.faultMu.Unlock()
+	if cfg == nil {
+		delete(gs.runtimeFaults, route)
+		return
+	}
+	if gs.runtimeFaults == nil {
+		gs.runtimeFaults = make(map[string]*runtimeFault)
+	}
+	gs.runtimeFaults[route] = &runtimeFault{FaultConfig: *cfg, expiresAt: expiresAt}
+}
+
+// errFaultTruncated 注入的响应截断
+var errFaultTruncated = errors.New("故障注入：截断响应体")
+
+// injectFaults 在转发（或组合调用）之前按路由的故障配置注入故障
+func (gs *GatewayService) injectFaults(route *Route, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fault, runtime := gs.activeFault(route, time.Now())
+		if fault == nil || !fault.matches(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		source := "config"
+		if runtime {
+			source = "admin"
+		}
+		logger := gs.logger.ForRequest(r).With(log.String("route", route.Name), log.String("source", source))
+
+		if d := fault.delay(); d > 0 {
+			gs.faults.record(route.Name, "delay")
+			logger.Warn("⚡ 注入故障：延迟", log.Duration("delay", d))
+			w.Header().Add(faultHeader, "delay")
+			timer := time.NewTimer(d)
+			select {
+			case <-timer.C:
+			case <-r.Context().Done():
+				timer.Stop()
+				return
+			}
+		}
+
+		switch {
+		case fault.Abort != 0:
+			gs.faults.record(route.Name, "abort")
+			logger.Warn("⚡ 注入故障：返回错误状态码", log.Int("status", fault.Abort))
+			w.Header().Add(faultHeader, "abort")
+			requestid.Error(w, r, "Fault injected", fault.Abort)
+		case fault.Reset:
+			gs.faults.record(route.Name, "reset")
+			logger.Warn("⚡ 注入故障：断开连接")
+			resetConnection(w)
+		case fault.TruncateBytes > 0:
+			gs.faults.record(route.Name, "truncate")
+			logger.Warn("⚡ 注入故障：截断响应体", log.Int64("truncate_bytes", fault.TruncateBytes))
+			w.Header().Add(faultHeader, "truncate")
+			tw := &truncatingWriter{ResponseWriter: w, remaining: fault.TruncateBytes}
+			next.ServeHTTP(tw, r)
+			if tw.truncated {
+				// 中止连接，客户端收到的是不完整的响应
+				panic(http.ErrAbortHandler)
+			}
+		default:
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// resetConnection 不返回响应直接关闭客户端连接（HTTP/1.x 发送 TCP RST，HTTP/2 重置该请求的流）
+func resetConnection(w http.ResponseWriter) {
+	conn, _, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		panic(http.ErrAbortHandler)
+	}
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		tcp.SetLinger(0)
+	}
+	conn.Close()
+}
+
+// truncatingWriter 只写出响应体的前 remaining 个字节
+type truncatingWriter struct {
+	http.ResponseWriter
+	remaining int64
+	truncated bool
+}
+
+func (tw *truncatingWriter) Write(b []byte) (int, error) {
+	if int64(len(b)) <= tw.remaining {
+		n, err := tw.ResponseWriter.Write(b)
+		tw.remaining -= int64(n)
+		return n, err
+	}
+	n, err := tw.ResponseWriter.Write(b[:tw.remaining])
+	tw.remaining -= int64(n)
+	tw.truncated = true
+	// 中止连接前把已写出的部分发送给客户端
+	http.NewResponseController(tw.ResponseWriter).Flush()
+	if err == nil {
+		err = errFaultTruncated
+	}
+	return n, err
+}
+
+// Unwrap 供 http.ResponseController 访问底层的 ResponseWriter
+func (tw *truncatingWriter) Unwrap() http.ResponseWriter {
+	return tw.ResponseWriter
+}
+
+// Flush 支持流式响应
+func (tw *truncatingWriter) Flush() {
+	if f, ok := tw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// faultStats 每条路由注入的故障次数（按故障类型，重新加载配置时保留）
+type faultStats struct {
+	mu     sync.Mutex
+	counts map[string]map[string]int64 // 路由名 -> 故障类型 -> 次数
+}
+
+func (s *faultStats) record(route, kind string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.counts == nil {
+		s.counts = make(map[string]map[string]int64)
+	}
+	if s.counts[route] == nil {
+		s.counts[route] = make(map[string]int64)
+	}
+	s.counts[route][kind]++
+}
+
+// Snapshot 返回路由各类故障的注入次数
+func (s *faultStats) Snapshot(route string) map[string]int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make(map[string]int64, len(s.counts[route]))
+	for kind, n := range s.counts[route] {
+		out[kind] = n
+	}
+	return out
+}
+
+// FaultStatus 路由的故障注入状态（用于管理接口）
+type FaultStatus struct {
+	Route     string           `json:"route"`
+	Source    string           `json:"source,omitempty"` // config 或 admin，没有生效的故障时为空
+	Fault     *FaultConfig     `json:"fault,omitempty"`
+	ExpiresAt *time.Time       `json:"expires_at,omitempty"`
+	Injected  map[string]int64 `json:"injected"` // 故障类型 -> 注入次数
+}
+
+// faultStatuses 返回配置了故障或注入过故障的路由的状态，name 不为空时只返回该路由
+func (gs *GatewayService) faultStatuses(name string) []FaultStatus {
+	table := gs.routes.Load()
+	if table == nil {
+		return nil
+	}
+	now := time.Now()
+	statuses := []FaultStatus{}
+	for _, route := range table.Routes {
+		if name != "" && route.Name != name {
+			continue
+		}
+		st := FaultStatus{Route: route.Name, Injected: gs.faults.Snapshot(route.Name)}
+		fault, runtime := gs.activeFault(route, now)
+		switch {
+		case runtime:
+			gs.faultMu.RLock()
+			expiresAt := gs.runtimeFaults[route.Name].expiresAt
+			gs.faultMu.RUnlock()
+			st.Source, st.Fault, st.ExpiresAt = "admin", fault, &expiresAt
+		case fault != nil:
+			st.Source, st.Fault = "config", fault
+		case len(st.Injected) == 0 && name == "":
+			continue
+		}
+		statuses = append(statuses, st)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Route < statuses[j].Route })
+	return statuses
+}
+
+// parseFaultDuration 解析管理接口中的故障持续时间，为空时使用默认值
+func parseFaultDuration(d Duration) (time.Duration, error) {
+	switch {
+	case d.Duration < 0:
+		return 0, errors.New("duration 不能为负数")
+	case d.Duration == 0:
+		return defaultFaultDuration, nil
+	}
+	return d.Duration, nil
+}
+
+// faultLabel 用于日志的故障描述
+func faultLabel(c *FaultConfig) string {
+	switch {
+	case c.Abort != 0:
+		return "abort " + strconv.Itoa(c.Abort)
+	case c.Reset:
+		return "reset"
+	case c.TruncateBytes > 0:
+		return "truncate " + strconv.FormatInt(c.TruncateBytes, 10)
+	}
+	return "delay"
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/widget"
+
+	"ttt/pkg/log"
+	"ttt/pkg/ui"
+)
+
+// gatewayPanel 网关窗口左侧的服务实例和路由列表，所有数据和操作都通过管理接口
+type gatewayPanel struct {
+	admin     *AdminClient
+	logger    *log.Logger
+	statusBar *ui.StatusBar
+	port      int
+	registry  string
+
+	mu        sync.RWMutex
+	upstreams []upstreamItem
+	routes    []RouteStatus
+	lastErr   string // 上次访问管理接口的错误，相同的错误只记录一次
+
+	upstreamList *widget.List
+	routeList    *widget.List
+	logLevel     *widget.Select
+}
+
+// upstreamItem 服务实例列表的一项
+type upstreamItem struct {
+	Service string
+	Status  UpstreamStatus
+}
+
+func newGatewayPanel(admin *AdminClient, logger *log.Logger, statusBar *ui.StatusBar, port int, registryURL string) *gatewayPanel {
+	p := &gatewayPanel{admin: admin, logger: logger, statusBar: statusBar, port: port, registry: registryURL}
+
+	p.upstreamList = widget.NewList(
+		func() int {
+			p.mu.RLock()
+			defer p.mu.RUnlock()
+			return len(p.upstreams)
+		},
+		func() fyne.CanvasObject {
+			return container.NewHBox(widget.NewLabel(""), widget.NewLabel(""), widget.NewLabel(""), widget.NewLabel(""))
+		},
+		func(id widget.ListItemID, obj fyne.CanvasObject) {
+			p.mu.RLock()
+			defer p.mu.RUnlock()
+			if id >= len(p.upstreams) {
+				return
+			}
+			item := p.upstreams[id]
+			labels := obj.(*fyne.Container).Objects
+			labels[0].(*widget.Label).SetText(item.Service)
+			labels[1].(*widget.Label).SetText(item.Status.URL)
+			labels[2].(*widget.Label).SetText(item.Status.Label())
+			labels[3].(*widget.Label).SetText(fmt.Sprintf("请求 %d / 失败 %d", item.Status.Requests, item.Status.Failures))
+		},
+	)
+
+	p.routeList = widget.NewList(
+		func() int {
+			p.mu.RLock()
+			defer p.mu.RUnlock()
+			return len(p.routes)
+		},
+		func() fyne.CanvasObject {
+			return container.NewHBox(widget.NewLabel(""), widget.NewLabel(""), widget.NewLabel(""), widget.NewButton("", nil))
+		},
+		func(id widget.ListItemID, obj fyne.CanvasObject) {
+			p.mu.RLock()
+			defer p.mu.RUnlock()
+			if id >= len(p.routes) {
+				return
+			}
+			route := p.routes[id]
+			objects := obj.(*fyne.Container).Objects
+			objects[0].(*widget.Label).SetText(route.Name)
+			objects[1].(*widget.Label).SetText(fmt.Sprintf("1分钟 %d 请求 / %d 错误", route.RecentRequests, route.RecentErrors))
+			button := objects[3].(*widget.Button)
+			if route.Disabled != nil {
+				objects[2].(*widget.Label).SetText("已停用至 " + route.Disabled.Until.Format("15:04:05"))
+				button.SetText("恢复")
+				button.OnTapped = func() { p.run("恢复路由", func() error { return p.admin.EnableRoute(route.Name) }) }
+			} else {
+				objects[2].(*widget.Label).SetText(fmt.Sprintf("进行中 %d", route.InFlight))
+				button.SetText("停用10分钟")
+				button.OnTapped = func() {
+					p.run("停用路由", func() error { return p.admin.DisableRoute(route.Name, "GUI", defaultDisableDuration) })
+				}
+			}
+		},
+	)
+
+	p.logLevel = widget.NewSelect([]string{"debug", "info", "warn", "error"}, func(level string) {
+		p.run("修改日志级别", func() error { return p.admin.SetLogLevel(level) })
+	})
+	return p
+}
+
+// content 左侧面板：操作按钮和日志级别，下方按标签页显示服务实例和路由
+func (p *gatewayPanel) content() fyne.CanvasObject {
+	refresh := widget.NewButton("刷新服务列表", func() {
+		p.run("刷新服务列表", p.admin.RefreshRegistry)
+	})
+	toolbar := container.NewHBox(refresh, widget.NewLabel("日志级别"), p.logLevel)
+	tabs := container.NewAppTabs(
+		container.NewTabItem("服务实例", p.upstreamList),
+		container.NewTabItem("路由", p.routeList),
+	)
+	return container.NewBorder(toolbar, nil, nil, nil, tabs)
+}
+
+// run 在后台执行管理操作，完成后立即刷新
+func (p *gatewayPanel) run(action string, fn func() error) {
+	go func() {
+		if err := fn(); err != nil {
+			p.logger.Error("✗ "+action+"失败", log.Err(err))
+			return
+		}
+		p.refresh()
+	}()
+}
+
+// Poll 定期通过管理接口更新列表和状态栏
+func (p *gatewayPanel) Poll(interval time.Duration) {
+	if level, err := p.admin.LogLevel(); err == nil {
+		p.logLevel.Selected = level // 不触发修改回调
+		p.logLevel.Refresh()
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		p.refresh()
+		<-ticker.C
+	}
+}
+
+// refresh 获取一次服务实例和路由状态
+func (p *gatewayPanel) refresh() {
+	upstreams, err := p.admin.Upstreams()
+	var routes []RouteStatus
+	if err == nil {
+		routes, err = p.admin.Routes()
+	}
+	if err != nil {
+		p.mu.Lock()
+		first := p.lastErr != err.Error()
+		p.lastErr = err.Error()
+		p.mu.Unlock()
+		if first {
+			p.logger.Warn("无法访问管理接口", log.Err(err))
+		}
+		p.statusBar.SetState(ui.StateError)
+		p.statusBar.Set("状态", "管理接口不可用")
+		return
+	}
+
+	items := make([]upstreamItem, 0)
+	instances := 0
+	for _, service := range upstreams {
+		for _, status := range service.Instances {
+			items = append(items, upstreamItem{Service: service.Service, Status: status})
+		}
+		instances += len(service.Instances)
+	}
+	p.mu.Lock()
+	p.upstreams, p.routes, p.lastErr = items, routes, ""
+	p.mu.Unlock()
+	p.upstreamList.Refresh()
+	p.routeList.Refresh()
+
+	p.statusBar.SetState(ui.StateRunning)
+	p.statusBar.Set("状态", "运行中")
+	p.statusBar.Set("端口", strconv.Itoa(p.port))
+	p.statusBar.Set("已发现服务", fmt.Sprintf("%d (%d 个实例)", len(upstreams), instances))
+	p.statusBar.Set("路由", strconv.Itoa(len(routes)))
+	p.statusBar.Set("注册中心", p.registry)
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 支持的签名算法
+const (
+	algHS256 = "HS256"
+	algRS256 = "RS256"
+	algES256 = "ES256"
+)
+
+var (
+	errTokenMalformed = errors.New("令牌格式错误")
+	errTokenSignature = errors.New("令牌签名无效")
+	errTokenNoKey     = errors.New("没有可以验证该令牌的密钥")
+)
+
+// jwtHeader JWT头部
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// jwtClaims JWT声明
+type jwtClaims map[string]interface{}
+
+// parseJWT 拆分并解码JWT，返回头部、声明、签名内容和签名（不校验签名）
+func parseJWT(token string) (jwtHeader, jwtClaims, []byte, []byte, error) {
+	var header jwtHeader
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return header, nil, nil, nil, errTokenMalformed
+	}
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || json.Unmarshal(headerJSON, &header) != nil {
+		return header, nil, nil, nil, errTokenMalformed
+	}
+	claimsJSON, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return header, nil, nil, nil, errTokenMalformed
+	}
+	claims := jwtClaims{}
+	dec := json.NewDecoder(bytes.NewReader(claimsJSON))
+	dec.UseNumber()
+	if err := dec.Decode(&claims); err != nil {
+		return header, nil, nil, nil, errTokenMalformed
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return header, nil, nil, nil, errTokenMalformed
+	}
+	return header, claims, []byte(parts[0] + "." + parts[1]), sig, nil
+}
+
+// verifyKey 用于验证签名的密钥
+type verifyKey struct {
+	kid string
+	alg string      // 算法，由密钥类型决定
+	key interface{} // []byte（HS256）、*rsa.PublicKey 或 *ecdsa.PublicKey
+}
+
+// verify 用密钥验证签名
+func (k verifyKey) verify(signingInput, sig []byte) bool {
+	digest := sha256.Sum256(signingInput)
+	switch key := k.key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, key)
+		mac.Write(signingInput)
+		return hmac.Equal(sig, mac.Sum(nil))
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig) == nil
+	case *ecdsa.PublicKey:
+		if len(sig) != 64 {
+			return false
+		}
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		return ecdsa.Verify(key, digest[:], r, s)
+	}
+	return false
+}
+
+// jwk JWKS中的一个密钥
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	K   string `json:"k"`
+}
+
+// parseJWKS 解析JWKS文档，跳过不支持的密钥类型和非签名用途的密钥
+func parseJWKS(data []byte) ([]verifyKey, error) {
+	var doc struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("JWKS格式错误: %w", err)
+	}
+
+	var keys []verifyKey
+	for i, k := range doc.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.verifyKey()
+		if err != nil {
+			return nil, fmt.Errorf("keys[%d]: %w", i, err)
+		}
+		if key.alg == "" {
+			continue
+		}
+		if k.Alg != "" && k.Alg != key.alg {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func (k jwk) verifyKey() (verifyKey, error) {
+	decode := func(field, s string) ([]byte, error) {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil || len(b) == 0 {
+			return nil, fmt.Errorf("%s 无效", field)
+		}
+		return b, nil
+	}
+
+	switch k.Kty {
+	case "RSA":
+		n, err := decode("n", k.N)
+		if err != nil {
+			return verifyKey{}, err
+		}
+		e, err := decode("e", k.E)
+		if err != nil {
+			return verifyKey{}, err
+		}
+		exp := new(big.Int).SetBytes(e)
+		if !exp.IsInt64() || exp.Int64() < 3 || exp.Int64() > 1<<31-1 {
+			return verifyKey{}, errors.New("e 无效")
+		}
+		pub := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exp.Int64())}
+		return verifyKey{kid: k.Kid, alg: algRS256, key: pub}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return verifyKey{}, nil
+		}
+		x, err := decode("x", k.X)
+		if err != nil {
+			return verifyKey{}, err
+		}
+		y, err := decode("y", k.Y)
+		if err != nil {
+			return verifyKey{}, err
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return verifyKey{}, errors.New("EC 公钥不在 P-256 曲线上")
+		}
+		return verifyKey{kid: k.Kid, alg: algES256, key: pub}, nil
+	case "oct":
+		secret, err := decode("k", k.K)
+		if err != nil {
+			return verifyKey{}, err
+		}
+		return verifyKey{kid: k.Kid, alg: algHS256, key: secret}, nil
+	}
+	return verifyKey{}, nil
+}
+
+// loadJWKSFile 读取本地JWKS文件
+func loadJWKSFile(path string) ([]verifyKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseJWKS(data)
+}
+
+// jwksMinRefetch 遇到未知 kid 时重新获取JWKS的最小间隔，防止伪造的 kid 触发大量请求
+const jwksMinRefetch = 30 * time.Second
+
+// jwksCache 从URL获取并缓存的JWKS，定期刷新，遇到未知的 kid 时提前刷新
+//
+// 获取JWKS时不持有锁，同时只有一个请求去获取，其他请求继续使用缓存的密钥（还没有密钥时等待获取结果）
+type jwksCache struct {
+	url     string
+	refresh time.Duration
+	client  *http.Client
+
+	mu          sync.Mutex
+	keys        []verifyKey
+	fetchedAt   time.Time
+	lastAttempt time.Time
+	fetching    chan struct{} // 正在获取时不为 nil，获取结束时关闭
+	fetchErr    error         // 最近一次获取的错误
+}
+
+func newJWKSCache(url string, refresh time.Duration) *jwksCache {
+	return &jwksCache{url: url, refresh: refresh, client: &http.Client{Timeout: 5 * time.Second}}
+}
+
+// get 返回缓存的密钥；缓存过期或 kid 不存在时重新获取（获取失败时继续使用旧密钥）
+func (c *jwksCache) get(kid string, now time.Time) ([]verifyKey, error) {
+	c.mu.Lock()
+	if done := c.fetching; done != nil {
+		keys := c.keys
+		c.mu.Unlock()
+		if keys != nil {
+			return keys, nil
+		}
+		<-done
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.keys == nil {
+			return nil, c.fetchErr
+		}
+		return c.keys, nil
+	}
+
+	stale := c.fetchedAt.IsZero() || now.Sub(c.fetchedAt) > c.refresh
+	if !stale && kid != "" && !hasKid(c.keys, kid) && now.Sub(c.lastAttempt) > jwksMinRefetch {
+		stale = true
+	}
+	if !stale || now.Sub(c.lastAttempt) < time.Second {
+		keys := c.keys
+		c.mu.Unlock()
+		return keys, nil
+	}
+
+	c.lastAttempt = now
+	done := make(chan struct{})
+	c.fetching = done
+	c.mu.Unlock()
+
+	keys, err := c.fetch()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.fetching = nil
+	close(done)
+	c.fetchErr = err
+	if err != nil {
+		if c.keys == nil {
+			return nil, err
+		}
+		return c.keys, nil
+	}
+	c.keys, c.fetchedAt = keys, now
+	return keys, nil
+}
+
+func (c *jwksCache) fetch() ([]verifyKey, error) {
+	resp, err := c.client.Get(c.url)
+	if err != nil {
+		return nil, fmt.Errorf("获取JWKS失败: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("获取JWKS失败: HTTP %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("获取JWKS失败: %w", err)
+	}
+	return parseJWKS(data)
+}
+
+func hasKid(keys []verifyKey, kid string) bool {
+	for _, k := range keys {
+		if k.kid == kid {
+			return true
+		}
+	}
+	return false
+}
+
+// numericClaim 读取数值类型的声明（exp、nbf 等）
+func (c jwtClaims) numericClaim(name string) (time.Time, bool, error) {
+	v, ok := c[name]
+	if !ok {
+		return time.Time{}, false, nil
+	}
+	n, ok := v.(json.Number)
+	if !ok {
+		return time.Time{}, false, fmt.Errorf("%s 必须是数字", name)
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("%s 必须是数字", name)
+	}
+	return time.Unix(int64(f), 0), true, nil
+}
+
+// stringClaim 读取字符串声明，不存在或类型不对时返回空字符串
+func (c jwtClaims) stringClaim(name string) string {
+	switch v := c[name].(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	}
+	return ""
+}
+
+// listClaim 读取列表声明，支持JSON数组和空格分隔的字符串（如 OAuth2 的 scope）
+func (c jwtClaims) listClaim(name string) []string {
+	switch v := c[name].(type) {
+	case string:
+		return strings.Fields(v)
+	case []interface{}:
+		list := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	}
+	return nil
+}
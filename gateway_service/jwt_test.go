@@ -0,0 +1,370 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testHSSecret = "0123456789abcdef0123456789abcdef"
+
+var b64 = base64.RawURLEncoding
+
+// signToken 生成测试令牌，key 为 []byte（HS256）、*rsa.PrivateKey 或 *ecdsa.PrivateKey
+func signToken(t *testing.T, header map[string]string, claims map[string]interface{}, key interface{}) string {
+	t.Helper()
+	h, _ := json.Marshal(header)
+	c, _ := json.Marshal(claims)
+	input := b64.EncodeToString(h) + "." + b64.EncodeToString(c)
+	digest := sha256.Sum256([]byte(input))
+
+	var sig []byte
+	switch key := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(input))
+		sig = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		var err error
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:]); err != nil {
+			t.Fatal(err)
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	default:
+		t.Fatalf("不支持的密钥类型 %T", key)
+	}
+	return input + "." + b64.EncodeToString(sig)
+}
+
+func rsaJWK(kid string, pub *rsa.PublicKey) map[string]string {
+	return map[string]string{
+		"kty": "RSA",
+		"kid": kid,
+		"n":   b64.EncodeToString(pub.N.Bytes()),
+		"e":   b64.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+	}
+}
+
+func ecJWK(kid string, pub *ecdsa.PublicKey) map[string]string {
+	x, y := make([]byte, 32), make([]byte, 32)
+	pub.X.FillBytes(x)
+	pub.Y.FillBytes(y)
+	return map[string]string{"kty": "EC", "kid": kid, "crv": "P-256", "x": b64.EncodeToString(x), "y": b64.EncodeToString(y)}
+}
+
+func jwksJSON(keys ...map[string]string) []byte {
+	data, _ := json.Marshal(map[string]interface{}{"keys": keys})
+	return data
+}
+
+func TestParseJWT(t *testing.T) {
+	valid := signToken(t, map[string]string{"alg": "HS256", "kid": "k1"}, map[string]interface{}{"sub": "alice", "exp": 100}, []byte(testHSSecret))
+	parts := strings.Split(valid, ".")
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr error
+	}{
+		{"valid", valid, nil},
+		{"two parts", parts[0] + "." + parts[1], errTokenMalformed},
+		{"four parts", valid + ".x", errTokenMalformed},
+		{"header not base64", "!!." + parts[1] + "." + parts[2], errTokenMalformed},
+		{"header not json", b64.EncodeToString([]byte("nope")) + "." + parts[1] + "." + parts[2], errTokenMalformed},
+		{"claims not object", parts[0] + "." + b64.EncodeToString([]byte(`[1]`)) + "." + parts[2], errTokenMalformed},
+		{"padded signature", parts[0] + "." + parts[1] + "." + parts[2] + "=", errTokenMalformed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header, claims, input, sig, err := parseJWT(tt.token)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if header.Alg != "HS256" || header.Kid != "k1" {
+				t.Errorf("header = %+v", header)
+			}
+			if claims.stringClaim("sub") != "alice" {
+				t.Errorf("sub = %q", claims.stringClaim("sub"))
+			}
+			if exp, ok, err := claims.numericClaim("exp"); !ok || err != nil || exp.Unix() != 100 {
+				t.Errorf("exp = %v, %v, %v", exp, ok, err)
+			}
+			if string(input) != parts[0]+"."+parts[1] || len(sig) != sha256.Size {
+				t.Errorf("signing input or signature not split correctly")
+			}
+		})
+	}
+}
+
+func TestParseJWKS(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	p384, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+
+	encRSA := rsaJWK("enc", &rsaKey.PublicKey)
+	encRSA["use"] = "enc"
+	mislabeled := rsaJWK("mislabeled", &rsaKey.PublicKey)
+	mislabeled["alg"] = "HS256"
+	offCurve := ecJWK("bad", &ecKey.PublicKey)
+	offCurve["y"] = offCurve["x"]
+	p384JWK := map[string]string{"kty": "EC", "kid": "p384", "crv": "P-384",
+		"x": b64.EncodeToString(p384.X.Bytes()), "y": b64.EncodeToString(p384.Y.Bytes())}
+
+	tests := []struct {
+		name     string
+		data     []byte
+		wantKids []string
+		wantAlgs []string
+		wantErr  bool
+	}{
+		{"rsa ec oct", jwksJSON(rsaJWK("r", &rsaKey.PublicKey), ecJWK("e", &ecKey.PublicKey),
+			map[string]string{"kty": "oct", "kid": "h", "k": b64.EncodeToString([]byte(testHSSecret))}),
+			[]string{"r", "e", "h"}, []string{algRS256, algES256, algHS256}, false},
+		{"skip encryption key", jwksJSON(encRSA, rsaJWK("sig", &rsaKey.PublicKey)), []string{"sig"}, []string{algRS256}, false},
+		// alg 与密钥类型不一致的密钥会被跳过，RSA 公钥不能被当作 HS256 密钥使用
+		{"skip alg mismatch", jwksJSON(mislabeled), nil, nil, false},
+		{"skip unsupported curve and kty", jwksJSON(p384JWK, map[string]string{"kty": "OKP", "kid": "ed"}), nil, nil, false},
+		{"point not on curve", jwksJSON(offCurve), nil, nil, true},
+		{"rsa missing n", jwksJSON(map[string]string{"kty": "RSA", "e": "AQAB"}), nil, nil, true},
+		{"rsa small e", jwksJSON(map[string]string{"kty": "RSA", "n": b64.EncodeToString(rsaKey.N.Bytes()), "e": "AQ"}), nil, nil, true},
+		{"not json", []byte("{"), nil, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys, err := parseJWKS(tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(keys) != len(tt.wantKids) {
+				t.Fatalf("got %d keys, want %d", len(keys), len(tt.wantKids))
+			}
+			for i, k := range keys {
+				if k.kid != tt.wantKids[i] || k.alg != tt.wantAlgs[i] {
+					t.Errorf("keys[%d] = %s/%s, want %s/%s", i, k.kid, k.alg, tt.wantKids[i], tt.wantAlgs[i])
+				}
+			}
+		})
+	}
+}
+
+func TestVerify(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	otherRSA, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	jwksFile := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(jwksFile, jwksJSON(rsaJWK("rsa-1", &rsaKey.PublicKey), ecJWK("ec-1", &ecKey.PublicKey)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TEST_JWT_SECRET", testHSSecret)
+
+	newAuth := func(t *testing.T, jc JWTConfig) *Authenticator {
+		t.Helper()
+		a, err := newAuthenticator(AuthConfig{JWT: jc})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return a
+	}
+	zero := Duration{}
+	noExp := false
+
+	now := time.Unix(1_700_000_000, 0)
+	at := func(d time.Duration) int64 { return now.Add(d).Unix() }
+	rs := map[string]string{"alg": "RS256", "kid": "rsa-1"}
+
+	// 用 RSA 公钥（DER）作为 HMAC 密钥签名，模拟算法混淆攻击
+	pubDER, _ := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+
+	tests := []struct {
+		name    string
+		cfg     JWTConfig
+		header  map[string]string
+		claims  map[string]interface{}
+		key     interface{}
+		wantErr string
+	}{
+		{"rs256 valid", JWTConfig{}, rs, map[string]interface{}{"exp": at(time.Hour)}, rsaKey, ""},
+		{"es256 valid", JWTConfig{}, map[string]string{"alg": "ES256", "kid": "ec-1"}, map[string]interface{}{"exp": at(time.Hour)}, ecKey, ""},
+		{"rs256 without kid", JWTConfig{}, map[string]string{"alg": "RS256"}, map[string]interface{}{"exp": at(time.Hour)}, rsaKey, ""},
+		{"wrong rsa key", JWTConfig{}, rs, map[string]interface{}{"exp": at(time.Hour)}, otherRSA, errTokenSignature.Error()},
+		{"alg confusion hs256 with rsa public key", JWTConfig{}, map[string]string{"alg": "HS256", "kid": "rsa-1"},
+			map[string]interface{}{"exp": at(time.Hour)}, pubDER, errTokenNoKey.Error()},
+		{"alg none", JWTConfig{}, map[string]string{"alg": "none"}, map[string]interface{}{"exp": at(time.Hour)}, []byte(testHSSecret), "不支持的签名算法"},
+		{"kid mismatch", JWTConfig{}, map[string]string{"alg": "RS256", "kid": "rsa-2"}, map[string]interface{}{"exp": at(time.Hour)}, rsaKey, errTokenNoKey.Error()},
+		{"kid of another alg", JWTConfig{}, map[string]string{"alg": "RS256", "kid": "ec-1"}, map[string]interface{}{"exp": at(time.Hour)}, rsaKey, errTokenNoKey.Error()},
+		{"hs256 env secret ignores kid", JWTConfig{HS256SecretEnv: "TEST_JWT_SECRET"}, map[string]string{"alg": "HS256", "kid": "any"},
+			map[string]interface{}{"exp": at(time.Hour)}, []byte(testHSSecret), ""},
+
+		{"expired", JWTConfig{}, rs, map[string]interface{}{"exp": at(-time.Hour)}, rsaKey, "令牌已过期"},
+		{"expired within default leeway", JWTConfig{}, rs, map[string]interface{}{"exp": at(-20 * time.Second)}, rsaKey, ""},
+		{"expired beyond default leeway", JWTConfig{}, rs, map[string]interface{}{"exp": at(-40 * time.Second)}, rsaKey, "令牌已过期"},
+		{"expired with zero leeway", JWTConfig{Leeway: &zero}, rs, map[string]interface{}{"exp": at(-time.Second)}, rsaKey, "令牌已过期"},
+		{"exp now with zero leeway", JWTConfig{Leeway: &zero}, rs, map[string]interface{}{"exp": at(0)}, rsaKey, ""},
+		{"exp not a number", JWTConfig{}, rs, map[string]interface{}{"exp": "tomorrow"}, rsaKey, "exp 必须是数字"},
+		{"missing exp", JWTConfig{}, rs, map[string]interface{}{"sub": "alice"}, rsaKey, "没有过期时间"},
+		{"missing exp allowed", JWTConfig{RequireExp: &noExp}, rs, map[string]interface{}{"sub": "alice"}, rsaKey, ""},
+		{"nbf in future", JWTConfig{}, rs, map[string]interface{}{"exp": at(time.Hour), "nbf": at(time.Minute)}, rsaKey, "令牌尚未生效"},
+		{"nbf within leeway", JWTConfig{}, rs, map[string]interface{}{"exp": at(time.Hour), "nbf": at(20 * time.Second)}, rsaKey, ""},
+		{"nbf with zero leeway", JWTConfig{Leeway: &zero}, rs, map[string]interface{}{"exp": at(time.Hour), "nbf": at(time.Second)}, rsaKey, "令牌尚未生效"},
+
+		{"issuer match", JWTConfig{Issuer: "https://idp"}, rs, map[string]interface{}{"exp": at(time.Hour), "iss": "https://idp"}, rsaKey, ""},
+		{"issuer mismatch", JWTConfig{Issuer: "https://idp"}, rs, map[string]interface{}{"exp": at(time.Hour), "iss": "https://evil"}, rsaKey, "签发者不匹配"},
+		{"issuer missing", JWTConfig{Issuer: "https://idp"}, rs, map[string]interface{}{"exp": at(time.Hour)}, rsaKey, "签发者不匹配"},
+		{"audience string", JWTConfig{Audience: "gateway"}, rs, map[string]interface{}{"exp": at(time.Hour), "aud": "gateway"}, rsaKey, ""},
+		{"audience list", JWTConfig{Audience: "gateway"}, rs, map[string]interface{}{"exp": at(time.Hour), "aud": []string{"other", "gateway"}}, rsaKey, ""},
+		{"audience mismatch", JWTConfig{Audience: "gateway"}, rs, map[string]interface{}{"exp": at(time.Hour), "aud": []string{"other"}}, rsaKey, "受众不匹配"},
+		{"audience missing", JWTConfig{Audience: "gateway"}, rs, map[string]interface{}{"exp": at(time.Hour)}, rsaKey, "受众不匹配"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := tt.cfg
+			if cfg.HS256SecretEnv == "" {
+				cfg.JWKSFile = jwksFile
+			}
+			a := newAuth(t, cfg)
+			_, err := a.Verify(signToken(t, tt.header, tt.claims, tt.key), now)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Verify() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Verify() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewAuthenticatorLeeway(t *testing.T) {
+	negative := Duration{Duration: -time.Second}
+	tests := []struct {
+		name string
+		data string
+		want time.Duration
+	}{
+		{"default", `{}`, 30 * time.Second},
+		{"zero", `{"leeway": "0s"}`, 0},
+		{"custom", `{"leeway": "5s"}`, 5 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var jc JWTConfig
+			if err := json.Unmarshal([]byte(tt.data), &jc); err != nil {
+				t.Fatal(err)
+			}
+			a, err := newAuthenticator(AuthConfig{JWT: jc})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if a.leeway != tt.want {
+				t.Errorf("leeway = %v, want %v", a.leeway, tt.want)
+			}
+			if !a.requireExp {
+				t.Error("require_exp should default to true")
+			}
+		})
+	}
+	if _, err := newAuthenticator(AuthConfig{JWT: JWTConfig{Leeway: &negative}}); err == nil {
+		t.Error("negative leeway should be rejected")
+	}
+}
+
+func TestSecureJWKSURL(t *testing.T) {
+	tests := []struct {
+		url  string
+		want bool
+	}{
+		{"https://auth.example.com/.well-known/jwks.json", true},
+		{"http://auth.example.com/.well-known/jwks.json", false},
+		{"http://localhost:9000/jwks.json", true},
+		{"http://127.0.0.1:9000/jwks.json", true},
+		{"http://[::1]:9000/jwks.json", true},
+		{"http://127.0.0.1.example.com/jwks.json", false},
+		{"http://10.0.0.1/jwks.json", false},
+		{"ftp://auth.example.com/jwks.json", false},
+		{"https:///jwks.json", false},
+		{"jwks.json", false},
+	}
+	for _, tt := range tests {
+		if got := secureJWKSURL(tt.url); got != tt.want {
+			t.Errorf("secureJWKSURL(%q) = %v, want %v", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestJWKSCacheFetchOutsideLock(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	doc := jwksJSON(rsaJWK("rsa-1", &rsaKey.PublicKey))
+
+	var fetches atomic.Int32
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 第一次获取立即返回，之后的获取等待 release
+		if fetches.Add(1) > 1 {
+			<-release
+		}
+		w.Write(doc)
+	}))
+	defer srv.Close()
+
+	c := newJWKSCache(srv.URL, 10*time.Minute)
+	now := time.Now()
+	if keys, err := c.get("rsa-1", now); err != nil || len(keys) != 1 {
+		t.Fatalf("first get = %d keys, %v", len(keys), err)
+	}
+
+	// 未知的 kid 触发重新获取，获取期间其他请求继续使用缓存的密钥
+	later := now.Add(jwksMinRefetch + time.Second)
+	refetched := make(chan struct{})
+	go func() {
+		defer close(refetched)
+		c.get("rotated", later)
+	}()
+	for fetches.Load() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	for _, kid := range []string{"rsa-1", "rotated", ""} {
+		start := time.Now()
+		keys, err := c.get(kid, later)
+		if err != nil || len(keys) != 1 {
+			t.Errorf("get(%q) during fetch = %d keys, %v", kid, len(keys), err)
+		}
+		if d := time.Since(start); d > time.Second {
+			t.Errorf("get(%q) blocked for %s while another request was fetching", kid, d)
+		}
+	}
+	close(release)
+	<-refetched
+	if n := fetches.Load(); n != 2 {
+		t.Errorf("fetches = %d, want 2 (one in-flight fetch at a time)", n)
+	}
+}
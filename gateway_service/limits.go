@@ -0,0 +1,138 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+
+	"ttt/pkg/log"
+	"ttt/pkg/requestid"
+)
+
+// LimitsConfig 请求的大小和类型限制，在网关入口检查，超过限制的请求不会转发到上游
+//
+// 顶层的 limits 是所有路由的默认值，路由的 limits 中设置了的字段覆盖默认值。
+type LimitsConfig struct {
+	MaxBodyBytes   int64 `json:"max_body_bytes,omitempty"`   // 请求体大小上限，超过时返回 413；0 表示不限制
+	MaxHeaderBytes int   `json:"max_header_bytes,omitempty"` // 请求行和请求头的总大小上限，超过时返回 431；0 表示不限制
+	// ContentTypes 允许的请求体类型，如 application/json、multipart/form-data 或 text/*；
+	// 为空表示不限制，没有请求体的请求不检查
+	ContentTypes []string `json:"content_types,omitempty"`
+}
+
+// merge 用 override 中设置了的字段覆盖默认值
+func (c LimitsConfig) merge(override *LimitsConfig) LimitsConfig {
+	if override == nil {
+		return c
+	}
+	if override.MaxBodyBytes != 0 {
+		c.MaxBodyBytes = override.MaxBodyBytes
+	}
+	if override.MaxHeaderBytes != 0 {
+		c.MaxHeaderBytes = override.MaxHeaderBytes
+	}
+	if override.ContentTypes != nil {
+		c.ContentTypes = override.ContentTypes
+	}
+	return c
+}
+
+// enabled 是否设置了任何限制
+func (c LimitsConfig) enabled() bool {
+	return c.MaxBodyBytes > 0 || c.MaxHeaderBytes > 0 || len(c.ContentTypes) > 0
+}
+
+// validate 校验请求限制配置
+func (c LimitsConfig) validate() []error {
+	var errs []error
+	if c.MaxBodyBytes < 0 || c.MaxHeaderBytes < 0 {
+		errs = append(errs, errors.New("limits.max_body_bytes 和 max_header_bytes 不能为负数"))
+	}
+	for _, ct := range c.ContentTypes {
+		mediaType, params, err := mime.ParseMediaType(ct)
+		if err != nil || len(params) > 0 || strings.HasPrefix(mediaType, "*/") || !strings.Contains(mediaType, "/") {
+			errs = append(errs, fmt.Errorf("limits.content_types 无效: %q（格式为 type/subtype 或 type/*）", ct))
+		}
+	}
+	return errs
+}
+
+// allowContentType 判断请求体类型是否在允许的列表中
+func (c LimitsConfig) allowContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, allowed := range c.ContentTypes {
+		allowed = strings.ToLower(allowed)
+		if allowed == mediaType || strings.HasSuffix(allowed, "/*") && strings.HasPrefix(mediaType, allowed[:len(allowed)-1]) {
+			return true
+		}
+	}
+	return false
+}
+
+// headerSize 估算请求行和请求头的大小（与 HTTP/1.1 报文中的大小相同）
+func headerSize(r *http.Request) int {
+	size := len(r.Method) + len(r.RequestURI) + len(r.Proto) + 4
+	size += len("Host: ") + len(r.Host) + 2
+	for name, values := range r.Header {
+		for _, v := range values {
+			size += len(name) + len(v) + 4
+		}
+	}
+	return size
+}
+
+// hasBody 判断请求是否带有请求体
+func hasBody(r *http.Request) bool {
+	return r.ContentLength > 0 || (r.ContentLength < 0 && r.Body != nil && r.Body != http.NoBody)
+}
+
+// enforceLimits 检查请求头大小、请求体类型和声明的请求体大小，并限制实际读取的请求体大小
+// 不符合限制的请求返回 JSON 错误，不会到达认证、中间件和上游
+func (gs *GatewayService) enforceLimits(route *Route, next http.Handler) http.Handler {
+	limits := route.limits
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reject := func(msg string, code int) {
+			gs.logger.ForRequest(r).Warn("✗ 请求超出限制",
+				log.String("route", route.Name),
+				log.Int("status", code),
+				log.String("reason", msg))
+			requestid.JSONError(w, r, msg, code)
+		}
+
+		if limits.MaxHeaderBytes > 0 && headerSize(r) > limits.MaxHeaderBytes {
+			reject(fmt.Sprintf("Request headers too large (limit %d bytes)", limits.MaxHeaderBytes), http.StatusRequestHeaderFieldsTooLarge)
+			return
+		}
+		if len(limits.ContentTypes) > 0 && hasBody(r) && !limits.allowContentType(r.Header.Get("Content-Type")) {
+			reject(fmt.Sprintf("Unsupported content type %q (allowed: %s)", r.Header.Get("Content-Type"), strings.Join(limits.ContentTypes, ", ")),
+				http.StatusUnsupportedMediaType)
+			return
+		}
+		if limits.MaxBodyBytes > 0 {
+			if r.ContentLength > limits.MaxBodyBytes {
+				reject(fmt.Sprintf("Request body too large (limit %d bytes)", limits.MaxBodyBytes), http.StatusRequestEntityTooLarge)
+				return
+			}
+			// 没有声明长度（分块传输）的请求在读取超过上限时中止
+			if hasBody(r) {
+				r.Body = http.MaxBytesReader(w, r.Body, limits.MaxBodyBytes)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// writeBodyError 读取请求体失败时返回 JSON 错误：超过路由的大小上限时返回 413，否则返回 400
+func writeBodyError(w http.ResponseWriter, r *http.Request, err error) {
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		requestid.JSONError(w, r, fmt.Sprintf("Request body too large (limit %d bytes)", maxErr.Limit), http.StatusRequestEntityTooLarge)
+		return
+	}
+	requestid.JSONError(w, r, "Failed to read request body", http.StatusBadRequest)
+}
@@ -1,516 +1,339 @@
 package main
 
 import (
-	"bytes"
-	"embed"
+	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"image/color"
 	"io"
-	"log"
+	"math"
 	"net/http"
-	"net/url"
-	"os"
-	"path/filepath"
-	"runtime"
-	"sort"
-	"strings"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"fyne.io/fyne/v2"
-	"fyne.io/fyne/v2/app"
-	"fyne.io/fyne/v2/canvas"
-	"fyne.io/fyne/v2/container"
-	"fyne.io/fyne/v2/storage"
-	"fyne.io/fyne/v2/theme"
-	"fyne.io/fyne/v2/widget"
-)
 
-// 嵌入字体文件
-// 使用方法：将中文字体文件复制到 fonts 目录下
-// 支持的格式：.ttf, .otf（注意：Fyne不支持.ttc字体集合文件）
-// 如果没有字体文件，程序会自动使用系统字体作为fallback
-//go:embed fonts/*
-var embeddedFonts embed.FS
-
-// ServiceInfo 服务信息（从注册中心获取）
-type ServiceInfo struct {
-	Name    string `json:"name"`
-	Address string `json:"address"`
-	Port    int    `json:"port"`
-	URL     string `json:"url"`
-}
+	"ttt/pkg/bodylimit"
+	"ttt/pkg/config"
+	"ttt/pkg/log"
+	"ttt/pkg/registry"
+	"ttt/pkg/requestid"
+	"ttt/pkg/tlsconfig"
+	"ttt/pkg/ui"
+)
 
 // GatewayService 网关服务
 type GatewayService struct {
 	port         int
-	registryURL  string
-	services     map[string]string // 服务名 -> URL
+	registry     *registry.Client
+	upstreams    map[string][]*Upstream // 服务名 -> 实例列表（按实例ID排序）
+	instances    map[string]*Upstream   // 实例ID -> 实例，刷新时保留熔断器状态
+	nextUpstream atomic.Uint64          // 轮询选择实例的计数器
 	mu           sync.RWMutex
-	logContainer *fyne.Container
-	logScroll    *container.Scroll
-	statusLabel  *widget.Label
-	servicesList *widget.List
-	servicesData []ServiceItem
-	muData       sync.RWMutex
-	updateListFn func() // 更新列表的函数
-}
-
-// ServiceItem 服务列表项
-type ServiceItem struct {
-	Name string
-	URL  string
+	logger       *log.Logger
+
+	routes     atomic.Pointer[RouteTable] // 当前路由表，重新加载时整体替换
+	routesPath string                     // 路由配置文件路径
+	routesMu   sync.Mutex                 // 串行化路由配置的重新加载
+	routesSum  [32]byte                   // 当前配置文件内容的SHA-256
+	transport  http.RoundTripper          // 所有转发请求共用的连接池（超时由路由控制）
+
+	retryBudget RetryBudget    // 全局重试预算，重新加载配置时保留统计
+	rateLimiter RateLimiter    // 限流令牌桶，重新加载配置时保留
+	streams     streamCounter  // 每条路由当前的 WebSocket/SSE 长连接数
+	cache       *ResponseCache // 响应缓存，重新加载配置时保留
+
+	splitMu        sync.RWMutex
+	splitOverrides map[string]map[string]int // 路由名 -> 管理接口设置的版本权重，重新加载配置时保留
+	versionStats   versionStats              // 每个服务版本最近1分钟的请求和错误数
+	mirrors        mirrorStats               // 流量复制到影子服务的统计
+
+	faultMu       sync.RWMutex
+	runtimeFaults map[string]*runtimeFault // 路由名 -> 管理接口开启的故障，重新加载配置时保留
+	faults        faultStats               // 每条路由注入的故障次数
+
+	routeStats     routeStats // 每条路由的请求统计，重新加载配置时保留
+	disableMu      sync.RWMutex
+	disabledRoutes map[string]*RouteDisable // 路由名 -> 管理接口停用的路由，重新加载配置时保留
+
+	apiKeys    *APIKeyStore // API Key 存储
+	adminToken string       // 管理接口的访问令牌
+	accessLog  *accessLog   // 访问日志（为 nil 时不记录）
 }
 
 // NewGatewayService 创建新的网关服务
-func NewGatewayService(port int, registryURL string, logContainer *fyne.Container, logScroll *container.Scroll, statusLabel *widget.Label, servicesList *widget.List) *GatewayService {
+func NewGatewayService(port int, registryClient *registry.Client, logger *log.Logger) *GatewayService {
 	return &GatewayService{
-		port:         port,
-		registryURL:  registryURL,
-		services:     make(map[string]string),
-		logContainer: logContainer,
-		logScroll:    logScroll,
-		statusLabel:  statusLabel,
-		servicesList: servicesList,
-		servicesData: make([]ServiceItem, 0),
-	}
-}
-
-// logMessage 添加日志消息（彩色显示）
-func (gs *GatewayService) logMessage(msg string) {
-	if gs.logContainer != nil {
-		timestamp := time.Now().Format("15:04:05")
-		fullMsg := fmt.Sprintf("[%s] %s", timestamp, msg)
-
-		// 根据消息内容确定颜色
-		var msgColor color.Color
-		if contains(msg, "注册") || contains(msg, "成功") || contains(msg, "发现") {
-			msgColor = color.NRGBA{R: 0, G: 200, B: 0, A: 255} // 绿色
-		} else if contains(msg, "警告") || contains(msg, "失败") {
-			msgColor = color.NRGBA{R: 255, G: 165, B: 0, A: 255} // 橙色
-		} else if contains(msg, "错误") || contains(msg, "异常") || contains(msg, "无法") {
-			msgColor = color.NRGBA{R: 255, G: 0, B: 0, A: 255} // 红色
-		} else if contains(msg, "启动") || contains(msg, "就绪") || contains(msg, "路由") {
-			msgColor = color.NRGBA{R: 0, G: 150, B: 255, A: 255} // 蓝色
-		} else {
-			msgColor = color.NRGBA{R: 200, G: 200, B: 200, A: 255} // 灰色（默认）
-		}
-
-		// 创建带颜色的文本
-		logText := canvas.NewText(fullMsg, msgColor)
-		logText.TextStyle = fyne.TextStyle{Monospace: true}
-		logText.Alignment = fyne.TextAlignLeading
-
-		// 添加到容器
-		gs.logContainer.Add(logText)
-
-		// 限制日志条目数量（保留最后200条）
-		if len(gs.logContainer.Objects) > 200 {
-			oldObjs := gs.logContainer.Objects
-			gs.logContainer.Objects = oldObjs[len(oldObjs)-200:]
-			gs.logContainer.Refresh()
-		}
-
-		// 滚动到底部
-		if gs.logScroll != nil {
-			gs.logScroll.ScrollToBottom()
-		}
-	}
-}
-
-// contains 检查字符串是否包含子串
-func contains(s, substr string) bool {
-	for i := 0; i <= len(s)-len(substr); i++ {
-		if s[i:i+len(substr)] == substr {
-			return true
-		}
-	}
-	return false
-}
-
-// updateStatus 更新状态
-func (gs *GatewayService) updateStatus() {
-	if gs.statusLabel != nil {
-		gs.mu.RLock()
-		serviceCount := len(gs.services)
-		gs.mu.RUnlock()
-		gs.statusLabel.SetText(fmt.Sprintf("状态: 运行中 | 端口: %d | 已发现服务: %d | 注册中心: %s", gs.port, serviceCount, gs.registryURL))
-	}
-}
-
-// RegisterToRegistry 注册到服务注册中心
-func (gs *GatewayService) RegisterToRegistry() {
-	if gs.registryURL == "" {
-		return
-	}
-
-	serviceInfo := map[string]interface{}{
-		"name":    "gateway-service",
-		"address": "localhost",
-		"port":    gs.port,
-	}
-
-	jsonData, _ := json.Marshal(serviceInfo)
-	resp, err := http.Post(gs.registryURL+"/register", "application/json", bytes.NewBuffer(jsonData))
-	if err != nil {
-		gs.logMessage(fmt.Sprintf("警告: 无法注册到服务注册中心: %v", err))
-		return
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode == http.StatusOK {
-		gs.logMessage("✓ 已注册到服务注册中心")
-		// 启动心跳协程
-		go gs.startHeartbeat()
-	}
-}
-
-// startHeartbeat 启动心跳（每5秒发送一次）
-func (gs *GatewayService) startHeartbeat() {
-	ticker := time.NewTicker(5 * time.Second)
-	defer ticker.Stop()
-
-	for range ticker.C {
-		if gs.registryURL != "" {
-			http.Post(gs.registryURL+"/heartbeat?name=gateway-service", "application/json", nil)
-		}
-	}
-}
-
-// UnregisterFromRegistry 从服务注册中心注销（同步等待完成）
-func (gs *GatewayService) UnregisterFromRegistry() {
-	if gs.registryURL == "" {
-		return
-	}
-
-	gs.logMessage("正在注销服务...")
-
-	// 创建带超时的HTTP客户端
-	client := &http.Client{Timeout: 2 * time.Second}
-	resp, err := client.Post(gs.registryURL+"/unregister?name=gateway-service", "application/json", nil)
-	if err != nil {
-		gs.logMessage(fmt.Sprintf("注销失败: %v", err))
-		return
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode == http.StatusOK {
-		gs.logMessage("✓ 已从服务注册中心注销")
-	} else {
-		gs.logMessage(fmt.Sprintf("注销失败，状态码: %d", resp.StatusCode))
+		port:        port,
+		registry:    registryClient,
+		upstreams:   make(map[string][]*Upstream),
+		instances:   make(map[string]*Upstream),
+		logger:      logger,
+		transport:   newTransport(),
+		rateLimiter: NewMemoryRateLimiter(),
+		cache:       NewResponseCache(),
 	}
 }
 
 // DiscoverService 从注册中心发现单个服务
 func (gs *GatewayService) DiscoverService(serviceName string) {
-	if gs.registryURL == "" {
-		return
-	}
-
-	resp, err := http.Get(gs.registryURL + "/discover?name=" + serviceName)
+	instance, err := gs.registry.Discover(serviceName)
 	if err != nil {
 		return
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode == http.StatusOK {
-		var service ServiceInfo
-		if err := json.NewDecoder(resp.Body).Decode(&service); err == nil {
-			gs.mu.Lock()
-			oldURL, existed := gs.services[serviceName]
-			gs.services[serviceName] = service.URL
-			gs.mu.Unlock()
-
-			if !existed {
-				gs.logMessage(fmt.Sprintf("✓ 新服务注册: %s -> %s", serviceName, service.URL))
-			} else if oldURL != service.URL {
-				gs.logMessage(fmt.Sprintf("⚠ 服务地址变更: %s %s -> %s", serviceName, oldURL, service.URL))
-			}
-			gs.updateStatus()
-		}
-	}
+	gs.addInstance(*instance)
 }
 
-// RefreshAllServices 从服务中心获取所有服务列表并更新
-func (gs *GatewayService) RefreshAllServices() {
-	if gs.registryURL == "" {
-		return
-	}
-
-	resp, err := http.Get(gs.registryURL + "/services")
+// RefreshAllServices 从服务中心获取所有服务实例并更新
+func (gs *GatewayService) RefreshAllServices() error {
+	instances, err := gs.registry.Instances()
 	if err != nil {
-		gs.logMessage(fmt.Sprintf("✗ 无法获取服务列表: %v", err))
-		return
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return
-	}
-
-	var services []ServiceInfo
-	if err := json.NewDecoder(resp.Body).Decode(&services); err != nil {
-		return
+		gs.logger.Error("✗ 无法获取服务列表", log.Err(err))
+		return err
 	}
+	gs.setInstances(instances)
+	return nil
+}
 
-	// 获取当前服务列表的快照
-	gs.mu.RLock()
-	oldServices := make(map[string]string)
-	for k, v := range gs.services {
-		oldServices[k] = v
-	}
-	gs.mu.RUnlock()
-
-	// 构建新的服务列表
-	newServices := make(map[string]string)
-	for _, service := range services {
-		// 跳过网关服务自己
-		if service.Name == "gateway-service" {
-			continue
+// handleRoute 根据路由表匹配请求并交给路由处理器（中间件 + 转发），记录每条路由的请求统计和访问日志
+func (gs *GatewayService) handleRoute(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	rec := &statusRecorder{ResponseWriter: w}
+	entry := newAccessEntry(r, start)
+	var stats *routeCounters
+	defer func() {
+		// 返回响应体的过程中中止（http.ErrAbortHandler）时已经写出了状态码，
+		// 记为 0（没有完整返回响应），不被采样丢弃，也不计为成功；记录后继续向上 panic 中断连接
+		aborted := recover()
+		status := rec.status
+		if aborted != nil {
+			status = 0
+		} else if status == 0 && isWebSocketUpgrade(r) {
+			status = http.StatusSwitchingProtocols // 接管连接后直接写出的 101 响应
 		}
-		newServices[service.Name] = service.URL
-	}
-
-	// 检测新增的服务
-	gs.mu.Lock()
-	for name, url := range newServices {
-		if oldURL, existed := gs.services[name]; !existed {
-			gs.services[name] = url
-			gs.logMessage(fmt.Sprintf("✓ 新服务上线: %s -> %s", name, url))
-		} else if oldURL != url {
-			gs.services[name] = url
-			gs.logMessage(fmt.Sprintf("⚠ 服务地址变更: %s %s -> %s", name, oldURL, url))
+		now := time.Now()
+		if stats != nil {
+			stats.inflight.Add(-1)
+			stats.record(status, now.Sub(start), now)
 		}
-	}
-
-	// 检测下线的服务
-	for name, oldURL := range oldServices {
-		if _, exists := newServices[name]; !exists {
-			delete(gs.services, name)
-			gs.logMessage(fmt.Sprintf("✗ 服务下线: %s (%s)", name, oldURL))
+		gs.accessLog.write(r, entry, status, rec.bytes, now)
+		if aborted != nil {
+			panic(aborted)
 		}
-	}
-	gs.mu.Unlock()
-
-	// 更新服务列表显示
-	gs.updateServicesList()
-	gs.updateStatus()
-}
-
-// updateServicesList 更新服务列表显示
-func (gs *GatewayService) updateServicesList() {
-	gs.mu.RLock()
-	servicesData := make([]ServiceItem, 0, len(gs.services))
-	for name, url := range gs.services {
-		servicesData = append(servicesData, ServiceItem{
-			Name: name,
-			URL:  url,
-		})
-	}
-	gs.mu.RUnlock()
-
-	// 按服务名称排序，确保顺序稳定
-	sort.Slice(servicesData, func(i, j int) bool {
-		return servicesData[i].Name < servicesData[j].Name
-	})
-
-	gs.muData.Lock()
-	gs.servicesData = servicesData
-	gs.muData.Unlock()
+	}()
 
-	if gs.servicesList != nil {
-		gs.servicesList.Refresh()
+	table := gs.routes.Load()
+	table.auth.stripIdentityHeaders(r)
+	entry.clientIP = table.resolveClientIP(r)
+	match := table.Match(r)
+	if match == nil {
+		gs.logger.ForRequest(r).Warn("✗ 没有匹配的路由", log.String("method", r.Method), log.String("path", r.URL.Path))
+		requestid.Error(rec, r, "No route matched", http.StatusNotFound)
+		return
 	}
 
-	// 调用外部设置的更新函数
-	if gs.updateListFn != nil {
-		gs.updateListFn()
-	}
-}
+	match.clientIP = entry.clientIP
+	route := match.route
+	entry.route, entry.service = route.Name, match.ServiceName()
+	stats = gs.routeStats.get(route.Name)
+	stats.inflight.Add(1)
 
-// getServiceURL 获取服务URL（带自动发现）
-func (gs *GatewayService) getServiceURL(serviceName string) string {
-	gs.mu.RLock()
-	url := gs.services[serviceName]
-	gs.mu.RUnlock()
-
-	// 如果URL为空，尝试重新发现
-	if url == "" {
-		gs.DiscoverService(serviceName)
-		gs.mu.RLock()
-		url = gs.services[serviceName]
-		gs.mu.RUnlock()
+	if d := gs.routeDisabled(route.Name, start); d != nil {
+		gs.logger.ForRequest(r).Warn("✗ 路由已停用", log.String("route", route.Name), log.String("reason", d.Reason))
+		rec.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(d.Until.Sub(start).Seconds()))))
+		requestid.Error(rec, r, fmt.Sprintf("Route %s is temporarily disabled", route.Name), http.StatusServiceUnavailable)
+		return
 	}
 
-	return url
+	ctx := context.WithValue(r.Context(), routeMatchKey{}, match)
+	ctx = context.WithValue(ctx, accessEntryKey{}, entry)
+	route.handler.ServeHTTP(rec, r.WithContext(ctx))
 }
 
-// proxyRequest 代理请求到目标服务
-func (gs *GatewayService) proxyRequest(w http.ResponseWriter, r *http.Request, targetURL string) {
-	// 解析目标URL
-	parsedURL, err := url.Parse(targetURL)
-	if err != nil {
-		http.Error(w, "Invalid target URL", http.StatusInternalServerError)
+// forward 把请求转发到匹配路由的目标服务
+// 服务有多个实例时轮询选择，跳过熔断和被摘除的实例；重试时优先选择其他实例
+func (gs *GatewayService) forward(w http.ResponseWriter, r *http.Request) {
+	if isWebSocketUpgrade(r) {
+		gs.proxyWebSocket(w, r)
 		return
 	}
 
-	// 添加查询参数（保留原有的查询参数，如果有的话）
-	if r.URL.RawQuery != "" {
-		if parsedURL.RawQuery != "" {
-			parsedURL.RawQuery += "&" + r.URL.RawQuery
+	match := routeFromContext(r.Context())
+	route := match.route
+	retries := &route.Retries
+	serviceName := match.ServiceName()
+	targetPath := match.TargetPath(r.URL.Path)
+
+	// 路由的整体超时；SSE 响应在收到响应头后停止计时，改为空闲超时
+	ctx, cancel := context.WithCancelCause(r.Context())
+	defer cancel(nil)
+	deadline := time.AfterFunc(route.timeout(), func() { cancel(context.DeadlineExceeded) })
+	defer deadline.Stop()
+
+	budget := gs.retryBudgetConfig()
+	gs.retryBudget.Request(budget, time.Now())
+
+	// 只有幂等请求（或携带 Idempotency-Key 的请求）才重试，请求体缓存后才能重新发送
+	attempts := 1
+	body := &requestBody{stream: r.Body}
+	if retries.Attempts > 0 && idempotent(r) {
+		var err error
+		body, err = bufferRequestBody(r, retries.MaxBodyBytes)
+		if err != nil {
+			gs.logger.ForRequest(r).Warn("✗ 读取请求体失败", log.String("route", route.Name), log.Err(err))
+			writeBodyError(w, r, err)
+			return
+		}
+		if body.replayable {
+			attempts += retries.Attempts
 		} else {
-			parsedURL.RawQuery = r.URL.RawQuery
+			gs.logger.ForRequest(r).Warn("请求体超过重试缓存上限，不重试",
+				log.String("route", route.Name),
+				log.Int64("max_body_bytes", retries.MaxBodyBytes))
 		}
 	}
 
-	// 创建新请求
-	req, err := http.NewRequest(r.Method, parsedURL.String(), r.Body)
-	if err != nil {
-		http.Error(w, "Failed to create request", http.StatusInternalServerError)
-		return
+	version := ""
+	if route.split != nil {
+		version = gs.chooseVersion(w, r, route)
 	}
 
-	// 复制请求头
-	for key, values := range r.Header {
-		for _, value := range values {
-			req.Header.Add(key, value)
+	tried := make(map[string]bool)
+	for attempt := 1; ; attempt++ {
+		upstream, generation, retryAfter, err := gs.pickUpstream(serviceName, version, tried)
+		if err != nil {
+			gs.writeUnavailable(w, r, route, serviceName, retryAfter, err)
+			return
 		}
-	}
-
-	// 发送请求
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		gs.logMessage(fmt.Sprintf("✗ 转发请求失败: %v", err))
-		http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
-		return
-	}
-	defer resp.Body.Close()
-
-	// 复制响应头
-	for key, values := range resp.Header {
-		for _, value := range values {
-			w.Header().Add(key, value)
+		tried[upstream.ID] = true
+
+		targetURL := upstream.URL + targetPath
+		gs.logger.ForRequest(r).Info("→ 转发请求",
+			log.String("route", route.Name),
+			log.String("service", serviceName),
+			log.String("instance", upstream.ID),
+			log.String("version", upstream.Version),
+			log.String("method", r.Method),
+			log.String("target", targetURL),
+			log.String("query", r.URL.RawQuery),
+			log.Int("attempt", attempt))
+
+		start := time.Now()
+		resp, err := gs.sendRequest(ctx, r, targetURL, body.reader())
+		recordUpstream(ctx, upstream, time.Since(start))
+		if err != nil {
+			// 客户端已断开时不计入实例的失败统计
+			if r.Context().Err() != nil {
+				upstream.breaker.Cancel(generation)
+				return
+			}
+			// 请求体超过路由的大小上限是客户端的问题，同样不计入失败统计
+			if bodylimit.TooLarge(err) {
+				upstream.breaker.Cancel(generation)
+				gs.logger.ForRequest(r).Warn("✗ 请求体超出限制", log.String("route", route.Name), log.Err(err))
+				writeBodyError(w, r, err)
+				return
+			}
+			if ctx.Err() != nil {
+				err = context.Cause(ctx)
+			}
+			gs.recordResult(upstream, generation, 0, time.Since(start), err)
+			gs.logger.ForRequest(r).Error("✗ 转发请求失败",
+				log.String("target", targetURL),
+				log.String("instance", upstream.ID),
+				log.Int("attempt", attempt),
+				log.Err(err))
+		} else {
+			gs.recordResult(upstream, generation, resp.StatusCode, time.Since(start), nil)
+			if !retries.retryStatus(resp.StatusCode) {
+				if isEventStream(resp.Header) {
+					gs.streamResponse(ctx, w, r, resp, deadline, cancel)
+				} else {
+					gs.copyResponse(w, r, resp)
+				}
+				return
+			}
 		}
-	}
-
-	// 设置状态码
-	w.WriteHeader(resp.StatusCode)
-
-	// 复制响应体
-	io.Copy(w, resp.Body)
-}
 
-// handleUserService 处理用户服务请求
-func (gs *GatewayService) handleUserService(w http.ResponseWriter, r *http.Request) {
-	userServiceURL := gs.getServiceURL("user-service")
-	if userServiceURL == "" {
-		gs.logMessage("✗ 用户服务不可用")
-		http.Error(w, "User service unavailable", http.StatusServiceUnavailable)
-		return
-	}
+		// 决定是否重试：次数、超时和全局重试预算都允许时才重试，否则返回本次结果
+		retry := attempt < attempts && ctx.Err() == nil
+		if retry && !gs.retryBudget.Withdraw(budget, time.Now()) {
+			gs.logger.ForRequest(r).Warn("重试预算已用完，不再重试", log.String("route", route.Name), log.String("service", serviceName))
+			retry = false
+		}
+		if !retry {
+			if resp != nil {
+				gs.copyResponse(w, r, resp)
+			} else {
+				requestid.Error(w, r, "Service unavailable", http.StatusServiceUnavailable)
+			}
+			return
+		}
+		if resp != nil {
+			gs.logger.ForRequest(r).Warn("上游返回可重试的状态码",
+				log.String("target", targetURL),
+				log.String("instance", upstream.ID),
+				log.Int("status", resp.StatusCode),
+				log.Int("attempt", attempt))
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
 
-	// 提取路径（去掉 /api/user）
-	path := strings.TrimPrefix(r.URL.Path, "/api/user")
-	if path == "" {
-		path = "/user"
-	} else {
-		path = "/user" + path
+		if !sleepContext(ctx, retries.backoff(attempt)) {
+			if r.Context().Err() == nil {
+				requestid.Error(w, r, "Service unavailable", http.StatusServiceUnavailable)
+			}
+			return
+		}
 	}
-
-	targetURL := userServiceURL + path
-	gs.logMessage(fmt.Sprintf("→ 转发到用户服务: %s %s", r.Method, targetURL))
-	gs.proxyRequest(w, r, targetURL)
 }
 
-// handleOrderService 处理订单服务请求
-func (gs *GatewayService) handleOrderService(w http.ResponseWriter, r *http.Request) {
-	orderServiceURL := gs.getServiceURL("order-service")
-	if orderServiceURL == "" {
-		gs.logMessage("✗ 订单服务不可用")
-		http.Error(w, "Order service unavailable", http.StatusServiceUnavailable)
+// writeUnavailable 没有可用实例时返回 503，实例都在熔断中时带上 Retry-After
+func (gs *GatewayService) writeUnavailable(w http.ResponseWriter, r *http.Request, route *Route, serviceName string, retryAfter time.Duration, err error) {
+	if errors.Is(err, errCircuitOpen) {
+		gs.logger.ForRequest(r).Warn("⚡ 熔断中，快速失败",
+			log.String("route", route.Name),
+			log.String("service", serviceName),
+			log.Duration("retry_after", retryAfter))
+		seconds := int(math.Ceil(retryAfter.Seconds()))
+		if seconds < 1 {
+			seconds = 1
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(seconds))
+		requestid.Error(w, r, fmt.Sprintf("Service %s unavailable (circuit open)", serviceName), http.StatusServiceUnavailable)
 		return
 	}
-
-	// 提取路径（去掉 /api/order）
-	path := strings.TrimPrefix(r.URL.Path, "/api/order")
-	if path == "" {
-		path = "/order"
-	} else {
-		path = "/order" + path
-	}
-
-	targetURL := orderServiceURL + path
-	gs.logMessage(fmt.Sprintf("→ 转发到订单服务: %s %s", r.Method, targetURL))
-	gs.proxyRequest(w, r, targetURL)
+	gs.logger.ForRequest(r).Error("✗ 服务不可用", log.String("route", route.Name), log.String("service", serviceName))
+	requestid.Error(w, r, fmt.Sprintf("Service %s unavailable", serviceName), http.StatusServiceUnavailable)
 }
 
-// handleDynamicRoute 动态路由处理（根据服务名自动路由）
-func (gs *GatewayService) handleDynamicRoute(w http.ResponseWriter, r *http.Request) {
-	// 路径格式: /api/{service-name}/...
-	pathParts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/"), "/")
-	if len(pathParts) == 0 {
-		http.Error(w, "Invalid path", http.StatusBadRequest)
-		return
+// retryBudgetConfig 返回当前生效的重试预算配置
+func (gs *GatewayService) retryBudgetConfig() *RetryBudgetConfig {
+	if table := gs.routes.Load(); table != nil {
+		return &table.RetryBudget
 	}
-
-	serviceName := pathParts[0]
-	serviceURL := gs.getServiceURL(serviceName)
-	if serviceURL == "" {
-		gs.logMessage(fmt.Sprintf("✗ 服务不可用: %s", serviceName))
-		http.Error(w, fmt.Sprintf("Service %s unavailable", serviceName), http.StatusServiceUnavailable)
-		return
-	}
-
-	// 构建目标路径（保留原始路径结构）
-	remainingPath := strings.Join(pathParts[1:], "/")
-
-	// 根据服务名映射到实际路径
-	var targetPath string
-	if remainingPath == "" {
-		// 如果没有剩余路径，根据服务名设置默认路径
-		switch serviceName {
-		case "user-service", "user":
-			targetPath = "/user"
-		case "order-service", "order":
-			targetPath = "/order"
-		default:
-			targetPath = "/" + serviceName
-		}
-	} else {
-		// 有剩余路径，直接使用（remainingPath已经包含了服务路径，如"user"或"order"）
-		// 确保路径以 / 开头
-		if !strings.HasPrefix(remainingPath, "/") {
-			targetPath = "/" + remainingPath
-		} else {
-			targetPath = remainingPath
-		}
-	}
-
-	targetURL := serviceURL + targetPath
-	// 如果有查询参数，添加到日志中
-	queryStr := ""
-	if r.URL.RawQuery != "" {
-		queryStr = "?" + r.URL.RawQuery
-	}
-	gs.logMessage(fmt.Sprintf("→ 动态路由: %s -> %s %s%s", serviceName, r.Method, targetURL, queryStr))
-	gs.proxyRequest(w, r, targetURL)
+	cfg := RetryBudgetConfig{}.withDefaults()
+	return &cfg
 }
 
 // handleHealth 健康检查
 func (gs *GatewayService) handleHealth(w http.ResponseWriter, r *http.Request) {
-	gs.mu.RLock()
-	services := make(map[string]string)
-	for k, v := range gs.services {
-		services[k] = v
-	}
-	gs.mu.RUnlock()
-
 	response := map[string]interface{}{
-		"status":   "ok",
-		"gateway":  fmt.Sprintf("http://localhost:%d", gs.port),
-		"services": services,
+		"status":       "ok",
+		"gateway":      fmt.Sprintf("http://localhost:%d", gs.port),
+		"services":     gs.upstreamStatuses(),
+		"retry_budget": gs.retryBudget.Snapshot(gs.retryBudgetConfig(), time.Now()),
+		"streams":      gs.streams.Snapshot(),
+	}
+	if table := gs.routes.Load(); table != nil {
+		response["routes"] = map[string]interface{}{
+			"count":     len(table.Routes),
+			"source":    table.Source,
+			"loaded_at": table.LoadedAt,
+		}
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -519,68 +342,70 @@ func (gs *GatewayService) handleHealth(w http.ResponseWriter, r *http.Request) {
 
 func main() {
 	// 创建GUI应用
-	myApp := app.New()
-	// 设置支持中文的主题（使用系统默认字体，支持中文）
-	myApp.Settings().SetTheme(newChineseTheme())
-	myWindow := myApp.NewWindow("API网关服务 (端口: 8083)")
+	myApp := ui.NewApp()
+	port := config.GetEnvInt("PORT", 8083)
+	myWindow := myApp.NewWindow(fmt.Sprintf("API网关服务 (端口: %d)", port))
 	myWindow.Resize(fyne.NewSize(800, 600))
 
-	// 创建日志显示区域（使用canvas.Text支持彩色显示）
-	logContainer := container.NewVBox()
-	// 添加初始消息
-	initText := canvas.NewText("API网关服务启动中...", color.NRGBA{R: 200, G: 200, B: 200, A: 255})
-	initText.TextStyle = fyne.TextStyle{Monospace: true}
-	logContainer.Add(initText)
-	logScroll := container.NewScroll(logContainer)
-	logScroll.SetMinSize(fyne.NewSize(0, 0))
-
-	// 创建状态标签
-	statusLabel := widget.NewLabel("状态: 启动中...")
-
-	// 创建服务列表
-	servicesData := make([]ServiceItem, 0)
-	servicesList := widget.NewList(
-		func() int {
-			return len(servicesData)
-		},
-		func() fyne.CanvasObject {
-			return container.NewHBox(
-				widget.NewLabel(""),
-				widget.NewLabel(""),
-			)
-		},
-		func(id widget.ListItemID, obj fyne.CanvasObject) {
-			if id < len(servicesData) {
-				service := servicesData[id]
-				boxes := obj.(*fyne.Container)
-				boxes.Objects[0].(*widget.Label).SetText(service.Name)
-				boxes.Objects[1].(*widget.Label).SetText(service.URL)
-			}
-		},
-	)
-
-	port := 8083
-	registryURL := "http://localhost:8080"
-
-	service := NewGatewayService(port, registryURL, logContainer, logScroll, statusLabel, servicesList)
-
-	// 设置路由
-	// 固定路由（向后兼容）
-	http.HandleFunc("/api/user", service.handleUserService)
-	http.HandleFunc("/api/user/", service.handleUserService)
-	http.HandleFunc("/api/order", service.handleOrderService)
-	http.HandleFunc("/api/order/", service.handleOrderService)
-	// 动态路由（支持任意服务）
-	http.HandleFunc("/api/", service.handleDynamicRoute)
+	// 创建日志：同时输出到标准输出和内存环形缓冲区，GUI订阅缓冲区显示
+	logBuffer := log.NewRingBuffer(200)
+	logger := log.NewFromEnv(logBuffer)
+
+	// 创建日志面板和状态栏
+	logPanel := ui.NewLogPanel(logBuffer, myWindow)
+	statusBar := ui.NewStatusBar()
+	logger.Info("API网关服务启动中...")
+
+	registryClient := registry.NewClient(config.GetEnv("REGISTRY_URL", "http://localhost:8080"), logger)
+	// 配置了证书文件或本地CA令牌（CA_TOKEN）时使用 HTTPS，证书自动重新加载或更新
+	tlsStore, err := registryClient.SetupTLS("gateway-service", "localhost")
+	if err != nil {
+		logger.Fatal("初始化TLS失败", log.Err(err))
+	}
+	service := NewGatewayService(port, registryClient, logger)
+	if tlsStore != nil {
+		// 转发到 https 上游时验证上游证书，上游要求时提供客户端证书
+		service.SetUpstreamTLS(tlsStore)
+	}
+
+	// 加载API Key（保存在本地文件中，通过管理接口创建、轮换和吊销）
+	apiKeysFile := config.GetEnv("GATEWAY_API_KEYS", "api_keys.json")
+	apiKeys, err := LoadAPIKeyStore(apiKeysFile)
+	if err != nil {
+		logger.Fatal("加载API Key失败", log.String("file", apiKeysFile), log.Err(err))
+	}
+	service.apiKeys = apiKeys
+	// 管理接口的令牌：没有设置 GATEWAY_ADMIN_TOKEN 时生成随机令牌，管理接口只监听本机
+	var adminHost string
+	service.adminToken, adminHost, err = loadAdminToken(logger)
+	if err != nil {
+		logger.Fatal("初始化管理接口令牌失败", log.Err(err))
+	}
+	// 访问日志（ACCESS_LOG=stdout 或文件路径时启用）
+	service.accessLog, err = newAccessLogFromEnv()
+	if err != nil {
+		logger.Fatal("初始化访问日志失败", log.Err(err))
+	}
+	adminPort := config.GetEnvInt("GATEWAY_ADMIN_PORT", port+1000)
+
+	// 加载路由配置（文件变化或收到SIGHUP时自动重新加载）
+	routesFile := config.GetEnv("GATEWAY_ROUTES", "routes.json")
+	if err := service.LoadRoutes(routesFile); err != nil {
+		logger.Fatal("加载路由配置失败", log.String("file", routesFile), log.Err(err))
+	}
+	go service.WatchRoutes(2 * time.Second)
+
+	// 设置路由：/health 由网关自己处理，其余请求按路由配置转发；管理接口使用单独的端口
 	http.HandleFunc("/health", service.handleHealth)
+	http.HandleFunc("/", service.handleRoute)
 
 	// 启动HTTP服务器
 	go func() {
-		service.logMessage(fmt.Sprintf("API网关服务启动在端口 %d", port))
-		service.logMessage(fmt.Sprintf("服务注册中心: %s", registryURL))
+		logger.Info("API网关服务启动", log.Int("port", port))
+		logger.Info("服务注册中心", log.String("url", registryClient.URL()))
 
 		// 注册到服务注册中心
-		service.RegisterToRegistry()
+		registryClient.Register("gateway-service", "localhost", port)
 
 		// 立即获取所有服务列表
 		service.RefreshAllServices()
@@ -594,83 +419,57 @@ func main() {
 			}
 		}()
 
-		service.logMessage("API端点:")
-		service.logMessage(fmt.Sprintf("  GET  http://localhost:%d/api/user?id=1 - 获取用户", port))
-		service.logMessage(fmt.Sprintf("  GET  http://localhost:%d/api/user - 列出所有用户", port))
-		service.logMessage(fmt.Sprintf("  POST http://localhost:%d/api/user - 创建用户", port))
-		service.logMessage(fmt.Sprintf("  GET  http://localhost:%d/api/order?id=1 - 获取订单", port))
-		service.logMessage(fmt.Sprintf("  GET  http://localhost:%d/api/order?user_id=1 - 获取用户的订单", port))
-		service.logMessage(fmt.Sprintf("  POST http://localhost:%d/api/order - 创建订单", port))
-		service.logMessage(fmt.Sprintf("  GET  http://localhost:%d/health - 健康检查（查看所有已发现的服务）", port))
-		service.logMessage("")
-		service.logMessage("网关会自动监听服务中心的服务列表变动")
-		service.logMessage("每1秒从服务中心获取最新服务列表")
-
-		service.updateStatus()
-
-		// 定期更新状态
-		go func() {
-			ticker := time.NewTicker(2 * time.Second)
-			defer ticker.Stop()
-			for range ticker.C {
-				service.updateStatus()
-			}
-		}()
-
-		log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", port), nil))
+		// 定期检测并摘除异常实例
+		go service.DetectOutliers()
+
+		logger.Info("API端点:")
+		logger.Info(fmt.Sprintf("  GET  http://localhost:%d/api/user?id=1 - 获取用户", port))
+		logger.Info(fmt.Sprintf("  GET  http://localhost:%d/api/user - 列出所有用户", port))
+		logger.Info(fmt.Sprintf("  POST http://localhost:%d/api/user - 创建用户", port))
+		logger.Info(fmt.Sprintf("  GET  http://localhost:%d/api/order?id=1 - 获取订单", port))
+		logger.Info(fmt.Sprintf("  GET  http://localhost:%d/api/order?user_id=1 - 获取用户的订单", port))
+		logger.Info(fmt.Sprintf("  POST http://localhost:%d/api/order - 创建订单", port))
+		logger.Info(fmt.Sprintf("  GET  http://localhost:%d/api/order-details?id=1 - 组合路由：订单、用户和用户的所有订单", port))
+		logger.Info(fmt.Sprintf("  GET  http://localhost:%d/health - 健康检查（查看所有已发现的服务）", port))
+		logger.Info(fmt.Sprintf("  GET  http://localhost:%d/api/{服务名}/... - 按服务名动态路由", port))
+		logger.Info("管理接口（单独的端口）:", log.Int("admin_port", adminPort))
+		logger.Info(fmt.Sprintf("  GET  http://localhost:%d%s - 路由及请求统计（POST %s/{路由名}/disable 临时停用）", adminPort, adminRoutesPath, adminRoutesPath))
+		logger.Info(fmt.Sprintf("  GET  http://localhost:%d%s - 已发现的服务实例及最近的错误数", adminPort, adminUpstreamsPath))
+		logger.Info(fmt.Sprintf("  POST http://localhost:%d%s - 立即从注册中心刷新服务列表", adminPort, adminRegistryPath))
+		logger.Info(fmt.Sprintf("  PUT  http://localhost:%d%s - 修改日志级别", adminPort, adminLogLevelPath))
+		logger.Info(fmt.Sprintf("  GET  http://localhost:%d%s - API Key 管理", adminPort, adminAPIKeysPath))
+		logger.Info(fmt.Sprintf("  GET  http://localhost:%d%s - 响应缓存统计（POST %s/purge 清除缓存）", adminPort, adminCachePath, adminCachePath))
+		logger.Info(fmt.Sprintf("  GET  http://localhost:%d%s - 按版本分配的流量和各版本错误率（PUT %s/{路由名} 修改权重）", adminPort, adminSplitsPath, adminSplitsPath))
+		logger.Info(fmt.Sprintf("  GET  http://localhost:%d%s - 流量复制（影子请求）统计", adminPort, adminMirrorsPath))
+		logger.Info(fmt.Sprintf("  GET  http://localhost:%d%s - 故障注入（PUT %s/{路由名} 临时开启故障）", adminPort, adminFaultsPath, adminFaultsPath))
+		logger.Info("路由规则见配置文件", log.String("file", routesFile))
+		logger.Info("")
+		logger.Info("网关会自动监听服务中心的服务列表变动")
+		logger.Info("每1秒从服务中心获取最新服务列表")
+		logger.Info("每个服务实例有独立的熔断器，配置见路由配置文件的 upstream 部分")
+
+		logger.Fatal("HTTP服务退出", log.Err(tlsconfig.ListenAndServe(fmt.Sprintf(":%d", port), requestid.Middleware(http.DefaultServeMux), tlsStore)))
 	}()
 
-	// 更新服务列表的刷新函数（使用闭包共享变量）
-	refreshServicesList := func() {
-		service.mu.RLock()
-		servicesData = make([]ServiceItem, 0, len(service.services))
-		for name, url := range service.services {
-			servicesData = append(servicesData, ServiceItem{
-				Name: name,
-				URL:  url,
-			})
-		}
-		service.mu.RUnlock()
-
-		// 按服务名称排序，确保顺序稳定
-		sort.Slice(servicesData, func(i, j int) bool {
-			return servicesData[i].Name < servicesData[j].Name
-		})
+	// 管理接口：路由和实例状态、刷新服务列表、停用路由、修改日志级别等，GUI 也通过它显示和操作
+	go func() {
+		logger.Fatal("管理接口退出", log.Err(tlsconfig.ListenAndServe(fmt.Sprintf("%s:%d", adminHost, adminPort), requestid.Middleware(service.adminHandler()), tlsStore)))
+	}()
 
-		servicesList.Refresh()
+	// 创建UI布局（左侧服务实例和路由，右侧日志），数据来自管理接口
+	// 只有管理接口实际使用 HTTPS 时（有服务证书）客户端才使用 https
+	var adminTLS *tls.Config
+	if tlsStore.Enabled() {
+		adminTLS = tlsStore.ClientConfig()
 	}
-
-	// 设置更新列表函数
-	service.updateListFn = refreshServicesList
-
-	// 创建HSplit并设置分割比例（左侧占30%，右侧占70%）
-	split := container.NewHSplit(
-		container.NewBorder(
-			widget.NewLabel("已发现服务列表"), nil, nil, nil,
-			servicesList,
-		),
-		container.NewBorder(
-			widget.NewLabel("日志输出"), nil, nil, nil,
-			logScroll,
-		),
-	)
-	split.SetOffset(0.3) // 左侧占30%宽度
-
-	// 创建UI布局
-	content := container.NewBorder(
-		statusLabel,
-		nil,
-		nil,
-		nil,
-		split,
-	)
-
-	myWindow.SetContent(content)
+	panel := newGatewayPanel(NewAdminClient(adminPort, service.adminToken, adminTLS), logger, statusBar, port, registryClient.URL())
+	go panel.Poll(time.Second)
+	myWindow.SetContent(ui.ServiceLayout(statusBar, logPanel, "服务实例与路由", panel.content()))
 
 	// 设置窗口关闭拦截，在关闭前注销服务
 	myWindow.SetCloseIntercept(func() {
-		service.logMessage("窗口关闭，正在注销服务...")
-		service.UnregisterFromRegistry()
+		logger.Info("窗口关闭，正在注销服务...")
+		registryClient.Unregister()
 		// 稍微等待一下，确保注销请求完成
 		time.Sleep(300 * time.Millisecond)
 		// 手动关闭窗口
@@ -679,169 +478,3 @@ func main() {
 
 	myWindow.ShowAndRun()
 }
-
-// chineseTheme 支持中文的主题
-type chineseTheme struct {
-	baseTheme   fyne.Theme
-	chineseFont fyne.Resource
-}
-
-func newChineseTheme() *chineseTheme {
-	t := &chineseTheme{
-		baseTheme: theme.DefaultTheme(),
-	}
-
-	// 尝试加载系统字体
-	t.chineseFont = loadSystemChineseFont()
-	if t.chineseFont == nil {
-		// 如果找不到系统字体，在Windows上直接使用默认主题字体
-		// Fyne在Windows上会自动使用系统默认字体，通常支持中文
-		t.chineseFont = t.baseTheme.Font(fyne.TextStyle{})
-		if t.chineseFont == nil {
-			t.chineseFont = theme.DefaultTheme().Font(fyne.TextStyle{})
-		}
-	}
-
-	return t
-}
-
-// loadSystemChineseFont 加载中文字体（优先使用嵌入的字体，否则使用系统字体）
-func loadSystemChineseFont() fyne.Resource {
-	// 1. 优先尝试加载嵌入的字体文件
-	if embeddedFont := loadEmbeddedFont(); embeddedFont != nil {
-		return embeddedFont
-	}
-
-	// 2. 如果嵌入字体不存在，尝试加载系统字体
-	var fontPaths []string
-
-	switch runtime.GOOS {
-	case "windows":
-		// Windows字体路径，按优先级排序
-		// 优先使用常见的Windows中文字体
-		windir := os.Getenv("WINDIR")
-		if windir == "" {
-			windir = "C:\\Windows"
-		}
-		
-		// 注意：Fyne不支持.ttc字体集合，只使用.ttf文件
-		fontPaths = []string{
-			filepath.Join(windir, "Fonts", "simhei.ttf"),  // SimHei (黑体)
-			filepath.Join(windir, "Fonts", "simsun.ttf"),  // SimSun (宋体)
-			filepath.Join(windir, "Fonts", "simkai.ttf"),  // SimKai (楷体)
-			filepath.Join(windir, "Fonts", "simli.ttf"),   // SimLi (隶书)
-		}
-		
-		if windir != "C:\\Windows" {
-			fontPaths = append(fontPaths,
-				filepath.Join("C:\\Windows", "Fonts", "simhei.ttf"),
-				filepath.Join("C:\\Windows", "Fonts", "simsun.ttf"),
-			)
-		}
-	case "darwin": // macOS
-		// 只使用.ttf文件，Fyne不支持.ttc
-		fontPaths = []string{
-			"/Library/Fonts/Arial Unicode.ttf",
-		}
-	case "linux":
-		// 只使用.ttf文件，Fyne不支持.ttc
-		fontPaths = []string{
-			"/usr/share/fonts/truetype/droid/DroidSansFallbackFull.ttf",
-		}
-	}
-
-	// 尝试加载系统字体文件（只加载.ttf和.otf，跳过.ttc）
-	for _, path := range fontPaths {
-		// 检查文件扩展名，只加载.ttf和.otf文件
-		ext := filepath.Ext(path)
-		if ext != ".ttf" && ext != ".otf" {
-			continue // 跳过非.ttf/.otf文件
-		}
-
-		// 检查文件是否存在
-		if info, err := os.Stat(path); err == nil && !info.IsDir() {
-			// 尝试使用file://协议加载字体
-			uri := storage.NewFileURI(path)
-			res, err := storage.LoadResourceFromURI(uri)
-			if err == nil && res != nil {
-				// 验证字体资源是否有效
-				content := res.Content()
-				if len(content) > 0 {
-					// 字体加载成功
-					return res
-				}
-			}
-			
-			// 如果storage.LoadResourceFromURI失败，尝试直接读取文件
-			if data, err := os.ReadFile(path); err == nil && len(data) > 0 {
-				// 创建内存资源
-				res := fyne.NewStaticResource(filepath.Base(path), data)
-				return res
-			}
-		}
-	}
-
-	// 如果所有字体都加载失败，返回nil（会使用默认字体）
-	return nil
-}
-
-// loadEmbeddedFont 加载嵌入的字体文件
-// 注意：Fyne不支持.ttc（TrueType Collection）字体集合文件，只支持.ttf和.otf
-func loadEmbeddedFont() fyne.Resource {
-	// 直接读取fonts目录下的文件
-	entries, err := embeddedFonts.ReadDir("fonts")
-	if err != nil {
-		return nil
-	}
-
-	// 按优先级查找字体文件（只查找.ttf和.otf，跳过.ttc）
-	preferredNames := []string{"chinese.ttf", "chinese.otf", "msyh.ttf", "simsun.ttf", "font.ttf", "font.otf"}
-
-	// 先查找优先字体
-	for _, preferredName := range preferredNames {
-		for _, entry := range entries {
-			if entry.Name() == preferredName && !entry.IsDir() {
-				data, err := embeddedFonts.ReadFile("fonts/" + preferredName)
-				if err == nil && len(data) > 0 {
-					return fyne.NewStaticResource(preferredName, data)
-				}
-			}
-		}
-	}
-
-	// 如果优先字体没找到，查找任何.ttf或.otf字体文件（跳过.ttc）
-	for _, entry := range entries {
-		if entry.IsDir() {
-			continue
-		}
-
-		name := entry.Name()
-		ext := filepath.Ext(name)
-		// 只加载.ttf和.otf文件，跳过.ttc（Fyne不支持）
-		if ext == ".ttf" || ext == ".otf" {
-			data, err := embeddedFonts.ReadFile("fonts/" + name)
-			if err == nil && len(data) > 0 {
-				return fyne.NewStaticResource(name, data)
-			}
-		}
-	}
-
-	return nil
-}
-
-func (t *chineseTheme) Color(name fyne.ThemeColorName, variant fyne.ThemeVariant) color.Color {
-	return t.baseTheme.Color(name, variant)
-}
-
-func (t *chineseTheme) Icon(name fyne.ThemeIconName) fyne.Resource {
-	return t.baseTheme.Icon(name)
-}
-
-func (t *chineseTheme) Font(style fyne.TextStyle) fyne.Resource {
-	// 使用加载的中文字体
-	return t.chineseFont
-}
-
-func (t *chineseTheme) Size(name fyne.ThemeSizeName) float32 {
-	return t.baseTheme.Size(name)
-}
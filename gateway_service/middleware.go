@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Middleware 路由中间件，包装转发处理器
+type Middleware func(next http.Handler) http.Handler
+
+// middlewareBuilder 根据配置创建中间件，config 为配置文件中该中间件的 config 字段
+type middlewareBuilder func(gs *GatewayService, config json.RawMessage) (Middleware, error)
+
+// middlewareTypes 已注册的中间件类型
+var middlewareTypes = map[string]middlewareBuilder{}
+
+// registerMiddleware 注册中间件类型，在 init 中调用
+func registerMiddleware(typ string, builder middlewareBuilder) {
+	if _, exists := middlewareTypes[typ]; exists {
+		panic("重复注册中间件类型: " + typ)
+	}
+	middlewareTypes[typ] = builder
+}
+
+// middlewareTypeNames 返回所有已注册的中间件类型（用于错误提示）
+func middlewareTypeNames() string {
+	names := make([]string, 0, len(middlewareTypes))
+	for name := range middlewareTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// buildMiddleware 创建配置文件中定义的中间件
+func (gs *GatewayService) buildMiddleware(cfg MiddlewareConfig) (Middleware, error) {
+	builder, ok := middlewareTypes[cfg.Type]
+	if !ok {
+		return nil, fmt.Errorf("未知的中间件类型 %q（可用类型: %s）", cfg.Type, middlewareTypeNames())
+	}
+	return builder(gs, cfg.Config)
+}
+
+// chain 按配置顺序组合中间件，第一个中间件最先处理请求
+func chain(h http.Handler, middlewares []Middleware) http.Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
+	}
+	return h
+}
+
+// decodeMiddlewareConfig 严格解析中间件配置（未知字段视为错误）
+func decodeMiddlewareConfig(config json.RawMessage, v interface{}) error {
+	if len(config) == 0 {
+		return nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(config))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
+
+func init() {
+	registerMiddleware("headers", newHeadersMiddleware)
+}
+
+// headersConfig headers 中间件配置：设置或删除请求头/响应头
+type headersConfig struct {
+	RequestSet     map[string]string `json:"request_set"`
+	RequestRemove  []string          `json:"request_remove"`
+	ResponseSet    map[string]string `json:"response_set"`
+	ResponseRemove []string          `json:"response_remove"`
+}
+
+func newHeadersMiddleware(gs *GatewayService, config json.RawMessage) (Middleware, error) {
+	var cfg headersConfig
+	if err := decodeMiddlewareConfig(config, &cfg); err != nil {
+		return nil, err
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for _, name := range cfg.RequestRemove {
+				r.Header.Del(name)
+			}
+			for name, value := range cfg.RequestSet {
+				r.Header.Set(name, value)
+			}
+			if len(cfg.ResponseSet) > 0 || len(cfg.ResponseRemove) > 0 {
+				w = &headerRewriter{ResponseWriter: w, set: cfg.ResponseSet, remove: cfg.ResponseRemove}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}, nil
+}
+
+// headerRewriter 在写出响应头之前修改响应头
+type headerRewriter struct {
+	http.ResponseWriter
+	set         map[string]string
+	remove      []string
+	wroteHeader bool
+}
+
+func (hw *headerRewriter) WriteHeader(code int) {
+	if !hw.wroteHeader {
+		hw.wroteHeader = true
+		for _, name := range hw.remove {
+			hw.Header().Del(name)
+		}
+		for name, value := range hw.set {
+			hw.Header().Set(name, value)
+		}
+	}
+	hw.ResponseWriter.WriteHeader(code)
+}
+
+func (hw *headerRewriter) Write(b []byte) (int, error) {
+	if !hw.wroteHeader {
+		hw.WriteHeader(http.StatusOK)
+	}
+	return hw.ResponseWriter.Write(b)
+}
+
+// Unwrap 供 http.ResponseController 访问底层的 ResponseWriter
+func (hw *headerRewriter) Unwrap() http.ResponseWriter {
+	return hw.ResponseWriter
+}
+
+// Flush 支持流式响应
+func (hw *headerRewriter) Flush() {
+	if !hw.wroteHeader {
+		hw.WriteHeader(http.StatusOK)
+	}
+	if f, ok := hw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
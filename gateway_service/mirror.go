@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	mathrand "math/rand"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"ttt/pkg/log"
+)
+
+// mirrorHeader 发往影子服务的请求带有该请求头，影子服务可以据此跳过外部副作用（如发送通知）
+const mirrorHeader = "X-Gateway-Mirror"
+
+func init() {
+	registerMiddleware("mirror", newMirrorMiddleware)
+}
+
+// mirrorConfig mirror 中间件配置：把路由的一部分请求复制一份异步发给影子服务
+//
+// 影子请求与主请求同时发出，影子服务的响应被丢弃，失败或超时都不影响主请求。
+// 需要复制请求体时先读取请求体（不超过 max_body_bytes），超过上限的请求不复制。
+type mirrorConfig struct {
+	Service       string   `json:"service"`        // 影子服务名（注册中心中的名称）
+	Percent       float64  `json:"percent"`        // 复制的请求比例（0-100），默认 100
+	Compare       bool     `json:"compare"`        // 比较影子响应与主响应的状态码和耗时
+	Timeout       Duration `json:"timeout"`        // 影子请求的超时时间，默认为路由的 timeout
+	MaxBodyBytes  int64    `json:"max_body_bytes"` // 复制的请求体大小上限，默认 1MB
+	MaxConcurrent int      `json:"max_concurrent"` // 同时进行的影子请求数上限，超过时不复制，默认 64
+}
+
+func newMirrorMiddleware(gs *GatewayService, config json.RawMessage) (Middleware, error) {
+	var cfg mirrorConfig
+	if err := decodeMiddlewareConfig(config, &cfg); err != nil {
+		return nil, err
+	}
+	if cfg.Service == "" {
+		return nil, errors.New("缺少 service")
+	}
+	if cfg.Percent == 0 {
+		cfg.Percent = 100
+	}
+	if cfg.MaxBodyBytes == 0 {
+		cfg.MaxBodyBytes = 1 << 20
+	}
+	if cfg.MaxConcurrent == 0 {
+		cfg.MaxConcurrent = 64
+	}
+	switch {
+	case cfg.Percent < 0 || cfg.Percent > 100:
+		return nil, errors.New("percent 必须在 0 到 100 之间")
+	case cfg.Timeout.Duration < 0 || cfg.MaxBodyBytes < 0 || cfg.MaxConcurrent < 0:
+		return nil, errors.New("timeout、max_body_bytes 和 max_concurrent 不能为负数")
+	}
+
+	var inflight atomic.Int64
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if isWebSocketUpgrade(r) || mathrand.Float64()*100 >= cfg.Percent {
+				next.ServeHTTP(w, r)
+				return
+			}
+			match := routeFromContext(r.Context())
+			stats := gs.mirrors.get(match.route.Name, cfg.Service)
+
+			body, err := bufferRequestBody(r, cfg.MaxBodyBytes)
+			if err != nil {
+				writeBodyError(w, r, err)
+				return
+			}
+			if !body.replayable {
+				r.Body = readCloser{body.stream, r.Body}
+				stats.dropped.Add(1)
+				next.ServeHTTP(w, r)
+				return
+			}
+			if body.buf != nil {
+				r.Body = io.NopCloser(body.reader())
+			}
+			if inflight.Add(1) > int64(cfg.MaxConcurrent) {
+				inflight.Add(-1)
+				stats.dropped.Add(1)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// 在主请求经过后续中间件之前复制请求，影子请求不受后续中间件对请求的修改影响
+			shadow := r.Clone(context.WithoutCancel(r.Context()))
+			shadow.Header.Set(mirrorHeader, "true")
+			targetPath := match.TargetPath(r.URL.Path)
+			timeout := cfg.Timeout.Duration
+			if timeout == 0 {
+				timeout = match.route.timeout()
+			}
+			var primary chan mirrorResult
+			if cfg.Compare {
+				primary = make(chan mirrorResult, 1)
+			}
+			go func() {
+				defer inflight.Add(-1)
+				gs.sendMirror(shadow, cfg.Service, targetPath, body, timeout, stats, primary)
+			}()
+
+			if !cfg.Compare {
+				next.ServeHTTP(w, r)
+				return
+			}
+			rec := &statusRecorder{ResponseWriter: w}
+			start := time.Now()
+			// 主请求中断（panic）时也要通知影子请求，避免 goroutine 一直等待
+			defer func() { primary <- mirrorResult{status: rec.status, latency: time.Since(start)} }()
+			next.ServeHTTP(rec, r)
+		})
+	}, nil
+}
+
+// readCloser 读取 Reader，关闭时关闭原始请求体
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// mirrorResult 一次请求的结果，status 为 0 表示没有收到响应
+type mirrorResult struct {
+	status  int
+	latency time.Duration
+}
+
+// sendMirror 把复制的请求发给影子服务并丢弃响应；primary 不为 nil 时等待主请求的结果并比较
+func (gs *GatewayService) sendMirror(r *http.Request, service, targetPath string, body *requestBody, timeout time.Duration, stats *mirrorCounters, primary <-chan mirrorResult) {
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	var shadow mirrorResult
+	start := time.Now()
+	upstream, generation, _, err := gs.pickUpstream(service, "", nil)
+	if err == nil {
+		var resp *http.Response
+		resp, err = gs.sendRequest(ctx, r, upstream.URL+targetPath, body.reader())
+		if err == nil {
+			_, err = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			shadow.status = resp.StatusCode
+		}
+		gs.recordResult(upstream, generation, shadow.status, time.Since(start), err)
+	}
+	shadow.latency = time.Since(start)
+
+	stats.mirrored.Add(1)
+	if err != nil {
+		stats.errors.Add(1)
+	}
+	stats.shadowLatency.Add(int64(shadow.latency))
+	if primary == nil {
+		if err != nil {
+			gs.logger.ForRequest(r).Debug("影子请求失败", log.String("service", service), log.Err(err))
+		}
+		return
+	}
+
+	result := <-primary
+	stats.compared.Add(1)
+	stats.primaryLatency.Add(int64(result.latency))
+	if result.status == shadow.status {
+		return
+	}
+	stats.mismatches.Add(1)
+	fields := []log.Field{
+		log.String("service", service),
+		log.String("method", r.Method),
+		log.String("path", r.URL.Path),
+		log.Int("primary_status", result.status),
+		log.Int("shadow_status", shadow.status),
+		log.Duration("primary_latency", result.latency),
+		log.Duration("shadow_latency", shadow.latency),
+	}
+	if err != nil {
+		fields = append(fields, log.Err(err))
+	}
+	gs.logger.ForRequest(r).Warn("影子请求与主请求的状态码不同", fields...)
+}
+
+// statusRecorder 记录写出的响应状态码和响应体大小
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (sr *statusRecorder) WriteHeader(code int) {
+	if sr.status == 0 && code >= 200 {
+		sr.status = code
+	}
+	sr.ResponseWriter.WriteHeader(code)
+}
+
+func (sr *statusRecorder) Write(b []byte) (int, error) {
+	if sr.status == 0 {
+		sr.status = http.StatusOK
+	}
+	n, err := sr.ResponseWriter.Write(b)
+	sr.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap 供 http.ResponseController 访问底层的 ResponseWriter
+func (sr *statusRecorder) Unwrap() http.ResponseWriter {
+	return sr.ResponseWriter
+}
+
+// Flush 支持流式响应
+func (sr *statusRecorder) Flush() {
+	if sr.status == 0 {
+		sr.status = http.StatusOK
+	}
+	if f, ok := sr.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// mirrorCounters 一条路由复制到一个影子服务的统计
+type mirrorCounters struct {
+	mirrored       atomic.Int64 // 已完成的影子请求
+	dropped        atomic.Int64 // 因请求体过大或并发数达到上限没有复制的请求
+	errors         atomic.Int64 // 影子请求连接失败或超时
+	compared       atomic.Int64 // 与主请求比较过的请求
+	mismatches     atomic.Int64 // 状态码不同的请求
+	primaryLatency atomic.Int64 // 比较过的主请求总耗时（纳秒）
+	shadowLatency  atomic.Int64 // 影子请求总耗时（纳秒）
+}
+
+// mirrorStats 所有路由的流量复制统计（按路由名和影子服务，重新加载配置时保留）
+type mirrorStats struct {
+	mu       sync.Mutex
+	counters map[[2]string]*mirrorCounters
+}
+
+func (s *mirrorStats) get(route, service string) *mirrorCounters {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.counters == nil {
+		s.counters = make(map[[2]string]*mirrorCounters)
+	}
+	key := [2]string{route, service}
+	c := s.counters[key]
+	if c == nil {
+		c = &mirrorCounters{}
+		s.counters[key] = c
+	}
+	return c
+}
+
+// MirrorStatus 流量复制的统计（用于管理接口）
+type MirrorStatus struct {
+	Route            string  `json:"route"`
+	Service          string  `json:"service"`
+	Mirrored         int64   `json:"mirrored"`
+	Dropped          int64   `json:"dropped"`
+	Errors           int64   `json:"errors"`
+	Compared         int64   `json:"compared"`
+	StatusMismatches int64   `json:"status_mismatches"`
+	MismatchRate     float64 `json:"mismatch_rate"`
+	AvgPrimaryMs     float64 `json:"avg_primary_ms,omitempty"`
+	AvgShadowMs      float64 `json:"avg_shadow_ms"`
+}
+
+// Snapshot 返回各路由的流量复制统计
+func (s *mirrorStats) Snapshot() []MirrorStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]MirrorStatus, 0, len(s.counters))
+	for key, c := range s.counters {
+		st := MirrorStatus{
+			Route:            key[0],
+			Service:          key[1],
+			Mirrored:         c.mirrored.Load(),
+			Dropped:          c.dropped.Load(),
+			Errors:           c.errors.Load(),
+			Compared:         c.compared.Load(),
+			StatusMismatches: c.mismatches.Load(),
+		}
+		if st.Mirrored > 0 {
+			st.AvgShadowMs = float64(c.shadowLatency.Load()) / float64(st.Mirrored) / float64(time.Millisecond)
+		}
+		if st.Compared > 0 {
+			st.MismatchRate = float64(st.StatusMismatches) / float64(st.Compared)
+			st.AvgPrimaryMs = float64(c.primaryLatency.Load()) / float64(st.Compared) / float64(time.Millisecond)
+		}
+		out = append(out, st)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Route != out[j].Route {
+			return out[i].Route < out[j].Route
+		}
+		return out[i].Service < out[j].Service
+	})
+	return out
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"ttt/pkg/log"
+	"ttt/pkg/registry"
+	"ttt/pkg/requestid"
+	"ttt/pkg/tlsconfig"
+)
+
+// hopHeaders 逐跳请求头，只对单个连接有效，不能转发（RFC 9110 第7.6.1节）
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// newTransport 创建所有上游共用的连接池
+// 不设置整体超时（由路由的 timeout 通过 context 控制），不自动解压，保证响应原样转发
+func newTransport() *http.Transport {
+	return &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout:   5 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:          200,
+		MaxIdleConnsPerHost:   32,
+		IdleConnTimeout:       90 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+		DisableCompression:    true,
+		ForceAttemptHTTP2:     true,
+	}
+}
+
+// SetUpstreamTLS 设置转发到 https 上游时使用的证书（验证上游证书、提供客户端证书），在开始转发之前调用
+func (gs *GatewayService) SetUpstreamTLS(store *tlsconfig.Store) {
+	t := newTransport()
+	store.ConfigureTransport(t, gs.upstreamURI)
+	gs.transport = t
+}
+
+// upstreamURI 返回地址（主机:端口）上的实例所属服务的 URI SAN，上游的证书必须属于该服务；
+// 不是已发现的实例时返回一个不可能匹配的 URI（拒绝连接）
+func (gs *GatewayService) upstreamURI(addr string) string {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	for _, u := range gs.instances {
+		if parsed, err := url.Parse(u.URL); err == nil && parsed.Host == addr {
+			return registry.ServiceURI(u.Service)
+		}
+	}
+	return registry.ServiceURI("unknown-upstream:" + addr)
+}
+
+// removeHopHeaders 删除逐跳请求头，包括 Connection 中列出的请求头
+func removeHopHeaders(h http.Header) {
+	for _, value := range h.Values("Connection") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				h.Del(name)
+			}
+		}
+	}
+	for _, name := range hopHeaders {
+		h.Del(name)
+	}
+}
+
+// copyHeader 把 src 中的所有值添加到 dst
+func copyHeader(dst, src http.Header) {
+	for key, values := range src {
+		for _, value := range values {
+			dst.Add(key, value)
+		}
+	}
+}
+
+// setForwardedHeaders 添加 X-Forwarded-* 和 Forwarded 请求头，让上游知道原始的客户端、Host 和协议
+// X-Forwarded-For 和 Forwarded 追加的是连接的远端地址，下游可以按自己信任的代理解析出客户端
+func setForwardedHeaders(req, r *http.Request) {
+	ip := peerIP(r)
+	proto := "http"
+	if r.TLS != nil {
+		proto = "https"
+	}
+
+	if prior := r.Header.Values("X-Forwarded-For"); len(prior) > 0 {
+		req.Header.Set("X-Forwarded-For", strings.Join(prior, ", ")+", "+ip)
+	} else {
+		req.Header.Set("X-Forwarded-For", ip)
+	}
+	req.Header.Set("X-Forwarded-Host", r.Host)
+	req.Header.Set("X-Forwarded-Proto", proto)
+
+	// Forwarded: for=...;host=...;proto=...（IPv6 地址需要加引号和方括号）
+	forIP := ip
+	if strings.Contains(ip, ":") {
+		forIP = `"[` + ip + `]"`
+	}
+	element := "for=" + forIP + ";host=" + quoteForwarded(r.Host) + ";proto=" + proto
+	if prior := r.Header.Values("Forwarded"); len(prior) > 0 {
+		element = strings.Join(prior, ", ") + ", " + element
+	}
+	req.Header.Set("Forwarded", element)
+}
+
+// quoteForwarded 在需要时给 Forwarded 中的值加引号
+func quoteForwarded(v string) string {
+	for _, c := range v {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("!#$%&'*+-.^_`|~", c)) {
+			return `"` + strings.ReplaceAll(strings.ReplaceAll(v, `\`, `\\`), `"`, `\"`) + `"`
+		}
+	}
+	return v
+}
+
+// sendRequest 向目标服务发送请求，保留原请求的查询参数、请求头和请求尾部
+func (gs *GatewayService) sendRequest(ctx context.Context, r *http.Request, targetURL string, body io.Reader) (*http.Response, error) {
+	// 解析目标URL
+	parsedURL, err := url.Parse(targetURL)
+	if err != nil {
+		return nil, err
+	}
+
+	// 添加查询参数（保留原有的查询参数，如果有的话）
+	if r.URL.RawQuery != "" {
+		if parsedURL.RawQuery != "" {
+			parsedURL.RawQuery += "&" + r.URL.RawQuery
+		} else {
+			parsedURL.RawQuery = r.URL.RawQuery
+		}
+	}
+
+	// 创建新请求（缓存的请求体会自动设置 Content-Length）
+	req, err := http.NewRequestWithContext(ctx, r.Method, parsedURL.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	// 未缓存的请求体保留原始长度（长度未知时为 -1，使用分块传输）
+	if _, buffered := body.(*bytes.Reader); body != nil && !buffered {
+		req.ContentLength = r.ContentLength
+	}
+
+	// 复制请求头，去掉逐跳请求头（客户端声明接受 trailers 时保留 TE: trailers）
+	copyHeader(req.Header, r.Header)
+	removeHopHeaders(req.Header)
+	// WebSocket 握手需要保留升级请求头
+	if isWebSocketUpgrade(r) {
+		req.Header.Set("Connection", "Upgrade")
+		req.Header.Set("Upgrade", r.Header.Get("Upgrade"))
+	}
+	if strings.Contains(strings.ToLower(strings.Join(r.Header.Values("Te"), ",")), "trailers") {
+		req.Header.Set("Te", "trailers")
+	}
+	// 没有 User-Agent 时不使用 Go 的默认值
+	if _, ok := req.Header["User-Agent"]; !ok {
+		req.Header.Set("User-Agent", "")
+	}
+	setForwardedHeaders(req, r)
+
+	// 请求尾部在请求体读完后才有值，转发时使用同一个 map
+	if len(r.Trailer) > 0 {
+		req.Trailer = r.Trailer
+		req.ContentLength = -1
+	}
+
+	// 直接使用 RoundTrip，不跟随上游返回的重定向
+	return gs.transport.RoundTrip(req)
+}
+
+// copyResponse 把目标服务的响应写回客户端
+// 长度未知（分块传输）的响应每次写入后立即刷新，保证流式响应及时到达客户端
+func (gs *GatewayService) copyResponse(w http.ResponseWriter, r *http.Request, resp *http.Response) {
+	defer resp.Body.Close()
+	announced := writeResponseHeader(w, resp)
+
+	// 复制响应体
+	rc := http.NewResponseController(w)
+	flush := resp.ContentLength == -1
+	if flush {
+		rc.Flush()
+	}
+	written, err := copyBody(w, resp.Body, rc, flush)
+	if err != nil {
+		if r.Context().Err() != nil {
+			gs.logger.ForRequest(r).Info("客户端已断开，停止转发响应", log.Int64("bytes", written))
+			return
+		}
+		gs.logger.ForRequest(r).Warn("✗ 转发响应体失败", log.Int64("bytes", written), log.Err(err))
+		// 中止连接，让客户端知道响应不完整
+		panic(http.ErrAbortHandler)
+	}
+	copyTrailers(w, resp, announced)
+}
+
+// writeResponseHeader 写出上游的响应头和状态码，返回提前声明的响应尾部数量
+func writeResponseHeader(w http.ResponseWriter, resp *http.Response) int {
+	// 复制响应头（请求ID已由网关设置，不重复添加上游返回的值）
+	removeHopHeaders(resp.Header)
+	resp.Header.Del(requestid.Header)
+	copyHeader(w.Header(), resp.Header)
+
+	// 声明上游的响应尾部，响应体写完后再设置值
+	announced := len(resp.Trailer)
+	if announced > 0 {
+		names := make([]string, 0, announced)
+		for name := range resp.Trailer {
+			names = append(names, name)
+		}
+		w.Header().Add("Trailer", strings.Join(names, ", "))
+	}
+
+	w.WriteHeader(resp.StatusCode)
+	return announced
+}
+
+// copyTrailers 在响应体写完后设置响应尾部（未提前声明的尾部使用 TrailerPrefix）
+func copyTrailers(w http.ResponseWriter, resp *http.Response, announced int) {
+	if len(resp.Trailer) == announced {
+		copyHeader(w.Header(), resp.Trailer)
+		return
+	}
+	for name, values := range resp.Trailer {
+		for _, value := range values {
+			w.Header().Add(http.TrailerPrefix+name, value)
+		}
+	}
+}
+
+// copyBody 复制响应体，flush 为 true 时每次写入后刷新
+func copyBody(w io.Writer, body io.Reader, rc *http.ResponseController, flush bool) (int64, error) {
+	buf := make([]byte, 32<<10)
+	var written int64
+	for {
+		n, readErr := body.Read(buf)
+		if n > 0 {
+			m, err := w.Write(buf[:n])
+			written += int64(m)
+			if err != nil {
+				return written, err
+			}
+			if flush {
+				if err := rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+					return written, err
+				}
+			}
+		}
+		if readErr == io.EOF {
+			return written, nil
+		}
+		if readErr != nil {
+			return written, readErr
+		}
+	}
+}
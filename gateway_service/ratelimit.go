@@ -0,0 +1,268 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"ttt/pkg/log"
+	"ttt/pkg/requestid"
+)
+
+// RateLimit 令牌桶参数：每秒补充 Rate 个令牌，桶容量为 Burst
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// RateLimitDecision 一次限流判断的结果
+type RateLimitDecision struct {
+	Allowed    bool
+	Remaining  int           // 剩余令牌数
+	Reset      time.Duration // 令牌桶补满需要的时间
+	RetryAfter time.Duration // 被拒绝时，下一个令牌可用前需要等待的时间
+}
+
+// RateLimiter 限流存储
+// 内存实现只在单个网关内生效，多个网关共享限额时可以换成基于共享存储的实现
+type RateLimiter interface {
+	// Take 从 key 对应的令牌桶中取一个令牌，scope 区分不同的限额（如路由和 key 配置），
+	// 不同 scope 的同名 key 使用不同的令牌桶
+	Take(scope, key string, limit RateLimit, now time.Time) RateLimitDecision
+}
+
+// tokenBucket 单个key的令牌桶
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+	fullAt time.Time // 令牌桶补满的时间
+}
+
+// bucketKey 令牌桶的key，overflow 为 true 时是该 scope 在令牌桶个数达到上限后共用的令牌桶
+type bucketKey struct {
+	scope    string
+	key      string
+	overflow bool
+}
+
+// memoryRateLimiter 内存中的令牌桶限流
+type memoryRateLimiter struct {
+	mu         sync.Mutex
+	buckets    map[bucketKey]*tokenBucket
+	maxBuckets int
+	lastSweep  time.Time
+}
+
+const (
+	// rateLimiterSweepInterval 清理已补满的令牌桶的间隔（补满的桶与新建的桶等价，可以删除）
+	rateLimiterSweepInterval = time.Minute
+	// maxRateLimitBuckets 令牌桶的最大个数，避免大量不同的 key 耗尽内存
+	maxRateLimitBuckets = 100000
+)
+
+// NewMemoryRateLimiter 创建内存限流存储
+func NewMemoryRateLimiter() RateLimiter {
+	return &memoryRateLimiter{buckets: make(map[bucketKey]*tokenBucket), maxBuckets: maxRateLimitBuckets}
+}
+
+func (m *memoryRateLimiter) Take(scope, key string, limit RateLimit, now time.Time) RateLimitDecision {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	burst := float64(limit.Burst)
+	if now.Sub(m.lastSweep) > rateLimiterSweepInterval {
+		m.sweep(now)
+	}
+
+	bk := bucketKey{scope: scope, key: key}
+	b, ok := m.buckets[bk]
+	if !ok && len(m.buckets) >= m.maxBuckets {
+		// 达到上限时先清理（最多每秒一次），仍然满时同一 scope 的新 key 共用一个令牌桶，
+		// 不能靠不断更换 key 绕过限流，也不会影响其他路由的限额
+		if now.Sub(m.lastSweep) > time.Second {
+			m.sweep(now)
+		}
+		if len(m.buckets) >= m.maxBuckets {
+			bk = bucketKey{scope: scope, overflow: true}
+			b, ok = m.buckets[bk]
+		}
+	}
+	if !ok {
+		b = &tokenBucket{tokens: burst, last: now}
+		m.buckets[bk] = b
+	}
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(burst, b.tokens+elapsed*limit.Rate)
+		b.last = now
+	}
+	// 配置重新加载后容量变小时，已有的令牌不能超过新容量
+	if b.tokens > burst {
+		b.tokens = burst
+	}
+
+	d := RateLimitDecision{}
+	if b.tokens >= 1 {
+		b.tokens--
+		d.Allowed = true
+	} else {
+		d.RetryAfter = rateDuration(1-b.tokens, limit.Rate)
+	}
+	d.Remaining = int(b.tokens)
+	d.Reset = rateDuration(burst-b.tokens, limit.Rate)
+	b.fullAt = now.Add(d.Reset)
+	return d
+}
+
+// sweep 删除空闲到已经补满的令牌桶（调用方需持有锁）
+func (m *memoryRateLimiter) sweep(now time.Time) {
+	m.lastSweep = now
+	for key, b := range m.buckets {
+		if !now.Before(b.fullAt) {
+			delete(m.buckets, key)
+		}
+	}
+}
+
+// rateDuration 以 rate 的速度补充 tokens 个令牌需要的时间
+func rateDuration(tokens, rate float64) time.Duration {
+	if tokens <= 0 || rate <= 0 {
+		return 0
+	}
+	return time.Duration(tokens / rate * float64(time.Second))
+}
+
+func init() {
+	registerMiddleware("rate_limit", newRateLimitMiddleware)
+}
+
+// rateLimitConfig rate_limit 中间件配置
+//
+// key 决定按什么区分客户端：
+//   - ip：客户端IP（IPv6 按 /64 网段，一个客户端通常拥有整个 /64）
+//   - api_key：API Key 的ID（路由配置了 API Key 认证，验证通过后的 X-API-Key-ID），
+//     不使用客户端提供的未经验证的 Key，否则每个请求换一个 Key 就能绕过限流
+//   - user：已认证的用户（JWT认证后的 X-User-ID 请求头，路由需要配置 auth）
+//   - header:名称：任意请求头
+//
+// 请求中没有对应的值时按客户端IP限流。每条路由有独立的限额。
+type rateLimitConfig struct {
+	Key    string   `json:"key"`
+	Rate   float64  `json:"rate"`   // 每个 period 补充的令牌数
+	Period Duration `json:"period"` // 默认 1s
+	Burst  int      `json:"burst"`  // 令牌桶容量，默认等于 rate
+}
+
+// rateLimitKeyFunc 从请求中提取限流的key，没有对应的值时返回空字符串
+type rateLimitKeyFunc func(r *http.Request) string
+
+// rateLimitKey 解析 key 配置
+func rateLimitKey(spec string) (rateLimitKeyFunc, error) {
+	switch {
+	case spec == "" || spec == "ip":
+		return clientIPKey, nil
+	case spec == "api_key":
+		return func(r *http.Request) string { return r.Header.Get(apiKeyIDHeader) }, nil
+	case spec == "user":
+		return func(r *http.Request) string { return r.Header.Get("X-User-ID") }, nil
+	case strings.HasPrefix(spec, "header:") && len(spec) > len("header:"):
+		name := http.CanonicalHeaderKey(strings.TrimPrefix(spec, "header:"))
+		return func(r *http.Request) string { return r.Header.Get(name) }, nil
+	}
+	return nil, fmt.Errorf("未知的 key %q（可用: ip, api_key, user, header:名称）", spec)
+}
+
+// clientIPKey 按客户端IP限流的key：IPv4 为完整地址，IPv6 为所在的 /64 网段，
+// 否则一个客户端可以用自己 /64 中的大量地址绕过限流并占满令牌桶
+func clientIPKey(r *http.Request) string {
+	client := clientIP(r)
+	ip, err := netip.ParseAddr(client)
+	if err != nil {
+		return client
+	}
+	ip = ip.Unmap().WithZone("")
+	if ip.Is4() {
+		return ip.String()
+	}
+	prefix, _ := ip.Prefix(64)
+	return prefix.String()
+}
+
+func newRateLimitMiddleware(gs *GatewayService, config json.RawMessage) (Middleware, error) {
+	var cfg rateLimitConfig
+	if err := decodeMiddlewareConfig(config, &cfg); err != nil {
+		return nil, err
+	}
+	keyFunc, err := rateLimitKey(cfg.Key)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Period.Duration == 0 {
+		cfg.Period.Duration = time.Second
+	}
+	if cfg.Burst == 0 {
+		cfg.Burst = int(math.Ceil(cfg.Rate))
+	}
+	var errs []error
+	if cfg.Rate <= 0 {
+		errs = append(errs, errors.New("rate 必须大于 0"))
+	}
+	if cfg.Period.Duration < 0 {
+		errs = append(errs, errors.New("period 不能为负数"))
+	}
+	if cfg.Burst < 0 {
+		errs = append(errs, errors.New("burst 不能为负数"))
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	limit := RateLimit{Rate: cfg.Rate / cfg.Period.Duration.Seconds(), Burst: cfg.Burst}
+	keySpec := cfg.Key
+	if keySpec == "" {
+		keySpec = "ip"
+	}
+	// RateLimit-Policy: 容量;w=补满需要的秒数
+	policy := fmt.Sprintf("%d;w=%d", limit.Burst, int(math.Ceil(float64(limit.Burst)/limit.Rate)))
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			client := keyFunc(r)
+			if client == "" {
+				client = clientIPKey(r)
+			}
+			routeName := ""
+			if m := routeFromContext(r.Context()); m != nil {
+				routeName = m.route.Name
+			}
+
+			d := gs.rateLimiter.Take(routeName+"|"+keySpec, client, limit, time.Now())
+			h := w.Header()
+			h.Set("RateLimit-Limit", strconv.Itoa(limit.Burst))
+			h.Set("RateLimit-Remaining", strconv.Itoa(d.Remaining))
+			h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(d.Reset)))
+			h.Set("RateLimit-Policy", policy)
+			if !d.Allowed {
+				gs.logger.ForRequest(r).Warn("✗ 请求被限流",
+					log.String("route", routeName),
+					log.String("key", keySpec),
+					log.Duration("retry_after", d.RetryAfter))
+				h.Set("Retry-After", strconv.Itoa(max(ceilSeconds(d.RetryAfter), 1)))
+				requestid.Error(w, r, "Too many requests", http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}, nil
+}
+
+// ceilSeconds 把时间长度向上取整为秒数
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
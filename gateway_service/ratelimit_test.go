@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMemoryRateLimiterTake(t *testing.T) {
+	limit := RateLimit{Rate: 2, Burst: 3} // 每秒补充2个令牌，容量3
+	type take struct {
+		at          time.Duration // 相对于开始的时间
+		wantAllowed bool
+		wantRemain  int
+	}
+	tests := []struct {
+		name  string
+		takes []take
+	}{
+		{"burst then reject", []take{
+			{0, true, 2}, {0, true, 1}, {0, true, 0}, {0, false, 0},
+		}},
+		{"refill at rate", []take{
+			{0, true, 2}, {0, true, 1}, {0, true, 0}, {500 * time.Millisecond, true, 0}, {500 * time.Millisecond, false, 0},
+		}},
+		{"refill capped at burst", []take{
+			{0, true, 2}, {time.Hour, true, 2},
+		}},
+		{"clock going backwards does not refill", []take{
+			{time.Second, true, 2}, {time.Second, true, 1}, {0, true, 0}, {0, false, 0},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewMemoryRateLimiter()
+			start := time.Unix(1_700_000_000, 0)
+			for i, tk := range tt.takes {
+				d := m.Take("route|ip", "10.0.0.1", limit, start.Add(tk.at))
+				if d.Allowed != tk.wantAllowed || d.Remaining != tk.wantRemain {
+					t.Errorf("take %d = allowed %v remaining %d, want %v %d", i, d.Allowed, d.Remaining, tk.wantAllowed, tk.wantRemain)
+				}
+				if !d.Allowed && d.RetryAfter <= 0 {
+					t.Errorf("take %d rejected without Retry-After", i)
+				}
+			}
+		})
+	}
+}
+
+func TestMemoryRateLimiterRetryAfterAndReset(t *testing.T) {
+	m := NewMemoryRateLimiter()
+	now := time.Unix(1_700_000_000, 0)
+	limit := RateLimit{Rate: 4, Burst: 2}
+	m.Take("s", "k", limit, now)
+	m.Take("s", "k", limit, now)
+	d := m.Take("s", "k", limit, now)
+	if d.Allowed || d.RetryAfter != 250*time.Millisecond || d.Reset != 500*time.Millisecond {
+		t.Errorf("decision = %+v, want rejected, retry after 250ms, reset 500ms", d)
+	}
+
+	// 配置重新加载后容量变小，已有的令牌不能超过新容量
+	m.Take("s", "big", RateLimit{Rate: 1, Burst: 10}, now)
+	if d := m.Take("s", "big", RateLimit{Rate: 1, Burst: 2}, now); d.Remaining != 1 {
+		t.Errorf("remaining after burst shrink = %d, want 1", d.Remaining)
+	}
+}
+
+func TestMemoryRateLimiterOverflow(t *testing.T) {
+	limit := RateLimit{Rate: 1, Burst: 1}
+	now := time.Unix(1_700_000_000, 0)
+	m := &memoryRateLimiter{buckets: make(map[bucketKey]*tokenBucket), maxBuckets: 3, lastSweep: now}
+
+	for _, key := range []string{"a", "b", "c"} {
+		if !m.Take("route-a", key, limit, now).Allowed {
+			t.Fatalf("key %s rejected before the cap", key)
+		}
+	}
+	// 令牌桶已满，新的 key 共用 route-a 的溢出令牌桶
+	if !m.Take("route-a", "d", limit, now).Allowed {
+		t.Error("first overflow key rejected")
+	}
+	if m.Take("route-a", "e", limit, now).Allowed {
+		t.Error("second overflow key should share the exhausted overflow bucket")
+	}
+	// 其他路由的溢出令牌桶不受影响
+	if !m.Take("route-b", "f", limit, now).Allowed {
+		t.Error("overflow of route-a should not lock out route-b")
+	}
+	// 已有的 key 继续使用自己的令牌桶
+	if d := m.Take("route-a", "a", limit, now.Add(time.Second)); !d.Allowed {
+		t.Error("existing key should keep its own bucket")
+	}
+	// 清理补满的令牌桶后新的 key 重新获得自己的令牌桶
+	later := now.Add(time.Minute)
+	if !m.Take("route-a", "g", limit, later).Allowed || !m.Take("route-a", "h", limit, later).Allowed {
+		t.Error("new keys should get their own buckets after the sweep")
+	}
+}
+
+func TestClientIPKey(t *testing.T) {
+	tests := []struct {
+		remote string
+		want   string
+	}{
+		{"203.0.113.7:1234", "203.0.113.7"},
+		{"[::ffff:203.0.113.7]:1234", "203.0.113.7"},
+		{"[2001:db8:1:2:3:4:5:6]:1234", "2001:db8:1:2::/64"},
+		{"[2001:db8:1:2:ffff::1]:1234", "2001:db8:1:2::/64"},
+		{"[2001:db8:1:3::1]:1234", "2001:db8:1:3::/64"},
+		{"[fe80::1%eth0]:1234", "fe80::/64"},
+		{"pipe", "pipe"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = tt.remote
+		if got := clientIPKey(r); got != tt.want {
+			t.Errorf("clientIPKey(%s) = %q, want %q", tt.remote, got, tt.want)
+		}
+	}
+
+	// 路由中使用按 trusted_proxies 解析后的客户端IP
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	match := &routeMatch{clientIP: "2001:db8:9::1"}
+	r = r.WithContext(context.WithValue(r.Context(), routeMatchKey{}, match))
+	if got := clientIPKey(r); got != "2001:db8:9::/64" {
+		t.Errorf("clientIPKey with resolved client = %q", got)
+	}
+}
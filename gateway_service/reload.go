@@ -0,0 +1,239 @@
+package main
+
+import (
+	"crypto/sha256"
+	_ "embed"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+	"time"
+
+	"ttt/pkg/log"
+)
+
+// defaultRoutesConfig 内置的默认路由配置（配置文件不存在时使用）
+//
+//go:embed routes.json
+var defaultRoutesConfig []byte
+
+// defaultRoutesSource 使用内置配置时的来源名称
+const defaultRoutesSource = "内置默认配置"
+
+// buildRouteTable 解析并校验路由配置，创建中间件和路由处理器
+// 配置有任何错误时返回所有错误，不返回部分可用的路由表
+func (gs *GatewayService) buildRouteTable(data []byte, source string) (*RouteTable, error) {
+	cfg, err := parseRoutesConfig(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var errs []error
+
+	names := make([]string, 0, len(cfg.Middlewares))
+	for name := range cfg.Middlewares {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	middlewares := make(map[string]Middleware, len(names))
+	for _, name := range names {
+		m, err := gs.buildMiddleware(cfg.Middlewares[name])
+		if err != nil {
+			errs = append(errs, fmt.Errorf("middlewares.%s: %w", name, err))
+			continue
+		}
+		middlewares[name] = m
+	}
+
+	if len(cfg.Routes) == 0 {
+		errs = append(errs, errors.New("routes: 至少需要一条路由"))
+	}
+
+	upstream := cfg.Upstream.withDefaults()
+	if err := upstream.validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	retryBudget := cfg.RetryBudget.withDefaults()
+	if err := retryBudget.validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	cache := cfg.Cache.withDefaults()
+	if err := cache.validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	errs = append(errs, cfg.Limits.validate()...)
+
+	ipLists, listErrs := compileIPLists(cfg.IPLists)
+	errs = append(errs, listErrs...)
+	trustedProxies, proxyErrs := parseIPSet(cfg.TrustedProxies, ipLists, "trusted_proxies")
+	errs = append(errs, proxyErrs...)
+
+	auth, err := newAuthenticator(cfg.Auth)
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	table := &RouteTable{Upstream: upstream, RetryBudget: retryBudget, Cache: cache, Source: source, LoadedAt: time.Now(), auth: auth, trustedProxies: trustedProxies}
+	seen := make(map[string]int)
+	for i, rc := range cfg.Routes {
+		label := fmt.Sprintf("routes[%d]", i)
+		if rc.Name != "" {
+			label = fmt.Sprintf("routes[%d] (%s)", i, rc.Name)
+		}
+
+		route, routeErrs := compileRoute(rc)
+		if rc.Name == "" {
+			routeErrs = append(routeErrs, errors.New("缺少 name"))
+		} else if j, dup := seen[rc.Name]; dup {
+			routeErrs = append(routeErrs, fmt.Errorf("name 与 routes[%d] 重复", j))
+		} else {
+			seen[rc.Name] = i
+		}
+
+		chainMws := make([]Middleware, 0, len(rc.Middleware))
+		for _, name := range rc.Middleware {
+			m, ok := middlewares[name]
+			if !ok {
+				if _, defined := cfg.Middlewares[name]; !defined {
+					routeErrs = append(routeErrs, fmt.Errorf("引用了未定义的中间件 %q", name))
+				}
+				continue
+			}
+			chainMws = append(chainMws, m)
+		}
+		if rc.Auth != nil {
+			routeErrs = append(routeErrs, rc.Auth.validate(auth)...)
+		}
+		if rc.Access != nil {
+			var accessErrs []error
+			route.access, accessErrs = compileAccess(*rc.Access, ipLists)
+			routeErrs = append(routeErrs, accessErrs...)
+		}
+
+		for _, err := range routeErrs {
+			errs = append(errs, fmt.Errorf("%s: %w", label, err))
+		}
+		if len(routeErrs) == 0 {
+			var h http.Handler = http.HandlerFunc(gs.forward)
+			if route.compose != nil {
+				h = gs.compose(route.compose)
+			}
+			// 故障注入在所有中间件之后执行，模拟上游的故障
+			route.handler = chain(gs.injectFaults(route, h), chainMws)
+			// 认证在所有中间件之前执行，中间件可以使用验证后的身份请求头（如按用户限流）
+			if rc.Auth != nil {
+				route.handler = gs.authenticate(auth, *rc.Auth, route.handler)
+			}
+			// 请求限制在认证之前检查，超过限制的请求不需要验证令牌
+			route.limits = cfg.Limits.merge(rc.Limits)
+			if route.limits.enabled() {
+				route.handler = gs.enforceLimits(route, route.handler)
+			}
+			if route.cors != nil {
+				route.handler = gs.cors(route.cors, route.handler)
+			}
+			// IP访问控制最先检查，不允许的客户端连跨域预检也不响应
+			if route.access != nil {
+				route.handler = gs.checkAccess(route, route.handler)
+			}
+			table.Routes = append(table.Routes, route)
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return table, nil
+}
+
+// LoadRoutes 启动时加载路由配置文件，文件不存在时使用内置默认配置
+func (gs *GatewayService) LoadRoutes(path string) error {
+	gs.routesPath = path
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		table, err := gs.buildRouteTable(defaultRoutesConfig, defaultRoutesSource)
+		if err != nil {
+			return fmt.Errorf("内置路由配置无效: %w", err)
+		}
+		gs.routes.Store(table)
+		gs.logger.Warn("路由配置文件不存在，使用内置默认配置", log.String("file", path))
+		return nil
+	}
+	return gs.ReloadRoutes("启动")
+}
+
+// ReloadRoutes 重新加载路由配置文件，配置无效时继续使用当前的路由表
+// 新路由表整体替换旧路由表，正在处理的请求继续使用旧路由表，不会中断连接
+func (gs *GatewayService) ReloadRoutes(reason string) error {
+	gs.routesMu.Lock()
+	defer gs.routesMu.Unlock()
+
+	data, err := os.ReadFile(gs.routesPath)
+	if err != nil {
+		gs.logger.Error("读取路由配置失败，继续使用当前配置", log.String("file", gs.routesPath), log.Err(err))
+		return err
+	}
+
+	table, err := gs.buildRouteTable(data, gs.routesPath)
+	if err != nil {
+		gs.logger.Error("路由配置无效，继续使用当前配置",
+			log.String("file", gs.routesPath),
+			log.String("reason", reason),
+			log.Err(err))
+		return err
+	}
+
+	gs.routes.Store(table)
+	gs.routesSum = sha256.Sum256(data)
+	gs.logger.Info("✓ 路由配置已加载",
+		log.String("file", gs.routesPath),
+		log.String("reason", reason),
+		log.Int("routes", len(table.Routes)))
+	return nil
+}
+
+// WatchRoutes 监视路由配置文件：文件内容变化或收到 SIGHUP 信号时重新加载
+// 使用定期检查文件的方式，兼容编辑器"写临时文件再重命名"的保存方式
+func (gs *GatewayService) WatchRoutes(interval time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var lastMod time.Time
+	var lastSize int64
+	if info, err := os.Stat(gs.routesPath); err == nil {
+		lastMod, lastSize = info.ModTime(), info.Size()
+	}
+
+	for {
+		select {
+		case <-hup:
+			gs.ReloadRoutes("SIGHUP")
+		case <-ticker.C:
+			info, err := os.Stat(gs.routesPath)
+			if err != nil || (info.ModTime().Equal(lastMod) && info.Size() == lastSize) {
+				continue
+			}
+			lastMod, lastSize = info.ModTime(), info.Size()
+
+			// 只修改了时间而内容未变时不重新加载
+			data, err := os.ReadFile(gs.routesPath)
+			if err != nil {
+				continue
+			}
+			gs.routesMu.Lock()
+			sum := gs.routesSum
+			gs.routesMu.Unlock()
+			if sha256.Sum256(data) != sum {
+				gs.ReloadRoutes("文件变化")
+			}
+		}
+	}
+}
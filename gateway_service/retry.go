@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// IdempotencyKeyHeader 客户端声明请求可以安全重试时携带的请求头
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// RetryConfig 路由的重试配置
+type RetryConfig struct {
+	// Attempts 失败后最多重试的次数（0 表示不重试）
+	Attempts int `json:"attempts"`
+	// StatusCodes 需要重试的上游响应状态码（未配置时为 502、503、504），连接失败总是重试
+	StatusCodes []int `json:"status_codes,omitempty"`
+	// BaseInterval 第一次重试前的最长等待时间，之后每次翻倍（实际等待时间在 0 到该值之间随机）
+	BaseInterval Duration `json:"base_interval,omitempty"`
+	// MaxInterval 重试等待时间的上限
+	MaxInterval Duration `json:"max_interval,omitempty"`
+	// MaxBodyBytes 为重试缓存的请求体大小上限，超过时请求只发送一次
+	MaxBodyBytes int64 `json:"max_body_bytes,omitempty"`
+}
+
+// withDefaults 未配置的字段使用默认值
+func (c RetryConfig) withDefaults() RetryConfig {
+	if c.StatusCodes == nil {
+		c.StatusCodes = []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout}
+	}
+	if c.BaseInterval.Duration == 0 {
+		c.BaseInterval.Duration = 25 * time.Millisecond
+	}
+	if c.MaxInterval.Duration == 0 {
+		c.MaxInterval.Duration = 250 * time.Millisecond
+	}
+	if c.MaxBodyBytes == 0 {
+		c.MaxBodyBytes = 64 << 10
+	}
+	return c
+}
+
+// validate 校验配置（在 withDefaults 之后调用）
+func (c RetryConfig) validate() []error {
+	var errs []error
+	if c.Attempts < 0 || c.Attempts > 10 {
+		errs = append(errs, errors.New("retries.attempts 必须在 0 到 10 之间"))
+	}
+	for _, code := range c.StatusCodes {
+		if code < 100 || code > 599 {
+			errs = append(errs, errors.New("retries.status_codes 中的状态码必须在 100 到 599 之间"))
+			break
+		}
+	}
+	if c.BaseInterval.Duration < 0 || c.MaxInterval.Duration < 0 {
+		errs = append(errs, errors.New("retries 中的时间不能为负数"))
+	} else if c.BaseInterval.Duration > c.MaxInterval.Duration {
+		errs = append(errs, errors.New("retries.base_interval 不能大于 max_interval"))
+	}
+	if c.MaxBodyBytes < 0 {
+		errs = append(errs, errors.New("retries.max_body_bytes 不能为负数"))
+	}
+	return errs
+}
+
+// retryStatus 判断上游响应状态码是否需要重试
+func (c *RetryConfig) retryStatus(code int) bool {
+	for _, s := range c.StatusCodes {
+		if s == code {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff 返回第 retry 次重试（从 1 开始）前的等待时间：指数退避加完全随机抖动
+func (c *RetryConfig) backoff(retry int) time.Duration {
+	max := c.BaseInterval.Duration
+	for i := 1; i < retry && max < c.MaxInterval.Duration; i++ {
+		max *= 2
+	}
+	if max > c.MaxInterval.Duration {
+		max = c.MaxInterval.Duration
+	}
+	if max <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(max) + 1))
+}
+
+// sleepContext 等待一段时间，context 结束时提前返回 false
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// idempotent 判断请求是否可以安全重试：幂等方法，或者客户端携带了 Idempotency-Key
+func idempotent(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return r.Header.Get(IdempotencyKeyHeader) != ""
+}
+
+// requestBody 转发使用的请求体
+// 请求体不超过大小限制时缓存在内存中，每次尝试都可以重新发送；否则只能发送一次
+type requestBody struct {
+	buf        []byte
+	stream     io.Reader // 超过限制时：已读取的部分 + 剩余部分
+	replayable bool
+}
+
+// bufferRequestBody 读取请求体用于重试，最多读取 limit 字节
+func bufferRequestBody(r *http.Request, limit int64) (*requestBody, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return &requestBody{replayable: true}, nil
+	}
+	if r.ContentLength > limit {
+		return &requestBody{stream: r.Body}, nil
+	}
+	buf, err := io.ReadAll(io.LimitReader(r.Body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(buf)) > limit {
+		return &requestBody{stream: io.MultiReader(bytes.NewReader(buf), r.Body)}, nil
+	}
+	return &requestBody{buf: buf, replayable: true}, nil
+}
+
+// reader 返回一次尝试使用的请求体
+func (b *requestBody) reader() io.Reader {
+	switch {
+	case !b.replayable:
+		return b.stream
+	case b.buf == nil:
+		return nil
+	default:
+		return bytes.NewReader(b.buf)
+	}
+}
+
+// RetryBudgetConfig 全局重试预算：限制重试请求占总请求的比例，防止上游故障时重试放大流量
+type RetryBudgetConfig struct {
+	Ratio               float64  `json:"ratio"`                  // 统计窗口内重试次数最多占请求数的比例
+	MinRetriesPerSecond int      `json:"min_retries_per_second"` // 请求量很小时每秒至少允许的重试次数
+	Window              Duration `json:"window"`                 // 统计窗口
+}
+
+// withDefaults 未配置的字段使用默认值
+func (c RetryBudgetConfig) withDefaults() RetryBudgetConfig {
+	if c.Ratio == 0 {
+		c.Ratio = 0.2
+	}
+	if c.MinRetriesPerSecond == 0 {
+		c.MinRetriesPerSecond = 3
+	}
+	if c.Window.Duration == 0 {
+		c.Window.Duration = 10 * time.Second
+	}
+	return c
+}
+
+// validate 校验配置（在 withDefaults 之后调用）
+func (c RetryBudgetConfig) validate() error {
+	var errs []error
+	if c.Ratio < 0 || c.Ratio > 1 {
+		errs = append(errs, errors.New("retry_budget.ratio 必须在 0 到 1 之间"))
+	}
+	if c.MinRetriesPerSecond < 0 {
+		errs = append(errs, errors.New("retry_budget.min_retries_per_second 不能为负数"))
+	}
+	if c.Window.Duration < time.Second {
+		errs = append(errs, errors.New("retry_budget.window 不能小于 1s"))
+	}
+	return errors.Join(errs...)
+}
+
+// RetryBudget 所有路由共用的重试预算，配置重新加载时保留统计
+type RetryBudget struct {
+	mu       sync.Mutex
+	requests rollingWindow
+	retries  rollingWindow
+}
+
+// allowed 返回统计窗口内允许的重试次数（调用方需持有锁）
+func (b *RetryBudget) allowed(cfg *RetryBudgetConfig, now time.Time) (requests, retries, allowed int) {
+	requests, _ = b.requests.counts(now, cfg.Window.Duration)
+	retries, _ = b.retries.counts(now, cfg.Window.Duration)
+	allowed = int(cfg.Ratio*float64(requests)) + cfg.MinRetriesPerSecond*int(cfg.Window.Duration/time.Second)
+	return requests, retries, allowed
+}
+
+// Request 记录一个新请求
+func (b *RetryBudget) Request(cfg *RetryBudgetConfig, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.requests.add(now, cfg.Window.Duration, false)
+}
+
+// Withdraw 申请一次重试，预算用完时返回 false
+func (b *RetryBudget) Withdraw(cfg *RetryBudgetConfig, now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_, retries, allowed := b.allowed(cfg, now)
+	if retries >= allowed {
+		return false
+	}
+	b.retries.add(now, cfg.Window.Duration, false)
+	return true
+}
+
+// RetryBudgetSnapshot 重试预算状态（用于 /health）
+type RetryBudgetSnapshot struct {
+	Requests int `json:"requests"` // 统计窗口内的请求数
+	Retries  int `json:"retries"`  // 统计窗口内的重试次数
+	Allowed  int `json:"allowed"`  // 统计窗口内允许的重试次数
+}
+
+// Snapshot 返回重试预算当前状态
+func (b *RetryBudget) Snapshot(cfg *RetryBudgetConfig, now time.Time) RetryBudgetSnapshot {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	requests, retries, allowed := b.allowed(cfg, now)
+	return RetryBudgetSnapshot{Requests: requests, Retries: retries, Allowed: allowed}
+}
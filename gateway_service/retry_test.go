@@ -0,0 +1,184 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRetryBackoff(t *testing.T) {
+	cfg := RetryConfig{BaseInterval: Duration{10 * time.Millisecond}, MaxInterval: Duration{70 * time.Millisecond}}.withDefaults()
+	tests := []struct {
+		retry int
+		max   time.Duration
+	}{
+		{1, 10 * time.Millisecond},
+		{2, 20 * time.Millisecond},
+		{3, 40 * time.Millisecond},
+		{4, 70 * time.Millisecond},
+		{10, 70 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		var longest time.Duration
+		for i := 0; i < 200; i++ {
+			d := cfg.backoff(tt.retry)
+			if d < 0 || d > tt.max {
+				t.Fatalf("backoff(%d) = %v, want within [0, %v]", tt.retry, d, tt.max)
+			}
+			if d > longest {
+				longest = d
+			}
+		}
+		// 抖动覆盖整个区间，200次中最长的等待时间应接近上限
+		if longest < tt.max/2 {
+			t.Errorf("backoff(%d) longest of 200 = %v, want close to %v", tt.retry, longest, tt.max)
+		}
+	}
+
+	if d := (&RetryConfig{}).backoff(3); d != 0 {
+		t.Errorf("backoff without interval = %v, want 0", d)
+	}
+}
+
+func TestRetryConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     RetryConfig
+		wantErr bool
+	}{
+		{"defaults", RetryConfig{Attempts: 2}, false},
+		{"too many attempts", RetryConfig{Attempts: 11}, true},
+		{"negative attempts", RetryConfig{Attempts: -1}, true},
+		{"bad status code", RetryConfig{StatusCodes: []int{503, 600}}, true},
+		{"base above max", RetryConfig{BaseInterval: Duration{time.Second}}, true},
+		{"negative interval", RetryConfig{MaxInterval: Duration{-time.Second}}, true},
+		{"negative body limit", RetryConfig{MaxBodyBytes: -1}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := tt.cfg.withDefaults().validate()
+			if (len(errs) > 0) != tt.wantErr {
+				t.Errorf("validate() = %v, want error %v", errs, tt.wantErr)
+			}
+		})
+	}
+
+	cfg := RetryConfig{}.withDefaults()
+	for code, want := range map[int]bool{502: true, 503: true, 504: true, 500: false, 429: false} {
+		if got := cfg.retryStatus(code); got != want {
+			t.Errorf("retryStatus(%d) = %v, want %v", code, got, want)
+		}
+	}
+}
+
+func TestRetryBudget(t *testing.T) {
+	cfg := RetryBudgetConfig{Ratio: 0.5, MinRetriesPerSecond: 1, Window: Duration{2 * time.Second}}.withDefaults()
+	start := time.Unix(1_700_000_000, 0)
+
+	tests := []struct {
+		name        string
+		requests    int
+		at          time.Duration // 申请重试的时间
+		withdraws   int
+		wantAllowed int // 成功申请的次数
+	}{
+		// 每秒至少 1 次，窗口 2s
+		{"minimum without requests", 0, 0, 5, 2},
+		{"ratio of requests", 10, 0, 10, 7},
+		{"ratio rounds down", 3, 0, 10, 3},
+		{"requests outside window", 10, 3 * time.Second, 10, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b RetryBudget
+			for i := 0; i < tt.requests; i++ {
+				b.Request(&cfg, start)
+			}
+			allowed := 0
+			for i := 0; i < tt.withdraws; i++ {
+				if b.Withdraw(&cfg, start.Add(tt.at)) {
+					allowed++
+				}
+			}
+			if allowed != tt.wantAllowed {
+				t.Errorf("withdrawn %d retries, want %d", allowed, tt.wantAllowed)
+			}
+			s := b.Snapshot(&cfg, start.Add(tt.at))
+			if s.Retries != tt.wantAllowed || s.Allowed != tt.wantAllowed {
+				t.Errorf("snapshot = %+v, want %d retries of %d allowed", s, tt.wantAllowed, tt.wantAllowed)
+			}
+		})
+	}
+}
+
+func TestIdempotent(t *testing.T) {
+	tests := []struct {
+		method string
+		key    string
+		want   bool
+	}{
+		{http.MethodGet, "", true},
+		{http.MethodPut, "", true},
+		{http.MethodDelete, "", true},
+		{http.MethodPost, "", false},
+		{http.MethodPatch, "", false},
+		{http.MethodPost, "order-1", true},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, "/", nil)
+		if tt.key != "" {
+			r.Header.Set(IdempotencyKeyHeader, tt.key)
+		}
+		if got := idempotent(r); got != tt.want {
+			t.Errorf("idempotent(%s, key %q) = %v, want %v", tt.method, tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestBufferRequestBody(t *testing.T) {
+	tests := []struct {
+		name           string
+		body           string
+		contentLength  int64 // -1 表示未知长度
+		wantReplayable bool
+	}{
+		{"empty", "", 0, true},
+		{"within limit", "hello", 5, true},
+		{"at limit", "12345678", 8, true},
+		{"declared over limit", "123456789", 9, false},
+		{"chunked over limit", "123456789", -1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			if tt.body == "" {
+				r.Body = http.NoBody
+			}
+			r.ContentLength = tt.contentLength
+			b, err := bufferRequestBody(r, 8)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if b.replayable != tt.wantReplayable {
+				t.Errorf("replayable = %v, want %v", b.replayable, tt.wantReplayable)
+			}
+			// 可以重放的请求体每次都完整发送，否则只能发送一次
+			attempts := 1
+			if b.replayable {
+				attempts = 2
+			}
+			for i := 0; i < attempts; i++ {
+				var got []byte
+				if body := b.reader(); body != nil {
+					got, _ = io.ReadAll(body)
+				}
+				if string(got) != tt.body {
+					t.Errorf("attempt %d body = %q, want %q", i, got, tt.body)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,422 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// defaultRouteTimeout 路由未配置超时时转发请求的超时时间
+const defaultRouteTimeout = 10 * time.Second
+
+// Duration 配置文件中的时间长度，使用字符串表示，如 "500ms"、"10s"
+type Duration struct {
+	time.Duration
+}
+
+// UnmarshalJSON 解析 "10s" 格式的时间长度
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("时间长度必须是字符串，如 \"10s\"")
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("无效的时间长度 %q", s)
+	}
+	d.Duration = v
+	return nil
+}
+
+// MarshalJSON 输出 "10s" 格式的时间长度
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.Duration.String())
+}
+
+// RoutesConfig 路由配置文件
+type RoutesConfig struct {
+	// Middlewares 命名的中间件定义，路由通过名称引用
+	Middlewares map[string]MiddlewareConfig `json:"middlewares"`
+	// Routes 路由列表，按顺序匹配，使用第一个匹配的路由
+	Routes []RouteConfig `json:"routes"`
+	// Upstream 上游实例的熔断和异常摘除配置（所有服务共用）
+	Upstream UpstreamConfig `json:"upstream"`
+	// RetryBudget 全局重试预算（所有路由共用）
+	RetryBudget RetryBudgetConfig `json:"retry_budget"`
+	// Auth JWT认证配置（路由通过 auth 字段启用）
+	Auth AuthConfig `json:"auth"`
+	// Cache 响应缓存的大小限制（路由通过 cache 中间件启用）
+	Cache CacheConfig `json:"cache"`
+	// Limits 所有路由默认的请求大小和类型限制（路由的 limits 可以覆盖）
+	Limits LimitsConfig `json:"limits"`
+	// TrustedProxies 可信代理的网段或IP，只有来自这些地址的 X-Forwarded-For 才用于确定客户端IP
+	TrustedProxies []string `json:"trusted_proxies"`
+	// IPLists 命名的IP列表，trusted_proxies 和路由的 access 中通过 @名称 引用
+	IPLists map[string][]string `json:"ip_lists"`
+}
+
+// MiddlewareConfig 中间件定义
+type MiddlewareConfig struct {
+	Type   string          `json:"type"`
+	Config json.RawMessage `json:"config"`
+}
+
+// RouteConfig 单条路由配置
+//
+// 路径匹配方式三选一：path（精确匹配）、path_prefix（按路径段匹配前缀）、path_regex（正则）。
+// 使用 path_regex 时，service 和 rewrite 中可以用 ${name} 引用命名分组。
+type RouteConfig struct {
+	Name       string   `json:"name"`
+	Host       string   `json:"host,omitempty"`    // 匹配的Host，支持 *.example.com
+	Methods    []string `json:"methods,omitempty"` // 为空表示所有方法
+	Path       string   `json:"path,omitempty"`
+	PathPrefix string   `json:"path_prefix,omitempty"`
+	PathRegex  string   `json:"path_regex,omitempty"`
+
+	Service       string `json:"service,omitempty"`        // 目标服务名（注册中心中的名称）
+	StripPrefix   bool   `json:"strip_prefix,omitempty"`   // 去掉匹配的前缀后转发
+	PrefixRewrite string `json:"prefix_rewrite,omitempty"` // 把匹配的前缀替换为该值后转发
+	Rewrite       string `json:"rewrite,omitempty"`        // path_regex 路由的目标路径模板
+
+	Timeout    Duration         `json:"timeout,omitempty"`
+	Retries    RetryConfig      `json:"retries,omitempty"`
+	Stream     StreamConfig     `json:"stream,omitempty"`  // WebSocket 和 SSE 长连接的配置
+	Compose    *ComposeConfig   `json:"compose,omitempty"` // 组合路由：调用多个服务并合并结果，不能与 service 同时设置
+	Split      *SplitConfig     `json:"split,omitempty"`   // 按服务版本分配流量（灰度发布）
+	Fault      *FaultConfig     `json:"fault,omitempty"`   // 故障注入，用于测试上游故障时的表现
+	Auth       *RouteAuthConfig `json:"auth,omitempty"`    // 为空表示不需要认证
+	CORS       *CORSConfig      `json:"cors,omitempty"`    // 为空表示不处理跨域请求
+	Limits     *LimitsConfig    `json:"limits,omitempty"`  // 请求大小和类型限制，覆盖顶层 limits 中的同名字段
+	Access     *AccessConfig    `json:"access,omitempty"`  // 按客户端IP允许或拒绝访问
+	Middleware []string         `json:"middleware,omitempty"`
+}
+
+// Route 编译后的路由
+type Route struct {
+	RouteConfig
+
+	prefix  string // path 或 path_prefix
+	exact   bool
+	regex   *regexp.Regexp
+	methods map[string]bool
+	cors    *corsPolicy
+	compose *composePlan
+	split   *splitPolicy
+	limits  LimitsConfig // 合并了顶层默认值的请求限制
+	access  *accessPolicy
+	handler http.Handler // IP访问控制 + 跨域 + 请求限制 + 认证 + 中间件 + 转发（或组合调用）
+}
+
+// RouteTable 一次加载得到的完整路由表，加载后不再修改，可以安全地并发读取
+type RouteTable struct {
+	Routes      []*Route
+	Upstream    UpstreamConfig    // 已填充默认值
+	RetryBudget RetryBudgetConfig // 已填充默认值
+	Cache       CacheConfig       // 已填充默认值
+	Source      string
+	LoadedAt    time.Time
+
+	auth           *Authenticator
+	trustedProxies ipSet
+}
+
+// routeMatch 请求匹配到的路由及正则分组
+type routeMatch struct {
+	route    *Route
+	params   map[string]string
+	clientIP string // 真实的客户端IP，见 RouteTable.resolveClientIP
+
+	// authenticated 网关已验证调用方的身份（JWT 或 API Key），响应可能因调用方而不同
+	authenticated bool
+}
+
+type routeMatchKey struct{}
+
+// routeFromContext 获取请求匹配到的路由
+func routeFromContext(ctx context.Context) *routeMatch {
+	m, _ := ctx.Value(routeMatchKey{}).(*routeMatch)
+	return m
+}
+
+// Match 按顺序查找第一个匹配请求的路由
+// 跨域预检请求按它要发送的实际方法匹配，由目标路由的跨域配置响应
+func (t *RouteTable) Match(r *http.Request) *routeMatch {
+	host := requestHost(r)
+	method := r.Method
+	if isPreflight(r) {
+		method = strings.ToUpper(r.Header.Get("Access-Control-Request-Method"))
+	}
+	for _, route := range t.Routes {
+		if params, ok := route.match(r, method, host); ok {
+			return &routeMatch{route: route, params: params}
+		}
+	}
+	return nil
+}
+
+// requestHost 返回请求的Host（小写，不含端口）
+func requestHost(r *http.Request) string {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(host)
+}
+
+func (rt *Route) match(r *http.Request, method, host string) (map[string]string, bool) {
+	if rt.methods != nil && !rt.methods[method] {
+		return nil, false
+	}
+	if rt.Host != "" && !matchHost(rt.Host, host) {
+		return nil, false
+	}
+
+	path := r.URL.Path
+	if rt.regex != nil {
+		m := rt.regex.FindStringSubmatch(path)
+		if m == nil {
+			return nil, false
+		}
+		params := make(map[string]string)
+		for i, name := range rt.regex.SubexpNames() {
+			if name != "" {
+				params[name] = m[i]
+			}
+		}
+		return params, true
+	}
+	if rt.exact {
+		return nil, path == rt.prefix
+	}
+	return nil, matchPrefix(rt.prefix, path)
+}
+
+// matchHost 匹配Host，pattern 以 "*." 开头时匹配任意子域名
+func matchHost(pattern, host string) bool {
+	if strings.HasPrefix(pattern, "*.") {
+		return strings.HasSuffix(host, pattern[1:])
+	}
+	return host == pattern
+}
+
+// matchPrefix 按路径段匹配前缀："/api/user" 匹配 "/api/user" 和 "/api/user/1"，不匹配 "/api/users"
+func matchPrefix(prefix, path string) bool {
+	if !strings.HasPrefix(path, prefix) {
+		return false
+	}
+	return len(path) == len(prefix) || strings.HasSuffix(prefix, "/") || path[len(prefix)] == '/'
+}
+
+// templateParam 模板中的 ${name} 引用
+var templateParam = regexp.MustCompile(`\$\{(\w+)\}`)
+
+// expand 替换模板中的 ${name} 为正则分组的值
+func (rt *Route) expand(template string, params map[string]string) string {
+	if rt.regex == nil || !strings.Contains(template, "$") {
+		return template
+	}
+	return templateParam.ReplaceAllStringFunc(template, func(s string) string {
+		return params[s[2:len(s)-1]]
+	})
+}
+
+// ServiceName 返回请求要转发到的服务名
+func (m *routeMatch) ServiceName() string {
+	return m.route.expand(m.route.Service, m.params)
+}
+
+// TargetPath 返回转发到上游的路径
+func (m *routeMatch) TargetPath(path string) string {
+	rt := m.route
+	switch {
+	case rt.regex != nil && rt.Rewrite != "":
+		path = rt.expand(rt.Rewrite, m.params)
+	case rt.StripPrefix:
+		path = strings.TrimPrefix(path, rt.prefix)
+	case rt.PrefixRewrite != "":
+		path = rt.PrefixRewrite + strings.TrimPrefix(path, rt.prefix)
+	}
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return path
+}
+
+// timeout 返回路由的转发超时时间
+func (rt *Route) timeout() time.Duration {
+	if rt.Timeout.Duration > 0 {
+		return rt.Timeout.Duration
+	}
+	return defaultRouteTimeout
+}
+
+// validMethods 允许在路由中配置的HTTP方法
+var validMethods = map[string]bool{
+	http.MethodGet: true, http.MethodHead: true, http.MethodPost: true, http.MethodPut: true,
+	http.MethodPatch: true, http.MethodDelete: true, http.MethodOptions: true,
+	http.MethodConnect: true, http.MethodTrace: true,
+}
+
+// compileRoute 校验并编译一条路由，返回的错误不包含路由名称前缀
+func compileRoute(cfg RouteConfig) (*Route, []error) {
+	var errs []error
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	rt := &Route{RouteConfig: cfg}
+
+	matchers := 0
+	for _, v := range []string{cfg.Path, cfg.PathPrefix, cfg.PathRegex} {
+		if v != "" {
+			matchers++
+		}
+	}
+	if matchers != 1 {
+		fail("必须且只能设置 path、path_prefix、path_regex 中的一个")
+	}
+
+	switch {
+	case cfg.Path != "":
+		rt.prefix, rt.exact = cfg.Path, true
+	case cfg.PathPrefix != "":
+		rt.prefix = cfg.PathPrefix
+	case cfg.PathRegex != "":
+		re, err := regexp.Compile(cfg.PathRegex)
+		if err != nil {
+			fail("path_regex 无效: %v", err)
+		} else {
+			rt.regex = re
+		}
+	}
+	if rt.prefix != "" && !strings.HasPrefix(rt.prefix, "/") {
+		fail("路径必须以 / 开头: %q", rt.prefix)
+	}
+
+	if cfg.Host != "" {
+		rt.Host = strings.ToLower(cfg.Host)
+		if strings.Contains(rt.Host, "*") && !strings.HasPrefix(rt.Host, "*.") || strings.Count(rt.Host, "*") > 1 {
+			fail("host 通配符只支持 *.example.com 形式: %q", cfg.Host)
+		}
+	}
+
+	if len(cfg.Methods) > 0 {
+		rt.methods = make(map[string]bool)
+		for _, m := range cfg.Methods {
+			m = strings.ToUpper(m)
+			if !validMethods[m] {
+				fail("未知的HTTP方法 %q", m)
+			}
+			rt.methods[m] = true
+		}
+	}
+
+	switch {
+	case cfg.Compose != nil:
+		if cfg.Service != "" {
+			fail("compose 路由不能设置 service")
+		}
+		if cfg.StripPrefix || cfg.PrefixRewrite != "" || cfg.Rewrite != "" || cfg.Retries.Attempts > 0 {
+			fail("compose 路由不支持 strip_prefix、prefix_rewrite、rewrite 和 retries")
+		}
+		var composeErrs []error
+		rt.compose, composeErrs = compileCompose(*cfg.Compose, rt.regex)
+		errs = append(errs, composeErrs...)
+	case cfg.Service == "":
+		fail("缺少 service（或 compose）")
+	}
+	if cfg.Split != nil {
+		if cfg.Compose != nil || strings.Contains(cfg.Service, "$") {
+			fail("split 只能用于 service 固定的路由")
+		}
+		var splitErrs []error
+		rt.split, splitErrs = compileSplit(*cfg.Split)
+		errs = append(errs, splitErrs...)
+	}
+	if cfg.StripPrefix && cfg.PrefixRewrite != "" {
+		fail("strip_prefix 和 prefix_rewrite 不能同时设置")
+	}
+	if (cfg.StripPrefix || cfg.PrefixRewrite != "") && rt.prefix == "" {
+		fail("strip_prefix/prefix_rewrite 只能用于 path 或 path_prefix 路由")
+	}
+	if cfg.Rewrite != "" && cfg.PathRegex == "" {
+		fail("rewrite 只能用于 path_regex 路由，前缀路由请使用 prefix_rewrite")
+	}
+
+	// 模板中引用的分组必须存在
+	for field, template := range map[string]string{"service": cfg.Service, "rewrite": cfg.Rewrite} {
+		for _, m := range templateParam.FindAllStringSubmatch(template, -1) {
+			if rt.regex == nil {
+				fail("%s 中的 %s 只能用于 path_regex 路由", field, m[0])
+			} else if rt.regex.SubexpIndex(m[1]) < 0 {
+				fail("%s 引用了 path_regex 中不存在的分组 %q", field, m[1])
+			}
+		}
+	}
+
+	if cfg.Timeout.Duration < 0 {
+		fail("timeout 不能为负数")
+	}
+	if cfg.CORS != nil {
+		var corsErrs []error
+		rt.cors, corsErrs = compileCORS(*cfg.CORS, cfg.Methods)
+		errs = append(errs, corsErrs...)
+	}
+
+	rt.Retries = cfg.Retries.withDefaults()
+	errs = append(errs, rt.Retries.validate()...)
+	rt.Stream = cfg.Stream.withDefaults()
+	errs = append(errs, rt.Stream.validate()...)
+	if cfg.Fault != nil {
+		errs = append(errs, cfg.Fault.validate()...)
+	}
+	if cfg.Limits != nil {
+		errs = append(errs, cfg.Limits.validate()...)
+	}
+
+	return rt, errs
+}
+
+// parseRoutesConfig 严格解析配置文件（未知字段视为错误，便于发现拼写错误）
+func parseRoutesConfig(data []byte) (*RoutesConfig, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+
+	var cfg RoutesConfig
+	if err := dec.Decode(&cfg); err != nil {
+		return nil, describeJSONError(data, err)
+	}
+	if dec.More() {
+		return nil, errors.New("配置文件中只能包含一个JSON对象")
+	}
+	return &cfg, nil
+}
+
+// describeJSONError 为JSON语法/类型错误加上行号和列号
+func describeJSONError(data []byte, err error) error {
+	var offset int64
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		offset = syntaxErr.Offset
+	case errors.As(err, &typeErr):
+		offset = typeErr.Offset
+	default:
+		return err
+	}
+
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	line := 1 + bytes.Count(data[:offset], []byte("\n"))
+	col := int(offset) - bytes.LastIndexByte(data[:offset], '\n')
+	return fmt.Errorf("第 %d 行第 %d 列: %w", line, col, err)
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// routeStatsWindow 路由最近请求数和错误数的统计窗口
+const routeStatsWindow = time.Minute
+
+// defaultDisableDuration 通过管理接口停用路由的默认时长
+const defaultDisableDuration = 10 * time.Minute
+
+// routeCounters 一条路由的请求统计（重新加载配置时保留）
+type routeCounters struct {
+	inflight atomic.Int64
+
+	mu           sync.Mutex
+	requests     int64
+	clientErrors int64 // 4xx
+	serverErrors int64 // 5xx 和没有返回响应的请求
+	totalLatency time.Duration
+	lastRequest  time.Time
+	recent       rollingWindow // 最近1分钟的请求数和 5xx 数
+}
+
+// record 记录一次请求的结果，status 为 0 表示没有返回响应（连接被中止）
+func (c *routeCounters) record(status int, latency time.Duration, now time.Time) {
+	failed := status == 0 || status >= 500
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.requests++
+	switch {
+	case failed:
+		c.serverErrors++
+	case status >= 400:
+		c.clientErrors++
+	}
+	c.totalLatency += latency
+	c.lastRequest = now
+	c.recent.add(now, routeStatsWindow, failed)
+}
+
+// routeStats 所有路由的请求统计
+type routeStats struct {
+	mu       sync.Mutex
+	counters map[string]*routeCounters // 路由名 -> 统计
+}
+
+func (s *routeStats) get(route string) *routeCounters {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.counters == nil {
+		s.counters = make(map[string]*routeCounters)
+	}
+	c := s.counters[route]
+	if c == nil {
+		c = &routeCounters{}
+		s.counters[route] = c
+	}
+	return c
+}
+
+// RouteDisable 通过管理接口停用的路由，到期后自动恢复
+type RouteDisable struct {
+	Reason string    `json:"reason,omitempty"`
+	Until  time.Time `json:"until"`
+}
+
+// routeDisabled 返回路由的停用信息，没有停用或已到期时返回 nil
+func (gs *GatewayService) routeDisabled(route string, now time.Time) *RouteDisable {
+	gs.disableMu.RLock()
+	defer gs.disableMu.RUnlock()
+	if d := gs.disabledRoutes[route]; d != nil && now.Before(d.Until) {
+		return d
+	}
+	return nil
+}
+
+// setRouteDisabled 停用（d 不为 nil）或恢复路由，重新加载配置后仍然生效
+func (gs *GatewayService) setRouteDisabled(route string, d *RouteDisable) {
+	gs.disableMu.Lock()
+	defer gs.disableMu.Unlock()
+	if d == nil {
+		delete(gs.disabledRoutes, route)
+		return
+	}
+	if gs.disabledRoutes == nil {
+		gs.disabledRoutes = make(map[string]*RouteDisable)
+	}
+	gs.disabledRoutes[route] = d
+}
+
+// RouteStatus 路由的配置摘要和请求统计（用于管理接口）
+type RouteStatus struct {
+	Name        string        `json:"name"`
+	Host        string        `json:"host,omitempty"`
+	Methods     []string      `json:"methods,omitempty"`
+	Path        string        `json:"path"` // path、path_prefix（以 * 结尾）或 path_regex
+	Service     string        `json:"service,omitempty"`
+	Compose     bool          `json:"compose,omitempty"`
+	Middleware  []string      `json:"middleware,omitempty"`
+	Disabled    *RouteDisable `json:"disabled,omitempty"`
+	InFlight    int64         `json:"in_flight"`
+	Requests    int64         `json:"requests"`
+	ClientErrs  int64         `json:"client_errors"`
+	ServerErrs  int64         `json:"server_errors"`
+	AvgMs       float64       `json:"avg_ms"`
+	LastRequest *time.Time    `json:"last_request,omitempty"`
+	// 最近1分钟
+	RecentRequests int     `json:"recent_requests"`
+	RecentErrors   int     `json:"recent_errors"`
+	RecentErrRate  float64 `json:"recent_error_rate"`
+}
+
+// routeStatuses 返回当前路由表中所有路由的状态（按配置顺序），name 不为空时只返回该路由
+func (gs *GatewayService) routeStatuses(name string) []RouteStatus {
+	table := gs.routes.Load()
+	if table == nil {
+		return nil
+	}
+	now := time.Now()
+	statuses := []RouteStatus{}
+	for _, route := range table.Routes {
+		if name != "" && route.Name != name {
+			continue
+		}
+		st := RouteStatus{
+			Name:       route.Name,
+			Host:       route.Host,
+			Methods:    route.Methods,
+			Service:    route.Service,
+			Compose:    route.compose != nil,
+			Middleware: route.Middleware,
+			Disabled:   gs.routeDisabled(route.Name, now),
+		}
+		switch {
+		case route.Path != "":
+			st.Path = route.Path
+		case route.PathPrefix != "":
+			st.Path = route.PathPrefix + "*"
+		default:
+			st.Path = route.PathRegex
+		}
+
+		c := gs.routeStats.get(route.Name)
+		st.InFlight = c.inflight.Load()
+		c.mu.Lock()
+		st.Requests, st.ClientErrs, st.ServerErrs = c.requests, c.clientErrors, c.serverErrors
+		if c.requests > 0 {
+			st.AvgMs = float64(c.totalLatency) / float64(c.requests) / float64(time.Millisecond)
+			last := c.lastRequest
+			st.LastRequest = &last
+		}
+		st.RecentRequests, st.RecentErrors = c.recent.counts(now, routeStatsWindow)
+		c.mu.Unlock()
+		if st.RecentRequests > 0 {
+			st.RecentErrRate = float64(st.RecentErrors) / float64(st.RecentRequests)
+		}
+		statuses = append(statuses, st)
+	}
+	return statuses
+}
+
+// UpstreamServiceStatus 一个服务的所有实例（用于管理接口）
+type UpstreamServiceStatus struct {
+	Service   string           `json:"service"`
+	Instances []UpstreamStatus `json:"instances"`
+}
+
+// upstreamList 返回按服务名排序的实例状态
+func (gs *GatewayService) upstreamList() []UpstreamServiceStatus {
+	statuses := gs.upstreamStatuses()
+	list := make([]UpstreamServiceStatus, 0, len(statuses))
+	for name, instances := range statuses {
+		list = append(list, UpstreamServiceStatus{Service: name, Instances: instances})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Service < list[j].Service })
+	return list
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	mathrand "math/rand"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultCanaryHeader 强制选择版本的请求头
+const defaultCanaryHeader = "X-Canary"
+
+// versionStatsWindow 版本错误率的统计窗口
+const versionStatsWindow = time.Minute
+
+// SplitConfig 路由按服务版本分配流量（版本由实例注册时上报）
+//
+// 分配顺序：
+//  1. 请求头 X-Canary: true 使用 canary 版本，false 排除 canary 版本，值为版本名时使用该版本
+//  2. 配置了 sticky 时，相同的请求头/Cookie 值总是分配到同一版本（权重变化时部分客户端会重新分配）
+//  3. 否则按权重随机分配
+type SplitConfig struct {
+	Weights        map[string]int `json:"weights"`                   // 版本 -> 权重，如 {"v1": 95, "v2": 5}
+	Canary         string         `json:"canary,omitempty"`          // 金丝雀版本，必须在 weights 中
+	Sticky         string         `json:"sticky,omitempty"`          // header:名称 或 cookie:名称
+	OverrideHeader string         `json:"override_header,omitempty"` // 默认 X-Canary
+}
+
+// splitPolicy 编译后的流量分配配置
+type splitPolicy struct {
+	SplitConfig
+	stickyHeader string
+	stickyCookie string
+}
+
+// compileSplit 校验流量分配配置
+func compileSplit(cfg SplitConfig) (*splitPolicy, []error) {
+	var errs []error
+	p := &splitPolicy{SplitConfig: cfg}
+	if err := validateWeights(cfg.Weights); err != nil {
+		errs = append(errs, fmt.Errorf("split.weights: %w", err))
+	}
+	if cfg.Canary != "" {
+		if _, ok := cfg.Weights[cfg.Canary]; !ok {
+			errs = append(errs, fmt.Errorf("split.canary 版本 %q 不在 weights 中", cfg.Canary))
+		}
+	}
+	switch kind, name, _ := strings.Cut(cfg.Sticky, ":"); {
+	case cfg.Sticky == "":
+	case kind == "header" && name != "":
+		p.stickyHeader = http.CanonicalHeaderKey(name)
+	case kind == "cookie" && name != "":
+		p.stickyCookie = name
+	default:
+		errs = append(errs, fmt.Errorf("split.sticky 无效: %q（可用: header:名称, cookie:名称）", cfg.Sticky))
+	}
+	if p.OverrideHeader == "" {
+		p.OverrideHeader = defaultCanaryHeader
+	}
+	p.OverrideHeader = http.CanonicalHeaderKey(p.OverrideHeader)
+	return p, errs
+}
+
+// validateWeights 校验版本权重：不能为负数，总和必须大于 0
+func validateWeights(weights map[string]int) error {
+	if len(weights) == 0 {
+		return errors.New("至少需要一个版本")
+	}
+	total := 0
+	for version, w := range weights {
+		if version == "" {
+			return errors.New("版本名不能为空")
+		}
+		if w < 0 {
+			return fmt.Errorf("版本 %q 的权重不能为负数", version)
+		}
+		total += w
+	}
+	if total == 0 {
+		return errors.New("权重总和必须大于 0")
+	}
+	return nil
+}
+
+// versionWeight 一个版本及其权重
+type versionWeight struct {
+	version string
+	weight  int
+}
+
+// sortedWeights 按版本名排序的权重，保证相同的 sticky 值总是落在同一版本
+func sortedWeights(weights map[string]int, exclude string) ([]versionWeight, int) {
+	list := make([]versionWeight, 0, len(weights))
+	total := 0
+	for version, w := range weights {
+		if version != exclude && w > 0 {
+			list = append(list, versionWeight{version, w})
+			total += w
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].version < list[j].version })
+	return list, total
+}
+
+// splitWeights 返回路由当前生效的权重：管理接口修改过时使用修改后的权重
+func (gs *GatewayService) splitWeights(route *Route) (weights map[string]int, overridden bool) {
+	gs.splitMu.RLock()
+	defer gs.splitMu.RUnlock()
+	if w, ok := gs.splitOverrides[route.Name]; ok {
+		return w, true
+	}
+	return route.split.Weights, false
+}
+
+// setSplitWeights 在运行时修改路由的权重，weights 为 nil 时恢复配置文件中的权重
+// 修改后的权重在重新加载配置后保留，直到网关重启或恢复
+func (gs *GatewayService) setSplitWeights(route string, weights map[string]int) {
+	gs.splitMu.Lock()
+	defer gs.splitMu.Unlock()
+	if weights == nil {
+		delete(gs.splitOverrides, route)
+		return
+	}
+	if gs.splitOverrides == nil {
+		gs.splitOverrides = make(map[string]map[string]int)
+	}
+	gs.splitOverrides[route] = weights
+}
+
+// chooseVersion 为请求选择版本
+// sticky 为 cookie 且请求中没有该 Cookie 时生成一个随机值并通过 Set-Cookie 返回给客户端
+func (gs *GatewayService) chooseVersion(w http.ResponseWriter, r *http.Request, route *Route) string {
+	p := route.split
+	weights, _ := gs.splitWeights(route)
+
+	exclude := ""
+	if override := r.Header.Get(p.OverrideHeader); override != "" {
+		switch strings.ToLower(override) {
+		case "true", "1":
+			if p.Canary != "" {
+				return p.Canary
+			}
+		case "false", "0":
+			exclude = p.Canary
+		default:
+			if _, ok := weights[override]; ok {
+				return override
+			}
+		}
+	}
+
+	list, total := sortedWeights(weights, exclude)
+	if total == 0 {
+		list, total = sortedWeights(weights, "")
+	}
+
+	var key string
+	switch {
+	case p.stickyHeader != "":
+		key = r.Header.Get(p.stickyHeader)
+	case p.stickyCookie != "":
+		if c, err := r.Cookie(p.stickyCookie); err == nil && c.Value != "" {
+			key = c.Value
+		} else {
+			key = newStickyID()
+			http.SetCookie(w, &http.Cookie{
+				Name:     p.stickyCookie,
+				Value:    key,
+				Path:     "/",
+				MaxAge:   int((30 * 24 * time.Hour).Seconds()),
+				HttpOnly: true,
+				SameSite: http.SameSiteLaxMode,
+			})
+		}
+	}
+
+	var n int
+	if key != "" {
+		h := fnv.New64a()
+		h.Write([]byte(route.Name + "\x00" + key))
+		n = int(h.Sum64() % uint64(total))
+	} else {
+		n = mathrand.Intn(total)
+	}
+	for _, vw := range list {
+		if n < vw.weight {
+			return vw.version
+		}
+		n -= vw.weight
+	}
+	return list[len(list)-1].version
+}
+
+// newStickyID 生成 sticky Cookie 的随机值
+func newStickyID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// versionStats 每个服务版本最近一段时间的请求数和错误数（连接失败和5xx），
+// 以及分配到该版本、但因为没有可用实例而转发到其他版本的请求数
+type versionStats struct {
+	mu        sync.Mutex
+	windows   map[string]*rollingWindow // 服务名|版本 -> 统计
+	fallbacks map[string]*rollingWindow // 服务名|分配的版本 -> 改用其他版本的请求数
+}
+
+// fallback 记录一次分配到 version 但转发到其他版本的请求，返回统计窗口内是否是第一次（用于记录日志）
+func (s *versionStats) fallback(service, version string, now time.Time) (first bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fallbacks == nil {
+		s.fallbacks = make(map[string]*rollingWindow)
+	}
+	key := service + "|" + version
+	w := s.fallbacks[key]
+	if w == nil {
+		w = &rollingWindow{}
+		s.fallbacks[key] = w
+	}
+	total, _ := w.counts(now, versionStatsWindow)
+	w.add(now, versionStatsWindow, false)
+	return total == 0
+}
+
+func (s *versionStats) fallbackCount(service, version string, now time.Time) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if w := s.fallbacks[service+"|"+version]; w != nil {
+		total, _ := w.counts(now, versionStatsWindow)
+		return total
+	}
+	return 0
+}
+
+func (s *versionStats) record(service, version string, failed bool, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.windows == nil {
+		s.windows = make(map[string]*rollingWindow)
+	}
+	key := service + "|" + version
+	w := s.windows[key]
+	if w == nil {
+		w = &rollingWindow{}
+		s.windows[key] = w
+	}
+	w.add(now, versionStatsWindow, failed)
+}
+
+func (s *versionStats) counts(service, version string, now time.Time) (total, failures int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if w := s.windows[service+"|"+version]; w != nil {
+		return w.counts(now, versionStatsWindow)
+	}
+	return 0, 0
+}
+
+// SplitVersionStatus 一个版本的权重和最近1分钟的统计
+type SplitVersionStatus struct {
+	Version   string  `json:"version"`
+	Weight    int     `json:"weight"`
+	Percent   float64 `json:"percent"`
+	Instances int     `json:"instances"`
+	Requests  int     `json:"requests"`
+	Errors    int     `json:"errors"`
+	ErrorRate float64 `json:"error_rate"`
+	Fallbacks int     `json:"fallbacks"` // 分配到该版本但转发到其他版本的请求数（该版本没有可用实例）
+}
+
+// SplitStatus 路由的流量分配状态
+type SplitStatus struct {
+	Route      string               `json:"route"`
+	Service    string               `json:"service"`
+	Canary     string               `json:"canary,omitempty"`
+	Sticky     string               `json:"sticky,omitempty"`
+	Overridden bool                 `json:"overridden"` // 权重是否通过管理接口修改过
+	Versions   []SplitVersionStatus `json:"versions"`
+}
+
+// splitStatus 返回路由的流量分配状态（包括实例上报了但没有配置权重的版本）
+func (gs *GatewayService) splitStatus(route *Route) SplitStatus {
+	weights, overridden := gs.splitWeights(route)
+	service := route.Service
+	st := SplitStatus{Route: route.Name, Service: service, Canary: route.split.Canary, Sticky: route.split.Sticky, Overridden: overridden}
+
+	instances := make(map[string]int)
+	gs.mu.RLock()
+	for _, u := range gs.upstreams[service] {
+		instances[u.Version]++
+	}
+	gs.mu.RUnlock()
+
+	versions := make(map[string]bool)
+	total := 0
+	for v, w := range weights {
+		versions[v] = true
+		total += w
+	}
+	for v := range instances {
+		versions[v] = true
+	}
+	names := make([]string, 0, len(versions))
+	for v := range versions {
+		names = append(names, v)
+	}
+	sort.Strings(names)
+
+	now := time.Now()
+	for _, v := range names {
+		vs := SplitVersionStatus{Version: v, Weight: weights[v], Instances: instances[v]}
+		if total > 0 {
+			vs.Percent = float64(vs.Weight) * 100 / float64(total)
+		}
+		vs.Requests, vs.Errors = gs.versionStats.counts(service, v, now)
+		vs.Fallbacks = gs.versionStats.fallbackCount(service, v, now)
+		if vs.Requests > 0 {
+			vs.ErrorRate = float64(vs.Errors) / float64(vs.Requests)
+		}
+		st.Versions = append(st.Versions, vs)
+	}
+	return st
+}
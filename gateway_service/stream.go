@@ -0,0 +1,336 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"ttt/pkg/log"
+	"ttt/pkg/requestid"
+)
+
+// StreamConfig 路由的长连接配置，对 WebSocket 连接和 SSE（text/event-stream）响应生效
+// 长连接不受路由 timeout 的限制（timeout 只限制握手和等待响应头的时间）
+type StreamConfig struct {
+	// IdleTimeout 连接上没有任何数据的最长时间，超过后网关断开连接，默认 5m
+	IdleTimeout Duration `json:"idle_timeout,omitempty"`
+	// MaxConnections 路由同时保持的长连接数上限，0 表示不限制
+	MaxConnections int `json:"max_connections,omitempty"`
+}
+
+// withDefaults 未配置的字段使用默认值
+func (c StreamConfig) withDefaults() StreamConfig {
+	if c.IdleTimeout.Duration == 0 {
+		c.IdleTimeout.Duration = 5 * time.Minute
+	}
+	return c
+}
+
+// validate 校验配置（在 withDefaults 之后调用）
+func (c StreamConfig) validate() []error {
+	var errs []error
+	if c.IdleTimeout.Duration < 0 {
+		errs = append(errs, errors.New("stream.idle_timeout 不能为负数"))
+	}
+	if c.MaxConnections < 0 {
+		errs = append(errs, errors.New("stream.max_connections 不能为负数"))
+	}
+	return errs
+}
+
+// errStreamIdle 长连接空闲超时
+var errStreamIdle = errors.New("长连接空闲超时")
+
+// streamCounter 每条路由当前保持的长连接数（按路由名统计，重新加载配置时保留）
+type streamCounter struct {
+	mu     sync.Mutex
+	active map[string]int
+}
+
+// acquire 占用一个连接名额，limit 为 0 表示不限制
+func (c *streamCounter) acquire(route string, limit int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if limit > 0 && c.active[route] >= limit {
+		return false
+	}
+	if c.active == nil {
+		c.active = make(map[string]int)
+	}
+	c.active[route]++
+	return true
+}
+
+// release 释放连接名额
+func (c *streamCounter) release(route string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.active[route]--; c.active[route] <= 0 {
+		delete(c.active, route)
+	}
+}
+
+// Snapshot 返回各路由当前的长连接数
+func (c *streamCounter) Snapshot() map[string]int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := make(map[string]int, len(c.active))
+	for route, n := range c.active {
+		out[route] = n
+	}
+	return out
+}
+
+// idleReader 每次读到数据时重置空闲计时器
+type idleReader struct {
+	r       io.Reader
+	timer   *time.Timer
+	timeout time.Duration
+}
+
+func (ir *idleReader) Read(p []byte) (int, error) {
+	n, err := ir.r.Read(p)
+	if n > 0 {
+		ir.timer.Reset(ir.timeout)
+	}
+	return n, err
+}
+
+// headerHasToken 判断请求头中是否包含指定的标记（不区分大小写，多个值用逗号分隔）
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, value := range h.Values(name) {
+		for _, t := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isWebSocketUpgrade 判断是否为 WebSocket 握手请求
+func isWebSocketUpgrade(r *http.Request) bool {
+	return headerHasToken(r.Header, "Connection", "upgrade") && strings.EqualFold(r.Header.Get("Upgrade"), "websocket")
+}
+
+// isEventStream 根据响应头判断是否为 SSE 响应
+func isEventStream(h http.Header) bool {
+	mediaType, _, _ := strings.Cut(h.Get("Content-Type"), ";")
+	return strings.EqualFold(strings.TrimSpace(mediaType), "text/event-stream")
+}
+
+// rejectStream 长连接数达到上限时返回 503
+func (gs *GatewayService) rejectStream(w http.ResponseWriter, r *http.Request, route *Route) {
+	gs.logger.ForRequest(r).Warn("✗ 长连接数已达上限",
+		log.String("route", route.Name),
+		log.Int("max_connections", route.Stream.MaxConnections))
+	w.Header().Set("Retry-After", "1")
+	requestid.Error(w, r, "Too many open connections for this route", http.StatusServiceUnavailable)
+}
+
+// streamResponse 转发 SSE 响应：每次收到数据立即发送给客户端，不受路由整体超时限制，
+// 超过 idle_timeout 没有新数据时断开（客户端的 EventSource 会自动重连）
+// deadline 为路由整体超时的计时器，cancel 用于在空闲超时时取消上游请求
+func (gs *GatewayService) streamResponse(ctx context.Context, w http.ResponseWriter, r *http.Request, resp *http.Response, deadline *time.Timer, cancel context.CancelCauseFunc) {
+	defer resp.Body.Close()
+	route := routeFromContext(r.Context()).route
+
+	if !deadline.Stop() {
+		// 响应头到达时已经超时，上游请求已被取消
+		requestid.Error(w, r, "Gateway timeout", http.StatusGatewayTimeout)
+		return
+	}
+	if !gs.streams.acquire(route.Name, route.Stream.MaxConnections) {
+		gs.rejectStream(w, r, route)
+		return
+	}
+	defer gs.streams.release(route.Name)
+
+	idleTimeout := route.Stream.IdleTimeout.Duration
+	idle := time.AfterFunc(idleTimeout, func() { cancel(errStreamIdle) })
+	defer idle.Stop()
+
+	start := time.Now()
+	gs.logger.ForRequest(r).Info("SSE 流已建立", log.String("route", route.Name))
+
+	announced := writeResponseHeader(w, resp)
+	rc := http.NewResponseController(w)
+	rc.Flush()
+	written, err := copyBody(w, &idleReader{r: resp.Body, timer: idle, timeout: idleTimeout}, rc, true)
+	fields := []log.Field{
+		log.String("route", route.Name),
+		log.Int64("bytes", written),
+		log.Duration("duration", time.Since(start)),
+	}
+	switch {
+	case err == nil:
+		copyTrailers(w, resp, announced)
+		gs.logger.ForRequest(r).Info("SSE 流已结束", fields...)
+	case errors.Is(context.Cause(ctx), errStreamIdle):
+		gs.logger.ForRequest(r).Info("SSE 流空闲超时，断开连接", append(fields, log.Duration("idle_timeout", idleTimeout))...)
+	case r.Context().Err() != nil:
+		gs.logger.ForRequest(r).Info("客户端已断开 SSE 流", fields...)
+	default:
+		gs.logger.ForRequest(r).Warn("✗ SSE 流中断", append(fields, log.Err(err))...)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// proxyWebSocket 转发 WebSocket 连接：把握手请求发给上游，上游同意升级后接管客户端连接，
+// 在两个连接之间双向复制数据，直到任意一方关闭或超过 idle_timeout 没有数据
+// WebSocket 连接不重试，握手受路由 timeout 限制
+func (gs *GatewayService) proxyWebSocket(w http.ResponseWriter, r *http.Request) {
+	match := routeFromContext(r.Context())
+	route := match.route
+	serviceName := match.ServiceName()
+
+	if !gs.streams.acquire(route.Name, route.Stream.MaxConnections) {
+		gs.rejectStream(w, r, route)
+		return
+	}
+	defer gs.streams.release(route.Name)
+
+	version := ""
+	if route.split != nil {
+		version = gs.chooseVersion(w, r, route)
+	}
+	upstream, generation, retryAfter, err := gs.pickUpstream(serviceName, version, nil)
+	if err != nil {
+		gs.writeUnavailable(w, r, route, serviceName, retryAfter, err)
+		return
+	}
+
+	targetURL := upstream.URL + match.TargetPath(r.URL.Path)
+	gs.logger.ForRequest(r).Info("→ 转发WebSocket握手",
+		log.String("route", route.Name),
+		log.String("service", serviceName),
+		log.String("instance", upstream.ID),
+		log.String("version", upstream.Version),
+		log.String("target", targetURL))
+
+	// 握手完成前受路由超时限制；连接建立后只受空闲超时限制
+	ctx, cancel := context.WithCancelCause(r.Context())
+	defer cancel(nil)
+	handshake := time.AfterFunc(route.timeout(), func() { cancel(context.DeadlineExceeded) })
+
+	start := time.Now()
+	resp, err := gs.sendRequest(ctx, r, targetURL, nil)
+	handshake.Stop()
+	recordUpstream(ctx, upstream, time.Since(start))
+	if err != nil {
+		if r.Context().Err() != nil {
+			upstream.breaker.Cancel(generation)
+			return
+		}
+		if ctx.Err() != nil {
+			err = context.Cause(ctx)
+		}
+		gs.recordResult(upstream, generation, 0, time.Since(start), err)
+		gs.logger.ForRequest(r).Error("✗ WebSocket握手失败",
+			log.String("target", targetURL),
+			log.String("instance", upstream.ID),
+			log.Err(err))
+		requestid.Error(w, r, "Bad gateway", http.StatusBadGateway)
+		return
+	}
+	gs.recordResult(upstream, generation, resp.StatusCode, time.Since(start), nil)
+
+	// 上游拒绝升级时按普通响应返回
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		gs.copyResponse(w, r, resp)
+		return
+	}
+	backend, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok {
+		resp.Body.Close()
+		requestid.Error(w, r, "Bad gateway", http.StatusBadGateway)
+		return
+	}
+	defer backend.Close()
+
+	conn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		gs.logger.ForRequest(r).Error("✗ 无法接管客户端连接", log.Err(err))
+		requestid.Error(w, r, "WebSocket is not supported on this connection", http.StatusInternalServerError)
+		return
+	}
+	defer conn.Close()
+
+	// 写回 101 响应：网关设置的响应头（如 X-Request-ID）加上上游的握手响应头
+	upgrade := resp.Header.Get("Upgrade")
+	removeHopHeaders(resp.Header)
+	resp.Header.Del(requestid.Header)
+	header := w.Header().Clone()
+	copyHeader(header, resp.Header)
+	header.Set("Connection", "Upgrade")
+	header.Set("Upgrade", upgrade)
+	brw.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+	header.Write(brw)
+	brw.WriteString("\r\n")
+	if err := brw.Flush(); err != nil {
+		gs.logger.ForRequest(r).Info("客户端已断开WebSocket连接", log.String("route", route.Name))
+		return
+	}
+	gs.logger.ForRequest(r).Info("WebSocket 连接已建立", log.String("route", route.Name), log.String("instance", upstream.ID))
+
+	// 双向复制，任意方向结束后关闭两个连接
+	idleTimeout := route.Stream.IdleTimeout.Duration
+	var idled bool
+	var idleMu sync.Mutex
+	idle := time.AfterFunc(idleTimeout, func() {
+		idleMu.Lock()
+		idled = true
+		idleMu.Unlock()
+		conn.Close()
+		backend.Close()
+	})
+	defer idle.Stop()
+
+	type result struct {
+		bytes int64
+		err   error
+	}
+	toUpstream := make(chan result, 1)
+	toClient := make(chan result, 1)
+	go func() {
+		// brw.Reader 中可能已经缓存了客户端在握手后立即发送的数据
+		n, err := io.Copy(backend, &idleReader{r: brw.Reader, timer: idle, timeout: idleTimeout})
+		toUpstream <- result{n, err}
+	}()
+	go func() {
+		n, err := io.Copy(conn, &idleReader{r: backend, timer: idle, timeout: idleTimeout})
+		toClient <- result{n, err}
+	}()
+
+	var up, down result
+	var closedBy string
+	select {
+	case up = <-toUpstream:
+		closedBy = "client"
+	case down = <-toClient:
+		closedBy = "upstream"
+	}
+	conn.Close()
+	backend.Close()
+	if closedBy == "client" {
+		down = <-toClient
+	} else {
+		up = <-toUpstream
+	}
+
+	idleMu.Lock()
+	if idled {
+		closedBy = "idle_timeout"
+	}
+	idleMu.Unlock()
+	gs.logger.ForRequest(r).Info("WebSocket 连接已关闭",
+		log.String("route", route.Name),
+		log.String("closed_by", closedBy),
+		log.Int64("bytes_in", up.bytes),
+		log.Int64("bytes_out", down.bytes),
+		log.Duration("duration", time.Since(start)))
+}
@@ -0,0 +1,437 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"ttt/pkg/log"
+	"ttt/pkg/requestid"
+)
+
+func init() {
+	registerMiddleware("transform", newTransformMiddleware)
+}
+
+// transformConfig transform 中间件配置：改写请求和响应的头、查询参数和 JSON 请求体/响应体
+//
+// 请求在转发前改写，响应在返回给客户端前改写。只改写 Content-Type 为 JSON 的请求体和响应体；
+// 响应体超过 max_body_bytes 或不是有效的 JSON 时原样返回，请求体超过上限时返回 413。
+type transformConfig struct {
+	Request      transformSide `json:"request"`
+	Response     transformSide `json:"response"`
+	MaxBodyBytes int64         `json:"max_body_bytes"` // 改写请求体/响应体时缓存的上限，默认 1MB
+}
+
+// transformSide 请求或响应的改写规则（响应不支持 query）
+type transformSide struct {
+	Headers *fieldTransform `json:"headers"`
+	Query   *fieldTransform `json:"query"`
+	Body    *bodyTransform  `json:"body"`
+}
+
+// fieldTransform 请求头/响应头或查询参数的改写，按 remove、rename、set、add 的顺序执行
+type fieldTransform struct {
+	Remove []string          `json:"remove"`
+	Rename map[string]string `json:"rename"` // 旧名称 -> 新名称，值保持不变
+	Set    map[string]string `json:"set"`    // 替换已有的值
+	Add    map[string]string `json:"add"`    // 保留已有的值，再追加一个
+}
+
+// bodyTransform JSON 的改写，按 drop、rename、inject、wrap 的顺序执行
+//
+// 字段路径用 . 分隔（如 user.address.city），路径中遇到数组时对每个元素生效，
+// 因此同样的规则可以用于单个对象和对象列表。
+type bodyTransform struct {
+	Drop   []string                   `json:"drop"`   // 删除字段
+	Rename map[string]string          `json:"rename"` // 字段路径 -> 新字段名（在同一层级）
+	Inject map[string]json.RawMessage `json:"inject"` // 字段路径 -> 值，已存在时替换，缺少的中间对象自动创建
+	Wrap   *envelopeConfig            `json:"wrap"`   // 把整个 JSON 放进信封
+}
+
+// envelopeConfig 信封：{"<field>": 原始内容, 以及 extra 中的字段}
+type envelopeConfig struct {
+	Field string                     `json:"field"`
+	Extra map[string]json.RawMessage `json:"extra"`
+}
+
+func newTransformMiddleware(gs *GatewayService, config json.RawMessage) (Middleware, error) {
+	var cfg transformConfig
+	if err := decodeMiddlewareConfig(config, &cfg); err != nil {
+		return nil, err
+	}
+	if cfg.MaxBodyBytes == 0 {
+		cfg.MaxBodyBytes = 1 << 20
+	}
+	var errs []error
+	if cfg.MaxBodyBytes < 0 {
+		errs = append(errs, errors.New("max_body_bytes 不能为负数"))
+	}
+	if cfg.Response.Query != nil {
+		errs = append(errs, errors.New("response 不支持 query"))
+	}
+	for _, side := range []struct {
+		name string
+		transformSide
+	}{{"request", cfg.Request}, {"response", cfg.Response}} {
+		errs = append(errs, side.Headers.validate(side.name+".headers")...)
+		errs = append(errs, side.Query.validate(side.name+".query")...)
+		errs = append(errs, side.Body.validate(side.name+".body")...)
+	}
+	if cfg.Request == (transformSide{}) && cfg.Response == (transformSide{}) {
+		errs = append(errs, errors.New("request 和 response 至少需要配置一个"))
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if cfg.Request.Headers != nil {
+				cfg.Request.Headers.applyHeader(r.Header)
+			}
+			if cfg.Request.Query != nil {
+				query := r.URL.Query()
+				cfg.Request.Query.applyValues(query)
+				r.URL.RawQuery = query.Encode()
+			}
+			if cfg.Request.Body != nil && isJSONContentType(r.Header.Get("Content-Type")) {
+				status, msg, err := transformRequestBody(r, cfg.Request.Body, cfg.MaxBodyBytes)
+				if err != nil {
+					writeBodyError(w, r, err)
+					return
+				}
+				if status != 0 {
+					gs.logger.ForRequest(r).Warn("✗ 改写请求体失败", log.String("reason", msg))
+					requestid.JSONError(w, r, msg, status)
+					return
+				}
+			}
+
+			if cfg.Response.Headers == nil && cfg.Response.Body == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			tw := &transformWriter{ResponseWriter: w, headers: cfg.Response.Headers, limit: cfg.MaxBodyBytes}
+			if cfg.Response.Body != nil && r.Method != http.MethodHead && !isWebSocketUpgrade(r) {
+				tw.body = cfg.Response.Body
+				// 要求上游返回未压缩的响应，才能改写响应体
+				r.Header.Del("Accept-Encoding")
+			}
+			next.ServeHTTP(tw, r)
+			if err := tw.finish(); err != nil {
+				gs.logger.ForRequest(r).Warn("响应体不是有效的JSON，原样返回", log.Err(err))
+			}
+		})
+	}, nil
+}
+
+// validate 校验字段改写规则
+func (t *fieldTransform) validate(label string) []error {
+	if t == nil {
+		return nil
+	}
+	var errs []error
+	for from, to := range t.Rename {
+		if from == "" || to == "" {
+			errs = append(errs, fmt.Errorf("%s.rename 的名称不能为空", label))
+		}
+	}
+	for _, name := range t.Remove {
+		if name == "" {
+			errs = append(errs, fmt.Errorf("%s.remove 的名称不能为空", label))
+		}
+	}
+	return errs
+}
+
+// applyHeader 改写请求头或响应头
+func (t *fieldTransform) applyHeader(h http.Header) {
+	for _, name := range t.Remove {
+		h.Del(name)
+	}
+	for from, to := range t.Rename {
+		if values := h.Values(from); len(values) > 0 {
+			values = append([]string(nil), values...)
+			h.Del(from)
+			h.Del(to)
+			for _, v := range values {
+				h.Add(to, v)
+			}
+		}
+	}
+	for name, value := range t.Set {
+		h.Set(name, value)
+	}
+	for name, value := range t.Add {
+		h.Add(name, value)
+	}
+}
+
+// applyValues 改写查询参数（名称区分大小写）
+func (t *fieldTransform) applyValues(v url.Values) {
+	for _, name := range t.Remove {
+		v.Del(name)
+	}
+	for from, to := range t.Rename {
+		if values, ok := v[from]; ok {
+			delete(v, from)
+			v[to] = values
+		}
+	}
+	for name, value := range t.Set {
+		v.Set(name, value)
+	}
+	for name, value := range t.Add {
+		v.Add(name, value)
+	}
+}
+
+// validate 校验 JSON 改写规则
+func (t *bodyTransform) validate(label string) []error {
+	if t == nil {
+		return nil
+	}
+	var errs []error
+	validPath := func(path string) bool {
+		for _, seg := range strings.Split(path, ".") {
+			if seg == "" {
+				return false
+			}
+		}
+		return true
+	}
+	for _, path := range t.Drop {
+		if !validPath(path) {
+			errs = append(errs, fmt.Errorf("%s.drop 的字段路径无效: %q", label, path))
+		}
+	}
+	for from, to := range t.Rename {
+		if !validPath(from) || to == "" || strings.Contains(to, ".") {
+			errs = append(errs, fmt.Errorf("%s.rename 无效: %q -> %q（值为同一层级的新字段名）", label, from, to))
+		}
+	}
+	for path, value := range t.Inject {
+		if !validPath(path) || !json.Valid(value) {
+			errs = append(errs, fmt.Errorf("%s.inject 无效: %q", label, path))
+		}
+	}
+	if t.Wrap != nil {
+		if t.Wrap.Field == "" {
+			errs = append(errs, fmt.Errorf("%s.wrap 缺少 field", label))
+		}
+		for key, value := range t.Wrap.Extra {
+			if !json.Valid(value) {
+				errs = append(errs, fmt.Errorf("%s.wrap.extra 无效: %q", label, key))
+			}
+		}
+	}
+	return errs
+}
+
+// apply 改写 JSON 文本
+func (t *bodyTransform) apply(data []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber() // 保留数字的原始精度
+	var doc interface{}
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, errors.New("JSON 之后还有多余的内容")
+	}
+
+	for _, path := range t.Drop {
+		walkJSONPath(doc, strings.Split(path, "."), false, func(obj map[string]interface{}, key string) {
+			delete(obj, key)
+		})
+	}
+	for from, to := range t.Rename {
+		walkJSONPath(doc, strings.Split(from, "."), false, func(obj map[string]interface{}, key string) {
+			if value, ok := obj[key]; ok {
+				delete(obj, key)
+				obj[to] = value
+			}
+		})
+	}
+	for path, value := range t.Inject {
+		value := value
+		walkJSONPath(doc, strings.Split(path, "."), true, func(obj map[string]interface{}, key string) {
+			obj[key] = value
+		})
+	}
+	if t.Wrap != nil {
+		envelope := make(map[string]interface{}, len(t.Wrap.Extra)+1)
+		for key, value := range t.Wrap.Extra {
+			envelope[key] = value
+		}
+		envelope[t.Wrap.Field] = doc
+		doc = envelope
+	}
+	var out bytes.Buffer
+	enc := json.NewEncoder(&out)
+	enc.SetEscapeHTML(false) // 保持 &、<、> 原样
+	if err := enc.Encode(doc); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(out.Bytes(), []byte("\n")), nil
+}
+
+// walkJSONPath 找到 path 指向的字段所在的对象，调用 fn(对象, 字段名)
+// 路径中遇到数组时对每个元素继续查找；create 为 true 时创建不存在的中间对象
+func walkJSONPath(node interface{}, path []string, create bool, fn func(obj map[string]interface{}, key string)) {
+	switch v := node.(type) {
+	case []interface{}:
+		for _, elem := range v {
+			walkJSONPath(elem, path, create, fn)
+		}
+	case map[string]interface{}:
+		if len(path) == 1 {
+			fn(v, path[0])
+			return
+		}
+		child, ok := v[path[0]]
+		if !ok || child == nil {
+			if !create {
+				return
+			}
+			child = map[string]interface{}{}
+			v[path[0]] = child
+		}
+		walkJSONPath(child, path[1:], create, fn)
+	}
+}
+
+// transformRequestBody 改写 JSON 请求体，请求体无法改写时返回状态码和错误信息，读取失败时返回错误
+func transformRequestBody(r *http.Request, t *bodyTransform, limit int64) (int, string, error) {
+	body, err := bufferRequestBody(r, limit)
+	if err != nil {
+		return 0, "", err
+	}
+	if !body.replayable {
+		return http.StatusRequestEntityTooLarge, "Request body too large to transform (limit " + strconv.FormatInt(limit, 10) + " bytes)", nil
+	}
+	if len(body.buf) == 0 {
+		return 0, "", nil
+	}
+	out, err := t.apply(body.buf)
+	if err != nil {
+		return http.StatusBadRequest, "Invalid JSON request body", nil
+	}
+	r.Body = io.NopCloser(bytes.NewReader(out))
+	r.ContentLength = int64(len(out))
+	return 0, "", nil
+}
+
+// isJSONContentType 判断 Content-Type 是否为 JSON（application/json 或 application/*+json）
+func isJSONContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || (strings.HasPrefix(mediaType, "application/") && strings.HasSuffix(mediaType, "+json"))
+}
+
+// transformWriter 写出响应头前改写响应头；需要改写响应体时缓存 JSON 响应，处理完成后由 finish 写出
+type transformWriter struct {
+	http.ResponseWriter
+	headers *fieldTransform
+	body    *bodyTransform
+	limit   int64
+
+	wroteHeader bool
+	status      int
+	buf         *bytes.Buffer // 不为 nil 表示正在缓存响应体
+}
+
+func (tw *transformWriter) WriteHeader(code int) {
+	if tw.wroteHeader || code < 200 {
+		tw.ResponseWriter.WriteHeader(code)
+		return
+	}
+	tw.wroteHeader = true
+	h := tw.Header()
+	if tw.headers != nil {
+		tw.headers.applyHeader(h)
+	}
+	if tw.body != nil && code != http.StatusNoContent && code != http.StatusNotModified &&
+		isJSONContentType(h.Get("Content-Type")) && h.Get("Content-Encoding") == "" {
+		tw.status = code
+		tw.buf = &bytes.Buffer{}
+		return
+	}
+	tw.ResponseWriter.WriteHeader(code)
+}
+
+func (tw *transformWriter) Write(b []byte) (int, error) {
+	if !tw.wroteHeader {
+		tw.WriteHeader(http.StatusOK)
+	}
+	if tw.buf == nil {
+		return tw.ResponseWriter.Write(b)
+	}
+	if int64(tw.buf.Len()+len(b)) > tw.limit {
+		// 超过上限，不再改写，把已缓存的部分和剩余部分原样写出
+		if err := tw.spill(); err != nil {
+			return 0, err
+		}
+		return tw.ResponseWriter.Write(b)
+	}
+	return tw.buf.Write(b)
+}
+
+// spill 放弃改写，原样写出已缓存的响应
+func (tw *transformWriter) spill() error {
+	buf := tw.buf
+	tw.buf = nil
+	tw.ResponseWriter.WriteHeader(tw.status)
+	_, err := tw.ResponseWriter.Write(buf.Bytes())
+	return err
+}
+
+// finish 改写并写出缓存的响应体；不是有效的 JSON 时原样写出并返回错误
+func (tw *transformWriter) finish() error {
+	if tw.buf == nil {
+		return nil
+	}
+	out, err := tw.body.apply(tw.buf.Bytes())
+	if err != nil {
+		if spillErr := tw.spill(); spillErr != nil {
+			return spillErr
+		}
+		return err
+	}
+	tw.buf = nil
+	tw.Header().Set("Content-Length", strconv.Itoa(len(out)))
+	tw.ResponseWriter.WriteHeader(tw.status)
+	_, err = tw.ResponseWriter.Write(out)
+	return err
+}
+
+// Unwrap 供 http.ResponseController 访问底层的 ResponseWriter
+func (tw *transformWriter) Unwrap() http.ResponseWriter {
+	return tw.ResponseWriter
+}
+
+// Flush 支持流式响应（缓存响应体时不刷新）
+func (tw *transformWriter) Flush() {
+	if tw.buf != nil {
+		return
+	}
+	if !tw.wroteHeader {
+		tw.WriteHeader(http.StatusOK)
+		if tw.buf != nil {
+			return
+		}
+	}
+	if f, ok := tw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
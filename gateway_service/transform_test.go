@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"ttt/pkg/log"
+	"ttt/pkg/registry"
+)
+
+func TestBodyTransformApply(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   string // bodyTransform 的 JSON 配置
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{"drop", `{"drop": ["password", "meta.internal"]}`,
+			`{"id": 1, "password": "x", "meta": {"internal": true, "v": 2}}`, `{"id":1,"meta":{"v":2}}`, false},
+		{"drop in array", `{"drop": ["password"]}`,
+			`[{"id": 1, "password": "x"}, {"id": 2}]`, `[{"id":1},{"id":2}]`, false},
+		{"drop nested array", `{"drop": ["items.secret"]}`,
+			`{"items": [{"secret": 1, "a": 1}, {"secret": 2}]}`, `{"items":[{"a":1},{}]}`, false},
+		{"drop missing path", `{"drop": ["a.b.c"]}`, `{"a": 1}`, `{"a":1}`, false},
+		{"rename", `{"rename": {"user.user_name": "name"}}`,
+			`{"user": {"user_name": "ann", "id": 1}}`, `{"user":{"id":1,"name":"ann"}}`, false},
+		{"rename missing field", `{"rename": {"user_name": "name"}}`, `{"id": 1}`, `{"id":1}`, false},
+		{"inject creates objects", `{"inject": {"meta.source": "gateway"}}`,
+			`{"id": 1}`, `{"id":1,"meta":{"source":"gateway"}}`, false},
+		{"inject replaces", `{"inject": {"version": 2}}`, `{"version": 1}`, `{"version":2}`, false},
+		{"inject into array elements", `{"inject": {"v": true}}`, `[{"id": 1}, {"id": 2}]`, `[{"id":1,"v":true},{"id":2,"v":true}]`, false},
+		{"wrap", `{"wrap": {"field": "data", "extra": {"ok": true}}}`, `[1, 2]`, `{"data":[1,2],"ok":true}`, false},
+		{"order drop rename inject wrap", `{"drop": ["b"], "rename": {"a": "b"}, "inject": {"c": 3}, "wrap": {"field": "data"}}`,
+			`{"a": 1, "b": 2}`, `{"data":{"b":1,"c":3}}`, false},
+		{"number precision kept", `{"drop": ["x"]}`, `{"id": 12345678901234567890, "f": 1.50}`, `{"f":1.50,"id":12345678901234567890}`, false},
+		{"html not escaped", `{"drop": ["x"]}`, `{"q": "a&b<c>"}`, `{"q":"a&b<c>"}`, false},
+		{"scalar document", `{"drop": ["x"]}`, `"text"`, `"text"`, false},
+		{"invalid json", `{"drop": ["x"]}`, `{"id": `, "", true},
+		{"trailing content", `{"drop": ["x"]}`, `{"id": 1} {"id": 2}`, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var rules bodyTransform
+			if err := json.Unmarshal([]byte(tt.rules), &rules); err != nil {
+				t.Fatal(err)
+			}
+			if errs := rules.validate("body"); len(errs) > 0 {
+				t.Fatal(errs)
+			}
+			out, err := rules.apply([]byte(tt.in))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("apply() error = %v, want error %v", err, tt.wantErr)
+			}
+			if string(out) != tt.want {
+				t.Errorf("apply() = %s, want %s", out, tt.want)
+			}
+		})
+	}
+}
+
+func TestFieldTransform(t *testing.T) {
+	rules := &fieldTransform{
+		Remove: []string{"X-Internal"},
+		Rename: map[string]string{"X-Old": "X-New"},
+		Set:    map[string]string{"X-Version": "2"},
+		Add:    map[string]string{"Via": "gateway"},
+	}
+
+	h := http.Header{}
+	h.Set("X-Internal", "secret")
+	h.Add("X-Old", "a")
+	h.Add("X-Old", "b")
+	h.Set("X-New", "replaced")
+	h.Set("X-Version", "1")
+	h.Set("Via", "proxy")
+	rules.applyHeader(h)
+	want := http.Header{
+		"X-New":     {"a", "b"},
+		"X-Version": {"2"},
+		"Via":       {"proxy", "gateway"},
+	}
+	if !reflect.DeepEqual(h, want) {
+		t.Errorf("applyHeader() = %v, want %v", h, want)
+	}
+
+	// 查询参数名称区分大小写
+	v := url.Values{"X-Internal": {"1"}, "X-Old": {"a"}, "x-old": {"b"}, "Via": {"proxy"}}
+	rules.applyValues(v)
+	wantValues := url.Values{"X-New": {"a"}, "x-old": {"b"}, "X-Version": {"2"}, "Via": {"proxy", "gateway"}}
+	if !reflect.DeepEqual(v, wantValues) {
+		t.Errorf("applyValues() = %v, want %v", v, wantValues)
+	}
+}
+
+func TestNewTransformMiddlewareValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		wantErr string
+	}{
+		{"valid", `{"request": {"body": {"drop": ["a"]}}}`, ""},
+		{"empty", `{}`, "至少需要配置一个"},
+		{"response query", `{"response": {"query": {"remove": ["a"]}}}`, "response 不支持 query"},
+		{"negative limit", `{"request": {"headers": {"remove": ["a"]}}, "max_body_bytes": -1}`, "max_body_bytes"},
+		{"empty path segment", `{"request": {"body": {"drop": ["a..b"]}}}`, "字段路径无效"},
+		{"rename to path", `{"response": {"body": {"rename": {"a": "b.c"}}}}`, "rename 无效"},
+		{"wrap without field", `{"response": {"body": {"wrap": {}}}}`, "wrap 缺少 field"},
+		{"empty header name", `{"request": {"headers": {"rename": {"": "a"}}}}`, "名称不能为空"},
+		{"unknown field", `{"request": {"body": {"remove": ["a"]}}}`, "remove"},
+	}
+	gs := NewGatewayService(0, registry.NewClient("", log.Nop()), log.Nop())
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newTransformMiddleware(gs, json.RawMessage(tt.config))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTransformMiddleware(t *testing.T) {
+	const config = `{
+		"request": {"body": {"drop": ["role"]}, "query": {"remove": ["debug"]}},
+		"response": {"headers": {"remove": ["X-Internal"]}, "body": {"drop": ["password"], "wrap": {"field": "data"}}},
+		"max_body_bytes": 64
+	}`
+
+	tests := []struct {
+		name         string
+		method       string
+		contentType  string
+		reqBody      string
+		respType     string
+		respBody     string
+		wantStatus   int
+		wantUpstream string // 上游收到的请求体
+		wantBody     string
+	}{
+		{"rewrites both bodies", http.MethodPost, "application/json", `{"name": "ann", "role": "admin"}`,
+			"application/json", `{"id": 1, "password": "x"}`, http.StatusOK, `{"name":"ann"}`, `{"data":{"id":1}}`},
+		{"vendor json type", http.MethodPost, "application/vnd.api+json; charset=utf-8", `{"role": "admin"}`,
+			"application/problem+json", `{"password": "x"}`, http.StatusOK, `{}`, `{"data":{}}`},
+		{"non-json request untouched", http.MethodPost, "text/plain", `{"role": "admin"}`,
+			"application/json", `{}`, http.StatusOK, `{"role": "admin"}`, `{"data":{}}`},
+		{"non-json response untouched", http.MethodGet, "", "",
+			"text/plain", `{"password": "x"}`, http.StatusOK, "", `{"password": "x"}`},
+		{"invalid response passed through", http.MethodGet, "", "",
+			"application/json", `{"password": `, http.StatusOK, "", `{"password": `},
+		{"large response passed through", http.MethodGet, "", "",
+			"application/json", `{"password": "` + strings.Repeat("x", 64) + `"}`, http.StatusOK, "", `{"password": "` + strings.Repeat("x", 64) + `"}`},
+		{"invalid request body", http.MethodPost, "application/json", `{"role": `,
+			"application/json", `{}`, http.StatusBadRequest, "", `Invalid JSON request body`},
+		{"request body over limit", http.MethodPost, "application/json", `{"role": "` + strings.Repeat("x", 64) + `"}`,
+			"application/json", `{}`, http.StatusRequestEntityTooLarge, "", `Request body too large to transform`},
+	}
+	gs := NewGatewayService(0, registry.NewClient("", log.Nop()), log.Nop())
+	m, err := newTransformMiddleware(gs, json.RawMessage(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var upstreamBody, upstreamQuery string
+			h := m(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				b, _ := io.ReadAll(r.Body)
+				upstreamBody, upstreamQuery = string(b), r.URL.RawQuery
+				w.Header().Set("Content-Type", tt.respType)
+				w.Header().Set("X-Internal", "1")
+				w.Write([]byte(tt.respBody))
+			}))
+
+			r := httptest.NewRequest(tt.method, "/users?debug=1&page=2", strings.NewReader(tt.reqBody))
+			if tt.contentType != "" {
+				r.Header.Set("Content-Type", tt.contentType)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %q)", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if !strings.Contains(w.Body.String(), tt.wantBody) {
+				t.Errorf("body = %q, want %q", w.Body.String(), tt.wantBody)
+			}
+			if tt.wantStatus != http.StatusOK {
+				// 错误使用 JSON 格式
+				if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+					t.Errorf("error Content-Type = %q, want JSON", ct)
+				}
+				return
+			}
+			if w.Body.String() != tt.wantBody {
+				t.Errorf("body = %q, want %q", w.Body.String(), tt.wantBody)
+			}
+			if upstreamBody != tt.wantUpstream || upstreamQuery != "page=2" {
+				t.Errorf("upstream got body %q query %q, want %q and page=2", upstreamBody, upstreamQuery, tt.wantUpstream)
+			}
+			if w.Header().Get("X-Internal") != "" {
+				t.Error("response header X-Internal not removed")
+			}
+			// 改写后的响应体使用新的长度
+			if cl := w.Header().Get("Content-Length"); tt.wantBody != tt.respBody && cl != strconv.Itoa(len(tt.wantBody)) {
+				t.Errorf("Content-Length = %q, want %d", cl, len(tt.wantBody))
+			}
+		})
+	}
+}
@@ -0,0 +1,364 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"ttt/pkg/log"
+	"ttt/pkg/registry"
+)
+
+var (
+	// errNoInstances 注册中心中没有该服务的实例
+	errNoInstances = errors.New("没有可用的服务实例")
+	// errCircuitOpen 服务的所有实例都处于熔断或摘除状态
+	errCircuitOpen = errors.New("所有实例都已熔断")
+)
+
+// Upstream 网关转发的一个上游服务实例
+// 实例在注册中心刷新时保留（按实例ID），熔断器状态不会因为刷新而丢失
+type Upstream struct {
+	ID      string
+	Service string
+	URL     string
+	Version string // 实例注册时上报的版本，用于按版本分配流量
+	breaker *CircuitBreaker
+
+	mu           sync.Mutex
+	ejectedUntil time.Time // 被异常检测摘除的截止时间
+	ejections    int       // 连续被摘除的次数，决定摘除时长
+}
+
+// ejectedFor 返回实例剩余的摘除时间，未被摘除时返回 0
+func (u *Upstream) ejectedFor(now time.Time) time.Duration {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if now.Before(u.ejectedUntil) {
+		return u.ejectedUntil.Sub(now)
+	}
+	return 0
+}
+
+// UpstreamStatus 上游实例状态（用于 /health 和GUI）
+type UpstreamStatus struct {
+	ID           string     `json:"id"`
+	URL          string     `json:"url"`
+	Version      string     `json:"version,omitempty"`
+	Ejected      bool       `json:"ejected"`
+	EjectedUntil *time.Time `json:"ejected_until,omitempty"`
+	BreakerSnapshot
+}
+
+// Label 用于GUI显示的状态
+func (s UpstreamStatus) Label() string {
+	if s.Ejected {
+		return "已摘除"
+	}
+	// 按 JSON 中的状态名判断，管理接口的客户端解析后也可以使用
+	for _, state := range []BreakerState{BreakerOpen, BreakerHalfOpen} {
+		if s.State == state.String() {
+			return state.Label()
+		}
+	}
+	return BreakerClosed.Label()
+}
+
+// status 返回实例当前状态
+func (u *Upstream) status(cfg *UpstreamConfig, now time.Time) UpstreamStatus {
+	s := UpstreamStatus{
+		ID:              u.ID,
+		URL:             u.URL,
+		Version:         u.Version,
+		BreakerSnapshot: u.breaker.Snapshot(&cfg.CircuitBreaker, now),
+	}
+	u.mu.Lock()
+	if now.Before(u.ejectedUntil) {
+		until := u.ejectedUntil
+		s.Ejected, s.EjectedUntil = true, &until
+	}
+	u.mu.Unlock()
+	return s
+}
+
+// newUpstream 创建上游实例，熔断器状态变化时记录日志并刷新GUI
+func (gs *GatewayService) newUpstream(instance registry.Instance) *Upstream {
+	u := &Upstream{ID: instance.ID, Service: instance.Name, URL: instance.URL, Version: instance.Version}
+	u.breaker = NewCircuitBreaker(func(from, to BreakerState) {
+		fields := []log.Field{log.String("service", u.Service), log.String("instance", u.ID)}
+		switch to {
+		case BreakerOpen:
+			gs.logger.Error("⚡ 熔断器打开，暂停转发到该实例", fields...)
+		case BreakerHalfOpen:
+			gs.logger.Warn("熔断器半开，开始探测实例", fields...)
+		case BreakerClosed:
+			gs.logger.Info("✓ 熔断器关闭，实例恢复", fields...)
+		}
+	})
+	return u
+}
+
+// upstreamConfig 返回当前生效的熔断和异常摘除配置
+func (gs *GatewayService) upstreamConfig() *UpstreamConfig {
+	if table := gs.routes.Load(); table != nil {
+		return &table.Upstream
+	}
+	cfg := UpstreamConfig{}.withDefaults()
+	return &cfg
+}
+
+// setInstances 用注册中心返回的实例列表更新上游实例，记录上线、下线和地址变更
+func (gs *GatewayService) setInstances(instances []registry.Instance) {
+	gs.mu.Lock()
+	upstreams := make(map[string][]*Upstream)
+	seen := make(map[string]bool)
+	for _, instance := range instances {
+		// 跳过网关服务自己
+		if instance.Name == "gateway-service" {
+			continue
+		}
+		seen[instance.ID] = true
+
+		u, existed := gs.instances[instance.ID]
+		switch {
+		case !existed:
+			u = gs.newUpstream(instance)
+			gs.instances[instance.ID] = u
+			gs.logger.Info("✓ 新服务上线", log.String("service", instance.Name), log.String("instance", instance.ID), log.String("url", instance.URL))
+		case u.URL != instance.URL:
+			gs.logger.Warn("⚠ 服务地址变更", log.String("service", instance.Name), log.String("instance", instance.ID), log.String("old_url", u.URL), log.String("url", instance.URL))
+			u = gs.newUpstream(instance)
+			gs.instances[instance.ID] = u
+		case u.Version != instance.Version:
+			gs.logger.Info("实例版本变更", log.String("service", instance.Name), log.String("instance", instance.ID), log.String("old_version", u.Version), log.String("version", instance.Version))
+			u = gs.newUpstream(instance)
+			gs.instances[instance.ID] = u
+		}
+		upstreams[instance.Name] = append(upstreams[instance.Name], u)
+	}
+
+	for id, u := range gs.instances {
+		if !seen[id] {
+			delete(gs.instances, id)
+			gs.logger.Warn("✗ 服务下线", log.String("service", u.Service), log.String("instance", id), log.String("url", u.URL))
+		}
+	}
+	for _, list := range upstreams {
+		sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	}
+	gs.upstreams = upstreams
+	gs.mu.Unlock()
+}
+
+// addInstance 添加单个实例（按需发现服务时使用），不影响其他实例
+func (gs *GatewayService) addInstance(instance registry.Instance) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+
+	if _, existed := gs.instances[instance.ID]; existed {
+		return
+	}
+	u := gs.newUpstream(instance)
+	gs.instances[instance.ID] = u
+	gs.upstreams[instance.Name] = append(gs.upstreams[instance.Name], u)
+	gs.logger.Info("✓ 新服务注册", log.String("service", instance.Name), log.String("instance", instance.ID), log.String("url", instance.URL))
+}
+
+// pickUpstream 选择转发的实例：轮询选择未熔断、未摘除的实例，优先选择本次请求还没有尝试过的实例
+// version 不为空时优先选择该版本的实例，该版本没有实例或实例都不可用时使用其他版本的实例（记录在 versionStats 中）
+// 所有实例都不可用时返回 errCircuitOpen 和建议的重试等待时间
+func (gs *GatewayService) pickUpstream(serviceName, version string, tried map[string]bool) (*Upstream, uint64, time.Duration, error) {
+	gs.mu.RLock()
+	list := gs.upstreams[serviceName]
+	gs.mu.RUnlock()
+
+	// 还不知道该服务时，尝试从注册中心发现
+	if len(list) == 0 {
+		gs.DiscoverService(serviceName)
+		gs.mu.RLock()
+		list = gs.upstreams[serviceName]
+		gs.mu.RUnlock()
+	}
+	if len(list) == 0 {
+		return nil, 0, 0, errNoInstances
+	}
+	if version != "" {
+		if filtered := filterVersion(list, version); len(filtered) > 0 && len(filtered) < len(list) {
+			if u, generation, _, err := gs.pickFrom(filtered, tried); err == nil {
+				return u, generation, 0, nil
+			}
+		}
+	}
+	u, generation, retryAfter, err := gs.pickFrom(list, tried)
+	if err == nil && version != "" && u.Version != version && gs.versionStats.fallback(serviceName, version, time.Now()) {
+		gs.logger.Warn("⚠ 分配的版本没有可用实例，改用其他版本",
+			log.String("service", serviceName),
+			log.String("version", version),
+			log.String("instance", u.ID),
+			log.String("instance_version", u.Version))
+	}
+	return u, generation, retryAfter, err
+}
+
+// pickFrom 从实例列表中轮询选择可用的实例
+func (gs *GatewayService) pickFrom(list []*Upstream, tried map[string]bool) (*Upstream, uint64, time.Duration, error) {
+	cfg := gs.upstreamConfig()
+	now := time.Now()
+	start := int(gs.nextUpstream.Add(1) % uint64(len(list)))
+	retryAfter := time.Duration(0)
+	for pass := 0; pass < 2; pass++ {
+		for i := range list {
+			u := list[(start+i)%len(list)]
+			if (pass == 0) == tried[u.ID] {
+				continue
+			}
+			wait := u.ejectedFor(now)
+			if wait == 0 {
+				var generation uint64
+				var ok bool
+				if generation, ok, wait = u.breaker.Allow(&cfg.CircuitBreaker, now); ok {
+					return u, generation, 0, nil
+				}
+			}
+			if retryAfter == 0 || wait < retryAfter {
+				retryAfter = wait
+			}
+		}
+	}
+	return nil, 0, retryAfter, errCircuitOpen
+}
+
+// recordResult 记录转发结果：连接失败、5xx响应和慢请求都算作失败
+func (gs *GatewayService) recordResult(u *Upstream, generation uint64, statusCode int, latency time.Duration, err error) {
+	cfg := gs.upstreamConfig().CircuitBreaker
+	failed := err != nil || statusCode >= 500 ||
+		(cfg.SlowCallThreshold.Duration > 0 && latency > cfg.SlowCallThreshold.Duration)
+	now := time.Now()
+	u.breaker.Record(&cfg, generation, now, failed)
+	gs.versionStats.record(u.Service, u.Version, err != nil || statusCode >= 500, now)
+}
+
+// filterVersion 返回指定版本的实例
+func filterVersion(list []*Upstream, version string) []*Upstream {
+	var out []*Upstream
+	for _, u := range list {
+		if u.Version == version {
+			out = append(out, u)
+		}
+	}
+	return out
+}
+
+// upstreamStatuses 返回所有实例的状态（按服务名分组）
+func (gs *GatewayService) upstreamStatuses() map[string][]UpstreamStatus {
+	cfg := gs.upstreamConfig()
+	now := time.Now()
+
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	result := make(map[string][]UpstreamStatus, len(gs.upstreams))
+	for name, list := range gs.upstreams {
+		statuses := make([]UpstreamStatus, 0, len(list))
+		for _, u := range list {
+			statuses = append(statuses, u.status(cfg, now))
+		}
+		result[name] = statuses
+	}
+	return result
+}
+
+// DetectOutliers 定期检测异常实例：同一服务有多个实例时，错误率明显高于其他实例的实例会被暂时摘除
+// 摘除时间随连续摘除次数增加；同一服务被摘除的实例数不超过 max_ejection_percent，且至少保留一个实例
+func (gs *GatewayService) DetectOutliers() {
+	for {
+		cfg := gs.upstreamConfig()
+		time.Sleep(cfg.OutlierDetection.Interval.Duration)
+		gs.detectOutliersOnce(gs.upstreamConfig(), time.Now())
+	}
+}
+
+// detectOutliersOnce 执行一次异常检测（error_rate_margin 为 -1 时不摘除）
+func (gs *GatewayService) detectOutliersOnce(cfg *UpstreamConfig, now time.Time) {
+	od := cfg.OutlierDetection
+	if od.ErrorRateMargin <= 0 {
+		return
+	}
+
+	gs.mu.RLock()
+	services := make(map[string][]*Upstream, len(gs.upstreams))
+	for name, list := range gs.upstreams {
+		if len(list) > 1 {
+			services[name] = list
+		}
+	}
+	gs.mu.RUnlock()
+
+	for name, list := range services {
+		statuses := make([]UpstreamStatus, len(list))
+		ejected := 0
+		for i, u := range list {
+			statuses[i] = u.status(cfg, now)
+			if statuses[i].Ejected {
+				ejected++
+			}
+		}
+		maxEjected := len(list) * od.MaxEjectionPercent / 100
+		if maxEjected >= len(list) {
+			maxEjected = len(list) - 1
+		}
+
+		for i, u := range list {
+			s := statuses[i]
+			if s.Ejected {
+				continue
+			}
+			if s.Requests < od.MinRequests {
+				gs.recoverUpstream(u, od, now)
+				continue
+			}
+
+			// 与其他有请求的实例的平均错误率比较
+			var sum float64
+			var peers int
+			for j, other := range statuses {
+				if j != i && other.Requests > 0 {
+					sum += other.ErrorRate
+					peers++
+				}
+			}
+			if peers == 0 || s.ErrorRate-sum/float64(peers) < od.ErrorRateMargin {
+				gs.recoverUpstream(u, od, now)
+				continue
+			}
+			if ejected >= maxEjected {
+				continue
+			}
+
+			u.mu.Lock()
+			if u.ejections < 10 {
+				u.ejections++
+			}
+			duration := od.BaseEjectionTime.Duration * time.Duration(u.ejections)
+			u.ejectedUntil = now.Add(duration)
+			u.mu.Unlock()
+			ejected++
+
+			gs.logger.Warn("⚠ 摘除异常实例",
+				log.String("service", name),
+				log.String("instance", u.ID),
+				log.Float64("error_rate", s.ErrorRate),
+				log.Float64("peer_error_rate", sum/float64(peers)),
+				log.Duration("duration", duration))
+		}
+	}
+}
+
+// recoverUpstream 实例表现正常且距离上次摘除结束已超过一个摘除周期时，减少摘除次数
+func (gs *GatewayService) recoverUpstream(u *Upstream, od OutlierDetectionConfig, now time.Time) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.ejections > 0 && now.Sub(u.ejectedUntil) > od.BaseEjectionTime.Duration {
+		u.ejections--
+	}
+}
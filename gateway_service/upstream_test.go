@@ -0,0 +1,211 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"ttt/pkg/log"
+	"ttt/pkg/registry"
+)
+
+// newUpstreamTestService 返回只包含 instances 的网关，实例按ID排序
+func newUpstreamTestService(instances ...registry.Instance) *GatewayService {
+	gs := NewGatewayService(0, registry.NewClient("", log.Nop()), log.Nop())
+	for _, instance := range instances {
+		u := gs.newUpstream(instance)
+		gs.instances[instance.ID] = u
+		gs.upstreams[instance.Name] = append(gs.upstreams[instance.Name], u)
+	}
+	for _, list := range gs.upstreams {
+		sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	}
+	return gs
+}
+
+// recordStats 在实例的统计窗口中记录 requests 个请求，其中 failures 个失败
+// 使用不存在的状态版本，只计入统计，不改变熔断器状态
+func recordStats(u *Upstream, cfg *UpstreamConfig, now time.Time, requests, failures int) {
+	for i := 0; i < requests; i++ {
+		u.breaker.Record(&cfg.CircuitBreaker, ^uint64(0), now, i < failures)
+	}
+}
+
+func TestDetectOutliers(t *testing.T) {
+	tests := []struct {
+		name        string
+		od          OutlierDetectionConfig
+		stats       [][2]int // 每个实例的请求数和失败数
+		wantEjected string   // 被摘除的实例（按ID）
+	}{
+		{"one bad instance", OutlierDetectionConfig{}, [][2]int{{10, 10}, {10, 0}, {10, 0}}, "a"},
+		{"within margin", OutlierDetectionConfig{}, [][2]int{{10, 1}, {10, 0}, {10, 0}}, ""},
+		{"at margin", OutlierDetectionConfig{}, [][2]int{{10, 5}, {10, 3}, {10, 3}}, "a"},
+		{"below min requests", OutlierDetectionConfig{}, [][2]int{{9, 9}, {10, 0}, {10, 0}}, ""},
+		{"peers without requests", OutlierDetectionConfig{}, [][2]int{{10, 10}, {0, 0}}, ""},
+		{"single instance", OutlierDetectionConfig{}, [][2]int{{10, 10}}, ""},
+		{"limited by max percent", OutlierDetectionConfig{}, [][2]int{{10, 10}, {10, 10}, {10, 0}}, "a"},
+		{"two of four at 50%", OutlierDetectionConfig{}, [][2]int{{10, 10}, {10, 10}, {10, 0}, {10, 0}}, "a,b"},
+		{"percent rounds down", OutlierDetectionConfig{MaxEjectionPercent: 40}, [][2]int{{10, 10}, {10, 0}}, ""},
+		{"100% of three", OutlierDetectionConfig{MaxEjectionPercent: 100}, [][2]int{{10, 10}, {10, 10}, {10, 0}}, "a,b"},
+		{"custom margin", OutlierDetectionConfig{ErrorRateMargin: 0.5}, [][2]int{{10, 4}, {10, 0}}, ""},
+		{"disabled", OutlierDetectionConfig{ErrorRateMargin: -1}, [][2]int{{10, 10}, {10, 0}, {10, 0}}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var instances []registry.Instance
+			for i := range tt.stats {
+				id := string(rune('a' + i))
+				instances = append(instances, registry.Instance{ID: id, Name: "svc", URL: "http://" + id})
+			}
+			gs := newUpstreamTestService(instances...)
+			cfg := UpstreamConfig{OutlierDetection: tt.od}.withDefaults()
+			now := time.Unix(1_700_000_000, 0)
+			for i, u := range gs.upstreams["svc"] {
+				recordStats(u, &cfg, now, tt.stats[i][0], tt.stats[i][1])
+			}
+
+			gs.detectOutliersOnce(&cfg, now)
+			var ejected []string
+			for _, u := range gs.upstreams["svc"] {
+				if u.ejectedFor(now) > 0 {
+					ejected = append(ejected, u.ID)
+				}
+			}
+			if got := strings.Join(ejected, ","); got != tt.wantEjected {
+				t.Errorf("ejected = %q, want %q", got, tt.wantEjected)
+			}
+		})
+	}
+}
+
+func TestDetectOutliersEjectionTime(t *testing.T) {
+	gs := newUpstreamTestService(
+		registry.Instance{ID: "a", Name: "svc", URL: "http://a"},
+		registry.Instance{ID: "b", Name: "svc", URL: "http://b"},
+	)
+	cfg := UpstreamConfig{OutlierDetection: OutlierDetectionConfig{BaseEjectionTime: Duration{time.Minute}}}.withDefaults()
+	a, b := gs.upstreams["svc"][0], gs.upstreams["svc"][1]
+	start := time.Unix(1_700_000_000, 0)
+
+	tests := []struct {
+		at       time.Duration
+		healthy  bool // 实例 a 是否正常
+		wantWait time.Duration
+	}{
+		{0, false, time.Minute},
+		// 仍在摘除中，不再重复摘除
+		{30 * time.Second, false, 30 * time.Second},
+		// 摘除结束后仍然异常，摘除时间倍增
+		{time.Minute, false, 2 * time.Minute},
+		{3 * time.Minute, false, 3 * time.Minute},
+		// 摘除结束超过一个摘除周期且表现正常时，每次检测摘除次数减一
+		{8 * time.Minute, true, 0},
+		{9 * time.Minute, true, 0},
+		{10 * time.Minute, false, 2 * time.Minute},
+	}
+	for _, tt := range tests {
+		now := start.Add(tt.at)
+		a.breaker.stats.reset()
+		b.breaker.stats.reset()
+		failures := 10
+		if tt.healthy {
+			failures = 0
+		}
+		recordStats(a, &cfg, now, 10, failures)
+		recordStats(b, &cfg, now, 10, 0)
+
+		gs.detectOutliersOnce(&cfg, now)
+		if got := a.ejectedFor(now); got != tt.wantWait {
+			t.Errorf("at %v: ejected for %v, want %v", tt.at, got, tt.wantWait)
+		}
+	}
+}
+
+func TestPickUpstreamVersion(t *testing.T) {
+	tests := []struct {
+		name         string
+		versions     []string // 实例 a、b、c... 的版本
+		open         string   // 熔断的实例
+		version      string
+		tried        string
+		want         string // 选中过的实例
+		wantFallback bool   // 是否记录为改用其他版本
+	}{
+		{"assigned version", []string{"v1", "v2"}, "", "v2", "", "b", false},
+		{"no version", []string{"v1", "v2"}, "", "", "", "a,b", false},
+		{"unknown version falls back", []string{"v1", "v2"}, "", "v3", "", "a,b", true},
+		{"open version falls back", []string{"v1", "v2"}, "b", "v2", "", "a", true},
+		{"only version", []string{"v2", "v2"}, "", "v2", "", "a,b", false},
+		{"prefers untried of version", []string{"v1", "v2", "v2"}, "", "v2", "b", "c", false},
+		{"tried version reused before fallback", []string{"v1", "v2"}, "", "v2", "b", "b", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var instances []registry.Instance
+			for i, version := range tt.versions {
+				id := string(rune('a' + i))
+				instances = append(instances, registry.Instance{ID: id, Name: "svc", URL: "http://" + id, Version: version})
+			}
+			gs := newUpstreamTestService(instances...)
+			if tt.open != "" {
+				cfg := gs.upstreamConfig().CircuitBreaker
+				for i := 0; i < cfg.ConsecutiveFailures; i++ {
+					gs.recordResult(gs.instances[tt.open], 0, 503, 0, nil)
+				}
+			}
+			tried := make(map[string]bool)
+			if tt.tried != "" {
+				tried[tt.tried] = true
+			}
+
+			// 轮询会依次尝试不同的起点
+			picks := 2 * len(tt.versions)
+			seen := make(map[string]bool)
+			for i := 0; i < picks; i++ {
+				u, _, _, err := gs.pickUpstream("svc", tt.version, tried)
+				if err != nil {
+					t.Fatal(err)
+				}
+				seen[u.ID] = true
+			}
+			var got []string
+			for id := range seen {
+				got = append(got, id)
+			}
+			sort.Strings(got)
+			if strings.Join(got, ",") != tt.want {
+				t.Errorf("picked %v, want %s", got, tt.want)
+			}
+			wantFallbacks := 0
+			if tt.wantFallback {
+				wantFallbacks = picks
+			}
+			if n := gs.versionStats.fallbackCount("svc", tt.version, time.Now()); n != wantFallbacks {
+				t.Errorf("fallbacks = %d, want %d", n, wantFallbacks)
+			}
+		})
+	}
+}
+
+func TestPickUpstreamAllOpen(t *testing.T) {
+	gs := newUpstreamTestService(
+		registry.Instance{ID: "a", Name: "svc", URL: "http://a", Version: "v1"},
+		registry.Instance{ID: "b", Name: "svc", URL: "http://b", Version: "v2"},
+	)
+	cfg := gs.upstreamConfig()
+	for _, u := range gs.upstreams["svc"] {
+		for i := 0; i < cfg.CircuitBreaker.ConsecutiveFailures; i++ {
+			gs.recordResult(u, 0, 0, 0, errors.New("connection refused"))
+		}
+	}
+	_, _, retryAfter, err := gs.pickUpstream("svc", "v2", nil)
+	if err != errCircuitOpen {
+		t.Fatalf("err = %v, want errCircuitOpen", err)
+	}
+	if retryAfter <= 0 || retryAfter > cfg.CircuitBreaker.OpenDuration.Duration {
+		t.Errorf("retry after = %v, want (0, %v]", retryAfter, cfg.CircuitBreaker.OpenDuration.Duration)
+	}
+}
@@ -1,35 +1,23 @@
 package main
 
 import (
-	"bytes"
-	"embed"
 	"encoding/json"
 	"fmt"
-	"image/color"
-	"log"
 	"net/http"
-	"os"
-	"path/filepath"
-	"runtime"
 	"strconv"
 	"sync"
 	"time"
 
 	"fyne.io/fyne/v2"
-	"fyne.io/fyne/v2/app"
-	"fyne.io/fyne/v2/canvas"
-	"fyne.io/fyne/v2/container"
-	"fyne.io/fyne/v2/storage"
-	"fyne.io/fyne/v2/theme"
-	"fyne.io/fyne/v2/widget"
-)
 
-// 嵌入字体文件
-// 使用方法：将中文字体文件复制到 fonts 目录下
-// 支持的格式：.ttf, .otf（注意：Fyne不支持.ttc字体集合文件）
-// 如果没有字体文件，程序会自动使用系统字体作为fallback
-//go:embed fonts/*
-var embeddedFonts embed.FS
+	"ttt/pkg/bodylimit"
+	"ttt/pkg/config"
+	"ttt/pkg/log"
+	"ttt/pkg/registry"
+	"ttt/pkg/requestid"
+	"ttt/pkg/tlsconfig"
+	"ttt/pkg/ui"
+)
 
 // Order 订单结构
 type Order struct {
@@ -41,38 +29,30 @@ type Order struct {
 	CreatedAt time.Time `json:"created_at"`
 }
 
-// ServiceInfo 服务信息（从注册中心获取）
-type ServiceInfo struct {
-	Name    string `json:"name"`
-	Address string `json:"address"`
-	Port    int    `json:"port"`
-	URL     string `json:"url"`
-}
-
 // OrderService 订单服务
 type OrderService struct {
 	orders         map[int]*Order
 	mu             sync.RWMutex
 	nextID         int
 	port           int
-	registryURL    string
+	registry       *registry.Client
 	userServiceURL string
 	muURL          sync.RWMutex
-	logContainer   *fyne.Container
-	logScroll      *container.Scroll
-	statusLabel    *widget.Label
+	logger         *log.Logger
+	statusBar      *ui.StatusBar
+	client         *http.Client // 调用其他服务使用的客户端（使用 https 时提供客户端证书）
 }
 
 // NewOrderService 创建新的订单服务
-func NewOrderService(port int, registryURL string, logContainer *fyne.Container, logScroll *container.Scroll, statusLabel *widget.Label) *OrderService {
+func NewOrderService(port int, registryClient *registry.Client, logger *log.Logger, statusBar *ui.StatusBar) *OrderService {
 	os := &OrderService{
-		orders:       make(map[int]*Order),
-		nextID:       1,
-		port:         port,
-		registryURL:  registryURL,
-		logContainer: logContainer,
-		logScroll:    logScroll,
-		statusLabel:  statusLabel,
+		orders:    make(map[int]*Order),
+		nextID:    1,
+		port:      port,
+		registry:  registryClient,
+		logger:    logger,
+		statusBar: statusBar,
+		client:    &http.Client{},
 	}
 	// 初始化一些示例数据
 	os.orders[1] = &Order{
@@ -95,61 +75,9 @@ func NewOrderService(port int, registryURL string, logContainer *fyne.Container,
 	return os
 }
 
-// logMessage 添加日志消息（彩色显示）
-func (os *OrderService) logMessage(msg string) {
-	if os.logContainer != nil {
-		timestamp := time.Now().Format("15:04:05")
-		fullMsg := fmt.Sprintf("[%s] %s", timestamp, msg)
-
-		// 根据消息内容确定颜色
-		var msgColor color.Color
-		if contains(msg, "注册") || contains(msg, "成功") || contains(msg, "发现") {
-			msgColor = color.NRGBA{R: 0, G: 200, B: 0, A: 255} // 绿色
-		} else if contains(msg, "警告") || contains(msg, "失败") {
-			msgColor = color.NRGBA{R: 255, G: 165, B: 0, A: 255} // 橙色
-		} else if contains(msg, "错误") || contains(msg, "异常") || contains(msg, "无法") {
-			msgColor = color.NRGBA{R: 255, G: 0, B: 0, A: 255} // 红色
-		} else if contains(msg, "启动") || contains(msg, "就绪") {
-			msgColor = color.NRGBA{R: 0, G: 150, B: 255, A: 255} // 蓝色
-		} else {
-			msgColor = color.NRGBA{R: 200, G: 200, B: 200, A: 255} // 灰色（默认）
-		}
-
-		// 创建带颜色的文本
-		logText := canvas.NewText(fullMsg, msgColor)
-		logText.TextStyle = fyne.TextStyle{Monospace: true}
-		logText.Alignment = fyne.TextAlignLeading
-
-		// 添加到容器
-		os.logContainer.Add(logText)
-
-		// 限制日志条目数量（保留最后200条）
-		if len(os.logContainer.Objects) > 200 {
-			oldObjs := os.logContainer.Objects
-			os.logContainer.Objects = oldObjs[len(oldObjs)-200:]
-			os.logContainer.Refresh()
-		}
-
-		// 滚动到底部
-		if os.logScroll != nil {
-			os.logScroll.ScrollToBottom()
-		}
-	}
-}
-
-// contains 检查字符串是否包含子串
-func contains(s, substr string) bool {
-	for i := 0; i <= len(s)-len(substr); i++ {
-		if s[i:i+len(substr)] == substr {
-			return true
-		}
-	}
-	return false
-}
-
 // updateStatus 更新状态
 func (os *OrderService) updateStatus() {
-	if os.statusLabel != nil {
+	if os.statusBar != nil {
 		os.mu.RLock()
 		orderCount := len(os.orders)
 		os.mu.RUnlock()
@@ -159,96 +87,29 @@ func (os *OrderService) updateStatus() {
 		if userServiceURL == "" {
 			userServiceURL = "未发现"
 		}
-		os.statusLabel.SetText(fmt.Sprintf("状态: 运行中 | 端口: %d | 订单数: %d | 用户服务: %s", os.port, orderCount, userServiceURL))
-	}
-}
-
-// RegisterToRegistry 注册到服务注册中心
-func (os *OrderService) RegisterToRegistry() {
-	if os.registryURL == "" {
-		return
-	}
-
-	serviceInfo := map[string]interface{}{
-		"name":    "order-service",
-		"address": "localhost",
-		"port":    os.port,
-	}
-
-	jsonData, _ := json.Marshal(serviceInfo)
-	resp, err := http.Post(os.registryURL+"/register", "application/json", bytes.NewBuffer(jsonData))
-	if err != nil {
-		os.logMessage(fmt.Sprintf("警告: 无法注册到服务注册中心: %v", err))
-		return
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode == http.StatusOK {
-		os.logMessage("✓ 已注册到服务注册中心")
-		// 启动心跳协程
-		go os.startHeartbeat()
-	}
-}
-
-// startHeartbeat 启动心跳（每5秒发送一次）
-func (os *OrderService) startHeartbeat() {
-	ticker := time.NewTicker(5 * time.Second)
-	defer ticker.Stop()
-
-	for range ticker.C {
-		if os.registryURL != "" {
-			http.Post(os.registryURL+"/heartbeat?name=order-service", "application/json", nil)
-		}
-	}
-}
-
-// UnregisterFromRegistry 从服务注册中心注销（同步等待完成）
-func (os *OrderService) UnregisterFromRegistry() {
-	if os.registryURL == "" {
-		return
-	}
-
-	os.logMessage("正在注销服务...")
-
-	// 创建带超时的HTTP客户端
-	client := &http.Client{Timeout: 2 * time.Second}
-	resp, err := client.Post(os.registryURL+"/unregister?name=order-service", "application/json", nil)
-	if err != nil {
-		os.logMessage(fmt.Sprintf("注销失败: %v", err))
-		return
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode == http.StatusOK {
-		os.logMessage("✓ 已从服务注册中心注销")
-	} else {
-		os.logMessage(fmt.Sprintf("注销失败，状态码: %d", resp.StatusCode))
+		os.statusBar.SetState(ui.StateRunning)
+		os.statusBar.Set("状态", "运行中")
+		os.statusBar.Set("端口", strconv.Itoa(os.port))
+		os.statusBar.Set("订单数", strconv.Itoa(orderCount))
+		os.statusBar.Set("用户服务", userServiceURL)
 	}
 }
 
 // DiscoverUserService 从注册中心发现用户服务
 func (os *OrderService) DiscoverUserService() {
-	if os.registryURL == "" {
-		return
-	}
-
-	resp, err := http.Get(os.registryURL + "/discover?name=user-service")
+	service, err := os.registry.Discover("user-service")
 	if err != nil {
-		os.logMessage(fmt.Sprintf("警告: 无法从注册中心发现用户服务: %v", err))
-		return
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode == http.StatusOK {
-		var service ServiceInfo
-		if err := json.NewDecoder(resp.Body).Decode(&service); err == nil {
-			os.muURL.Lock()
-			os.userServiceURL = service.URL
-			os.muURL.Unlock()
-			os.logMessage(fmt.Sprintf("✓ 发现用户服务: %s", service.URL))
-			os.updateStatus()
+		if err != registry.ErrNotFound {
+			os.logger.Warn("无法从注册中心发现用户服务", log.Err(err))
 		}
+		return
 	}
+
+	os.muURL.Lock()
+	os.userServiceURL = service.URL
+	os.muURL.Unlock()
+	os.logger.Info("✓ 发现用户服务", log.String("url", service.URL))
+	os.updateStatus()
 }
 
 // getUserServiceURL 获取用户服务URL（带重试发现）
@@ -268,22 +129,31 @@ func (os *OrderService) getUserServiceURL() string {
 	return url
 }
 
+// fetchUser 调用用户服务查询用户（携带当前请求的请求ID）
+func (os *OrderService) fetchUser(r *http.Request, userServiceURL string, userID int) (*http.Response, error) {
+	req, err := requestid.NewRequest(r.Context(), http.MethodGet, fmt.Sprintf("%s/user?id=%d", userServiceURL, userID), nil)
+	if err != nil {
+		return nil, err
+	}
+	return os.client.Do(req)
+}
+
 // GetOrder 获取订单信息
 func (os *OrderService) GetOrder(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		requestid.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
 	idStr := r.URL.Query().Get("id")
 	if idStr == "" {
-		http.Error(w, "Missing id parameter", http.StatusBadRequest)
+		requestid.Error(w, r, "Missing id parameter", http.StatusBadRequest)
 		return
 	}
 
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		http.Error(w, "Invalid id parameter", http.StatusBadRequest)
+		requestid.Error(w, r, "Invalid id parameter", http.StatusBadRequest)
 		return
 	}
 
@@ -292,11 +162,11 @@ func (os *OrderService) GetOrder(w http.ResponseWriter, r *http.Request) {
 	os.mu.RUnlock()
 
 	if !exists {
-		http.Error(w, "Order not found", http.StatusNotFound)
+		requestid.Error(w, r, "Order not found", http.StatusNotFound)
 		return
 	}
 
-	os.logMessage(fmt.Sprintf("GET /order?id=%d - 返回订单信息", id))
+	os.logger.ForRequest(r).Info("GET /order - 返回订单信息", log.Int("id", id))
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(order)
 }
@@ -304,7 +174,7 @@ func (os *OrderService) GetOrder(w http.ResponseWriter, r *http.Request) {
 // ListOrders 获取所有订单列表
 func (os *OrderService) ListOrders(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		requestid.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
@@ -315,7 +185,7 @@ func (os *OrderService) ListOrders(w http.ResponseWriter, r *http.Request) {
 	}
 	os.mu.RUnlock()
 
-	os.logMessage(fmt.Sprintf("GET /order - 返回所有订单列表（共 %d 条）", len(orders)))
+	os.logger.ForRequest(r).Info("GET /order - 返回所有订单列表", log.Int("count", len(orders)))
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(orders)
 }
@@ -323,19 +193,19 @@ func (os *OrderService) ListOrders(w http.ResponseWriter, r *http.Request) {
 // GetOrdersByUser 根据用户ID获取订单列表
 func (os *OrderService) GetOrdersByUser(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		requestid.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
 	userIDStr := r.URL.Query().Get("user_id")
 	if userIDStr == "" {
-		http.Error(w, "Missing user_id parameter", http.StatusBadRequest)
+		requestid.Error(w, r, "Missing user_id parameter", http.StatusBadRequest)
 		return
 	}
 
 	userID, err := strconv.Atoi(userIDStr)
 	if err != nil {
-		http.Error(w, "Invalid user_id parameter", http.StatusBadRequest)
+		requestid.Error(w, r, "Invalid user_id parameter", http.StatusBadRequest)
 		return
 	}
 
@@ -348,7 +218,7 @@ func (os *OrderService) GetOrdersByUser(w http.ResponseWriter, r *http.Request)
 	}
 	os.mu.RUnlock()
 
-	os.logMessage(fmt.Sprintf("GET /order?user_id=%d - 返回用户订单列表", userID))
+	os.logger.ForRequest(r).Info("GET /order - 返回用户订单列表", log.Int("user_id", userID))
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(orders)
 }
@@ -356,27 +226,31 @@ func (os *OrderService) GetOrdersByUser(w http.ResponseWriter, r *http.Request)
 // CreateOrder 创建订单（会调用用户服务验证用户是否存在）
 func (os *OrderService) CreateOrder(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		requestid.JSONError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
 	var order Order
 	if err := json.NewDecoder(r.Body).Decode(&order); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		if bodylimit.TooLarge(err) {
+			requestid.JSONError(w, r, "Request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		requestid.JSONError(w, r, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 
 	// 通过服务发现获取用户服务URL并验证用户是否存在
 	userServiceURL := os.getUserServiceURL()
 	if userServiceURL != "" {
-		resp, err := http.Get(fmt.Sprintf("%s/user?id=%d", userServiceURL, order.UserID))
+		resp, err := os.fetchUser(r, userServiceURL, order.UserID)
 		if err != nil || resp.StatusCode != http.StatusOK {
-			os.logMessage(fmt.Sprintf("创建订单失败: 用户 %d 不存在", order.UserID))
-			http.Error(w, "User not found", http.StatusBadRequest)
+			os.logger.ForRequest(r).Warn("创建订单失败: 用户不存在", log.Int("user_id", order.UserID))
+			requestid.JSONError(w, r, "User not found", http.StatusBadRequest)
 			return
 		}
 		resp.Body.Close()
-		os.logMessage(fmt.Sprintf("通过服务发现验证用户 %d 存在", order.UserID))
+		os.logger.ForRequest(r).Info("通过服务发现验证用户存在", log.Int("user_id", order.UserID))
 	}
 
 	os.mu.Lock()
@@ -389,7 +263,7 @@ func (os *OrderService) CreateOrder(w http.ResponseWriter, r *http.Request) {
 	os.orders[order.ID] = &order
 	os.mu.Unlock()
 
-	os.logMessage(fmt.Sprintf("POST /order - 创建新订单: ID=%d, 用户=%d, 金额=%.2f", order.ID, order.UserID, order.Amount))
+	os.logger.ForRequest(r).Info("POST /order - 创建新订单", log.Int("id", order.ID), log.Int("user_id", order.UserID), log.Float64("amount", order.Amount))
 	os.updateStatus()
 
 	w.Header().Set("Content-Type", "application/json")
@@ -400,19 +274,19 @@ func (os *OrderService) CreateOrder(w http.ResponseWriter, r *http.Request) {
 // GetOrderWithUserInfo 获取订单信息（包含用户信息，演示服务间调用）
 func (os *OrderService) GetOrderWithUserInfo(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		requestid.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
 	idStr := r.URL.Query().Get("id")
 	if idStr == "" {
-		http.Error(w, "Missing id parameter", http.StatusBadRequest)
+		requestid.Error(w, r, "Missing id parameter", http.StatusBadRequest)
 		return
 	}
 
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		http.Error(w, "Invalid id parameter", http.StatusBadRequest)
+		requestid.Error(w, r, "Invalid id parameter", http.StatusBadRequest)
 		return
 	}
 
@@ -421,7 +295,7 @@ func (os *OrderService) GetOrderWithUserInfo(w http.ResponseWriter, r *http.Requ
 	os.mu.RUnlock()
 
 	if !exists {
-		http.Error(w, "Order not found", http.StatusNotFound)
+		requestid.Error(w, r, "Order not found", http.StatusNotFound)
 		return
 	}
 
@@ -435,46 +309,53 @@ func (os *OrderService) GetOrderWithUserInfo(w http.ResponseWriter, r *http.Requ
 
 	userServiceURL := os.getUserServiceURL()
 	if userServiceURL != "" {
-		os.logMessage(fmt.Sprintf("通过服务发现调用用户服务: %s/user?id=%d", userServiceURL, order.UserID))
-		resp, err := http.Get(fmt.Sprintf("%s/user?id=%d", userServiceURL, order.UserID))
+		os.logger.ForRequest(r).Info("通过服务发现调用用户服务", log.String("url", userServiceURL), log.Int("user_id", order.UserID))
+		resp, err := os.fetchUser(r, userServiceURL, order.UserID)
 		if err == nil && resp.StatusCode == http.StatusOK {
 			var user interface{}
 			json.NewDecoder(resp.Body).Decode(&user)
 			result.User = user
 			resp.Body.Close()
-			os.logMessage("✓ 成功获取用户信息")
+			os.logger.ForRequest(r).Info("✓ 成功获取用户信息")
 		}
 	}
 
-	os.logMessage(fmt.Sprintf("GET /order/with-user?id=%d - 返回订单和用户信息", id))
+	os.logger.ForRequest(r).Info("GET /order/with-user - 返回订单和用户信息", log.Int("id", id))
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(result)
 }
 
 func main() {
 	// 创建GUI应用
-	myApp := app.New()
-	// 设置支持中文的主题（使用系统默认字体，支持中文）
-	myApp.Settings().SetTheme(newChineseTheme())
-	myWindow := myApp.NewWindow("订单服务 (端口: 8082)")
+	myApp := ui.NewApp()
+	port := config.GetEnvInt("PORT", 8082)
+	// 请求体大小上限，超过时返回 413
+	maxBodyBytes := int64(config.GetEnvInt("MAX_BODY_BYTES", bodylimit.DefaultMaxBytes))
+	myWindow := myApp.NewWindow(fmt.Sprintf("订单服务 (端口: %d)", port))
 	myWindow.Resize(fyne.NewSize(800, 600))
 
-	// 创建日志显示区域（使用canvas.Text支持彩色显示）
-	logContainer := container.NewVBox()
-	// 添加初始消息
-	initText := canvas.NewText("订单服务启动中...", color.NRGBA{R: 200, G: 200, B: 200, A: 255})
-	initText.TextStyle = fyne.TextStyle{Monospace: true}
-	logContainer.Add(initText)
-	logScroll := container.NewScroll(logContainer)
-	logScroll.SetMinSize(fyne.NewSize(0, 0))
-
-	// 创建状态标签
-	statusLabel := widget.NewLabel("状态: 启动中...")
+	// 创建日志：同时输出到标准输出和内存环形缓冲区，GUI订阅缓冲区显示
+	logBuffer := log.NewRingBuffer(200)
+	logger := log.NewFromEnv(logBuffer)
 
-	port := 8082
-	registryURL := "http://localhost:8080"
+	// 创建日志面板和状态栏
+	logPanel := ui.NewLogPanel(logBuffer, myWindow)
+	statusBar := ui.NewStatusBar()
+	logger.Info("订单服务启动中...")
 
-	service := NewOrderService(port, registryURL, logContainer, logScroll, statusLabel)
+	registryClient := registry.NewClient(config.GetEnv("REGISTRY_URL", "http://localhost:8080"), logger)
+	// 配置了证书文件或本地CA令牌（CA_TOKEN）时使用 HTTPS，证书自动重新加载或更新
+	tlsStore, err := registryClient.SetupTLS("order-service", "localhost", "gateway-service")
+	if err != nil {
+		logger.Fatal("初始化TLS失败", log.Err(err))
+	}
+	service := NewOrderService(port, registryClient, logger, statusBar)
+	if tlsStore != nil {
+		transport := &http.Transport{}
+		// 只接受用户服务的证书
+		tlsStore.ConfigureTransport(transport, func(string) string { return registry.ServiceURI("user-service") })
+		service.client.Transport = transport
+	}
 
 	http.HandleFunc("/order", func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
@@ -490,7 +371,7 @@ func main() {
 		case http.MethodPost:
 			service.CreateOrder(w, r)
 		default:
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			requestid.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
 		}
 	})
 
@@ -498,11 +379,12 @@ func main() {
 
 	// 启动HTTP服务器
 	go func() {
-		service.logMessage(fmt.Sprintf("订单服务启动在端口 %d", port))
-		service.logMessage(fmt.Sprintf("服务注册中心: %s", registryURL))
+		logger.Info("订单服务启动", log.Int("port", port))
+		logger.Info("服务注册中心", log.String("url", registryClient.URL()))
 
 		// 注册到服务注册中心
-		service.RegisterToRegistry()
+		registryClient.SetVersion(config.GetEnv("SERVICE_VERSION", "v1"))
+		registryClient.Register("order-service", "localhost", port)
 
 		// 从注册中心发现用户服务
 		service.DiscoverUserService()
@@ -524,11 +406,11 @@ func main() {
 			}
 		}()
 
-		service.logMessage("API端点:")
-		service.logMessage(fmt.Sprintf("  GET  http://localhost:%d/order?id=1 - 获取订单", port))
-		service.logMessage(fmt.Sprintf("  GET  http://localhost:%d/order?user_id=1 - 获取用户的订单列表", port))
-		service.logMessage(fmt.Sprintf("  GET  http://localhost:%d/order/with-user?id=1 - 获取订单（包含用户信息，演示服务间调用）", port))
-		service.logMessage(fmt.Sprintf("  POST http://localhost:%d/order - 创建订单（会验证用户是否存在）", port))
+		logger.Info("API端点:")
+		logger.Info(fmt.Sprintf("  GET  http://localhost:%d/order?id=1 - 获取订单", port))
+		logger.Info(fmt.Sprintf("  GET  http://localhost:%d/order?user_id=1 - 获取用户的订单列表", port))
+		logger.Info(fmt.Sprintf("  GET  http://localhost:%d/order/with-user?id=1 - 获取订单（包含用户信息，演示服务间调用）", port))
+		logger.Info(fmt.Sprintf("  POST http://localhost:%d/order - 创建订单（会验证用户是否存在）", port))
 
 		service.updateStatus()
 
@@ -541,27 +423,16 @@ func main() {
 			}
 		}()
 
-		log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", port), nil))
+		logger.Fatal("HTTP服务退出", log.Err(tlsconfig.ListenAndServe(fmt.Sprintf(":%d", port), requestid.Middleware(bodylimit.Middleware(maxBodyBytes, http.DefaultServeMux)), tlsStore)))
 	}()
 
 	// 创建UI布局
-	content := container.NewBorder(
-		statusLabel,
-		nil,
-		nil,
-		nil,
-		container.NewBorder(
-			widget.NewLabel("日志输出"), nil, nil, nil,
-			logScroll,
-		),
-	)
-
-	myWindow.SetContent(content)
+	myWindow.SetContent(ui.ServiceLayout(statusBar, logPanel, "", nil))
 
 	// 设置窗口关闭拦截，在关闭前注销服务
 	myWindow.SetCloseIntercept(func() {
-		service.logMessage("窗口关闭，正在注销服务...")
-		service.UnregisterFromRegistry()
+		logger.Info("窗口关闭，正在注销服务...")
+		registryClient.Unregister()
 		// 稍微等待一下，确保注销请求完成
 		time.Sleep(300 * time.Millisecond)
 		// 手动关闭窗口
@@ -570,169 +441,3 @@ func main() {
 
 	myWindow.ShowAndRun()
 }
-
-// chineseTheme 支持中文的主题
-type chineseTheme struct {
-	baseTheme   fyne.Theme
-	chineseFont fyne.Resource
-}
-
-func newChineseTheme() *chineseTheme {
-	t := &chineseTheme{
-		baseTheme: theme.DefaultTheme(),
-	}
-
-	// 尝试加载系统字体
-	t.chineseFont = loadSystemChineseFont()
-	if t.chineseFont == nil {
-		// 如果找不到系统字体，在Windows上直接使用默认主题字体
-		// Fyne在Windows上会自动使用系统默认字体，通常支持中文
-		t.chineseFont = t.baseTheme.Font(fyne.TextStyle{})
-		if t.chineseFont == nil {
-			t.chineseFont = theme.DefaultTheme().Font(fyne.TextStyle{})
-		}
-	}
-
-	return t
-}
-
-// loadSystemChineseFont 加载中文字体（优先使用嵌入的字体，否则使用系统字体）
-func loadSystemChineseFont() fyne.Resource {
-	// 1. 优先尝试加载嵌入的字体文件
-	if embeddedFont := loadEmbeddedFont(); embeddedFont != nil {
-		return embeddedFont
-	}
-
-	// 2. 如果嵌入字体不存在，尝试加载系统字体
-	var fontPaths []string
-
-	switch runtime.GOOS {
-	case "windows":
-		// Windows字体路径，按优先级排序
-		// 优先使用常见的Windows中文字体
-		windir := os.Getenv("WINDIR")
-		if windir == "" {
-			windir = "C:\\Windows"
-		}
-		
-		// 注意：Fyne不支持.ttc字体集合，只使用.ttf文件
-		fontPaths = []string{
-			filepath.Join(windir, "Fonts", "simhei.ttf"),  // SimHei (黑体)
-			filepath.Join(windir, "Fonts", "simsun.ttf"),  // SimSun (宋体)
-			filepath.Join(windir, "Fonts", "simkai.ttf"),  // SimKai (楷体)
-			filepath.Join(windir, "Fonts", "simli.ttf"),   // SimLi (隶书)
-		}
-		
-		if windir != "C:\\Windows" {
-			fontPaths = append(fontPaths,
-				filepath.Join("C:\\Windows", "Fonts", "simhei.ttf"),
-				filepath.Join("C:\\Windows", "Fonts", "simsun.ttf"),
-			)
-		}
-	case "darwin": // macOS
-		// 只使用.ttf文件，Fyne不支持.ttc
-		fontPaths = []string{
-			"/Library/Fonts/Arial Unicode.ttf",
-		}
-	case "linux":
-		// 只使用.ttf文件，Fyne不支持.ttc
-		fontPaths = []string{
-			"/usr/share/fonts/truetype/droid/DroidSansFallbackFull.ttf",
-		}
-	}
-
-	// 尝试加载系统字体文件（只加载.ttf和.otf，跳过.ttc）
-	for _, path := range fontPaths {
-		// 检查文件扩展名，只加载.ttf和.otf文件
-		ext := filepath.Ext(path)
-		if ext != ".ttf" && ext != ".otf" {
-			continue // 跳过非.ttf/.otf文件
-		}
-
-		// 检查文件是否存在
-		if info, err := os.Stat(path); err == nil && !info.IsDir() {
-			// 尝试使用file://协议加载字体
-			uri := storage.NewFileURI(path)
-			res, err := storage.LoadResourceFromURI(uri)
-			if err == nil && res != nil {
-				// 验证字体资源是否有效
-				content := res.Content()
-				if len(content) > 0 {
-					// 字体加载成功
-					return res
-				}
-			}
-			
-			// 如果storage.LoadResourceFromURI失败，尝试直接读取文件
-			if data, err := os.ReadFile(path); err == nil && len(data) > 0 {
-				// 创建内存资源
-				res := fyne.NewStaticResource(filepath.Base(path), data)
-				return res
-			}
-		}
-	}
-
-	// 如果所有字体都加载失败，返回nil（会使用默认字体）
-	return nil
-}
-
-// loadEmbeddedFont 加载嵌入的字体文件
-// 注意：Fyne不支持.ttc（TrueType Collection）字体集合文件，只支持.ttf和.otf
-func loadEmbeddedFont() fyne.Resource {
-	// 直接读取fonts目录下的文件
-	entries, err := embeddedFonts.ReadDir("fonts")
-	if err != nil {
-		return nil
-	}
-
-	// 按优先级查找字体文件（只查找.ttf和.otf，跳过.ttc）
-	preferredNames := []string{"chinese.ttf", "chinese.otf", "msyh.ttf", "simsun.ttf", "font.ttf", "font.otf"}
-
-	// 先查找优先字体
-	for _, preferredName := range preferredNames {
-		for _, entry := range entries {
-			if entry.Name() == preferredName && !entry.IsDir() {
-				data, err := embeddedFonts.ReadFile("fonts/" + preferredName)
-				if err == nil && len(data) > 0 {
-					return fyne.NewStaticResource(preferredName, data)
-				}
-			}
-		}
-	}
-
-	// 如果优先字体没找到，查找任何.ttf或.otf字体文件（跳过.ttc）
-	for _, entry := range entries {
-		if entry.IsDir() {
-			continue
-		}
-
-		name := entry.Name()
-		ext := filepath.Ext(name)
-		// 只加载.ttf和.otf文件，跳过.ttc（Fyne不支持）
-		if ext == ".ttf" || ext == ".otf" {
-			data, err := embeddedFonts.ReadFile("fonts/" + name)
-			if err == nil && len(data) > 0 {
-				return fyne.NewStaticResource(name, data)
-			}
-		}
-	}
-
-	return nil
-}
-
-func (t *chineseTheme) Color(name fyne.ThemeColorName, variant fyne.ThemeVariant) color.Color {
-	return t.baseTheme.Color(name, variant)
-}
-
-func (t *chineseTheme) Icon(name fyne.ThemeIconName) fyne.Resource {
-	return t.baseTheme.Icon(name)
-}
-
-func (t *chineseTheme) Font(style fyne.TextStyle) fyne.Resource {
-	// 使用加载的中文字体
-	return t.chineseFont
-}
-
-func (t *chineseTheme) Size(name fyne.ThemeSizeName) float32 {
-	return t.baseTheme.Size(name)
-}
@@ -0,0 +1,33 @@
+package bodylimit
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"ttt/pkg/requestid"
+)
+
+// DefaultMaxBytes 服务默认接受的请求体大小上限
+const DefaultMaxBytes = 1 << 20
+
+// Middleware 限制请求体大小：声明的 Content-Length 超过 limit 时直接返回 413，
+// 否则用 http.MaxBytesReader 包装请求体，读取超过 limit 时返回错误（可用 TooLarge 判断）
+func Middleware(limit int64, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > limit {
+			requestid.JSONError(w, r, fmt.Sprintf("Request body too large (limit %d bytes)", limit), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if r.Body != nil && r.Body != http.NoBody {
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// TooLarge 判断读取请求体的错误是否因为超过了大小上限
+func TooLarge(err error) bool {
+	var maxErr *http.MaxBytesError
+	return errors.As(err, &maxErr)
+}
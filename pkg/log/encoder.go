@@ -0,0 +1,110 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Encoder 将日志条目编码为一行文本（不含换行符）
+type Encoder interface {
+	Encode(e Entry) []byte
+}
+
+// ConsoleEncoder 人类可读的控制台格式，如：
+//
+//	2024-01-02 15:04:05.000 INFO  服务启动 port=8081
+type ConsoleEncoder struct {
+	// TimeLayout 时间格式，为空时使用 "2006-01-02 15:04:05.000"
+	TimeLayout string
+}
+
+// Encode 实现 Encoder
+func (c ConsoleEncoder) Encode(e Entry) []byte {
+	layout := c.TimeLayout
+	if layout == "" {
+		layout = "2006-01-02 15:04:05.000"
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(e.Time.Format(layout))
+	buf.WriteByte(' ')
+	fmt.Fprintf(&buf, "%-5s", strings.ToUpper(e.Level.String()))
+	buf.WriteByte(' ')
+	buf.WriteString(e.Message)
+	for _, f := range e.Fields {
+		buf.WriteByte(' ')
+		buf.WriteString(f.Key)
+		buf.WriteByte('=')
+		buf.WriteString(consoleValue(f.Value))
+	}
+	return buf.Bytes()
+}
+
+// consoleValue 格式化字段值，包含空白或特殊字符时加引号
+func consoleValue(v interface{}) string {
+	var s string
+	switch val := v.(type) {
+	case nil:
+		return "<nil>"
+	case string:
+		s = val
+	case time.Duration:
+		return val.String()
+	case fmt.Stringer:
+		s = val.String()
+	default:
+		s = fmt.Sprint(val)
+	}
+	if s == "" || strings.ContainsAny(s, " \t\n\"=") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// JSONEncoder 每行一个JSON对象，包含 time、level、msg 以及所有字段
+type JSONEncoder struct{}
+
+// Encode 实现 Encoder
+func (JSONEncoder) Encode(e Entry) []byte {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	writeJSONKV(&buf, "time", e.Time.Format(time.RFC3339Nano))
+	buf.WriteByte(',')
+	writeJSONKV(&buf, "level", e.Level.String())
+	buf.WriteByte(',')
+	writeJSONKV(&buf, "msg", e.Message)
+	for _, f := range e.Fields {
+		buf.WriteByte(',')
+		writeJSONKV(&buf, f.Key, jsonValue(f.Value))
+	}
+	buf.WriteByte('}')
+	return buf.Bytes()
+}
+
+func writeJSONKV(buf *bytes.Buffer, key string, value interface{}) {
+	k, _ := json.Marshal(key)
+	buf.Write(k)
+	buf.WriteByte(':')
+	v, err := json.Marshal(value)
+	if err != nil {
+		v, _ = json.Marshal(fmt.Sprint(value))
+	}
+	buf.Write(v)
+}
+
+// jsonValue 将不适合直接序列化的值转换为字符串
+func jsonValue(v interface{}) interface{} {
+	switch val := v.(type) {
+	case time.Duration:
+		return val.String()
+	case error:
+		return val.Error()
+	case fmt.Stringer:
+		return val.String()
+	}
+	return v
+}
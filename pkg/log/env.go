@@ -0,0 +1,50 @@
+package log
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"ttt/pkg/config"
+)
+
+// NewFromEnv 根据环境变量创建Logger，额外的输出目标（如GUI使用的环形缓冲区）通过 extra 传入
+//
+//	LOG_LEVEL         最低级别 debug/info/warn/error（默认 info）
+//	LOG_FORMAT        标准输出格式 console/json（默认 console）
+//	LOG_FILE          日志文件路径，为空则不写文件
+//	LOG_FILE_FORMAT   日志文件格式 console/json（默认 json）
+//	LOG_FILE_MAX_MB   单个日志文件最大大小，单位MB（默认 10）
+//	LOG_FILE_BACKUPS  保留的旧日志文件个数（默认 5）
+func NewFromEnv(extra ...Sink) *Logger {
+	level, err := ParseLevel(config.GetEnv("LOG_LEVEL", "info"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "log: %v，使用 info\n", err)
+	}
+
+	logger := New(level, NewStdoutSink(encoderByName(config.GetEnv("LOG_FORMAT", "console"))))
+
+	if path := config.GetEnv("LOG_FILE", ""); path != "" {
+		maxSize := int64(config.GetEnvInt("LOG_FILE_MAX_MB", 10)) << 20
+		backups := config.GetEnvInt("LOG_FILE_BACKUPS", 5)
+		rf, err := NewRotatingFile(path, maxSize, backups, encoderByName(config.GetEnv("LOG_FILE_FORMAT", "json")))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "log: 无法打开日志文件 %s: %v\n", path, err)
+		} else {
+			logger.AddSink(rf)
+		}
+	}
+
+	for _, s := range extra {
+		logger.AddSink(s)
+	}
+	return logger
+}
+
+// encoderByName 根据名称返回编码器（json 或 console）
+func encoderByName(name string) Encoder {
+	if strings.EqualFold(name, "json") {
+		return JSONEncoder{}
+	}
+	return ConsoleEncoder{}
+}
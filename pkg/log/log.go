@@ -0,0 +1,227 @@
+// Package log 提供各服务共用的结构化日志
+// 日志条目带有级别和结构化字段，通过编码器（控制台/JSON）写入可插拔的输出（标准输出、滚动文件、内存环形缓冲区）
+package log
+
+import (
+	"fmt"
+	"image/color"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"ttt/pkg/requestid"
+)
+
+// Level 日志级别
+type Level int32
+
+const (
+	DebugLevel Level = iota
+	InfoLevel
+	WarnLevel
+	ErrorLevel
+)
+
+// String 返回级别名称
+func (l Level) String() string {
+	switch l {
+	case DebugLevel:
+		return "debug"
+	case InfoLevel:
+		return "info"
+	case WarnLevel:
+		return "warn"
+	case ErrorLevel:
+		return "error"
+	default:
+		return fmt.Sprintf("level(%d)", int32(l))
+	}
+}
+
+// Color 返回级别对应的显示颜色（GUI日志视图使用）
+func (l Level) Color() color.Color {
+	switch l {
+	case DebugLevel:
+		return color.NRGBA{R: 150, G: 150, B: 150, A: 255} // 灰色
+	case WarnLevel:
+		return color.NRGBA{R: 255, G: 165, B: 0, A: 255} // 橙色
+	case ErrorLevel:
+		return color.NRGBA{R: 255, G: 0, B: 0, A: 255} // 红色
+	default:
+		return color.NRGBA{R: 200, G: 200, B: 200, A: 255} // 浅灰色
+	}
+}
+
+// ParseLevel 解析级别名称（debug/info/warn/error，不区分大小写）
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "debug":
+		return DebugLevel, nil
+	case "info", "":
+		return InfoLevel, nil
+	case "warn", "warning":
+		return WarnLevel, nil
+	case "error":
+		return ErrorLevel, nil
+	}
+	return InfoLevel, fmt.Errorf("未知的日志级别: %q", s)
+}
+
+// Field 结构化字段
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// String 字符串字段
+func String(key, value string) Field { return Field{Key: key, Value: value} }
+
+// Int 整数字段
+func Int(key string, value int) Field { return Field{Key: key, Value: value} }
+
+// Int64 64位整数字段
+func Int64(key string, value int64) Field { return Field{Key: key, Value: value} }
+
+// Float64 浮点数字段
+func Float64(key string, value float64) Field { return Field{Key: key, Value: value} }
+
+// Bool 布尔字段
+func Bool(key string, value bool) Field { return Field{Key: key, Value: value} }
+
+// Duration 时长字段
+func Duration(key string, value time.Duration) Field { return Field{Key: key, Value: value} }
+
+// Any 任意类型字段
+func Any(key string, value interface{}) Field { return Field{Key: key, Value: value} }
+
+// Err 错误字段（键为 error）
+func Err(err error) Field {
+	if err == nil {
+		return Field{Key: "error", Value: nil}
+	}
+	return Field{Key: "error", Value: err.Error()}
+}
+
+// Entry 一条日志
+type Entry struct {
+	Time    time.Time
+	Level   Level
+	Message string
+	Fields  []Field
+}
+
+// Sink 日志输出目标
+type Sink interface {
+	Write(e Entry) error
+}
+
+// core 同一Logger及其派生Logger共享的状态
+type core struct {
+	level atomic.Int32
+	mu    sync.RWMutex
+	sinks []Sink
+}
+
+// Logger 结构化日志记录器
+// With/ForRequest 派生的Logger共享级别和输出目标
+type Logger struct {
+	core   *core
+	fields []Field
+}
+
+// New 创建Logger
+func New(level Level, sinks ...Sink) *Logger {
+	c := &core{sinks: sinks}
+	c.level.Store(int32(level))
+	return &Logger{core: c}
+}
+
+// Nop 返回丢弃所有日志的Logger
+func Nop() *Logger {
+	return New(ErrorLevel + 1)
+}
+
+// SetLevel 设置最低输出级别（对所有派生Logger生效）
+func (l *Logger) SetLevel(level Level) {
+	l.core.level.Store(int32(level))
+}
+
+// Level 返回当前最低输出级别
+func (l *Logger) Level() Level {
+	return Level(l.core.level.Load())
+}
+
+// Enabled 判断指定级别是否会被输出
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.Level()
+}
+
+// AddSink 添加输出目标
+func (l *Logger) AddSink(s Sink) {
+	l.core.mu.Lock()
+	l.core.sinks = append(l.core.sinks, s)
+	l.core.mu.Unlock()
+}
+
+// With 返回附加了字段的派生Logger
+func (l *Logger) With(fields ...Field) *Logger {
+	merged := make([]Field, 0, len(l.fields)+len(fields))
+	merged = append(merged, l.fields...)
+	merged = append(merged, fields...)
+	return &Logger{core: l.core, fields: merged}
+}
+
+// ForRequest 返回带请求ID字段的派生Logger
+func (l *Logger) ForRequest(r *http.Request) *Logger {
+	if id := requestid.FromRequest(r); id != "" {
+		return l.With(String("request_id", id))
+	}
+	return l
+}
+
+// Debug 输出调试日志
+func (l *Logger) Debug(msg string, fields ...Field) { l.log(DebugLevel, msg, fields) }
+
+// Info 输出普通日志
+func (l *Logger) Info(msg string, fields ...Field) { l.log(InfoLevel, msg, fields) }
+
+// Warn 输出警告日志
+func (l *Logger) Warn(msg string, fields ...Field) { l.log(WarnLevel, msg, fields) }
+
+// Error 输出错误日志
+func (l *Logger) Error(msg string, fields ...Field) { l.log(ErrorLevel, msg, fields) }
+
+// Fatal 输出错误日志后退出进程
+func (l *Logger) Fatal(msg string, fields ...Field) {
+	l.log(ErrorLevel, msg, fields)
+	os.Exit(1)
+}
+
+func (l *Logger) log(level Level, msg string, fields []Field) {
+	if !l.Enabled(level) {
+		return
+	}
+
+	e := Entry{
+		Time:    time.Now(),
+		Level:   level,
+		Message: msg,
+	}
+	if len(l.fields)+len(fields) > 0 {
+		e.Fields = make([]Field, 0, len(l.fields)+len(fields))
+		e.Fields = append(e.Fields, l.fields...)
+		e.Fields = append(e.Fields, fields...)
+	}
+
+	l.core.mu.RLock()
+	sinks := l.core.sinks
+	l.core.mu.RUnlock()
+	for _, s := range sinks {
+		if err := s.Write(e); err != nil {
+			fmt.Fprintf(os.Stderr, "log: 写入日志失败: %v\n", err)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package log
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// WriterSink 将编码后的日志逐行写入 io.Writer（如标准输出）
+type WriterSink struct {
+	mu  sync.Mutex
+	w   io.Writer
+	enc Encoder
+}
+
+// NewWriterSink 创建写入 w 的输出目标
+func NewWriterSink(w io.Writer, enc Encoder) *WriterSink {
+	return &WriterSink{w: w, enc: enc}
+}
+
+// NewStdoutSink 创建写入标准输出的输出目标
+func NewStdoutSink(enc Encoder) *WriterSink {
+	return NewWriterSink(os.Stdout, enc)
+}
+
+// Write 实现 Sink
+func (s *WriterSink) Write(e Entry) error {
+	line := append(s.enc.Encode(e), '\n')
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.w.Write(line)
+	return err
+}
+
+// RotatingFile 按大小滚动的日志文件
+// 当前文件超过 maxSize 字节后重命名为 path.1，原 path.1 变为 path.2，依此类推，最多保留 maxBackups 个旧文件
+type RotatingFile struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+	enc        Encoder
+	file       *os.File
+	size       int64
+}
+
+// NewRotatingFile 创建滚动日志文件输出目标
+func NewRotatingFile(path string, maxSize int64, maxBackups int, enc Encoder) (*RotatingFile, error) {
+	if maxSize <= 0 {
+		return nil, fmt.Errorf("日志文件最大大小必须大于0")
+	}
+	if maxBackups < 0 {
+		maxBackups = 0
+	}
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, err
+		}
+	}
+	rf := &RotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups, enc: enc}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *RotatingFile) open() error {
+	f, err := os.OpenFile(rf.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	rf.file = f
+	rf.size = info.Size()
+	return nil
+}
+
+// rotate 关闭当前文件并依次重命名旧文件
+func (rf *RotatingFile) rotate() error {
+	if rf.file != nil {
+		rf.file.Close()
+		rf.file = nil
+	}
+	if rf.maxBackups == 0 {
+		if err := os.Remove(rf.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return rf.open()
+	}
+	for i := rf.maxBackups - 1; i >= 1; i-- {
+		src := fmt.Sprintf("%s.%d", rf.path, i)
+		dst := fmt.Sprintf("%s.%d", rf.path, i+1)
+		if _, err := os.Stat(src); err == nil {
+			os.Rename(src, dst)
+		}
+	}
+	if err := os.Rename(rf.path, rf.path+".1"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return rf.open()
+}
+
+// Write 实现 Sink
+func (rf *RotatingFile) Write(e Entry) error {
+	line := append(rf.enc.Encode(e), '\n')
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.file == nil {
+		if err := rf.open(); err != nil {
+			return err
+		}
+	}
+	if rf.size > 0 && rf.size+int64(len(line)) > rf.maxSize {
+		if err := rf.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := rf.file.Write(line)
+	rf.size += int64(n)
+	return err
+}
+
+// Close 关闭日志文件
+func (rf *RotatingFile) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.file == nil {
+		return nil
+	}
+	err := rf.file.Close()
+	rf.file = nil
+	return err
+}
+
+// RingBuffer 保存最近若干条日志的内存环形缓冲区，GUI通过订阅获取新日志
+type RingBuffer struct {
+	mu      sync.Mutex
+	entries []Entry
+	next    int
+	full    bool
+	subs    map[chan Entry]struct{}
+}
+
+// NewRingBuffer 创建容量为 size 的环形缓冲区
+func NewRingBuffer(size int) *RingBuffer {
+	if size <= 0 {
+		size = 200
+	}
+	return &RingBuffer{
+		entries: make([]Entry, size),
+		subs:    make(map[chan Entry]struct{}),
+	}
+}
+
+// Write 实现 Sink（订阅者处理不过来时丢弃该条通知，不阻塞日志调用方）
+func (rb *RingBuffer) Write(e Entry) error {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	rb.entries[rb.next] = e
+	rb.next = (rb.next + 1) % len(rb.entries)
+	if rb.next == 0 {
+		rb.full = true
+	}
+	for ch := range rb.subs {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+	return nil
+}
+
+// Entries 按时间顺序返回缓冲区中的所有日志
+func (rb *RingBuffer) Entries() []Entry {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	return rb.snapshot()
+}
+
+func (rb *RingBuffer) snapshot() []Entry {
+	if !rb.full {
+		return append([]Entry(nil), rb.entries[:rb.next]...)
+	}
+	out := make([]Entry, 0, len(rb.entries))
+	out = append(out, rb.entries[rb.next:]...)
+	out = append(out, rb.entries[:rb.next]...)
+	return out
+}
+
+// Subscribe 订阅新日志，返回当前已有日志、新日志通道以及取消订阅函数
+func (rb *RingBuffer) Subscribe(buffer int) ([]Entry, <-chan Entry, func()) {
+	ch := make(chan Entry, buffer)
+	rb.mu.Lock()
+	existing := rb.snapshot()
+	rb.subs[ch] = struct{}{}
+	rb.mu.Unlock()
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			rb.mu.Lock()
+			delete(rb.subs, ch)
+			rb.mu.Unlock()
+			close(ch)
+		})
+	}
+	return existing, ch, cancel
+}
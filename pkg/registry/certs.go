@@ -0,0 +1,205 @@
+package registry
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"ttt/pkg/config"
+	"ttt/pkg/log"
+	"ttt/pkg/tlsconfig"
+)
+
+// certRetryInterval 更新证书失败后的重试间隔
+const certRetryInterval = 10 * time.Second
+
+// CenterServiceName 注册中心的服务名，本地 CA 只为注册中心自己签发这个服务名的证书
+const CenterServiceName = "center-service"
+
+// ServiceURI 返回本地 CA 签发的证书中表示服务身份的 URI SAN
+func ServiceURI(name string) string {
+	return "spiffe://ttt.local/service/" + name
+}
+
+// CertificateRequest 向注册中心的本地 CA 申请证书（POST /ca/sign）
+type CertificateRequest struct {
+	Name    string `json:"name"`              // 服务名
+	Address string `json:"address,omitempty"` // 实例地址，写入证书的 DNS/IP SAN
+	CSR     string `json:"csr"`               // PEM 格式的证书请求
+}
+
+// CertificateResponse 本地 CA 签发的证书
+type CertificateResponse struct {
+	Certificate string    `json:"certificate"` // PEM 格式的证书
+	CA          string    `json:"ca"`          // PEM 格式的 CA 证书
+	NotAfter    time.Time `json:"not_after"`
+}
+
+// SetupTLS 按环境变量配置本实例的 TLS，没有配置时返回 nil（使用 HTTP）：
+//   - 设置了 TLS_CERT_FILE 等证书文件时使用证书文件（文件变化时自动重新加载）
+//   - 否则设置了 CA_TOKEN 时向注册中心的本地 CA 申请证书，在剩余约1/3有效期时自动更新
+//
+// CA_TOKEN 和返回的 CA 证书不能通过普通 HTTP 传输：使用 CA_TOKEN 时 REGISTRY_URL 必须是 https，
+// 并且用 TLS_CA_FILE 指定验证注册中心证书的 CA（例如注册中心 CA_DIR 中的 ca.crt）；
+// 注册中心的证书需要是本地 CA 为 center-service 签发的（自动签发或 center_service cert center-service）。
+//
+// 启用 TLS 后实例注册为 https，访问注册中心时提供客户端证书。
+// 要求客户端证书时默认只接受 allowedClients 中的服务（没有配置 TLS_ALLOWED_CLIENTS 时）。需要在 Register 之前调用
+func (c *Client) SetupTLS(name, address string, allowedClients ...string) (*tlsconfig.Store, error) {
+	store, err := tlsconfig.LoadFromEnv(c.logger)
+	if err != nil {
+		return nil, err
+	}
+	token := config.GetEnv("CA_TOKEN", "")
+	if token != "" {
+		if err := c.checkBootstrap(store); err != nil {
+			return nil, err
+		}
+		// 本地 CA 签发的服务证书都包含 localhost 和服务申请的地址，只按主机名验证时
+		// 其他服务的证书也能冒充注册中心，因此还要求注册中心的 URI SAN
+		c.registryURI = ServiceURI(CenterServiceName)
+	}
+	if !store.Enabled() && token != "" {
+		if store, err = c.useLocalCA(name, address, token, store); err != nil {
+			return nil, err
+		}
+	} else if store != nil {
+		go store.Watch(5 * time.Second)
+	}
+	// 注册中心启用本地 CA 时，注册、心跳和注销需要令牌（或本服务的客户端证书）
+	c.SetToken(token)
+	c.SetScheme(store.Scheme())
+	if store != nil {
+		uris := make([]string, 0, len(allowedClients))
+		for _, client := range allowedClients {
+			uris = append(uris, ServiceURI(client))
+		}
+		store.AllowClients(uris...)
+		c.SetTLS(store)
+	}
+	return store, nil
+}
+
+// checkBootstrap 检查令牌只会通过验证了注册中心证书的 HTTPS 发送
+func (c *Client) checkBootstrap(pinned *tlsconfig.Store) error {
+	if c.registryURL == "" {
+		return errors.New("使用 CA_TOKEN 需要配置注册中心")
+	}
+	if !strings.HasPrefix(c.registryURL, "https://") {
+		return fmt.Errorf("CA_TOKEN 不能通过普通 HTTP 发送，REGISTRY_URL 需要使用 https: %s", c.registryURL)
+	}
+	if pinned == nil || config.GetEnv("TLS_CA_FILE", "") == "" {
+		return errors.New("使用 CA_TOKEN 需要用 TLS_CA_FILE 指定验证注册中心证书的 CA")
+	}
+	return nil
+}
+
+// useLocalCA 向本地 CA 申请证书并在后台定期更新；pinned 为 TLS_CA_FILE，用于验证注册中心的证书
+func (c *Client) useLocalCA(name, address, token string, pinned *tlsconfig.Store) (*tlsconfig.Store, error) {
+	cfg, err := tlsconfig.FromEnv()
+	if err != nil {
+		return nil, err
+	}
+	pinnedPEM, err := os.ReadFile(cfg.CAFile)
+	if err != nil {
+		return nil, err
+	}
+	// 申请证书时用 TLS_CA_FILE 验证注册中心，不信任网络上返回的 CA 证书
+	c.SetTLS(pinned)
+	cert, roots, err := c.RequestCertificate(name, address, token)
+	if err != nil {
+		return nil, fmt.Errorf("向本地CA申请证书失败: %w", err)
+	}
+	// 之后继续信任 TLS_CA_FILE（注册中心的证书可能不是本地 CA 签发的）
+	roots.AppendCertsFromPEM(pinnedPEM)
+	store := tlsconfig.NewManaged(cfg, c.logger)
+	store.SetCertificate(cert, roots)
+	c.logger.Info("✓ 已从本地CA获取证书", log.String("service", name), log.Any("not_after", cert.Leaf.NotAfter))
+	go c.rotateCertificate(store, cert.Leaf, name, address, token, pinnedPEM)
+	return store, nil
+}
+
+// rotateCertificate 在证书剩余约1/3有效期时申请新证书，失败时每隔 certRetryInterval 重试直到成功
+func (c *Client) rotateCertificate(store *tlsconfig.Store, leaf *x509.Certificate, name, address, token string, pinnedPEM []byte) {
+	for {
+		lifetime := leaf.NotAfter.Sub(leaf.NotBefore)
+		time.Sleep(time.Until(leaf.NotBefore.Add(lifetime * 2 / 3)))
+		for {
+			cert, roots, err := c.RequestCertificate(name, address, token)
+			if err == nil {
+				roots.AppendCertsFromPEM(pinnedPEM)
+				store.SetCertificate(cert, roots)
+				leaf = cert.Leaf
+				c.logger.Info("✓ 服务证书已更新", log.Any("not_after", leaf.NotAfter))
+				break
+			}
+			c.logger.Warn("更新服务证书失败，稍后重试", log.Err(err), log.Any("not_after", leaf.NotAfter))
+			time.Sleep(certRetryInterval)
+		}
+	}
+}
+
+// RequestCertificate 生成新的私钥，向注册中心的本地 CA 申请服务证书，返回证书和 CA
+// 只通过 HTTPS 申请（令牌和 CA 证书不能明文传输），调用前需要用 SetTLS 配置验证注册中心证书的 CA
+func (c *Client) RequestCertificate(name, address, token string) (*tls.Certificate, *x509.CertPool, error) {
+	if !strings.HasPrefix(c.registryURL, "https://") {
+		return nil, nil, errors.New("只能通过 https 向注册中心申请证书")
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: pkix.Name{CommonName: name}}, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	data, _ := json.Marshal(CertificateRequest{
+		Name:    name,
+		Address: address,
+		CSR:     string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr})),
+	})
+	req, err := http.NewRequest(http.MethodPost, c.registryURL+"/ca/sign", bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("注册中心返回 %s", resp.Status)
+	}
+	var result CertificateResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, nil, err
+	}
+
+	block, _ := pem.Decode([]byte(result.Certificate))
+	if block == nil {
+		return nil, nil, errors.New("注册中心返回的证书无效")
+	}
+	leaf, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM([]byte(result.CA)) {
+		return nil, nil, errors.New("注册中心返回的 CA 证书无效")
+	}
+	return &tls.Certificate{Certificate: [][]byte{leaf.Raw}, PrivateKey: key, Leaf: leaf}, roots, nil
+}
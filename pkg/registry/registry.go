@@ -0,0 +1,274 @@
+// Package registry 服务注册中心客户端：注册、心跳、注销和服务发现
+package registry
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"ttt/pkg/log"
+	"ttt/pkg/tlsconfig"
+)
+
+// HeartbeatInterval 心跳间隔，注册中心超过10秒未收到心跳会移除实例
+const HeartbeatInterval = 5 * time.Second
+
+// ErrNotFound 注册中心中没有找到服务
+var ErrNotFound = errors.New("服务未注册")
+
+// Instance 注册中心中的一个服务实例
+// 同一服务可以有多个实例，ID 默认由服务名、地址和端口组成
+type Instance struct {
+	ID            string    `json:"id"`
+	Name          string    `json:"name"`
+	Address       string    `json:"address"`
+	Port          int       `json:"port"`
+	URL           string    `json:"url"`
+	Scheme        string    `json:"scheme,omitempty"`  // 实例提供服务的协议：http（默认）或 https
+	Version       string    `json:"version,omitempty"` // 实例上报的版本，网关按版本分配流量
+	LastHeartbeat time.Time `json:"last_heartbeat"`
+}
+
+// DefaultID 返回实例的默认ID
+func DefaultID(name, address string, port int) string {
+	return fmt.Sprintf("%s-%s:%d", name, address, port)
+}
+
+// Client 注册中心客户端
+// registryURL 为空时所有操作都不做任何事，便于不依赖注册中心单独运行服务
+type Client struct {
+	registryURL string
+	logger      *log.Logger
+	http        *http.Client
+	token       string // 注册、心跳和注销时提供的令牌（注册中心启用本地 CA 时需要）
+	registryURI string // 注册中心的证书应有的 URI SAN，为空表示只验证主机名
+
+	mu       sync.Mutex
+	version  string    // 注册时上报的版本
+	scheme   string    // 注册时上报的协议
+	instance *Instance // 已注册的本实例
+	stop     chan struct{}
+}
+
+// NewClient 创建注册中心客户端
+func NewClient(registryURL string, logger *log.Logger) *Client {
+	return &Client{
+		registryURL: registryURL,
+		logger:      logger,
+		http:        &http.Client{Timeout: 2 * time.Second},
+	}
+}
+
+// URL 返回注册中心地址
+func (c *Client) URL() string {
+	return c.registryURL
+}
+
+// SetVersion 设置注册时上报的版本（如 v1、v2），需要在 Register 之前调用
+func (c *Client) SetVersion(version string) {
+	c.mu.Lock()
+	c.version = version
+	c.mu.Unlock()
+}
+
+// SetScheme 设置本实例提供服务的协议（http 或 https），需要在 Register 之前调用
+func (c *Client) SetScheme(scheme string) {
+	c.mu.Lock()
+	c.scheme = scheme
+	c.mu.Unlock()
+}
+
+// SetTLS 设置访问注册中心使用的证书（注册中心使用 HTTPS 或要求客户端证书时），需要在 Register 之前调用
+func (c *Client) SetTLS(store *tlsconfig.Store) {
+	t := &http.Transport{}
+	store.ConfigureTransport(t, func(string) string { return c.registryURI })
+	c.http.Transport = t
+}
+
+// SetToken 设置注册、心跳和注销时提供的令牌（CA_TOKEN），需要在 Register 之前调用
+func (c *Client) SetToken(token string) {
+	c.token = token
+}
+
+// post 向注册中心发送 POST 请求，设置了令牌时在 Authorization 中提供
+func (c *Client) post(path string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodPost, c.registryURL+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	return c.http.Do(req)
+}
+
+// Register 把本实例注册到注册中心，成功后定期发送心跳
+// 心跳时如果注册中心中已经没有本实例（例如在控制台上被注销、注册中心重启），会自动重新注册
+func (c *Client) Register(name, address string, port int) error {
+	if c.registryURL == "" {
+		return nil
+	}
+
+	c.mu.Lock()
+	version, scheme := c.version, c.scheme
+	c.mu.Unlock()
+	instance := &Instance{
+		ID:      DefaultID(name, address, port),
+		Name:    name,
+		Address: address,
+		Port:    port,
+		Version: version,
+		Scheme:  scheme,
+	}
+	if err := c.register(instance); err != nil {
+		c.logger.Warn("无法注册到服务注册中心", log.Err(err))
+		return err
+	}
+	c.logger.Info("✓ 已注册到服务注册中心", log.String("id", instance.ID), log.String("version", instance.Version), log.String("url", instance.URL))
+
+	c.mu.Lock()
+	c.instance = instance
+	if c.stop == nil {
+		c.stop = make(chan struct{})
+		go c.heartbeat(c.stop)
+	}
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *Client) register(instance *Instance) error {
+	data, _ := json.Marshal(instance)
+	resp, err := c.post("/register", data)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("注册中心返回 %s", resp.Status)
+	}
+	var result struct {
+		ID  string `json:"id"`
+		URL string `json:"url"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err == nil {
+		if result.ID != "" {
+			instance.ID = result.ID
+		}
+		instance.URL = result.URL
+	}
+	return nil
+}
+
+// heartbeat 定期发送心跳，直到 stop 被关闭
+func (c *Client) heartbeat(stop chan struct{}) {
+	ticker := time.NewTicker(HeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		c.mu.Lock()
+		instance := c.instance
+		c.mu.Unlock()
+
+		resp, err := c.post("/heartbeat?id="+url.QueryEscape(instance.ID), nil)
+		if err != nil {
+			continue
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode == http.StatusNotFound {
+			c.logger.Warn("注册中心中没有本实例，重新注册", log.String("id", instance.ID))
+			if err := c.register(instance); err != nil {
+				c.logger.Warn("重新注册失败", log.Err(err))
+			}
+		}
+	}
+}
+
+// Unregister 从注册中心注销本实例并停止心跳（同步等待完成）
+func (c *Client) Unregister() {
+	c.mu.Lock()
+	instance := c.instance
+	if c.stop != nil {
+		close(c.stop)
+		c.stop = nil
+	}
+	c.mu.Unlock()
+
+	if c.registryURL == "" || instance == nil {
+		return
+	}
+
+	c.logger.Info("正在注销服务...")
+	resp, err := c.post("/unregister?id="+url.QueryEscape(instance.ID), nil)
+	if err != nil {
+		c.logger.Warn("注销失败", log.Err(err))
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		c.logger.Info("✓ 已从服务注册中心注销")
+	} else {
+		c.logger.Warn("注销失败", log.Int("status", resp.StatusCode))
+	}
+}
+
+// Discover 发现服务的一个可用实例（有多个实例时由注册中心随机选择）
+func (c *Client) Discover(name string) (*Instance, error) {
+	if c.registryURL == "" {
+		return nil, ErrNotFound
+	}
+
+	resp, err := c.http.Get(c.registryURL + "/discover?name=" + url.QueryEscape(name))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("注册中心返回 %s", resp.Status)
+	}
+	var instance Instance
+	if err := json.NewDecoder(resp.Body).Decode(&instance); err != nil {
+		return nil, err
+	}
+	return &instance, nil
+}
+
+// Instances 获取所有在线的服务实例
+func (c *Client) Instances() ([]Instance, error) {
+	if c.registryURL == "" {
+		return nil, nil
+	}
+
+	resp, err := c.http.Get(c.registryURL + "/services")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("注册中心返回 %s", resp.Status)
+	}
+	var instances []Instance
+	if err := json.NewDecoder(resp.Body).Decode(&instances); err != nil {
+		return nil, err
+	}
+	return instances, nil
+}
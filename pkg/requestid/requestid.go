@@ -0,0 +1,110 @@
+package requestid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// Header 请求ID使用的HTTP头
+const Header = "X-Request-ID"
+
+// maxLength 接受的外部请求ID最大长度，超过则重新生成
+const maxLength = 128
+
+type contextKey struct{}
+
+// New 生成新的请求ID（16字节随机数的十六进制表示）
+func New() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
+
+// valid 检查外部传入的请求ID是否可用（长度有限且只包含可打印ASCII字符）
+func valid(id string) bool {
+	if id == "" || len(id) > maxLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// WithContext 将请求ID保存到context中
+func WithContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext 从context中获取请求ID，不存在时返回空字符串
+func FromContext(ctx context.Context) string {
+	if id, ok := ctx.Value(contextKey{}).(string); ok {
+		return id
+	}
+	return ""
+}
+
+// FromRequest 获取请求的请求ID（优先使用context，其次使用请求头）
+func FromRequest(r *http.Request) string {
+	if id := FromContext(r.Context()); id != "" {
+		return id
+	}
+	return r.Header.Get(Header)
+}
+
+// Middleware 为每个请求分配请求ID
+// 如果请求已经带有合法的 X-Request-ID 则沿用，否则生成新的ID；
+// 请求ID会写回请求头（便于转发）、保存到context并在响应头中返回
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(Header)
+		if !valid(id) {
+			id = New()
+		}
+		r.Header.Set(Header, id)
+		w.Header().Set(Header, id)
+		next.ServeHTTP(w, r.WithContext(WithContext(r.Context(), id)))
+	})
+}
+
+// NewRequest 创建带请求ID的出站请求，用于服务间调用时传递请求ID
+func NewRequest(ctx context.Context, method, url string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	if id := FromContext(ctx); id != "" {
+		req.Header.Set(Header, id)
+	}
+	return req, nil
+}
+
+// Error 返回带请求ID的错误响应（与 http.Error 相同，但在错误信息后附加请求ID）
+func Error(w http.ResponseWriter, r *http.Request, msg string, code int) {
+	if id := FromRequest(r); id != "" {
+		msg = fmt.Sprintf("%s (request_id: %s)", msg, id)
+	}
+	http.Error(w, msg, code)
+}
+
+// JSONError 返回带请求ID的JSON错误响应：{"error": "...", "request_id": "..."}
+func JSONError(w http.ResponseWriter, r *http.Request, msg string, code int) {
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Set("Content-Type", "application/json; charset=utf-8")
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(struct {
+		Error     string `json:"error"`
+		RequestID string `json:"request_id,omitempty"`
+	}{msg, FromRequest(r)})
+}
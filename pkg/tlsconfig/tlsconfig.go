@@ -0,0 +1,452 @@
+// Package tlsconfig 服务的 TLS 配置：HTTPS 证书（多个证书时按 SNI 选择）、双向 TLS（mTLS），
+// 证书文件变化时自动重新加载，不需要重启服务
+//
+// 证书可以从文件加载（通过环境变量配置），也可以由程序设置（由注册中心的本地 CA 签发）。
+// 从文件加载时的环境变量，没有设置 TLS_CERT_FILE 时服务使用普通 HTTP：
+//
+//	TLS_CERT_FILE / TLS_KEY_FILE                 服务证书和私钥，多个证书用逗号分隔（按客户端请求的主机名选择）
+//	TLS_CA_FILE                                  验证对端证书的 CA：作为客户端时验证上游，要求客户端证书时验证客户端
+//	TLS_CLIENT_AUTH                              require 表示要求客户端证书（双向 TLS），optional 表示客户端提供时验证，默认不要求
+//	TLS_ALLOWED_CLIENTS                          允许的客户端证书 URI SAN，多个用逗号分隔，* 表示 CA 签发的任意证书
+//	TLS_CLIENT_CERT_FILE / TLS_CLIENT_KEY_FILE   访问其他服务时使用的客户端证书，默认使用第一个服务证书
+package tlsconfig
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"ttt/pkg/config"
+	"ttt/pkg/log"
+)
+
+// Config TLS 证书文件配置
+type Config struct {
+	CertFiles         []string // 服务证书，与 KeyFiles 一一对应
+	KeyFiles          []string
+	CAFile            string
+	RequireClientCert bool
+	VerifyClientCert  bool     // 客户端提供证书时验证（不要求提供）
+	AllowedClients    []string // 允许的客户端证书 URI SAN，为空时由服务设置默认值（AllowClients），* 表示任意
+	ClientCertFile    string
+	ClientKeyFile     string
+}
+
+// FromEnv 从环境变量读取配置
+func FromEnv() (Config, error) {
+	cfg := Config{
+		CertFiles:      splitList(config.GetEnv("TLS_CERT_FILE", "")),
+		KeyFiles:       splitList(config.GetEnv("TLS_KEY_FILE", "")),
+		CAFile:         config.GetEnv("TLS_CA_FILE", ""),
+		ClientCertFile: config.GetEnv("TLS_CLIENT_CERT_FILE", ""),
+		ClientKeyFile:  config.GetEnv("TLS_CLIENT_KEY_FILE", ""),
+		AllowedClients: splitList(config.GetEnv("TLS_ALLOWED_CLIENTS", "")),
+	}
+	switch auth := config.GetEnv("TLS_CLIENT_AUTH", ""); auth {
+	case "", "none":
+	case "require":
+		cfg.RequireClientCert = true
+	case "optional":
+		cfg.VerifyClientCert = true
+	default:
+		return cfg, fmt.Errorf("TLS_CLIENT_AUTH 无效: %q（可用: none, optional, require）", auth)
+	}
+	return cfg, nil
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// Enabled 是否配置了服务证书（服务使用 HTTPS）
+func (c Config) Enabled() bool {
+	return len(c.CertFiles) > 0
+}
+
+// validate 校验配置
+func (c Config) validate() error {
+	var errs []error
+	if len(c.CertFiles) != len(c.KeyFiles) {
+		errs = append(errs, errors.New("TLS_CERT_FILE 和 TLS_KEY_FILE 的数量必须相同"))
+	}
+	if (c.RequireClientCert || c.VerifyClientCert) && c.CAFile == "" {
+		errs = append(errs, errors.New("TLS_CLIENT_AUTH=require 或 optional 需要设置 TLS_CA_FILE"))
+	}
+	if (c.ClientCertFile == "") != (c.ClientKeyFile == "") {
+		errs = append(errs, errors.New("TLS_CLIENT_CERT_FILE 和 TLS_CLIENT_KEY_FILE 必须同时设置"))
+	}
+	return errors.Join(errs...)
+}
+
+// files 返回需要监视的所有文件
+func (c Config) files() []string {
+	files := append(append([]string{}, c.CertFiles...), c.KeyFiles...)
+	for _, f := range []string{c.CAFile, c.ClientCertFile, c.ClientKeyFile} {
+		if f != "" {
+			files = append(files, f)
+		}
+	}
+	return files
+}
+
+// material 一次加载得到的证书，加载后不再修改
+type material struct {
+	certs  []*tls.Certificate // 服务证书
+	client *tls.Certificate   // 客户端证书，可能为空
+	roots  *x509.CertPool     // 为空时使用系统 CA
+}
+
+// Store 当前使用的证书，重新加载时整体替换，已建立的连接不受影响
+type Store struct {
+	cfg     Config
+	logger  *log.Logger
+	cur     atomic.Pointer[material]
+	managed bool // 证书由程序设置（SetCertificate），不从文件加载
+
+	mu   sync.Mutex
+	mods map[string]time.Time // 文件 -> 上次加载时的修改时间
+}
+
+// Load 加载证书文件
+func Load(cfg Config, logger *log.Logger) (*Store, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	s := &Store{cfg: cfg, logger: logger}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// NewManaged 创建由程序设置证书的 Store（例如由本地 CA 签发的证书），
+// 在第一次 SetCertificate 之前没有证书；cfg 中只使用客户端证书的验证方式（RequireClientCert、VerifyClientCert、AllowedClients）
+func NewManaged(cfg Config, logger *log.Logger) *Store {
+	s := &Store{
+		cfg:     Config{RequireClientCert: cfg.RequireClientCert, VerifyClientCert: cfg.VerifyClientCert, AllowedClients: cfg.AllowedClients},
+		logger:  logger,
+		managed: true,
+	}
+	s.cur.Store(&material{})
+	return s
+}
+
+// AllowClients 设置默认允许的客户端证书 URI SAN（没有配置 TLS_ALLOWED_CLIENTS 时生效），需要在开始服务之前调用
+func (s *Store) AllowClients(uris ...string) {
+	if len(s.cfg.AllowedClients) == 0 {
+		s.cfg.AllowedClients = uris
+	}
+}
+
+// SetCertificate 替换服务证书（同时作为客户端证书）和验证对端证书的 CA，新连接立即使用新证书
+func (s *Store) SetCertificate(cert *tls.Certificate, roots *x509.CertPool) {
+	s.cur.Store(&material{certs: []*tls.Certificate{cert}, client: cert, roots: roots})
+}
+
+// LoadFromEnv 按环境变量加载证书；没有配置证书和 CA 时返回 nil（使用普通 HTTP）
+func LoadFromEnv(logger *log.Logger) (*Store, error) {
+	cfg, err := FromEnv()
+	if err != nil {
+		return nil, err
+	}
+	if !cfg.Enabled() && cfg.CAFile == "" && cfg.ClientCertFile == "" {
+		return nil, nil
+	}
+	return Load(cfg, logger)
+}
+
+// Reload 重新读取证书文件，失败时继续使用当前证书
+func (s *Store) Reload() error {
+	mods := make(map[string]time.Time)
+	for _, f := range s.cfg.files() {
+		info, err := os.Stat(f)
+		if err != nil {
+			return err
+		}
+		mods[f] = info.ModTime()
+	}
+
+	m := &material{}
+	for i, certFile := range s.cfg.CertFiles {
+		cert, err := loadKeyPair(certFile, s.cfg.KeyFiles[i])
+		if err != nil {
+			return err
+		}
+		m.certs = append(m.certs, cert)
+	}
+	switch {
+	case s.cfg.ClientCertFile != "":
+		cert, err := loadKeyPair(s.cfg.ClientCertFile, s.cfg.ClientKeyFile)
+		if err != nil {
+			return err
+		}
+		m.client = cert
+	case len(m.certs) > 0:
+		m.client = m.certs[0]
+	}
+	if s.cfg.CAFile != "" {
+		pem, err := os.ReadFile(s.cfg.CAFile)
+		if err != nil {
+			return err
+		}
+		m.roots = x509.NewCertPool()
+		if !m.roots.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("%s 中没有有效的 CA 证书", s.cfg.CAFile)
+		}
+	}
+
+	s.cur.Store(m)
+	s.mu.Lock()
+	s.mods = mods
+	s.mu.Unlock()
+	for _, cert := range m.certs {
+		s.logger.Info("✓ 已加载TLS证书",
+			log.String("subject", cert.Leaf.Subject.CommonName),
+			log.String("names", strings.Join(cert.Leaf.DNSNames, ",")),
+			log.Any("not_after", cert.Leaf.NotAfter))
+	}
+	return nil
+}
+
+// loadKeyPair 加载证书和私钥，并解析证书（用于 SNI 匹配和日志）
+func loadKeyPair(certFile, keyFile string) (*tls.Certificate, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("加载证书 %s 失败: %w", certFile, err)
+	}
+	if cert.Leaf == nil {
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return nil, fmt.Errorf("解析证书 %s 失败: %w", certFile, err)
+		}
+	}
+	return &cert, nil
+}
+
+// Watch 定期检查证书文件，修改时间变化时重新加载
+// 证书和私钥通常分别写入，加载失败（例如只更新了证书）时在下次检查时重试
+func (s *Store) Watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if !s.changed() {
+			continue
+		}
+		if err := s.Reload(); err != nil {
+			s.logger.Warn("重新加载TLS证书失败，继续使用当前证书", log.Err(err))
+			continue
+		}
+		s.logger.Info("✓ TLS证书已更新")
+	}
+}
+
+// changed 判断证书文件是否有变化
+func (s *Store) changed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for f, mod := range s.mods {
+		if info, err := os.Stat(f); err == nil && !info.ModTime().Equal(mod) {
+			return true
+		}
+	}
+	return false
+}
+
+// Enabled 是否有服务证书（服务使用 HTTPS）
+func (s *Store) Enabled() bool {
+	return s != nil && len(s.cur.Load().certs) > 0
+}
+
+// getCertificate 按客户端请求的主机名（SNI）选择证书，没有匹配时使用第一个证书
+func (s *Store) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	certs := s.cur.Load().certs
+	if len(certs) == 0 {
+		return nil, errors.New("没有可用的TLS证书")
+	}
+	for _, cert := range certs {
+		if hello.SupportsCertificate(cert) == nil {
+			return cert, nil
+		}
+	}
+	return certs[0], nil
+}
+
+// ServerConfig 服务端 TLS 配置；要求客户端证书时用当前的 CA 验证，
+// 并且只接受 URI SAN 在允许列表（AllowedClients）中的客户端证书
+func (s *Store) ServerConfig() *tls.Config {
+	cfg := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: s.getCertificate,
+	}
+	if s.cfg.RequireClientCert || s.cfg.VerifyClientCert {
+		cfg.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			c := cfg.Clone()
+			c.GetConfigForClient = nil
+			c.ClientAuth = tls.RequireAndVerifyClientCert
+			if !s.cfg.RequireClientCert {
+				c.ClientAuth = tls.VerifyClientCertIfGiven
+			}
+			c.VerifyConnection = s.verifyClient
+			if c.ClientCAs = s.cur.Load().roots; c.ClientCAs == nil {
+				return nil, errors.New("还没有可用的 CA 证书，无法验证客户端")
+			}
+			return c, nil
+		}
+	}
+	return cfg
+}
+
+// verifyClient 检查客户端证书的 URI SAN 是否在允许列表中（证书链已由标准验证检查）
+func (s *Store) verifyClient(cs tls.ConnectionState) error {
+	allowed := s.cfg.AllowedClients
+	if len(cs.PeerCertificates) == 0 || len(allowed) == 1 && allowed[0] == "*" {
+		return nil
+	}
+	for _, uri := range allowed {
+		if HasURI(cs.PeerCertificates[0], uri) {
+			return nil
+		}
+	}
+	return fmt.Errorf("客户端证书 %q 不在允许的列表中", cs.PeerCertificates[0].Subject.CommonName)
+}
+
+// HasURI 判断证书是否包含指定的 URI SAN
+func HasURI(cert *x509.Certificate, uri string) bool {
+	for _, u := range cert.URIs {
+		if u.String() == uri {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientConfig 访问其他服务时的 TLS 配置：对方要求时提供客户端证书，配置了 CA 时用它验证对方的证书
+//
+// 配置了 CA 时按 ServerName 验证对方的主机名，ServerName 为空时（例如连接IP地址）拒绝连接，
+// 访问按IP地址注册的服务时使用 ConfigureTransport
+func (s *Store) ClientConfig() *tls.Config {
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			if cert := s.cur.Load().client; cert != nil {
+				return cert, nil
+			}
+			return &tls.Certificate{}, nil
+		},
+	}
+	if s.verifiesPeer() {
+		// 标准验证只能使用固定的 RootCAs，这里改为每次用当前加载的 CA 验证，CA 文件更新后立即生效
+		cfg.InsecureSkipVerify = true
+		cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+			return s.verifyPeer(cs, cs.ServerName, "")
+		}
+	}
+	return cfg
+}
+
+// verifiesPeer 是否用配置的 CA（而不是系统 CA）验证对方的证书
+func (s *Store) verifiesPeer() bool {
+	return s.cfg.CAFile != "" || s.managed
+}
+
+// verifyPeer 用当前加载的 CA 验证对方的证书链，以及证书中的主机名（host 为IP地址时验证 IP SAN），
+// uri 不为空时要求证书包含该 URI SAN（对方的服务身份）
+func (s *Store) verifyPeer(cs tls.ConnectionState, host, uri string) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("对方没有提供证书")
+	}
+	if host == "" {
+		return errors.New("没有对方的主机名，无法验证证书")
+	}
+	roots := s.cur.Load().roots
+	if roots == nil {
+		// 程序设置的证书还没有设置时不能退回到系统 CA
+		return errors.New("还没有可用的 CA 证书，无法验证对方")
+	}
+	opts := x509.VerifyOptions{
+		Roots:         roots,
+		DNSName:       host,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	if _, err := cs.PeerCertificates[0].Verify(opts); err != nil {
+		return err
+	}
+	if uri != "" && !HasURI(cs.PeerCertificates[0], uri) {
+		return fmt.Errorf("对方的证书不属于 %s", uri)
+	}
+	return nil
+}
+
+// ConfigureTransport 让 t 访问 https 服务时提供客户端证书并验证对方的证书
+//
+// 标准的 TLS 客户端连接IP地址时不发送 SNI，VerifyConnection 中的 ServerName 为空，
+// 因此这里自己建立 TLS 连接，按连接的地址验证对方证书中的主机名或IP地址。
+// peerURI 不为 nil 时返回连接地址（主机:端口）上的服务应有的 URI SAN，为空表示不检查
+func (s *Store) ConfigureTransport(t *http.Transport, peerURI func(addr string) string) {
+	t.TLSClientConfig = s.ClientConfig()
+	t.ForceAttemptHTTP2 = true
+	t.DialTLSContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		var uri string
+		if peerURI != nil {
+			uri = peerURI(addr)
+		}
+		cfg := s.ClientConfig()
+		cfg.ServerName = host
+		cfg.NextProtos = []string{"h2", "http/1.1"}
+		if s.verifiesPeer() {
+			cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+				return s.verifyPeer(cs, host, uri)
+			}
+		} else if uri != "" {
+			cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+				if !HasURI(cs.PeerCertificates[0], uri) {
+					return fmt.Errorf("对方的证书不属于 %s", uri)
+				}
+				return nil
+			}
+		}
+		dialer := &tls.Dialer{
+			NetDialer: &net.Dialer{Timeout: 5 * time.Second, KeepAlive: 30 * time.Second},
+			Config:    cfg,
+		}
+		return dialer.DialContext(ctx, network, addr)
+	}
+}
+
+// ListenAndServe 启动 HTTP 服务；s 有服务证书时使用 HTTPS
+func ListenAndServe(addr string, handler http.Handler, s *Store) error {
+	if !s.Enabled() {
+		return http.ListenAndServe(addr, handler)
+	}
+	server := &http.Server{Addr: addr, Handler: handler, TLSConfig: s.ServerConfig()}
+	return server.ListenAndServeTLS("", "")
+}
+
+// Scheme 返回服务使用的协议：http 或 https
+func (s *Store) Scheme() string {
+	if s.Enabled() {
+		return "https"
+	}
+	return "http"
+}
@@ -0,0 +1,25 @@
+package ui
+
+import (
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/widget"
+)
+
+// ServiceLayout 服务窗口的标准布局：顶部状态栏，下方为日志面板
+// side 不为 nil 时在左侧显示（占30%宽度），标题为 sideTitle
+func ServiceLayout(status *StatusBar, logs *LogPanel, sideTitle string, side fyne.CanvasObject) fyne.CanvasObject {
+	logArea := container.NewBorder(widget.NewLabel("日志输出"), nil, nil, nil, logs)
+
+	var body fyne.CanvasObject = logArea
+	if side != nil {
+		split := container.NewHSplit(
+			container.NewBorder(widget.NewLabel(sideTitle), nil, nil, nil, side),
+			logArea,
+		)
+		split.SetOffset(0.3) // 左侧占30%宽度
+		body = split
+	}
+
+	return container.NewBorder(status, nil, nil, nil, body)
+}
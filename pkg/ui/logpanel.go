@@ -0,0 +1,225 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+
+	"ttt/pkg/log"
+)
+
+// maxLogLines 日志面板最多保留的日志条数
+const maxLogLines = 500
+
+// levelOptions 级别过滤选项（显示该级别及以上的日志）
+var levelOptions = []struct {
+	label string
+	level log.Level
+}{
+	{"全部", log.DebugLevel},
+	{"信息", log.InfoLevel},
+	{"警告", log.WarnLevel},
+	{"错误", log.ErrorLevel},
+}
+
+// LogPanel 日志面板：订阅日志环形缓冲区，按级别着色显示
+// 支持级别过滤、关键字搜索、暂停滚动和复制当前显示的日志
+type LogPanel struct {
+	widget.BaseWidget
+
+	window  fyne.Window
+	encoder log.ConsoleEncoder
+
+	mu       sync.Mutex // 保护以下字段和 lines.Objects（新日志在订阅的 goroutine 中添加）
+	entries  []log.Entry
+	minLevel log.Level
+	keyword  string
+	paused   bool
+
+	lines     *fyne.Container
+	scroll    *container.Scroll
+	pauseBtn  *widget.Button
+	toolbar   fyne.CanvasObject
+	countText *widget.Label
+}
+
+// NewLogPanel 创建日志面板并开始订阅 buffer 中的日志，window 用于访问剪贴板
+func NewLogPanel(buffer *log.RingBuffer, window fyne.Window) *LogPanel {
+	p := &LogPanel{
+		window:  window,
+		encoder: log.ConsoleEncoder{TimeLayout: "15:04:05"},
+		lines:   container.NewVBox(),
+	}
+	p.scroll = container.NewScroll(p.lines)
+	p.scroll.SetMinSize(fyne.NewSize(0, 0))
+	p.countText = widget.NewLabel("")
+
+	labels := make([]string, len(levelOptions))
+	for i, opt := range levelOptions {
+		labels[i] = opt.label
+	}
+	levelSelect := widget.NewSelect(labels, func(selected string) {
+		for _, opt := range levelOptions {
+			if opt.label == selected {
+				p.mu.Lock()
+				p.minLevel = opt.level
+				p.mu.Unlock()
+				p.rebuild()
+				return
+			}
+		}
+	})
+	levelSelect.SetSelected(levelOptions[0].label)
+
+	search := widget.NewEntry()
+	search.SetPlaceHolder("搜索日志...")
+	search.OnChanged = func(text string) {
+		p.mu.Lock()
+		p.keyword = strings.ToLower(strings.TrimSpace(text))
+		p.mu.Unlock()
+		p.rebuild()
+	}
+
+	p.pauseBtn = widget.NewButtonWithIcon("暂停", theme.MediaPauseIcon(), p.togglePause)
+	copyBtn := widget.NewButtonWithIcon("复制", theme.ContentCopyIcon(), p.copyVisible)
+	clearBtn := widget.NewButtonWithIcon("清空", theme.DeleteIcon(), p.clear)
+
+	p.toolbar = container.NewBorder(nil, nil,
+		container.NewHBox(widget.NewLabel("级别:"), levelSelect),
+		container.NewHBox(p.pauseBtn, copyBtn, clearBtn, p.countText),
+		search,
+	)
+
+	p.ExtendBaseWidget(p)
+
+	existing, entries, _ := buffer.Subscribe(100)
+	for _, e := range existing {
+		p.append(e)
+	}
+	go func() {
+		for e := range entries {
+			p.append(e)
+		}
+	}()
+	return p
+}
+
+// CreateRenderer 实现 fyne.Widget
+func (p *LogPanel) CreateRenderer() fyne.WidgetRenderer {
+	return widget.NewSimpleRenderer(container.NewBorder(p.toolbar, nil, nil, nil, p.scroll))
+}
+
+// matches 判断日志是否满足当前过滤条件（调用方需持有 p.mu）
+func (p *LogPanel) matches(e log.Entry, line string) bool {
+	if e.Level < p.minLevel {
+		return false
+	}
+	return p.keyword == "" || strings.Contains(strings.ToLower(line), p.keyword)
+}
+
+// newLine 创建带颜色的日志文本（颜色由日志级别决定）
+func newLine(text string, e log.Entry) *canvas.Text {
+	t := canvas.NewText(text, e.Level.Color())
+	t.TextStyle = fyne.TextStyle{Monospace: true}
+	t.Alignment = fyne.TextAlignLeading
+	return t
+}
+
+// append 添加一条日志，满足过滤条件且未暂停时立即显示
+func (p *LogPanel) append(e log.Entry) {
+	line := string(p.encoder.Encode(e))
+
+	p.mu.Lock()
+	p.entries = append(p.entries, e)
+	if len(p.entries) > maxLogLines {
+		p.entries = p.entries[len(p.entries)-maxLogLines:]
+	}
+	show := !p.paused && p.matches(e, line)
+	if show {
+		p.lines.Objects = append(p.lines.Objects, newLine(line, e))
+		if len(p.lines.Objects) > maxLogLines {
+			p.lines.Objects = p.lines.Objects[len(p.lines.Objects)-maxLogLines:]
+		}
+	}
+	p.mu.Unlock()
+
+	if !show {
+		return
+	}
+	p.lines.Refresh()
+	p.scroll.ScrollToBottom()
+	p.updateCount()
+}
+
+// rebuild 根据过滤条件重新生成显示的日志
+func (p *LogPanel) rebuild() {
+	p.mu.Lock()
+	objects := make([]fyne.CanvasObject, 0, len(p.entries))
+	for _, e := range p.entries {
+		line := string(p.encoder.Encode(e))
+		if p.matches(e, line) {
+			objects = append(objects, newLine(line, e))
+		}
+	}
+	p.lines.Objects = objects
+	p.mu.Unlock()
+
+	p.lines.Refresh()
+	p.scroll.ScrollToBottom()
+	p.updateCount()
+}
+
+// updateCount 更新显示条数
+func (p *LogPanel) updateCount() {
+	p.mu.Lock()
+	shown, total := len(p.lines.Objects), len(p.entries)
+	p.mu.Unlock()
+	p.countText.SetText(fmt.Sprintf("%d/%d", shown, total))
+}
+
+// togglePause 暂停/继续显示新日志（暂停期间的日志仍会保留，继续后一并显示）
+func (p *LogPanel) togglePause() {
+	p.mu.Lock()
+	p.paused = !p.paused
+	paused := p.paused
+	p.mu.Unlock()
+
+	if paused {
+		p.pauseBtn.SetText("继续")
+		p.pauseBtn.SetIcon(theme.MediaPlayIcon())
+		return
+	}
+	p.pauseBtn.SetText("暂停")
+	p.pauseBtn.SetIcon(theme.MediaPauseIcon())
+	p.rebuild()
+}
+
+// copyVisible 将当前显示的日志复制到剪贴板
+func (p *LogPanel) copyVisible() {
+	var b strings.Builder
+	p.mu.Lock()
+	for _, obj := range p.lines.Objects {
+		if t, ok := obj.(*canvas.Text); ok {
+			b.WriteString(t.Text)
+			b.WriteByte('\n')
+		}
+	}
+	p.mu.Unlock()
+	if p.window != nil {
+		p.window.Clipboard().SetContent(b.String())
+	}
+}
+
+// clear 清空面板中的日志（不影响日志缓冲区和其他输出）
+func (p *LogPanel) clear() {
+	p.mu.Lock()
+	p.entries = nil
+	p.mu.Unlock()
+	p.rebuild()
+}
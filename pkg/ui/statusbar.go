@@ -0,0 +1,93 @@
+package ui
+
+import (
+	"image/color"
+	"strings"
+	"sync"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/widget"
+)
+
+// 状态指示灯颜色
+var (
+	StateStarting = color.NRGBA{R: 255, G: 165, B: 0, A: 255} // 橙色：启动中
+	StateRunning  = color.NRGBA{R: 0, G: 200, B: 0, A: 255}   // 绿色：运行中
+	StateError    = color.NRGBA{R: 255, G: 0, B: 0, A: 255}   // 红色：异常
+)
+
+// StatusBar 状态栏：左侧为状态指示灯，右侧为按添加顺序排列的 "名称: 值" 项，以 " | " 分隔
+type StatusBar struct {
+	widget.BaseWidget
+
+	mu    sync.Mutex
+	keys  []string
+	items map[string]string
+
+	light *canvas.Circle
+	label *widget.Label
+}
+
+// NewStatusBar 创建状态栏，初始状态为启动中
+func NewStatusBar() *StatusBar {
+	s := &StatusBar{
+		items: make(map[string]string),
+		light: canvas.NewCircle(StateStarting),
+		label: widget.NewLabel("状态: 启动中..."),
+	}
+	s.ExtendBaseWidget(s)
+	return s
+}
+
+// CreateRenderer 实现 fyne.Widget
+func (s *StatusBar) CreateRenderer() fyne.WidgetRenderer {
+	light := container.NewGridWrap(fyne.NewSize(12, 12), s.light)
+	return widget.NewSimpleRenderer(container.NewHBox(container.NewCenter(light), s.label))
+}
+
+// SetState 设置状态指示灯颜色（StateStarting/StateRunning/StateError）
+func (s *StatusBar) SetState(c color.Color) {
+	s.light.FillColor = c
+	s.light.Refresh()
+}
+
+// Set 设置一项状态，新的名称追加在末尾
+func (s *StatusBar) Set(key, value string) {
+	s.mu.Lock()
+	if _, ok := s.items[key]; !ok {
+		s.keys = append(s.keys, key)
+	}
+	s.items[key] = value
+	text := s.textLocked()
+	s.mu.Unlock()
+
+	s.label.SetText(text)
+}
+
+// Remove 移除一项状态
+func (s *StatusBar) Remove(key string) {
+	s.mu.Lock()
+	if _, ok := s.items[key]; ok {
+		delete(s.items, key)
+		for i, k := range s.keys {
+			if k == key {
+				s.keys = append(s.keys[:i], s.keys[i+1:]...)
+				break
+			}
+		}
+	}
+	text := s.textLocked()
+	s.mu.Unlock()
+
+	s.label.SetText(text)
+}
+
+func (s *StatusBar) textLocked() string {
+	parts := make([]string, 0, len(s.keys))
+	for _, k := range s.keys {
+		parts = append(parts, k+": "+s.items[k])
+	}
+	return strings.Join(parts, " | ")
+}
@@ -0,0 +1,198 @@
+// Package ui 提供各服务GUI窗口共用的主题、日志面板和状态栏
+package ui
+
+import (
+	"context"
+	"embed"
+	"image/color"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/app"
+	"fyne.io/fyne/v2/theme"
+)
+
+// 嵌入字体文件
+// 使用方法：将中文字体文件复制到 pkg/ui/fonts 目录下，所有服务共用
+// 支持的格式：.ttf, .otf（注意：Fyne不支持.ttc字体集合文件）
+// 如果没有字体文件，程序会自动查找系统字体作为fallback
+//
+//go:embed fonts/*
+var embeddedFonts embed.FS
+
+// NewApp 创建使用中文主题的Fyne应用
+func NewApp() fyne.App {
+	a := app.New()
+	a.Settings().SetTheme(NewTheme())
+	return a
+}
+
+// chineseTheme 支持中文的主题
+type chineseTheme struct {
+	baseTheme   fyne.Theme
+	chineseFont fyne.Resource
+}
+
+// NewTheme 创建支持中文的主题（字体查找顺序见 FindChineseFont）
+func NewTheme() fyne.Theme {
+	t := &chineseTheme{
+		baseTheme: theme.DefaultTheme(),
+	}
+
+	t.chineseFont = FindChineseFont()
+	if t.chineseFont == nil {
+		// 找不到中文字体时使用默认主题字体
+		// Fyne在Windows上会自动使用系统默认字体，通常支持中文
+		t.chineseFont = t.baseTheme.Font(fyne.TextStyle{})
+	}
+	return t
+}
+
+func (t *chineseTheme) Color(name fyne.ThemeColorName, variant fyne.ThemeVariant) color.Color {
+	return t.baseTheme.Color(name, variant)
+}
+
+func (t *chineseTheme) Icon(name fyne.ThemeIconName) fyne.Resource {
+	return t.baseTheme.Icon(name)
+}
+
+func (t *chineseTheme) Font(style fyne.TextStyle) fyne.Resource {
+	return t.chineseFont
+}
+
+func (t *chineseTheme) Size(name fyne.ThemeSizeName) float32 {
+	return t.baseTheme.Size(name)
+}
+
+// FindChineseFont 查找中文字体，按以下顺序：
+//  1. 嵌入到程序中的字体（pkg/ui/fonts）
+//  2. 环境变量 UI_FONT 指定的字体文件
+//  3. Linux 上通过 fontconfig（fc-list）查找支持中文的字体
+//  4. 各平台常见的中文字体路径
+//
+// 都找不到时返回 nil
+func FindChineseFont() fyne.Resource {
+	if res := loadEmbeddedFont(); res != nil {
+		return res
+	}
+
+	candidates := make([]string, 0, 16)
+	if path := os.Getenv("UI_FONT"); path != "" {
+		candidates = append(candidates, path)
+	}
+	if runtime.GOOS == "linux" || runtime.GOOS == "freebsd" {
+		candidates = append(candidates, fontconfigFonts()...)
+	}
+	candidates = append(candidates, systemFontPaths()...)
+
+	for _, path := range candidates {
+		if res := loadFontFile(path); res != nil {
+			return res
+		}
+	}
+	return nil
+}
+
+// supportedFont 判断是否为Fyne支持的字体格式（.ttf/.otf，不支持.ttc字体集合）
+func supportedFont(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	return ext == ".ttf" || ext == ".otf"
+}
+
+// loadFontFile 读取字体文件，格式不支持或读取失败时返回 nil
+func loadFontFile(path string) fyne.Resource {
+	if !supportedFont(path) {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil || len(data) == 0 {
+		return nil
+	}
+	return fyne.NewStaticResource(filepath.Base(path), data)
+}
+
+// loadEmbeddedFont 加载嵌入的字体文件，优先使用约定的文件名
+func loadEmbeddedFont() fyne.Resource {
+	entries, err := embeddedFonts.ReadDir("fonts")
+	if err != nil {
+		return nil
+	}
+
+	names := []string{"chinese.ttf", "chinese.otf", "msyh.ttf", "simsun.ttf", "font.ttf", "font.otf"}
+	for _, entry := range entries {
+		if !entry.IsDir() && supportedFont(entry.Name()) {
+			names = append(names, entry.Name())
+		}
+	}
+
+	for _, name := range names {
+		data, err := embeddedFonts.ReadFile("fonts/" + name)
+		if err == nil && len(data) > 0 {
+			return fyne.NewStaticResource(name, data)
+		}
+	}
+	return nil
+}
+
+// fontconfigFonts 通过 fontconfig 查找支持中文的字体文件（只返回.ttf/.otf）
+func fontconfigFonts() []string {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	out, err := exec.CommandContext(ctx, "fc-list", ":lang=zh", "file").Output()
+	if err != nil {
+		return nil
+	}
+
+	var fonts []string
+	for _, line := range strings.Split(string(out), "\n") {
+		// 输出格式: /path/to/font.ttf:
+		path := strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(line), ":"))
+		if path != "" && supportedFont(path) {
+			fonts = append(fonts, path)
+		}
+	}
+	return fonts
+}
+
+// systemFontPaths 各平台常见的中文字体路径，按优先级排序
+func systemFontPaths() []string {
+	switch runtime.GOOS {
+	case "windows":
+		windir := os.Getenv("WINDIR")
+		if windir == "" {
+			windir = "C:\\Windows"
+		}
+		paths := []string{
+			filepath.Join(windir, "Fonts", "simhei.ttf"), // SimHei (黑体)
+			filepath.Join(windir, "Fonts", "simsun.ttf"), // SimSun (宋体)
+			filepath.Join(windir, "Fonts", "simkai.ttf"), // SimKai (楷体)
+			filepath.Join(windir, "Fonts", "simli.ttf"),  // SimLi (隶书)
+		}
+		if windir != "C:\\Windows" {
+			paths = append(paths,
+				filepath.Join("C:\\Windows", "Fonts", "simhei.ttf"),
+				filepath.Join("C:\\Windows", "Fonts", "simsun.ttf"),
+			)
+		}
+		return paths
+	case "darwin":
+		return []string{
+			"/Library/Fonts/Arial Unicode.ttf",
+			"/System/Library/Fonts/Supplemental/Arial Unicode.ttf",
+		}
+	default:
+		return []string{
+			"/usr/share/fonts/truetype/droid/DroidSansFallbackFull.ttf",
+			"/usr/share/fonts/google-droid-sans-fonts/DroidSansFallbackFull.ttf",
+			"/usr/share/fonts/opentype/noto/NotoSansSC-Regular.otf",
+			"/usr/share/fonts/noto-cjk/NotoSansSC-Regular.otf",
+			"/usr/share/fonts/truetype/arphic/uming.ttf",
+		}
+	}
+}